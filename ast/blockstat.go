@@ -3,10 +3,16 @@ package ast
 // A BlockStat is a statement node that represents a block of statements,
 // optionally ending in a return statement (if Return is not a nil slice - note
 // that a bare return is encoded as a non-nil slice of length 0).
+//
+// The comment fields are only set when the parser keeps comments.
 type BlockStat struct {
 	Location
 	Stats  []Stat
 	Return []ExpNode
+
+	StatComments   []Comments // nil or one item per statement in Stats
+	ReturnComments Comments   // Comments attached to the return statement
+	EndComments    []Comment  // Comments after the last statement
 }
 
 var _ Stat = BlockStat{}
@@ -0,0 +1,46 @@
+package ast
+
+import (
+	"regexp"
+
+	"github.com/arnodel/golua/token"
+)
+
+// A Comment is a comment in the source code.  Comments are only recorded in
+// the AST when the parser is asked to keep them.
+type Comment struct {
+	Location
+	Text            string // The whole comment, including the leading "--"
+	BlankLineBefore bool   // True if there is an empty line before the comment
+}
+
+// NewComment returns a Comment for the given COMMENT token.
+func NewComment(tok *token.Token, blankLineBefore bool) Comment {
+	return Comment{
+		Location:        LocFromToken(tok),
+		Text:            string(tok.Lit),
+		BlankLineBefore: blankLineBefore,
+	}
+}
+
+// IsLong returns true if the comment is a long comment (i.e. --[[ ... ]]).
+// Other comments extend to the end of the line.
+func (c Comment) IsLong() bool {
+	return longCommentPrefix.MatchString(c.Text)
+}
+
+// Comments contains the comments attached to a statement or a table field,
+// and also records whether it is separated from what precedes it by an empty
+// line, so that the layout of the source can be reproduced.
+type Comments struct {
+	Leading         []Comment // Comments on the lines before the node
+	Trailing        []Comment // Comments after the node, on the same line
+	BlankLineBefore bool      // True if there is an empty line before the node
+}
+
+// IsEmpty returns true if there are no comments and no empty line.
+func (c Comments) IsEmpty() bool {
+	return len(c.Leading) == 0 && len(c.Trailing) == 0 && !c.BlankLineBefore
+}
+
+var longCommentPrefix = regexp.MustCompile(`^--\[=*\[`)
@@ -14,6 +14,9 @@ import (
 type TableConstructor struct {
 	Location
 	Fields []TableField
+
+	FieldComments []Comments // nil or one item per field in Fields
+	EndComments   []Comment  // Comments after the last field
 }
 
 var _ ExpNode = TableConstructor{}
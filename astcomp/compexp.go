@@ -358,6 +358,12 @@ func (c *compiler) compileExpList(exps []ast.ExpNode, dstRegs []ir.Register) {
 
 func (c *compiler) compileFunctionBody(f ast.Function) {
 	recvRegs := make([]ir.Register, len(f.Params))
+	c.SetFunctionInfo(ir.FunctionInfo{
+		ParamCount:      len(f.Params),
+		IsVararg:        f.HasDots,
+		LineDefined:     getLine(f),
+		LastLineDefined: getEndLine(f),
+	})
 	callerReg := c.GetFreeRegister()
 	c.DeclareLocal(callerRegName, callerReg)
 	for i, p := range f.Params {
@@ -420,7 +426,7 @@ func (c *compiler) compileCall(f ast.BFunctionCall, tail bool) {
 			Tail:    tail,
 		})
 		c.emitInstr(f, ir.Push{
-			Cont: fReg,
+			Cont: contReg,
 			Item: self,
 		})
 		c.ReleaseRegister(self)
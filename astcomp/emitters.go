@@ -40,3 +40,13 @@ func getLine(l ast.Locator) int {
 	}
 	return 0
 }
+
+func getEndLine(l ast.Locator) int {
+	if l != nil {
+		locEnd := l.Locate().EndPos()
+		if locEnd != nil {
+			return locEnd.Line
+		}
+	}
+	return 0
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/coverage"
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/lib/base"
 	"github.com/arnodel/golua/lib/debuglib"
@@ -18,6 +19,26 @@ import (
 	rt "github.com/arnodel/golua/runtime"
 )
 
+// Subcommands are run as "golua <name> [args]".  Otherwise the arguments are
+// the Lua script to run and its arguments.
+var subcommands = map[string]func(args []string) int{
+	"bind":  runBind,
+	"check": runCheck,
+	"fmt":   runFmt,
+}
+
+// runSubcommand runs the subcommand given on the command line, if any.
+func runSubcommand() (int, bool) {
+	if len(os.Args) < 2 {
+		return 0, false
+	}
+	run, ok := subcommands[os.Args[1]]
+	if !ok {
+		return 0, false
+	}
+	return run(os.Args[2:]), true
+}
+
 type luaCmd struct {
 	disFlag        bool
 	astFlag        bool
@@ -26,15 +47,27 @@ type luaCmd struct {
 	memLimit       uint64
 	flags          string
 	exec           execFlags
+	dapFlag        bool
+	dapAddr        string
+	luaProfile     string
+	coverProfile   string
+	coverFormat    string
+	optLevel       int
 
 	complianceFlags rt.ComplianceFlags
 }
 
 func (c *luaCmd) setFlags() {
 	flag.BoolVar(&c.disFlag, "dis", false, "Disassemble source instead of running it")
+	flag.IntVar(&c.optLevel, "O", 0, "optimisation level of the compiled code (0, 1 or 2)")
 	flag.BoolVar(&c.astFlag, "ast", false, "Print AST instead of running code")
 	flag.BoolVar(&c.unbufferedFlag, "u", false, "Force unbuffered output")
 	flag.Var(&c.exec, "e", "statement to execute")
+	flag.BoolVar(&c.dapFlag, "dap", false, "Run a Debug Adapter Protocol server on stdio")
+	flag.StringVar(&c.dapAddr, "dapaddr", "", "Run a Debug Adapter Protocol server listening on this TCP address")
+	flag.StringVar(&c.luaProfile, "luaprofile", "", "write a profile of the Lua code to `file` (in pprof format)")
+	flag.StringVar(&c.coverProfile, "coverprofile", "", "write a code coverage profile of the Lua code to `file`")
+	flag.StringVar(&c.coverFormat, "coverformat", "lcov", "format of the code coverage profile (lcov or cobertura)")
 
 	if rt.QuotasAvailable {
 		flag.Uint64Var(&c.cpuLimit, "cpulimit", 0, "CPU limit")
@@ -53,6 +86,10 @@ func (c *luaCmd) run() (retcode int) {
 		repl      bool
 	)
 
+	if c.dapFlag || c.dapAddr != "" {
+		return c.runDAP()
+	}
+
 	buffered := !isaTTY(os.Stdin) || flag.NArg() > 0
 	if c.unbufferedFlag {
 		buffered = false
@@ -70,7 +107,7 @@ func (c *luaCmd) run() (retcode int) {
 	}
 
 	// Get a Lua runtime
-	r := rt.New(nil)
+	r := rt.New(nil, rt.WithOptimisationLevel(c.optLevel))
 	c.pushContext(r)
 
 	cleanup := lib.LoadAll(r)
@@ -79,6 +116,47 @@ func (c *luaCmd) run() (retcode int) {
 	// Run finalizers before we exit
 	defer r.Close(nil)
 
+	if c.luaProfile != "" {
+		f, err := os.Create(c.luaProfile)
+		if err != nil {
+			return fatal("Could not create Lua profile: %s", err)
+		}
+		defer f.Close()
+		if err := r.StartLuaProfile(f, 0); err != nil {
+			return fatal("Could not start Lua profile: %s", err)
+		}
+		defer func() {
+			if err := r.StopLuaProfile(); err != nil {
+				retcode = fatal("Could not write Lua profile: %s", err)
+			}
+		}()
+	}
+
+	if c.coverProfile != "" {
+		var write func(*coverage.Profile, io.Writer) error
+		switch c.coverFormat {
+		case "lcov":
+			write = (*coverage.Profile).WriteLCOV
+		case "cobertura":
+			write = (*coverage.Profile).WriteCobertura
+		default:
+			return fatal("Unknown coverage format: %s", c.coverFormat)
+		}
+		f, err := os.Create(c.coverProfile)
+		if err != nil {
+			return fatal("Could not create coverage profile: %s", err)
+		}
+		defer f.Close()
+		if err := r.StartCoverage(); err != nil {
+			return fatal("Could not start coverage: %s", err)
+		}
+		defer func() {
+			if err := write(r.StopCoverage(), f); err != nil {
+				retcode = fatal("Could not write coverage profile: %s", err)
+			}
+		}()
+	}
+
 	if len(c.exec) == 0 && flag.NArg() == 0 {
 		chunkName = "<stdin>"
 		readStdin = true
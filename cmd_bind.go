@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/arnodel/golua/gobind"
+)
+
+// runBind implements "golua bind", which generates a Go file defining a Lua
+// module for a Go package (see package gobind).
+func runBind(args []string) int {
+	var (
+		flags   = flag.NewFlagSet("bind", flag.ExitOnError)
+		output  = flags.String("o", "", "write the generated file to `file` instead of stdout")
+		pkgName = flags.String("pkg", "", "package name of the generated file (default: <name>bind)")
+		luaName = flags.String("name", "", "name of the Lua module (default: the Go package name)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: golua bind [flags] package\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	src, err := gobind.Generate(gobind.Config{
+		Package:     flags.Arg(0),
+		OutPackage:  *pkgName,
+		LuaName:     *luaName,
+		CommandLine: strings.Join(append([]string{"golua bind"}, args...), " "),
+	})
+	if err != nil {
+		return fatal("%s", err)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return 0
+	}
+	if err := ioutil.WriteFile(*output, src, 0666); err != nil {
+		return fatal("Error writing '%s': %s", *output, err)
+	}
+	return 0
+}
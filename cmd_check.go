@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/arnodel/golua/lualint"
+)
+
+// runCheck implements "golua check", which reports likely mistakes in Lua
+// source files without running them.
+func runCheck(args []string) int {
+	var (
+		flags     = flag.NewFlagSet("check", flag.ExitOnError)
+		asJSON    = flags.Bool("json", false, "output diagnostics as a JSON array")
+		globals   = flags.String("globals", "", "comma separated list of additional known globals")
+		noStd     = flags.Bool("nostd", false, "do not consider standard library globals as known")
+		disable   = flags.String("disable", "", "comma separated list of diagnostic codes not to report")
+		cfg       lualint.Config
+		diags     = []lualint.Diagnostic{}
+		exitValue int
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: golua check [flags] [path ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg.Globals = splitList(*globals)
+	cfg.NoDefaultGlobals = *noStd
+	cfg.Disable = splitList(*disable)
+
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fatal("Error reading <stdin>: %s", err)
+		}
+		diags = append(diags, lualint.Check("<stdin>", src, cfg)...)
+	}
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			exitValue = fatal("Error reading '%s': %s", path, err)
+			continue
+		}
+		diags = append(diags, lualint.Check(path, src, cfg)...)
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(diags, "", "  ")
+		if err != nil {
+			return fatal("%s", err)
+		}
+		fmt.Printf("%s\n", out)
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+	if len(diags) > 0 && exitValue == 0 {
+		exitValue = 1
+	}
+	return exitValue
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
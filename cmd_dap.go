@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/arnodel/golua/dap"
+)
+
+// runDAP runs a DAP server, either on stdio or listening on a TCP address.  In
+// the latter case, it serves one client at a time until it is killed.
+func (c *luaCmd) runDAP() int {
+	server := new(dap.Server)
+	if c.dapAddr == "" {
+		// Stdout is used to talk to the client, so the output of Lua code
+		// which doesn't go through the runtime (e.g. io.write) is redirected
+		// to stderr.
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		if err := server.Serve(os.Stdin, out); err != nil {
+			return fatal("DAP error: %s", err)
+		}
+		return 0
+	}
+	l, err := net.Listen("tcp", c.dapAddr)
+	if err != nil {
+		return fatal("Error listening on %s: %s", c.dapAddr, err)
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "DAP server listening on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fatal("Error accepting connection: %s", err)
+		}
+		if err := server.Serve(conn, conn); err != nil {
+			fmt.Fprintf(os.Stderr, "DAP error: %s\n", err)
+		}
+		conn.Close()
+	}
+}
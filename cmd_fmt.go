@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/arnodel/golua/luafmt"
+)
+
+// runFmt implements "golua fmt", which formats Lua source files in the same
+// way as gofmt does for Go.
+func runFmt(args []string) int {
+	var (
+		flags     = flag.NewFlagSet("fmt", flag.ExitOnError)
+		write     = flags.Bool("w", false, "write result to source file instead of stdout")
+		list      = flags.Bool("l", false, "list files whose formatting differs from golua fmt's")
+		indent    = flags.Int("indent", 4, "number of spaces per indentation level")
+		tabs      = flags.Bool("tabs", false, "indent with tabs")
+		quote     = flags.String("quote", "double", "quote style for strings (double or single)")
+		cfg       luafmt.Config
+		exitValue int
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: golua fmt [flags] [path ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *tabs {
+		cfg.Indent = "\t"
+	} else {
+		cfg.Indent = strings.Repeat(" ", *indent)
+	}
+	switch *quote {
+	case "double":
+		cfg.Quote = '"'
+	case "single":
+		cfg.Quote = '\''
+	default:
+		return fatal("Unknown quote style: %s", *quote)
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			return fatal("Cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fatal("Error reading <stdin>: %s", err)
+		}
+		out, err := luafmt.Source("<stdin>", src, cfg)
+		if err != nil {
+			return fatal("%s", err)
+		}
+		if *list {
+			if !bytes.Equal(src, out) {
+				fmt.Println("<stdin>")
+			}
+			return 0
+		}
+		os.Stdout.Write(out)
+		return 0
+	}
+
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			exitValue = fatal("Error reading '%s': %s", path, err)
+			continue
+		}
+		out, err := luafmt.Source(path, src, cfg)
+		if err != nil {
+			exitValue = fatal("%s", err)
+			continue
+		}
+		changed := !bytes.Equal(src, out)
+		if *list && changed {
+			fmt.Println(path)
+		}
+		if *write {
+			if changed {
+				if err := ioutil.WriteFile(path, out, 0666); err != nil {
+					exitValue = fatal("Error writing '%s': %s", path, err)
+				}
+			}
+		} else if !*list {
+			os.Stdout.Write(out)
+		}
+	}
+	return exitValue
+}
@@ -3,6 +3,7 @@ package code
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // A Constant is a literal that can be loaded into a register (that include code
@@ -14,12 +15,42 @@ type Constant interface {
 // Code is a constant representing a chunk of code.  It doesn't contain any
 // actual opcodes, but refers to a range in the code unit it belongs to.
 type Code struct {
-	Name                   string   // Name of the function (if it has one)
-	StartOffset, EndOffset uint     // Where to find the opcode in the code Unit this belongs to
-	UpvalueCount           int16    // Number of upvalues
-	CellCount              int16    // Number of cell registers needed to run the code
-	RegCount               int16    // Number of registers needed to run the coee
-	UpNames                []string // Names of the upvalues
+	Name                   string     // Name of the function (if it has one)
+	StartOffset, EndOffset uint       // Where to find the opcode in the code Unit this belongs to
+	UpvalueCount           int16      // Number of upvalues
+	CellCount              int16      // Number of cell registers needed to run the code
+	RegCount               int16      // Number of registers needed to run the coee
+	UpNames                []string   // Names of the upvalues
+	ParamCount             int16      // Number of named parameters
+	IsVararg               bool       // True if the function has a "..." parameter
+	LineDefined            int32      // Line where the function definition starts (0 for a main chunk)
+	LastLineDefined        int32      // Line where the function definition ends (0 for a main chunk)
+	Locals                 []LocalVar // Scopes of the local variables
+}
+
+// A LocalVar records which register holds a local variable and the range of
+// instructions where it is in scope.  Offsets are relative to the start of the
+// function's code.
+//
+// Some names are used by the compiler for hidden local variables: the varargs
+// of a function are stored in a variable called "..." and other hidden
+// variables have a name starting with "<".
+type LocalVar struct {
+	Name        string
+	Reg         Reg
+	StartOffset uint32 // First instruction where the variable is in scope
+	EndOffset   uint32 // First instruction after the variable's scope
+}
+
+// IsHidden returns true if the local variable was introduced by the compiler
+// (so it does not correspond to a name in the source code).
+func (v LocalVar) IsHidden() bool {
+	return v.Name == "..." || strings.HasPrefix(v.Name, "<")
+}
+
+// InScope returns true if the variable is in scope at the given offset.
+func (v LocalVar) InScope(offset int) bool {
+	return offset >= int(v.StartOffset) && offset < int(v.EndOffset)
 }
 
 var _ Constant = Code{}
@@ -626,7 +626,7 @@ func (c Opcode) Disassemble(d OpcodeDisassembler, i int) string {
 		tpl := "???"
 		switch c.GetY() {
 		case OpInt16:
-			tpl = fmt.Sprint(n)
+			tpl = fmt.Sprint(n.ToInt16())
 		case OpStr2:
 			tpl = fmt.Sprintf("%q", n.ToStr2())
 		case OpK:
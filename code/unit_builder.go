@@ -67,6 +67,16 @@ func (c *Builder) Offset() uint {
 	return uint(len(c.code))
 }
 
+// Pos returns the current location, without the checks made by Offset.  It is
+// used to record where the scopes of local variables start and end, which
+// happens in the middle of a function body.  There may be unresolved jump
+// labels at that point (e.g. a forward jump out of a loop), which would make
+// Offset panic, and Offset also forgets the labels emitted so far, which would
+// break the jumps back to them emitted later.
+func (c *Builder) Pos() uint {
+	return uint(len(c.code))
+}
+
 // AddConstant adds a constant.
 func (c *Builder) AddConstant(k Constant) {
 	c.constants = append(c.constants, k)
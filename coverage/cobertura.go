@@ -0,0 +1,144 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The structure of a Cobertura report, see
+// http://cobertura.sourceforge.net/xml/coverage-04.dtd.
+type (
+	coberturaCoverage struct {
+		XMLName         xml.Name           `xml:"coverage"`
+		LineRate        float64            `xml:"line-rate,attr"`
+		BranchRate      float64            `xml:"branch-rate,attr"`
+		LinesCovered    int                `xml:"lines-covered,attr"`
+		LinesValid      int                `xml:"lines-valid,attr"`
+		BranchesCovered int                `xml:"branches-covered,attr"`
+		BranchesValid   int                `xml:"branches-valid,attr"`
+		Complexity      float64            `xml:"complexity,attr"`
+		Version         string             `xml:"version,attr"`
+		Timestamp       int64              `xml:"timestamp,attr"`
+		Sources         []string           `xml:"sources>source"`
+		Packages        []coberturaPackage `xml:"packages>package"`
+	}
+	coberturaPackage struct {
+		Name       string           `xml:"name,attr"`
+		LineRate   float64          `xml:"line-rate,attr"`
+		BranchRate float64          `xml:"branch-rate,attr"`
+		Complexity float64          `xml:"complexity,attr"`
+		Classes    []coberturaClass `xml:"classes>class"`
+	}
+	coberturaClass struct {
+		Name       string          `xml:"name,attr"`
+		Filename   string          `xml:"filename,attr"`
+		LineRate   float64         `xml:"line-rate,attr"`
+		BranchRate float64         `xml:"branch-rate,attr"`
+		Complexity float64         `xml:"complexity,attr"`
+		Methods    struct{}        `xml:"methods"`
+		Lines      []coberturaLine `xml:"lines>line"`
+	}
+	coberturaLine struct {
+		Number            int    `xml:"number,attr"`
+		Hits              int64  `xml:"hits,attr"`
+		Branch            bool   `xml:"branch,attr"`
+		ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+	}
+)
+
+// A coverageCount counts covered and valid lines or branches.
+type coverageCount struct {
+	covered, valid int
+}
+
+func (c *coverageCount) add(covered, valid int) {
+	c.covered += covered
+	c.valid += valid
+}
+
+func (c coverageCount) rate() float64 {
+	if c.valid == 0 {
+		return 1
+	}
+	return float64(c.covered) / float64(c.valid)
+}
+
+// WriteCobertura writes the profile to w in the Cobertura XML format.  Files
+// are grouped into packages according to their directory.
+func (p *Profile) WriteCobertura(w io.Writer) error {
+	var (
+		report              = coberturaCoverage{Version: "golua", Timestamp: time.Now().UnixNano() / int64(time.Millisecond)}
+		lines, branches     coverageCount
+		pkgs                = map[string]*coberturaPackage{}
+		pkgLines, pkgBranch = map[string]*coverageCount{}, map[string]*coverageCount{}
+		pkgNames            []string
+	)
+	for _, f := range p.SortedFiles() {
+		dir := filepath.Dir(f.Name)
+		pkg, ok := pkgs[dir]
+		if !ok {
+			pkg = &coberturaPackage{Name: dir}
+			pkgs[dir] = pkg
+			pkgLines[dir], pkgBranch[dir] = new(coverageCount), new(coverageCount)
+			pkgNames = append(pkgNames, dir)
+		}
+		fileLines := coverageCount{f.LinesCovered(), len(f.Lines)}
+		fileBranches := coverageCount{f.BranchesCovered(), len(f.Branches)}
+		class := coberturaClass{
+			Name:       filepath.Base(f.Name),
+			Filename:   f.Name,
+			LineRate:   fileLines.rate(),
+			BranchRate: fileBranches.rate(),
+		}
+		lineBranches := map[int]*coverageCount{}
+		for id, count := range f.Branches {
+			c, ok := lineBranches[id.Line]
+			if !ok {
+				c = new(coverageCount)
+				lineBranches[id.Line] = c
+			}
+			if count > 0 {
+				c.covered++
+			}
+			c.valid++
+		}
+		for _, l := range f.SortedLines() {
+			line := coberturaLine{Number: l, Hits: f.Lines[l]}
+			if c, ok := lineBranches[l]; ok {
+				line.Branch = true
+				line.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", c.covered*100/c.valid, c.covered, c.valid)
+			}
+			class.Lines = append(class.Lines, line)
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		pkgLines[dir].add(fileLines.covered, fileLines.valid)
+		pkgBranch[dir].add(fileBranches.covered, fileBranches.valid)
+		lines.add(fileLines.covered, fileLines.valid)
+		branches.add(fileBranches.covered, fileBranches.valid)
+	}
+	sort.Strings(pkgNames)
+	for _, name := range pkgNames {
+		pkg := pkgs[name]
+		pkg.LineRate = pkgLines[name].rate()
+		pkg.BranchRate = pkgBranch[name].rate()
+		report.Packages = append(report.Packages, *pkg)
+	}
+	report.LineRate, report.LinesCovered, report.LinesValid = lines.rate(), lines.covered, lines.valid
+	report.BranchRate, report.BranchesCovered, report.BranchesValid = branches.rate(), branches.covered, branches.valid
+	report.Sources = []string{"."}
+
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
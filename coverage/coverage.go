@@ -0,0 +1,159 @@
+// Package coverage represents code coverage profiles of Lua code and writes
+// them in the LCOV and Cobertura XML formats, which are understood by most CI
+// tools.
+//
+// Profiles are recorded by a runtime (see Runtime.StartCoverage in the runtime
+// package).  A profile records for each source file how many times each line
+// containing code was executed, and how many times each branch of conditional
+// jumps was taken.
+package coverage
+
+import (
+	"sort"
+)
+
+// A BranchID identifies a branch in a file.  Block is the index of the
+// conditional jump among the jumps on the same line and Branch is 0 when the
+// jump is not taken and 1 when it is taken.
+type BranchID struct {
+	Line, Block, Branch int
+}
+
+// A File contains the coverage data for a source file.
+type File struct {
+	Name     string             // The name of the source file
+	Lines    map[int]int64      // Hit count by line number, for lines with code
+	Branches map[BranchID]int64 // Hit count by branch
+}
+
+// NewFile returns a new empty File with the given name.
+func NewFile(name string) *File {
+	return &File{
+		Name:     name,
+		Lines:    map[int]int64{},
+		Branches: map[BranchID]int64{},
+	}
+}
+
+// AddLine adds count hits to a line.  A count of 0 records that the line
+// contains code.
+func (f *File) AddLine(line int, count int64) {
+	f.Lines[line] += count
+}
+
+// AddBranch adds count hits to a branch.  A count of 0 records that the branch
+// exists.
+func (f *File) AddBranch(id BranchID, count int64) {
+	f.Branches[id] += count
+}
+
+// SortedLines returns the lines with code in increasing order.
+func (f *File) SortedLines() []int {
+	lines := make([]int, 0, len(f.Lines))
+	for l := range f.Lines {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// SortedBranches returns the branches in increasing order of line, block and
+// branch.
+func (f *File) SortedBranches() []BranchID {
+	ids := make([]BranchID, 0, len(f.Branches))
+	for id := range f.Branches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		x, y := ids[i], ids[j]
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		if x.Block != y.Block {
+			return x.Block < y.Block
+		}
+		return x.Branch < y.Branch
+	})
+	return ids
+}
+
+// LinesCovered returns the number of lines with code which were executed.
+func (f *File) LinesCovered() int {
+	n := 0
+	for _, count := range f.Lines {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// BranchesCovered returns the number of branches which were taken.
+func (f *File) BranchesCovered() int {
+	n := 0
+	for _, count := range f.Branches {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// A Profile contains the coverage data for a set of source files.
+type Profile struct {
+	Files map[string]*File // Coverage data by source file name
+}
+
+// NewProfile returns a new empty Profile.
+func NewProfile() *Profile {
+	return &Profile{Files: map[string]*File{}}
+}
+
+// File returns the coverage data for the given source file name, creating it
+// if needed.
+func (p *Profile) File(name string) *File {
+	f, ok := p.Files[name]
+	if !ok {
+		f = NewFile(name)
+		p.Files[name] = f
+	}
+	return f
+}
+
+// SortedFiles returns the files in the profile sorted by name.
+func (p *Profile) SortedFiles() []*File {
+	files := make([]*File, 0, len(p.Files))
+	for _, f := range p.Files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// Merge adds the coverage data in q to p.  This is useful to combine the
+// coverage of several runs of the same source files.
+func (p *Profile) Merge(q *Profile) {
+	for name, qf := range q.Files {
+		pf := p.File(name)
+		for l, count := range qf.Lines {
+			pf.AddLine(l, count)
+		}
+		for id, count := range qf.Branches {
+			pf.AddBranch(id, count)
+		}
+	}
+}
+
+// Rename renames a source file in the profile.  If there already is a file
+// called newName, the coverage data are merged.
+func (p *Profile) Rename(oldName, newName string) {
+	f, ok := p.Files[oldName]
+	if !ok || oldName == newName {
+		return
+	}
+	delete(p.Files, oldName)
+	q := NewProfile()
+	f.Name = newName
+	q.Files[newName] = f
+	p.Merge(q)
+}
@@ -0,0 +1,93 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func testProfile() *Profile {
+	p := NewProfile()
+	f := p.File("scripts/rules.lua")
+	f.AddLine(1, 1)
+	f.AddLine(2, 3)
+	f.AddLine(3, 0)
+	f.AddBranch(BranchID{Line: 2, Block: 0, Branch: 0}, 3)
+	f.AddBranch(BranchID{Line: 2, Block: 0, Branch: 1}, 0)
+	f.AddBranch(BranchID{Line: 3, Block: 0, Branch: 0}, 0)
+	f.AddBranch(BranchID{Line: 3, Block: 0, Branch: 1}, 0)
+	g := p.File("main.lua")
+	g.AddLine(1, 2)
+	return p
+}
+
+func TestWriteLCOV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile().WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `TN:
+SF:main.lua
+BRF:0
+BRH:0
+DA:1,2
+LF:1
+LH:1
+end_of_record
+TN:
+SF:scripts/rules.lua
+BRDA:2,0,0,3
+BRDA:2,0,1,0
+BRDA:3,0,0,-
+BRDA:3,0,1,-
+BRF:4
+BRH:1
+DA:1,1
+DA:2,3
+DA:3,0
+LF:3
+LH:2
+end_of_record
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteCobertura(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile().WriteCobertura(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var report coberturaCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.LinesCovered != 3 || report.LinesValid != 4 || report.BranchesCovered != 1 || report.BranchesValid != 4 {
+		t.Errorf("unexpected totals: %+v", report)
+	}
+	if len(report.Packages) != 2 || report.Packages[0].Name != "." || report.Packages[1].Name != "scripts" {
+		t.Fatalf("unexpected packages: %+v", report.Packages)
+	}
+	class := report.Packages[1].Classes[0]
+	if class.Name != "rules.lua" || class.Filename != "scripts/rules.lua" || class.BranchRate != 0.25 {
+		t.Errorf("unexpected class: %+v", class)
+	}
+	line := class.Lines[1]
+	if line.Number != 2 || line.Hits != 3 || !line.Branch || line.ConditionCoverage != "50% (1/2)" {
+		t.Errorf("unexpected line: %+v", line)
+	}
+}
+
+func TestMergeAndRename(t *testing.T) {
+	p := testProfile()
+	p.Merge(testProfile())
+	p.Rename("main.lua", "scripts/rules.lua")
+	if len(p.Files) != 1 {
+		t.Fatalf("expected one file, got %d", len(p.Files))
+	}
+	f := p.Files["scripts/rules.lua"]
+	if f.Lines[1] != 6 || f.Lines[2] != 6 || f.Branches[BranchID{Line: 2}] != 6 {
+		t.Errorf("unexpected merged counts: %v %v", f.Lines, f.Branches)
+	}
+}
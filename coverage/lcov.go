@@ -0,0 +1,29 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteLCOV writes the profile to w in the LCOV tracefile format (as read by
+// e.g. genhtml).
+func (p *Profile) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range p.SortedFiles() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		for _, id := range f.SortedBranches() {
+			taken := "-" // The line was not executed
+			if f.Lines[id.Line] > 0 {
+				taken = fmt.Sprint(f.Branches[id])
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", id.Line, id.Block, id.Branch, taken)
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", len(f.Branches), f.BranchesCovered())
+		for _, l := range f.SortedLines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l, f.Lines[l])
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), f.LinesCovered())
+	}
+	return bw.Flush()
+}
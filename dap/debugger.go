@@ -0,0 +1,528 @@
+package dap
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A stepKind tells the debugger when to stop next.
+type stepKind uint8
+
+const (
+	stepNone stepKind = iota // Only stop at breakpoints
+	stepIn                   // Stop at the next line
+	stepOver                 // Stop at the next line in the same function or a caller
+	stepOut                  // Stop at the next line in a caller
+)
+
+// Reasons for stopping, as reported in the "stopped" event.
+const (
+	reasonEntry      = "entry"
+	reasonBreakpoint = "breakpoint"
+	reasonStep       = "step"
+	reasonPause      = "pause"
+)
+
+var errTerminated = errors.New("terminated by debugger")
+
+// A debugger controls the execution of Lua code via a line hook.  When
+// execution is stopped, the goroutine running the Lua code waits in the hook
+// for commands.  Inspecting the state of the runtime is done by sending
+// functions to execute in that goroutine, so the runtime is never accessed
+// concurrently.
+type debugger struct {
+	onStop func(reason string) // Called in the Lua goroutine when execution stops
+
+	mu             sync.Mutex
+	breakpoints    map[string]map[int32]bool // Lines with breakpoints, by absolute path
+	paths          map[string]string         // Cache of absolute paths of sources
+	step           stepKind
+	stepFrame      rt.Cont // Frame where stepping started
+	stepLine       int32   // Line where stepping started
+	stepDepth      int     // Stack depth where stepping started
+	stopOnEntry    bool
+	pauseRequested bool
+	terminated     bool
+	stopped        bool
+
+	cmds chan func() bool // Commands for the Lua goroutine while it is stopped
+
+	// The fields below are only accessed in the Lua goroutine while it is
+	// stopped.
+	frames []rt.Cont
+	refs   []varRef
+}
+
+func newDebugger(onStop func(reason string)) *debugger {
+	return &debugger{
+		onStop:      onStop,
+		breakpoints: map[string]map[int32]bool{},
+		paths:       map[string]string{},
+		cmds:        make(chan func() bool),
+	}
+}
+
+// setup installs the debugger hook in the thread.
+func (d *debugger) setup(t *rt.Thread) {
+	hook := rt.NewGoFunction(d.hook, "debugger", 2, false)
+	t.SetupHooks(rt.DebugHooks{
+		DebugHookFlags: rt.HookFlagLine,
+		Hook:           rt.FunctionValue(hook),
+	})
+}
+
+// setBreakpoints replaces the breakpoints in the given source file.
+func (d *debugger) setBreakpoints(path string, lines []int) {
+	bps := make(map[int32]bool, len(lines))
+	for _, l := range lines {
+		bps[int32(l)] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[absPath(path)] = bps
+}
+
+func (d *debugger) pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pauseRequested = true
+}
+
+// isStopped returns true if the Lua goroutine is waiting for commands.
+func (d *debugger) isStopped() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stopped
+}
+
+// resume makes the stopped Lua goroutine continue, stepping according to
+// step.
+func (d *debugger) resume(step stepKind) error {
+	return d.do(func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.step = step
+		d.stepFrame = nil
+		d.stepDepth = 0
+		if len(d.frames) > 0 {
+			d.stepFrame = d.frames[0]
+			d.stepLine = d.frames[0].DebugInfo().CurrentLine
+			d.stepDepth = stackDepth(d.frames[0])
+		}
+		return true
+	})
+}
+
+// terminate stops the execution of the Lua code at the next opportunity (i.e.
+// the next time the line hook is called).
+func (d *debugger) terminate() {
+	d.mu.Lock()
+	d.terminated = true
+	stopped := d.stopped
+	d.mu.Unlock()
+	if stopped {
+		_ = d.do(func() bool { return true })
+	}
+}
+
+func (d *debugger) isTerminated() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.terminated
+}
+
+// inspect runs f in the Lua goroutine, which must be stopped.
+func (d *debugger) inspect(f func()) error {
+	return d.do(func() bool {
+		f()
+		return false
+	})
+}
+
+// do sends a command to the stopped Lua goroutine and waits for it to be
+// executed.  If the command returns true, execution resumes.
+func (d *debugger) do(cmd func() bool) error {
+	if !d.isStopped() {
+		return errors.New("not stopped")
+	}
+	done := make(chan struct{})
+	d.cmds <- func() bool {
+		defer close(done)
+		return cmd()
+	}
+	<-done
+	return nil
+}
+
+// hook is the Lua debug hook.  It is called with the event and the line number
+// and c.Next() is a termination standing for the function being run.
+func (d *debugger) hook(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	next := c.Next()
+	if c.NArgs() < 2 {
+		return next, nil
+	}
+	line, ok := c.Arg(1).TryInt()
+	if !ok {
+		return next, nil
+	}
+	frame := next
+	if term, ok := frame.(*rt.Termination); ok {
+		frame = term.Origin()
+	}
+	if frame == nil {
+		return next, nil
+	}
+	reason, err := d.shouldStop(frame, int32(line))
+	if err != nil || reason == "" {
+		return next, err
+	}
+	d.stop(frame, reason)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.terminated {
+		return nil, errTerminated
+	}
+	return next, nil
+}
+
+// shouldStop returns the reason for stopping at the given line in frame, or ""
+// if execution should carry on.
+func (d *debugger) shouldStop(frame rt.Cont, line int32) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.terminated:
+		return "", errTerminated
+	case d.stopOnEntry:
+		d.stopOnEntry = false
+		return reasonEntry, nil
+	case d.pauseRequested:
+		d.pauseRequested = false
+		return reasonPause, nil
+	}
+	if len(d.breakpoints) > 0 {
+		if info := frame.DebugInfo(); info != nil && d.breakpoints[d.absPath(info.Source)][line] {
+			return reasonBreakpoint, nil
+		}
+	}
+	if d.step == stepNone {
+		return "", nil
+	}
+	// When returning from a call, the line hook is called again for the line
+	// that made the call, so we need to skip it.
+	sameLine := frame == d.stepFrame && line == d.stepLine
+	switch d.step {
+	case stepIn:
+		if sameLine {
+			return "", nil
+		}
+	case stepOver:
+		if sameLine || stackDepth(frame) > d.stepDepth {
+			return "", nil
+		}
+	case stepOut:
+		if stackDepth(frame) >= d.stepDepth {
+			return "", nil
+		}
+	}
+	return reasonStep, nil
+}
+
+// stop waits for commands until one of them says to resume execution.
+func (d *debugger) stop(frame rt.Cont, reason string) {
+	d.frames = d.frames[:0]
+	for c := frame; c != nil; c = c.Parent() {
+		if c.DebugInfo() != nil {
+			d.frames = append(d.frames, c)
+		}
+	}
+	d.refs = d.refs[:0]
+	d.mu.Lock()
+	d.stopped = true
+	d.step = stepNone
+	d.mu.Unlock()
+
+	d.onStop(reason)
+	for cmd := range d.cmds {
+		if cmd() {
+			break
+		}
+	}
+
+	d.mu.Lock()
+	d.stopped = false
+	d.mu.Unlock()
+	d.frames = d.frames[:0]
+	d.refs = d.refs[:0]
+}
+
+func (d *debugger) absPath(source string) string {
+	path, ok := d.paths[source]
+	if !ok {
+		path = absPath(source)
+		d.paths[source] = path
+	}
+	return path
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+func stackDepth(c rt.Cont) int {
+	depth := 0
+	for ; c != nil; c = c.Parent() {
+		depth++
+	}
+	return depth
+}
+
+//
+// Inspection of the stopped state.  The methods below must only be called via
+// debugger.inspect().
+//
+
+// A varRef is something that has children variables.
+type varRef struct {
+	kind  varRefKind
+	frame rt.Cont  // For locals and upvalues
+	value rt.Value // For tables
+}
+
+type varRefKind uint8
+
+const (
+	localsRef varRefKind = iota
+	upvaluesRef
+	tableRef
+)
+
+// newRef returns a new variables reference (variables references start at 1).
+func (d *debugger) newRef(ref varRef) int {
+	d.refs = append(d.refs, ref)
+	return len(d.refs)
+}
+
+func (d *debugger) stackTrace(start, levels int) ([]StackFrame, int) {
+	frames := []StackFrame{}
+	for i := start; i < len(d.frames) && (levels <= 0 || i < start+levels); i++ {
+		info := d.frames[i].DebugInfo()
+		frame := StackFrame{
+			ID:     i + 1,
+			Name:   info.Name,
+			Line:   int(info.CurrentLine),
+			Column: 1,
+		}
+		if info.What == "C" {
+			frame.PresentationHint = "subtle"
+			frame.Line = 0
+			frame.Column = 0
+		} else {
+			frame.Source = &Source{
+				Name: filepath.Base(info.Source),
+				Path: d.absPath(info.Source),
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames, len(d.frames)
+}
+
+func (d *debugger) frame(id int) (rt.Cont, error) {
+	if id < 1 || id > len(d.frames) {
+		return nil, errors.New("invalid frame id")
+	}
+	return d.frames[id-1], nil
+}
+
+func (d *debugger) scopes(frameID int) ([]Scope, error) {
+	frame, err := d.frame(frameID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []Scope{}
+	if _, ok := luaFrame(frame); ok {
+		scopes = append(scopes,
+			Scope{
+				Name:               "Locals",
+				PresentationHint:   "locals",
+				VariablesReference: d.newRef(varRef{kind: localsRef, frame: frame}),
+			},
+			Scope{
+				Name:               "Upvalues",
+				VariablesReference: d.newRef(varRef{kind: upvaluesRef, frame: frame}),
+			},
+		)
+	}
+	return scopes, nil
+}
+
+func (d *debugger) variables(ref int) ([]Variable, error) {
+	if ref < 1 || ref > len(d.refs) {
+		return nil, errors.New("invalid variables reference")
+	}
+	vars := []Variable{}
+	switch r := d.refs[ref-1]; r.kind {
+	case localsRef:
+		cont, _ := luaFrame(r.frame)
+		for i := 1; ; i++ {
+			name, val, ok := cont.GetLocal(i)
+			if !ok {
+				break
+			}
+			vars = append(vars, d.variable(name, val))
+		}
+		for i := 1; ; i++ {
+			_, val, ok := cont.GetLocal(-i)
+			if !ok {
+				break
+			}
+			vars = append(vars, d.variable("..."+strconv.Itoa(i), val))
+		}
+	case upvaluesRef:
+		cont, _ := luaFrame(r.frame)
+		for i, name := range cont.UpNames {
+			vars = append(vars, d.variable(name, cont.GetUpvalue(i)))
+		}
+	case tableRef:
+		tbl := r.value.AsTable()
+		var keys []rt.Value
+		for k, _, ok := tbl.Next(rt.NilValue); ok && !k.IsNil(); k, _, ok = tbl.Next(k) {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keyLess(keys[i], keys[j])
+		})
+		for _, k := range keys {
+			vars = append(vars, d.variable(keyName(k), tbl.Get(k)))
+		}
+	}
+	return vars, nil
+}
+
+// evaluate returns the value of a variable visible in the given frame (this
+// does not support general expressions).
+func (d *debugger) evaluate(expr string, frameID int) (Variable, error) {
+	frame, err := d.frame(frameID)
+	if err != nil {
+		return Variable{}, err
+	}
+	cont, ok := luaFrame(frame)
+	if !ok {
+		return Variable{}, errors.New("not a Lua function")
+	}
+	// The innermost local variable with that name wins.
+	var (
+		val   rt.Value
+		found bool
+	)
+	for i := 1; ; i++ {
+		name, v, ok := cont.GetLocal(i)
+		if !ok {
+			break
+		}
+		if name == expr {
+			val, found = v, true
+		}
+	}
+	if !found {
+		for i, name := range cont.UpNames {
+			if name == expr {
+				val, found = cont.GetUpvalue(i), true
+				break
+			}
+		}
+	}
+	if !found {
+		env, _ := d.lookupEnv(cont)
+		if env == nil {
+			return Variable{}, errors.New("unknown variable: " + expr)
+		}
+		val = env.Get(rt.StringValue(expr))
+	}
+	return d.variable(expr, val), nil
+}
+
+// lookupEnv returns the _ENV table visible from a Lua continuation.
+func (d *debugger) lookupEnv(cont *rt.LuaCont) (*rt.Table, bool) {
+	for i, name := range cont.UpNames {
+		if name == "_ENV" {
+			return cont.GetUpvalue(i).TryTable()
+		}
+	}
+	return nil, false
+}
+
+func (d *debugger) variable(name string, val rt.Value) Variable {
+	v := Variable{
+		Name:  name,
+		Value: valueString(val),
+		Type:  val.TypeName(),
+	}
+	if _, ok := val.TryTable(); ok {
+		v.VariablesReference = d.newRef(varRef{kind: tableRef, value: val})
+	}
+	return v
+}
+
+// luaFrame returns the Lua continuation that c stands for, if there is one.
+func luaFrame(c rt.Cont) (*rt.LuaCont, bool) {
+	for {
+		switch cc := c.(type) {
+		case *rt.LuaCont:
+			return cc, true
+		case *rt.Termination:
+			c = cc.Origin()
+		default:
+			return nil, false
+		}
+	}
+}
+
+func valueString(v rt.Value) string {
+	if s, ok := v.TryString(); ok {
+		return strconv.Quote(s)
+	}
+	s, _ := v.ToString()
+	return s
+}
+
+func keyName(k rt.Value) string {
+	if s, ok := k.TryString(); ok {
+		return s
+	}
+	return "[" + valueString(k) + "]"
+}
+
+// keyLess orders table keys so that numbers come first, in increasing order,
+// then strings in lexicographic order, then other values.
+func keyLess(k1, k2 rt.Value) bool {
+	isNum1 := k1.NumberType() != rt.UnknownType
+	isNum2 := k2.NumberType() != rt.UnknownType
+	switch {
+	case isNum1 && isNum2:
+		n1, _ := rt.ToFloat(k1)
+		n2, _ := rt.ToFloat(k2)
+		return n1 < n2
+	case isNum1 != isNum2:
+		return isNum1
+	}
+	s1, isStr1 := k1.TryString()
+	s2, isStr2 := k2.TryString()
+	switch {
+	case isStr1 && isStr2:
+		return s1 < s2
+	case isStr1 != isStr2:
+		return isStr1
+	}
+	return valueString(k1) < valueString(k2)
+}
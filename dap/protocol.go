@@ -0,0 +1,278 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file contains the subset of the Debug Adapter Protocol that the server
+// implements, see https://microsoft.github.io/debug-adapter-protocol/specification.
+
+// Request is a request sent by the client.
+type Request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Response is sent by the server in reply to a request.
+type Response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// Event is sent by the server to notify the client of a change of state.
+type Event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Capabilities is the body of the response to the "initialize" request.
+type Capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+}
+
+// LaunchArguments are the arguments of the "launch" request.
+type LaunchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args,omitempty"`
+	StopOnEntry bool     `json:"stopOnEntry,omitempty"`
+	NoDebug     bool     `json:"noDebug,omitempty"`
+}
+
+// Source identifies a source file.
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// SourceBreakpoint is a breakpoint requested by the client.
+type SourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+// SetBreakpointsArguments are the arguments of the "setBreakpoints" request.
+type SetBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []SourceBreakpoint `json:"breakpoints,omitempty"`
+	Lines       []int              `json:"lines,omitempty"` // Deprecated by the protocol
+}
+
+// Breakpoint is a breakpoint as set by the server.
+type Breakpoint struct {
+	ID       int     `json:"id,omitempty"`
+	Verified bool    `json:"verified"`
+	Line     int     `json:"line,omitempty"`
+	Source   *Source `json:"source,omitempty"`
+}
+
+// Thread is a thread as reported by the "threads" request.
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// ThreadArguments are the arguments of requests that apply to a thread
+// ("continue", "next", "stepIn", "stepOut", "pause").
+type ThreadArguments struct {
+	ThreadID int `json:"threadId"`
+}
+
+// StackTraceArguments are the arguments of the "stackTrace" request.
+type StackTraceArguments struct {
+	ThreadID   int `json:"threadId"`
+	StartFrame int `json:"startFrame,omitempty"`
+	Levels     int `json:"levels,omitempty"`
+}
+
+// StackFrame is a frame in a stack trace.
+type StackFrame struct {
+	ID               int     `json:"id"`
+	Name             string  `json:"name"`
+	Source           *Source `json:"source,omitempty"`
+	Line             int     `json:"line"`
+	Column           int     `json:"column"`
+	PresentationHint string  `json:"presentationHint,omitempty"`
+}
+
+// ScopesArguments are the arguments of the "scopes" request.
+type ScopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+// Scope is a set of variables in a stack frame.
+type Scope struct {
+	Name               string `json:"name"`
+	PresentationHint   string `json:"presentationHint,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+// VariablesArguments are the arguments of the "variables" request.
+type VariablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+// Variable is a name / value pair.  If VariablesReference is not 0, the value
+// has children which can be retrieved with a "variables" request.
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// EvaluateArguments are the arguments of the "evaluate" request.
+type EvaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+// StoppedEventBody is the body of the "stopped" event.
+type StoppedEventBody struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+// OutputEventBody is the body of the "output" event.
+type OutputEventBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+// ExitedEventBody is the body of the "exited" event.
+type ExitedEventBody struct {
+	ExitCode int `json:"exitCode"`
+}
+
+// A conn reads requests from the client and writes responses and events to it.
+// Writing is safe for concurrent use.
+type conn struct {
+	r *bufio.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// readRequest reads the next request sent by the client.  It returns io.EOF if
+// there are no more requests.
+func (c *conn) readRequest() (*Request, error) {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := cutHeader(line)
+		if !ok {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		if name == "Content-Length" {
+			length, err = strconv.Atoi(value)
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length: %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(c.r, content); err != nil {
+		return nil, err
+	}
+	req := new(Request)
+	if err := json.Unmarshal(content, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func cutHeader(line string) (name, value string, ok bool) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+}
+
+// respond sends a successful response to req.
+func (c *conn) respond(req *Request, body interface{}) error {
+	return c.send(&Response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    true,
+		Command:    req.Command,
+		Body:       body,
+	})
+}
+
+// respondError sends an error response to req.
+func (c *conn) respondError(req *Request, err error) error {
+	return c.send(&Response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Message:    err.Error(),
+	})
+}
+
+// event sends an event.
+func (c *conn) event(name string, body interface{}) error {
+	return c.send(&Event{
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+func (c *conn) send(msg interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	switch m := msg.(type) {
+	case *Response:
+		m.Seq = c.seq
+	case *Event:
+		m.Seq = c.seq
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(content)
+	return err
+}
@@ -0,0 +1,375 @@
+// Package dap implements a Debug Adapter Protocol server for golua, so that
+// Lua scripts can be debugged from editors that support the protocol.
+//
+// A session is started by calling Server.Serve with a client connection.  The
+// server supports launching a script, breakpoints by file and line, stepping
+// in / over / out, pausing, stack traces and inspection of local variables,
+// upvalues and tables.  Only the main thread of the runtime is debugged.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The id of the only thread reported to the client.
+const mainThreadID = 1
+
+// A Server serves DAP sessions.
+type Server struct {
+	// NewRuntime is used to create the runtime the debugged script runs in.
+	// The runtime's output must be sent to stdout.  It returns the runtime
+	// and a cleanup function.  If nil, a runtime with all the standard
+	// libraries loaded is created.
+	NewRuntime func(stdout io.Writer) (*rt.Runtime, func())
+}
+
+// Serve runs a debug session, reading requests from in and writing responses
+// and events to out.  It returns when the client disconnects.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	sess := &session{
+		server: s,
+		conn:   newConn(in, out),
+	}
+	sess.debugger = newDebugger(sess.stopped)
+	defer sess.terminate()
+	for {
+		req, err := sess.conn.readRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done, err := sess.handle(req)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+type session struct {
+	server   *Server
+	conn     *conn
+	debugger *debugger
+
+	runtime *rt.Runtime
+	cleanup func()
+	program rt.Value
+	args    []rt.Value
+	noDebug bool
+
+	mu       sync.Mutex
+	running  bool
+	finished chan struct{} // Closed when the program has finished running
+}
+
+func (s *session) handle(req *Request) (done bool, err error) {
+	var body interface{}
+	switch req.Command {
+	case "initialize":
+		body, err = s.initialize(req)
+	case "launch":
+		body, err = s.launch(req)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []Breakpoint{}}
+	case "configurationDone":
+		err = s.configurationDone()
+	case "threads":
+		body = map[string]interface{}{
+			"threads": []Thread{{ID: mainThreadID, Name: "main"}},
+		}
+	case "stackTrace":
+		body, err = s.stackTrace(req)
+	case "scopes":
+		body, err = s.scopes(req)
+	case "variables":
+		body, err = s.variables(req)
+	case "evaluate":
+		body, err = s.evaluate(req)
+	case "continue":
+		body = map[string]interface{}{"allThreadsContinued": true}
+		return false, s.resume(req, body, stepNone)
+	case "next":
+		return false, s.resume(req, nil, stepOver)
+	case "stepIn":
+		return false, s.resume(req, nil, stepIn)
+	case "stepOut":
+		return false, s.resume(req, nil, stepOut)
+	case "pause":
+		s.debugger.pause()
+	case "terminate", "disconnect":
+		// Respond first, as terminating causes "exited" and "terminated"
+		// events to be sent.
+		if err := s.conn.respond(req, nil); err != nil {
+			return true, err
+		}
+		s.terminate()
+		return req.Command == "disconnect", nil
+	default:
+		err = fmt.Errorf("unsupported command: %s", req.Command)
+	}
+	if err != nil {
+		return done, s.conn.respondError(req, err)
+	}
+	if err := s.conn.respond(req, body); err != nil {
+		return true, err
+	}
+	if req.Command == "initialize" {
+		return false, s.conn.event("initialized", nil)
+	}
+	return done, nil
+}
+
+func (s *session) initialize(req *Request) (interface{}, error) {
+	return &Capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsTerminateRequest:         true,
+		SupportsEvaluateForHovers:        true,
+	}, nil
+}
+
+func (s *session) launch(req *Request) (interface{}, error) {
+	var args LaunchArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	if args.Program == "" {
+		return nil, errors.New("missing program")
+	}
+	if s.runtime != nil {
+		return nil, errors.New("already launched")
+	}
+	chunk, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return nil, err
+	}
+	stdout := &outputWriter{conn: s.conn, category: "stdout"}
+	var r *rt.Runtime
+	var cleanup func()
+	if s.server.NewRuntime != nil {
+		r, cleanup = s.server.NewRuntime(stdout)
+	} else {
+		r = rt.New(stdout)
+		cleanup = lib.LoadAll(r)
+	}
+	clos, err := r.LoadFromSourceOrCode(args.Program, chunk, "bt", rt.TableValue(r.GlobalEnv()), true)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	s.runtime = r
+	s.cleanup = cleanup
+	s.program = rt.FunctionValue(clos)
+	s.noDebug = args.NoDebug
+	for _, arg := range args.Args {
+		s.args = append(s.args, rt.StringValue(arg))
+	}
+	s.debugger.stopOnEntry = args.StopOnEntry && !args.NoDebug
+	return nil, nil
+}
+
+func (s *session) setBreakpoints(req *Request) (interface{}, error) {
+	var args SetBreakpointsArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	lines := args.Lines
+	if args.Breakpoints != nil {
+		lines = nil
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+	}
+	s.debugger.setBreakpoints(args.Source.Path, lines)
+	source := args.Source
+	bps := make([]Breakpoint, len(lines))
+	for i, l := range lines {
+		bps[i] = Breakpoint{Verified: true, Line: l, Source: &source}
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+// configurationDone starts running the program.
+func (s *session) configurationDone() error {
+	if s.runtime == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errors.New("already running")
+	}
+	s.running = true
+	s.finished = make(chan struct{})
+	go s.run()
+	return nil
+}
+
+// run runs the program in the current goroutine.
+func (s *session) run() {
+	defer close(s.finished)
+	defer s.cleanup()
+	defer s.runtime.Close(nil)
+	t := s.runtime.MainThread()
+	if !s.noDebug {
+		s.debugger.setup(t)
+	}
+	exitCode := 0
+	err := rt.Call(t, s.program, s.args, rt.NewTerminationWith(nil, 0, false))
+	if err != nil {
+		exitCode = 1
+		if !errors.Is(err, errTerminated) && !s.debugger.isTerminated() {
+			_ = s.conn.event("output", &OutputEventBody{
+				Category: "stderr",
+				Output:   err.Error() + "\n",
+			})
+		}
+	}
+	_ = s.conn.event("exited", &ExitedEventBody{ExitCode: exitCode})
+	_ = s.conn.event("terminated", nil)
+}
+
+// terminate stops the program if it is running.
+func (s *session) terminate() {
+	s.debugger.terminate()
+}
+
+// stopped is called by the debugger when execution stops.
+func (s *session) stopped(reason string) {
+	_ = s.conn.event("stopped", &StoppedEventBody{
+		Reason:            reason,
+		ThreadID:          mainThreadID,
+		AllThreadsStopped: true,
+	})
+}
+
+func (s *session) resume(req *Request, body interface{}, step stepKind) error {
+	if !s.debugger.isStopped() {
+		return s.conn.respondError(req, errors.New("not stopped"))
+	}
+	// The response must be sent before execution resumes, otherwise the
+	// client could receive a "stopped" event before it.
+	if err := s.conn.respond(req, body); err != nil {
+		return err
+	}
+	_ = s.debugger.resume(step)
+	return nil
+}
+
+func (s *session) stackTrace(req *Request) (interface{}, error) {
+	var args StackTraceArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	var (
+		frames []StackFrame
+		total  int
+	)
+	err := s.debugger.inspect(func() {
+		frames, total = s.debugger.stackTrace(args.StartFrame, args.Levels)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"stackFrames": frames,
+		"totalFrames": total,
+	}, nil
+}
+
+func (s *session) scopes(req *Request) (interface{}, error) {
+	var args ScopesArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	var (
+		scopes []Scope
+		err    error
+	)
+	if ierr := s.debugger.inspect(func() {
+		scopes, err = s.debugger.scopes(args.FrameID)
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *session) variables(req *Request) (interface{}, error) {
+	var args VariablesArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	var (
+		vars []Variable
+		err  error
+	)
+	if ierr := s.debugger.inspect(func() {
+		vars, err = s.debugger.variables(args.VariablesReference)
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *session) evaluate(req *Request) (interface{}, error) {
+	var args EvaluateArguments
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	var (
+		v   Variable
+		err error
+	)
+	if ierr := s.debugger.inspect(func() {
+		v, err = s.debugger.evaluate(args.Expression, args.FrameID)
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"result":             v.Value,
+		"type":               v.Type,
+		"variablesReference": v.VariablesReference,
+	}, nil
+}
+
+func unmarshalArgs(req *Request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, args)
+}
+
+// An outputWriter sends what is written to it as "output" events.
+type outputWriter struct {
+	conn     *conn
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if err := w.conn.event("output", &OutputEventBody{
+		Category: w.category,
+		Output:   string(p),
+	}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
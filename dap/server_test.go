@@ -0,0 +1,342 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A testClient is a scripted DAP client talking to a server running in the
+// same process.
+type testClient struct {
+	t      *testing.T
+	w      io.Writer
+	seq    int
+	msgs   chan map[string]interface{}
+	output strings.Builder
+	done   chan error
+}
+
+func startSession(t *testing.T) *testClient {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	c := &testClient{
+		t:    t,
+		w:    clientW,
+		msgs: make(chan map[string]interface{}, 100),
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- new(Server).Serve(serverR, serverW)
+		serverW.Close()
+	}()
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(clientR)
+		for {
+			var length int
+			if _, err := fmt.Fscanf(r, "Content-Length: %d\r\n\r\n", &length); err != nil {
+				return
+			}
+			content := make([]byte, length)
+			if _, err := io.ReadFull(r, content); err != nil {
+				return
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(content, &msg); err != nil {
+				t.Errorf("invalid message: %s", err)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *testClient) send(command string, args interface{}) {
+	c.t.Helper()
+	c.seq++
+	content, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next returns the next message which is not an output event.
+func (c *testClient) next() map[string]interface{} {
+	c.t.Helper()
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatal("connection closed")
+			}
+			if msg["type"] == "event" && msg["event"] == "output" {
+				body := msg["body"].(map[string]interface{})
+				c.output.WriteString(body["output"].(string))
+				continue
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			c.t.Fatal("timeout waiting for message")
+		}
+	}
+}
+
+// request sends a request and returns the body of the response, which must be
+// successful.
+func (c *testClient) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.send(command, args)
+	msg := c.next()
+	if msg["type"] != "response" || msg["command"] != command {
+		c.t.Fatalf("expected %s response, got %v", command, msg)
+	}
+	if msg["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, msg["message"])
+	}
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+func (c *testClient) expectEvent(event string) map[string]interface{} {
+	c.t.Helper()
+	msg := c.next()
+	if msg["type"] != "event" || msg["event"] != event {
+		c.t.Fatalf("expected %s event, got %v", event, msg)
+	}
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+// expectStop waits for a stopped event with the given reason and returns the
+// name and line of the top frame.
+func (c *testClient) expectStop(reason string) (string, int) {
+	c.t.Helper()
+	body := c.expectEvent("stopped")
+	if body["reason"] != reason {
+		c.t.Fatalf("expected stop reason %s, got %v", reason, body["reason"])
+	}
+	frames := c.stackTrace()
+	return frames[0]["name"].(string), int(frames[0]["line"].(float64))
+}
+
+func (c *testClient) stackTrace() []map[string]interface{} {
+	c.t.Helper()
+	body := c.request("stackTrace", map[string]interface{}{"threadId": mainThreadID})
+	var frames []map[string]interface{}
+	for _, f := range body["stackFrames"].([]interface{}) {
+		frames = append(frames, f.(map[string]interface{}))
+	}
+	return frames
+}
+
+// variables returns the variables for a reference, formatted as "name=value"
+// strings, and a map from names to variables references.
+func (c *testClient) variables(ref int) ([]string, map[string]int) {
+	c.t.Helper()
+	body := c.request("variables", map[string]interface{}{"variablesReference": ref})
+	var vars []string
+	refs := map[string]int{}
+	for _, v := range body["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		name := v["name"].(string)
+		vars = append(vars, name+"="+v["value"].(string))
+		refs[name] = int(v["variablesReference"].(float64))
+	}
+	return vars, refs
+}
+
+// scopes returns the variables references of the scopes of a frame, by name.
+func (c *testClient) scopes(frameID int) map[string]int {
+	c.t.Helper()
+	body := c.request("scopes", map[string]interface{}{"frameId": frameID})
+	refs := map[string]int{}
+	for _, s := range body["scopes"].([]interface{}) {
+		s := s.(map[string]interface{})
+		refs[s["name"].(string)] = int(s["variablesReference"].(float64))
+	}
+	return refs
+}
+
+func (c *testClient) launch(program string, stopOnEntry bool, breakpoints ...int) {
+	c.t.Helper()
+	c.request("initialize", map[string]interface{}{"adapterID": "golua"})
+	c.expectEvent("initialized")
+	c.request("launch", map[string]interface{}{"program": program, "stopOnEntry": stopOnEntry})
+	var bps []map[string]interface{}
+	for _, l := range breakpoints {
+		bps = append(bps, map[string]interface{}{"line": l})
+	}
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": bps,
+	})
+	if n := len(body["breakpoints"].([]interface{})); n != len(breakpoints) {
+		c.t.Fatalf("expected %d breakpoints, got %d", len(breakpoints), n)
+	}
+	c.request("configurationDone", nil)
+}
+
+func (c *testClient) disconnect() {
+	c.t.Helper()
+	c.request("disconnect", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func writeProgram(t *testing.T, src string) string {
+	path := filepath.Join(t.TempDir(), "prog.lua")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testProgram = `local function add(a, b)
+    local s = a + b
+    return s
+end
+local t = {x = 1, 10}
+local r = add(1, 2)
+print(r)
+r = add(r, 3)
+print(r)
+`
+
+func checkStop(t *testing.T, c *testClient, reason string, expectedName string, expectedLine int) {
+	t.Helper()
+	name, line := c.expectStop(reason)
+	if name != expectedName || line != expectedLine {
+		t.Fatalf("expected to stop in %s at line %d, got %s at line %d", expectedName, expectedLine, name, line)
+	}
+}
+
+func checkStrings(t *testing.T, what string, got []string, expected ...string) {
+	t.Helper()
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("%s: expected %q, got %q", what, expected, got)
+	}
+}
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	c := startSession(t)
+	c.launch(writeProgram(t, testProgram), false, 2)
+
+	checkStop(t, c, "breakpoint", "add", 2)
+	frames := c.stackTrace()
+	if len(frames) != 2 || int(frames[1]["line"].(float64)) != 6 {
+		t.Fatalf("unexpected stack trace: %v", frames)
+	}
+	scopes := c.scopes(1)
+	vars, _ := c.variables(scopes["Locals"])
+	checkStrings(t, "locals", vars, "a=1", "b=2")
+
+	c.request("next", map[string]interface{}{"threadId": mainThreadID})
+	checkStop(t, c, "step", "add", 3)
+	vars, _ = c.variables(c.scopes(1)["Locals"])
+	checkStrings(t, "locals", vars, "a=1", "b=2", "s=3")
+
+	c.request("stepOut", map[string]interface{}{"threadId": mainThreadID})
+	checkStop(t, c, "step", "<main chunk>", 7)
+	vars, refs := c.variables(c.scopes(1)["Locals"])
+	if len(vars) != 3 || !strings.HasPrefix(vars[0], "add=function: ") || !strings.HasPrefix(vars[1], "t=table: ") || vars[2] != "r=3" {
+		t.Fatalf("unexpected locals: %q", vars)
+	}
+	vars, _ = c.variables(refs["t"])
+	checkStrings(t, "table", vars, "[1]=10", `x=1`)
+
+	body := c.request("evaluate", map[string]interface{}{"expression": "r", "frameId": 1})
+	if body["result"] != "3" {
+		t.Fatalf("expected r to evaluate to 3, got %v", body["result"])
+	}
+
+	// Clear the breakpoint so it doesn't interfere with stepping.
+	c.request("setBreakpoints", map[string]interface{}{
+		"source": map[string]interface{}{"path": frames[0]["source"].(map[string]interface{})["path"]},
+	})
+	c.request("next", map[string]interface{}{"threadId": mainThreadID})
+	checkStop(t, c, "step", "<main chunk>", 8)
+	c.request("stepIn", map[string]interface{}{"threadId": mainThreadID})
+	checkStop(t, c, "step", "add", 2)
+
+	c.request("continue", map[string]interface{}{"threadId": mainThreadID})
+	if code := c.expectEvent("exited")["exitCode"]; code != 0.0 {
+		t.Fatalf("expected exit code 0, got %v", code)
+	}
+	c.expectEvent("terminated")
+	if c.output.String() != "3\n6\n" {
+		t.Fatalf("unexpected output: %q", c.output.String())
+	}
+	c.disconnect()
+}
+
+func TestStopOnEntryAndUpvalues(t *testing.T) {
+	c := startSession(t)
+	c.launch(writeProgram(t, testProgram), true)
+
+	checkStop(t, c, "entry", "<main chunk>", 1)
+	c.request("next", map[string]interface{}{"threadId": mainThreadID})
+	checkStop(t, c, "step", "<main chunk>", 5)
+	vars, _ := c.variables(c.scopes(1)["Upvalues"])
+	if len(vars) != 1 || !strings.HasPrefix(vars[0], "_ENV=table: ") {
+		t.Fatalf("unexpected upvalues: %q", vars)
+	}
+	c.request("continue", map[string]interface{}{"threadId": mainThreadID})
+	c.expectEvent("exited")
+	c.expectEvent("terminated")
+	c.disconnect()
+}
+
+func TestPauseAndTerminate(t *testing.T) {
+	c := startSession(t)
+	c.launch(writeProgram(t, "local n = 0\nwhile true do\n  n = n + 1\nend\n"), false)
+
+	c.request("pause", map[string]interface{}{"threadId": mainThreadID})
+	name, _ := c.expectStop("pause")
+	if name != "<main chunk>" {
+		t.Fatalf("unexpected frame: %s", name)
+	}
+	c.request("terminate", nil)
+	c.expectEvent("exited")
+	c.expectEvent("terminated")
+	if c.output.String() != "" {
+		t.Fatalf("unexpected output: %q", c.output.String())
+	}
+	c.disconnect()
+}
+
+func TestErrors(t *testing.T) {
+	c := startSession(t)
+	c.request("initialize", nil)
+	c.expectEvent("initialized")
+
+	c.send("launch", map[string]interface{}{"program": filepath.Join(t.TempDir(), "missing.lua")})
+	if msg := c.next(); msg["success"] != false {
+		t.Fatalf("expected launch to fail, got %v", msg)
+	}
+	c.send("stackTrace", map[string]interface{}{"threadId": mainThreadID})
+	if msg := c.next(); msg["success"] != false || msg["message"] != "not stopped" {
+		t.Fatalf("expected stackTrace to fail, got %v", msg)
+	}
+	c.send("foo", nil)
+	if msg := c.next(); msg["success"] != false || msg["message"] != "unsupported command: foo" {
+		t.Fatalf("expected foo to fail, got %v", msg)
+	}
+	c.disconnect()
+}
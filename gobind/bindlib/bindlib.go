@@ -0,0 +1,274 @@
+// Package bindlib contains the functions used by Lua bindings generated by
+// "golua bind" (see package gobind) to convert between Lua values and Go
+// values.
+package bindlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// ArgError returns an error for a bad argument at position n (starting from 0).
+func ArgError(n int, err error) error {
+	return fmt.Errorf("#%d %s", n+1, err)
+}
+
+// TypeError returns the error for a Lua value which cannot be converted to the
+// Go type with the given name.
+func TypeError(typeName string) error {
+	return fmt.Errorf("must be %s", typeName)
+}
+
+// ToBool converts v to a Go bool.  Like in Lua, nil is false.
+func ToBool(v rt.Value) (bool, error) {
+	if v.IsNil() {
+		return false, nil
+	}
+	b, ok := v.TryBool()
+	if !ok {
+		return false, errors.New("must be a boolean")
+	}
+	return b, nil
+}
+
+// ToString converts v to a Go string.  Numbers are converted to strings as in
+// Lua.
+func ToString(v rt.Value) (string, error) {
+	if v.Type() == rt.StringType || v.Type() == rt.IntType || v.Type() == rt.FloatType {
+		s, _ := v.ToString()
+		return s, nil
+	}
+	return "", errors.New("must be a string")
+}
+
+// ToBytes converts the Lua string v to a []byte.
+func ToBytes(v rt.Value) ([]byte, error) {
+	s, err := ToString(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// ToInt converts v to a Go signed integer of the given size in bits (0 means
+// the size of int).
+func ToInt(v rt.Value, size int) (int64, error) {
+	n, ok := rt.ToInt(v)
+	if !ok {
+		return 0, errors.New("must be an integer")
+	}
+	if size == 0 {
+		size = bits.UintSize
+	}
+	if size < 64 && (n < -1<<(size-1) || n >= 1<<(size-1)) {
+		return 0, fmt.Errorf("is out of range for int%d", size)
+	}
+	return n, nil
+}
+
+// ToUint converts v to a Go unsigned integer of the given size in bits (0 means
+// the size of uint).  Floats are accepted in order to represent integers
+// greater than the maximum Lua integer.
+func ToUint(v rt.Value, size int) (uint64, error) {
+	if size == 0 {
+		size = bits.UintSize
+	}
+	var n uint64
+	if f, ok := v.TryFloat(); ok && f >= 1<<63 && f < 1<<64 && f == math.Floor(f) {
+		n = uint64(f)
+	} else {
+		i, ok := rt.ToInt(v)
+		if !ok {
+			return 0, errors.New("must be an integer")
+		}
+		if i < 0 {
+			return 0, fmt.Errorf("is out of range for uint%d", size)
+		}
+		n = uint64(i)
+	}
+	if size < 64 && n >= 1<<size {
+		return 0, fmt.Errorf("is out of range for uint%d", size)
+	}
+	return n, nil
+}
+
+// ToFloat converts v to a Go float.
+func ToFloat(v rt.Value) (float64, error) {
+	f, ok := rt.ToFloat(v)
+	if !ok {
+		return 0, errors.New("must be a number")
+	}
+	return f, nil
+}
+
+// ToGo converts v to a Go value of a type which is not handled by the other
+// conversion functions.  Userdata are converted to the Go value they contain,
+// other Lua values to their natural Go counterpart (nil, bool, int64, float64,
+// string).  Other Lua values (e.g. tables) are returned as is, so they can be
+// passed to a Go function expecting an interface{}.
+func ToGo(v rt.Value) interface{} {
+	if u, ok := v.TryUserData(); ok {
+		return u.Value()
+	}
+	return v.Interface()
+}
+
+// UintValue returns a Lua value for n.  If n does not fit in a Lua integer, it
+// is returned as a float.
+func UintValue(n uint64) rt.Value {
+	if n > math.MaxInt64 {
+		return rt.FloatValue(float64(n))
+	}
+	return rt.IntValue(int64(n))
+}
+
+// NewValue returns a userdata wrapping the Go value x, with the given
+// metatable.  If x is nil (including a nil pointer, map, slice...), nil is
+// returned.
+func NewValue(r *rt.Runtime, x interface{}, meta *rt.Table) rt.Value {
+	if isNil(x) {
+		return rt.NilValue
+	}
+	return r.NewUserDataValue(x, meta)
+}
+
+// NewGoValue returns a userdata wrapping the Go value x, for values of types
+// which have no metatable of their own (see NewMeta).  Such values can be
+// passed to Go functions and compared.
+func NewGoValue(r *rt.Runtime, x interface{}) rt.Value {
+	meta, ok := r.Registry(goValueMetaKey).TryTable()
+	if !ok {
+		meta = NewMeta(r, "go value", nil, nil, nil)
+		r.SetRegistry(goValueMetaKey, rt.TableValue(meta))
+	}
+	return NewValue(r, x, meta)
+}
+
+type goValueMetaKeyType struct{}
+
+var goValueMetaKey = rt.AsValue(goValueMetaKeyType{})
+
+// A FieldGetter returns the value of the field with the given name of the Go
+// value x, or false if there is no such field.
+type FieldGetter func(r *rt.Runtime, x interface{}, name string) (rt.Value, bool)
+
+// A FieldSetter sets the field with the given name of the Go value x to v, or
+// returns false if there is no such field.
+type FieldSetter func(x interface{}, name string, v rt.Value) (bool, error)
+
+// NewMeta returns a metatable for userdata wrapping a Go value of a type named
+// name.  Indexing the userdata looks up methods, then fields with get, and
+// assigning to a field uses set.  Any of methods, get and set may be nil.
+func NewMeta(r *rt.Runtime, name string, methods *rt.Table, get FieldGetter, set FieldSetter) *rt.Table {
+	meta := rt.NewTable()
+	r.SetEnv(meta, "__name", rt.StringValue(name))
+	index := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		u, err := c.UserDataArg(0)
+		if err != nil {
+			return nil, err
+		}
+		key := c.Arg(1)
+		if methods != nil {
+			if m := methods.Get(key); !m.IsNil() {
+				return c.PushingNext1(t.Runtime, m), nil
+			}
+		}
+		if k, ok := key.TryString(); ok && get != nil {
+			if v, ok := get(t.Runtime, u.Value(), k); ok {
+				return c.PushingNext1(t.Runtime, v), nil
+			}
+		}
+		k, _ := key.ToString()
+		return nil, fmt.Errorf("%s has no field or method %s", name, k)
+	}
+	newIndex := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		u, err := c.UserDataArg(0)
+		if err != nil {
+			return nil, err
+		}
+		key := c.Arg(1)
+		if k, ok := key.TryString(); ok && set != nil {
+			ok, err := set(u.Value(), k, c.Arg(2))
+			if err != nil {
+				return nil, fmt.Errorf("field %s %s", k, err)
+			}
+			if ok {
+				return c.Next(), nil
+			}
+		}
+		k, _ := key.ToString()
+		return nil, fmt.Errorf("cannot set field %s of %s", k, name)
+	}
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
+		r.SetEnvGoFunc(meta, "__index", index, 2, false),
+		r.SetEnvGoFunc(meta, "__newindex", newIndex, 3, false),
+		r.SetEnvGoFunc(meta, "__eq", eq, 2, false),
+		r.SetEnvGoFunc(meta, "__tostring", tostring, 1, false),
+	)
+	return meta
+}
+
+func eq(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	res := false
+	u1, ok1 := c.Arg(0).TryUserData()
+	u2, ok2 := c.Arg(1).TryUserData()
+	if ok1 && ok2 {
+		x, y := reflect.ValueOf(u1.Value()), reflect.ValueOf(u2.Value())
+		res = x.Type() == y.Type() && x.Type().Comparable() && u1.Value() == u2.Value()
+	}
+	return c.PushingNext1(t.Runtime, rt.BoolValue(res)), nil
+}
+
+func tostring(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	u, err := c.UserDataArg(0)
+	if err != nil {
+		return nil, err
+	}
+	var s string
+	switch x := u.Value().(type) {
+	case error:
+		s = x.Error()
+	case fmt.Stringer:
+		s = x.String()
+	default:
+		s = fmt.Sprintf("%v", x)
+	}
+	return c.PushingNext1(t.Runtime, rt.StringValue(s)), nil
+}
+
+func isNil(x interface{}) bool {
+	if x == nil {
+		return true
+	}
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// SetFields sets the fields of the Go value x from the string keys of the Lua
+// table tbl, using set.
+func SetFields(x interface{}, tbl *rt.Table, set FieldSetter) error {
+	for k, v, ok := tbl.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = tbl.Next(k) {
+		name, isStr := k.TryString()
+		if !isStr {
+			return fmt.Errorf("field names must be strings, got %s", k.TypeName())
+		}
+		found, err := set(x, name, v)
+		if err != nil {
+			return fmt.Errorf("field %s %s", name, err)
+		}
+		if !found {
+			return fmt.Errorf("no field %s", name)
+		}
+	}
+	return nil
+}
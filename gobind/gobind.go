@@ -0,0 +1,710 @@
+// Package gobind generates Lua bindings for Go packages ahead of time.
+//
+// Whereas the golib library builds a plugin at runtime and uses reflection to
+// call Go functions, the bindings generated by this package are plain Go code
+// which is compiled into the program using them.  Generate produces a Go file
+// defining a packagelib.Loader for the Go package, which gives access to
+//
+//   - exported functions, with arguments checked and converted to the Go
+//     parameter types.  A trailing error result is turned into a Lua error;
+//   - exported constants, and the values of exported variables when the module
+//     is loaded;
+//   - exported types: struct types are constructors returning a pointer to a
+//     new value (optionally initialised from a table of fields), and values of
+//     struct types or types with methods give access to their methods and
+//     exported fields.
+//
+// Values of Go types which have no Lua equivalent are passed to Lua as
+// userdata, and can be passed back to Go functions.  Go functions, types and
+// variables whose types cannot be named outside of their package (e.g. because
+// they involve unexported or generic types) are skipped.
+package gobind
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/constant"
+	"go/format"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Config describes what to generate.
+type Config struct {
+	Package     string // Import path of the Go package to bind
+	Dir         string // Directory to resolve Package from (default: current directory)
+	OutPackage  string // Package name of the generated file (default: <name>bind)
+	LuaName     string // Name of the Lua module (default: the Go package name)
+	CommandLine string // Command line to mention in the generated file
+}
+
+// Generate returns the source of a Go file binding the package described by
+// cfg.
+func Generate(cfg Config) ([]byte, error) {
+	if cfg.Package == "" {
+		return nil, errors.New("no package to bind")
+	}
+	fset := token.NewFileSet()
+	imp, ok := importer.ForCompiler(fset, "source", nil).(types.ImporterFrom)
+	if !ok {
+		return nil, errors.New("source importer not available")
+	}
+	if cfg.Dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Dir = wd
+	}
+	path, err := importPath(cfg.Package, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := imp.ImportFrom(path, cfg.Dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OutPackage == "" {
+		cfg.OutPackage = pkg.Name() + "bind"
+	}
+	if cfg.LuaName == "" {
+		cfg.LuaName = pkg.Name()
+	}
+	if cfg.CommandLine == "" {
+		cfg.CommandLine = "golua bind " + cfg.Package
+	}
+	cfg.Package = path
+	g := newGenerator(pkg)
+	g.generate()
+	src := g.file(cfg)
+	out, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %s\n%s", err, src)
+	}
+	return out, nil
+}
+
+// importPath resolves a relative package path such as "." to an import path,
+// so the generated file can import it.
+func importPath(path, dir string) (string, error) {
+	if !build.IsLocalImport(path) {
+		return path, nil
+	}
+	cmd := exec.Command("go", "list", "-find", "-f", "{{.ImportPath}}", path)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("cannot resolve %s: %s", path, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", err
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+type generator struct {
+	pkg     *types.Package
+	imports map[string]string // Import path => local name
+	names   map[string]bool   // Local names in use in the generated file
+
+	metaLoad bytes.Buffer // Start of the load function, creating metatables
+	load     bytes.Buffer // Rest of the load function
+	decls    bytes.Buffer // Functions
+	skipped  []string     // Exported names which could not be bound, with reason
+
+	metas map[types.Type]string // Types with a metatable => key in the registry
+}
+
+// Names used by the generated code, which imported packages must not shadow.
+var reservedNames = []string{
+	"rt", "bindlib", "packagelib",
+	"t", "c", "r", "err", "ok", "x", "v", "name", "val", "etc", "i", "va", "pkg",
+	"methods", "meta", "metaKey", "load", "recv", "tbl",
+}
+
+func newGenerator(pkg *types.Package) *generator {
+	g := &generator{
+		pkg:     pkg,
+		imports: map[string]string{},
+		names:   map[string]bool{},
+		metas:   map[types.Type]string{},
+	}
+	for _, name := range reservedNames {
+		g.names[name] = true
+	}
+	return g
+}
+
+// qualifier returns the local name of package p in the generated file,
+// importing it if necessary.
+func (g *generator) qualifier(p *types.Package) string {
+	if name, ok := g.imports[p.Path()]; ok {
+		return name
+	}
+	name := p.Name()
+	for i := 2; g.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", p.Name(), i)
+	}
+	g.names[name] = true
+	g.imports[p.Path()] = name
+	return name
+}
+
+// typeString returns how to write tp in the generated file.
+func (g *generator) typeString(tp types.Type) string {
+	return types.TypeString(tp, g.qualifier)
+}
+
+// typeName returns a name for tp to use in messages.
+func typeName(tp types.Type) string {
+	return types.TypeString(tp, func(p *types.Package) string { return p.Name() })
+}
+
+func (g *generator) skip(name, format string, args ...interface{}) {
+	g.skipped = append(g.skipped, name+": "+fmt.Sprintf(format, args...))
+}
+
+func (g *generator) generate() {
+	scope := g.pkg.Scope()
+	names := scope.Names() // Sorted
+	// Metatables first, as functions need to know which types have one.
+	for _, name := range names {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok && tn.Exported() {
+			g.registerMetas(tn)
+		}
+	}
+	for _, name := range names {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch x := obj.(type) {
+		case *types.Func:
+			g.genFunc(x)
+		case *types.Const:
+			g.genConst(x)
+		case *types.Var:
+			g.genVar(x)
+		case *types.TypeName:
+			g.genType(x)
+		}
+	}
+}
+
+// registerMetas decides whether values of the type named tn (and pointers to
+// them) get a metatable.
+func (g *generator) registerMetas(tn *types.TypeName) {
+	named, ok := tn.Type().(*types.Named)
+	if !ok || tn.IsAlias() || isGeneric(named) {
+		return
+	}
+	switch named.Underlying().(type) {
+	case *types.Basic, *types.Interface:
+		// Basic values are converted to Lua values and values of interface
+		// types have a dynamic type.
+		return
+	case *types.Struct:
+	default:
+		if types.NewMethodSet(types.NewPointer(named)).Len() == 0 {
+			return
+		}
+	}
+	g.metas[named] = tn.Name()
+	g.metas[types.NewPointer(named)] = "*" + tn.Name()
+}
+
+// metaKey returns the registry key of the metatable for values of type tp,
+// or "" if there is none.
+func (g *generator) metaKey(tp types.Type) string {
+	for mt, key := range g.metas {
+		if types.Identical(mt, tp) {
+			return key
+		}
+	}
+	return ""
+}
+
+// usable returns an error if tp cannot be written in the generated file.
+func (g *generator) usable(tp types.Type) error {
+	switch x := tp.(type) {
+	case *types.Basic:
+		if x.Kind() == types.UnsafePointer || x.Info()&types.IsUntyped != 0 {
+			return fmt.Errorf("unsupported type %s", x)
+		}
+	case *types.Named:
+		obj := x.Obj()
+		if obj.Pkg() != nil && !obj.Exported() {
+			return fmt.Errorf("unexported type %s", typeName(x))
+		}
+		if isGeneric(x) {
+			return fmt.Errorf("generic type %s", typeName(x))
+		}
+	case *types.Pointer:
+		return g.usable(x.Elem())
+	case *types.Slice:
+		return g.usable(x.Elem())
+	case *types.Array:
+		return g.usable(x.Elem())
+	case *types.Chan:
+		return g.usable(x.Elem())
+	case *types.Map:
+		if err := g.usable(x.Key()); err != nil {
+			return err
+		}
+		return g.usable(x.Elem())
+	case *types.Signature:
+		if isGeneric(x) {
+			return fmt.Errorf("generic function type")
+		}
+		for _, tuple := range []*types.Tuple{x.Params(), x.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if err := g.usable(tuple.At(i).Type()); err != nil {
+					return err
+				}
+			}
+		}
+	case *types.Struct:
+		for i := 0; i < x.NumFields(); i++ {
+			f := x.Field(i)
+			if !f.Exported() {
+				return fmt.Errorf("struct type with unexported field %s", f.Name())
+			}
+			if err := g.usable(f.Type()); err != nil {
+				return err
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < x.NumMethods(); i++ {
+			m := x.Method(i)
+			if !m.Exported() {
+				return fmt.Errorf("interface type with unexported method %s", m.Name())
+			}
+			if err := g.usable(m.Type()); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", typeName(tp))
+	}
+	return nil
+}
+
+// isGeneric returns true for generic types and instances of generic types.
+// This avoids depending on the go/types API for type parameters.
+func isGeneric(tp types.Type) bool {
+	switch x := tp.(type) {
+	case *types.Named:
+		return strings.HasSuffix(types.TypeString(x, nil), "]")
+	case *types.Signature:
+		return strings.HasPrefix(types.TypeString(x, nil), "func[")
+	}
+	return false
+}
+
+func isUntyped(tp types.Type) bool {
+	b, ok := tp.(*types.Basic)
+	return ok && b.Info()&types.IsUntyped != 0
+}
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// toLua returns an expression converting the Go expression expr of type tp to
+// a Lua value.  rexpr is an expression for the runtime.
+func (g *generator) toLua(expr string, tp types.Type, rexpr string) string {
+	if b, ok := tp.Underlying().(*types.Basic); ok {
+		info := b.Info()
+		switch {
+		case info&types.IsBoolean != 0:
+			return fmt.Sprintf("rt.BoolValue(bool(%s))", expr)
+		case info&types.IsString != 0:
+			return fmt.Sprintf("rt.StringValue(string(%s))", expr)
+		case info&types.IsUnsigned != 0 && (b.Kind() == types.Uint || b.Kind() == types.Uint64 || b.Kind() == types.Uintptr):
+			return fmt.Sprintf("bindlib.UintValue(uint64(%s))", expr)
+		case info&types.IsInteger != 0:
+			return fmt.Sprintf("rt.IntValue(int64(%s))", expr)
+		case info&types.IsFloat != 0:
+			return fmt.Sprintf("rt.FloatValue(float64(%s))", expr)
+		}
+	}
+	if isByteSlice(tp) {
+		return fmt.Sprintf("rt.StringValue(string(%s))", expr)
+	}
+	if key := g.metaKey(tp); key != "" {
+		return fmt.Sprintf("bindlib.NewValue(%s, %s, meta(%s, %q))", rexpr, expr, rexpr, key)
+	}
+	return fmt.Sprintf("bindlib.NewGoValue(%s, %s)", rexpr, expr)
+}
+
+func isByteSlice(tp types.Type) bool {
+	s, ok := tp.Underlying().(*types.Slice)
+	return ok && types.Identical(s.Elem(), types.Typ[types.Byte])
+}
+
+// fromLua returns statements declaring a variable called name from the Lua
+// value src, and an expression of type tp for it.  If the conversion fails,
+// the statements run onErr with the error in a variable called err.
+func (g *generator) fromLua(name, src string, tp types.Type, onErr string) (string, string) {
+	// conv uses a bindlib conversion function returning a value of type
+	// goType.
+	conv := func(fn, goType string) (string, string) {
+		expr := name
+		if s := g.typeString(tp); s != goType {
+			expr = fmt.Sprintf("%s(%s)", s, name)
+		}
+		return fmt.Sprintf("%s, err := bindlib.%s\nif err != nil {\n%s\n}\n", name, fn, onErr), expr
+	}
+	if b, ok := tp.Underlying().(*types.Basic); ok {
+		info := b.Info()
+		switch {
+		case info&types.IsBoolean != 0:
+			return conv(fmt.Sprintf("ToBool(%s)", src), "bool")
+		case info&types.IsString != 0:
+			return conv(fmt.Sprintf("ToString(%s)", src), "string")
+		case info&types.IsUnsigned != 0:
+			return conv(fmt.Sprintf("ToUint(%s, %d)", src, intSize(b)), "uint64")
+		case info&types.IsInteger != 0:
+			return conv(fmt.Sprintf("ToInt(%s, %d)", src, intSize(b)), "int64")
+		case info&types.IsFloat != 0:
+			return conv(fmt.Sprintf("ToFloat(%s)", src), "float64")
+		}
+	}
+	if isByteSlice(tp) {
+		return conv(fmt.Sprintf("ToBytes(%s)", src), "[]byte")
+	}
+	if named, ok := tp.(*types.Named); ok && g.metaKey(named) != "" {
+		// Accept pointers too, as constructors return pointers.
+		return fmt.Sprintf(
+			"%s, ok := to%s(%s)\nif !ok {\nerr := bindlib.TypeError(%q)\n%s\n}\n",
+			name, named.Obj().Name(), src, typeName(tp), onErr,
+		), name
+	}
+	cond := "!ok"
+	if nilable(tp) {
+		cond = fmt.Sprintf("!ok && !%s.IsNil()", src)
+	}
+	return fmt.Sprintf(
+		"%s, ok := bindlib.ToGo(%s).(%s)\nif %s {\nerr := bindlib.TypeError(%q)\n%s\n}\n",
+		name, src, g.typeString(tp), cond, typeName(tp), onErr,
+	), name
+}
+
+// intSize returns the size in bits of an integer type, or 0 for int, uint and
+// uintptr whose size depends on the platform.
+func intSize(b *types.Basic) int {
+	switch b.Kind() {
+	case types.Int8, types.Uint8:
+		return 8
+	case types.Int16, types.Uint16:
+		return 16
+	case types.Int32, types.Uint32:
+		return 32
+	case types.Int64, types.Uint64:
+		return 64
+	}
+	return 0
+}
+
+func nilable(tp types.Type) bool {
+	switch tp.Underlying().(type) {
+	case *types.Pointer, *types.Interface, *types.Map, *types.Slice, *types.Signature, *types.Chan:
+		return true
+	}
+	return false
+}
+
+// genCall writes the body of a function calling the Go function or method
+// described by sig.  call is the Go expression for the function and
+// firstArg the position in the Lua arguments of its first argument.
+func (g *generator) genCall(w *bytes.Buffer, call string, sig *types.Signature, firstArg int) {
+	params := sig.Params()
+	var args []string
+	n := params.Len()
+	if sig.Variadic() {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("a%d", i)
+		stmts, expr := g.fromLua(name, fmt.Sprintf("c.Arg(%d)", i+firstArg), params.At(i).Type(),
+			fmt.Sprintf("return nil, bindlib.ArgError(%d, err)", i+firstArg))
+		w.WriteString(stmts)
+		args = append(args, expr)
+	}
+	if sig.Variadic() {
+		elem := params.At(n).Type().(*types.Slice).Elem()
+		pos := "i"
+		if n+firstArg > 0 {
+			pos = fmt.Sprintf("%d+i", n+firstArg)
+		}
+		stmts, expr := g.fromLua("x", "v", elem, fmt.Sprintf("return nil, bindlib.ArgError(%s, err)", pos))
+		fmt.Fprintf(w, "etc := c.Etc()\nva := make([]%s, len(etc))\nfor i, v := range etc {\n%sva[i] = %s\n}\n",
+			g.typeString(elem), stmts, expr)
+		args = append(args, "va...")
+	}
+	call = fmt.Sprintf("%s(%s)", call, strings.Join(args, ", "))
+
+	results := sig.Results()
+	nres := results.Len()
+	hasErr := nres > 0 && types.Identical(results.At(nres-1).Type(), errorType)
+	if hasErr {
+		nres--
+	}
+	var vars, vals []string
+	for i := 0; i < nres; i++ {
+		v := fmt.Sprintf("r%d", i)
+		vars = append(vars, v)
+		vals = append(vals, g.toLua(v, results.At(i).Type(), "t.Runtime"))
+	}
+	switch {
+	case nres == 0 && hasErr:
+		fmt.Fprintf(w, "if err := %s; err != nil {\nreturn nil, err\n}\n", call)
+	case nres == 0:
+		fmt.Fprintf(w, "%s\n", call)
+	case hasErr:
+		fmt.Fprintf(w, "%s, err := %s\nif err != nil {\nreturn nil, err\n}\n", strings.Join(vars, ", "), call)
+	default:
+		fmt.Fprintf(w, "%s := %s\n", strings.Join(vars, ", "), call)
+	}
+	switch nres {
+	case 0:
+		w.WriteString("return c.Next(), nil\n")
+	case 1:
+		fmt.Fprintf(w, "return c.PushingNext1(t.Runtime, %s), nil\n", vals[0])
+	default:
+		fmt.Fprintf(w, "return c.PushingNext(t.Runtime, %s), nil\n", strings.Join(vals, ", "))
+	}
+}
+
+func (g *generator) genFunc(f *types.Func) {
+	sig := f.Type().(*types.Signature)
+	if err := g.usable(sig); err != nil {
+		g.skip(f.Name(), "%s", err)
+		return
+	}
+	fname := "fn" + f.Name()
+	fmt.Fprintf(&g.decls, "\nfunc %s(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {\n", fname)
+	g.genCall(&g.decls, g.qualifier(g.pkg)+"."+f.Name(), sig, 0)
+	g.decls.WriteString("}\n")
+	fmt.Fprintf(&g.load, "r.SetEnvGoFunc(pkg, %q, %s, %d, %t)\n", f.Name(), fname, nParams(sig), sig.Variadic())
+}
+
+func nParams(sig *types.Signature) int {
+	n := sig.Params().Len()
+	if sig.Variadic() {
+		n--
+	}
+	return n
+}
+
+func (g *generator) genConst(c *types.Const) {
+	// Untyped constants are converted according to their value.
+	if !isUntyped(c.Type()) {
+		if err := g.usable(c.Type()); err != nil {
+			g.skip(c.Name(), "%s", err)
+			return
+		}
+	}
+	expr := g.qualifier(g.pkg) + "." + c.Name()
+	var val string
+	switch v := c.Val(); v.Kind() {
+	case constant.Bool:
+		val = fmt.Sprintf("rt.BoolValue(bool(%s))", expr)
+	case constant.String:
+		val = fmt.Sprintf("rt.StringValue(string(%s))", expr)
+	case constant.Int:
+		if _, exact := constant.Int64Val(v); exact {
+			val = fmt.Sprintf("rt.IntValue(int64(%s))", expr)
+		} else {
+			val = fmt.Sprintf("rt.FloatValue(float64(%s))", expr)
+		}
+	case constant.Float:
+		if f, _ := constant.Float64Val(v); math.IsInf(f, 0) {
+			g.skip(c.Name(), "constant overflows float64")
+			return
+		}
+		val = fmt.Sprintf("rt.FloatValue(float64(%s))", expr)
+	default:
+		g.skip(c.Name(), "unsupported constant %s", v)
+		return
+	}
+	fmt.Fprintf(&g.load, "r.SetEnv(pkg, %q, %s)\n", c.Name(), val)
+}
+
+func (g *generator) genVar(v *types.Var) {
+	if err := g.usable(v.Type()); err != nil {
+		g.skip(v.Name(), "%s", err)
+		return
+	}
+	expr := g.qualifier(g.pkg) + "." + v.Name()
+	fmt.Fprintf(&g.load, "r.SetEnv(pkg, %q, %s)\n", v.Name(), g.toLua(expr, v.Type(), "r"))
+}
+
+func (g *generator) genType(tn *types.TypeName) {
+	named, ok := tn.Type().(*types.Named)
+	if !ok || g.metaKey(named) == "" {
+		return
+	}
+	g.genMeta(named, false)
+	g.genMeta(named, true)
+	fmt.Fprintf(&g.decls, `
+func to%[1]s(v rt.Value) (%[2]s, bool) {
+	switch x := bindlib.ToGo(v).(type) {
+	case %[2]s:
+		return x, true
+	case *%[2]s:
+		if x != nil {
+			return *x, true
+		}
+	}
+	var zero %[2]s
+	return zero, false
+}
+`, tn.Name(), g.typeString(named))
+	if _, ok := named.Underlying().(*types.Struct); ok {
+		name := tn.Name()
+		fmt.Fprintf(&g.decls, `
+func new%[1]s(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	x := new(%[2]s)
+	if tbl, ok := c.Arg(0).TryTable(); ok {
+		if err := bindlib.SetFields(x, tbl, setPtr%[1]s); err != nil {
+			return nil, bindlib.ArgError(0, err)
+		}
+	}
+	return c.PushingNext1(t.Runtime, bindlib.NewValue(t.Runtime, x, meta(t.Runtime, "*%[1]s"))), nil
+}
+`, name, g.typeString(named))
+		fmt.Fprintf(&g.load, "r.SetEnvGoFunc(pkg, %q, new%s, 1, false)\n", name, name)
+	}
+}
+
+// genMeta writes the metatable for values of type named or *named.
+func (g *generator) genMeta(named *types.Named, ptr bool) {
+	var tp types.Type = named
+	prefix := ""
+	if ptr {
+		tp = types.NewPointer(named)
+		prefix = "Ptr"
+	}
+	id := prefix + named.Obj().Name()
+	g.metaLoad.WriteString("\nmethods = rt.NewTable()\n")
+	mset := types.NewMethodSet(tp)
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i).Obj().(*types.Func)
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		if err := g.usable(sig); err != nil {
+			g.skip(typeName(tp)+"."+m.Name(), "%s", err)
+			continue
+		}
+		fname := "m" + id + m.Name()
+		fmt.Fprintf(&g.decls, "\nfunc %s(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {\n", fname)
+		fmt.Fprintf(&g.decls, "recv, ok := bindlib.ToGo(c.Arg(0)).(%s)\nif !ok {\nreturn nil, bindlib.ArgError(0, bindlib.TypeError(%q))\n}\n",
+			g.typeString(tp), typeName(tp))
+		g.genCall(&g.decls, "recv."+m.Name(), sig, 1)
+		g.decls.WriteString("}\n")
+		fmt.Fprintf(&g.metaLoad, "r.SetEnvGoFunc(methods, %q, %s, %d, %t)\n", m.Name(), fname, nParams(sig)+1, sig.Variadic())
+	}
+	get, set := "nil", "nil"
+	if st, ok := named.Underlying().(*types.Struct); ok {
+		get, set = "get"+id, "set"+id
+		g.genFields(st, tp, id, ptr)
+	}
+	fmt.Fprintf(&g.metaLoad, "r.SetRegistry(rt.AsValue(metaKey(%q)), rt.TableValue(bindlib.NewMeta(r, %q, methods, %s, %s)))\n",
+		g.metaKey(tp), typeName(tp), get, set)
+}
+
+// genFields writes the getter and setter functions for the exported fields of
+// a struct type.  Fields of a struct value cannot be set.
+func (g *generator) genFields(st *types.Struct, tp types.Type, id string, settable bool) {
+	var getCases, setCases bytes.Buffer
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		if err := g.usable(f.Type()); err != nil {
+			g.skip(typeName(tp)+"."+f.Name(), "%s", err)
+			continue
+		}
+		fmt.Fprintf(&getCases, "case %q:\nreturn %s, true\n", f.Name(), g.toLua("v."+f.Name(), f.Type(), "r"))
+		stmts, expr := g.fromLua("x", "val", f.Type(), "return true, err")
+		fmt.Fprintf(&setCases, "case %q:\n%sv.%s = %s\nreturn true, nil\n", f.Name(), stmts, f.Name(), expr)
+	}
+	ts := g.typeString(tp)
+	if getCases.Len() == 0 {
+		fmt.Fprintf(&g.decls, "\nfunc get%s(r *rt.Runtime, x interface{}, name string) (rt.Value, bool) {\nreturn rt.NilValue, false\n}\n", id)
+	} else {
+		fmt.Fprintf(&g.decls, "\nfunc get%s(r *rt.Runtime, x interface{}, name string) (rt.Value, bool) {\nv := x.(%s)\nswitch name {\n%s}\nreturn rt.NilValue, false\n}\n",
+			id, ts, getCases.String())
+	}
+	if !settable || setCases.Len() == 0 {
+		fmt.Fprintf(&g.decls, "\nfunc set%s(x interface{}, name string, val rt.Value) (bool, error) {\nreturn false, nil\n}\n", id)
+	} else {
+		fmt.Fprintf(&g.decls, "\nfunc set%s(x interface{}, name string, val rt.Value) (bool, error) {\nv := x.(%s)\nswitch name {\n%s}\nreturn false, nil\n}\n",
+			id, ts, setCases.String())
+	}
+}
+
+// file returns the complete generated file.
+func (g *generator) file(cfg Config) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by %q; DO NOT EDIT.\n\n", cfg.CommandLine)
+	fmt.Fprintf(&b, "package %s\n\n", cfg.OutPackage)
+	methodsDecl := ""
+	if len(g.metas) > 0 {
+		methodsDecl = "var methods *rt.Table\n"
+	}
+	b.WriteString("import (\n")
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s %q\n", g.imports[path], path)
+	}
+	b.WriteString(`
+	"github.com/arnodel/golua/gobind/bindlib"
+	"github.com/arnodel/golua/lib/packagelib"
+	rt "github.com/arnodel/golua/runtime"
+)
+`)
+	fmt.Fprintf(&b, `
+// LibLoader loads the Go package %[1]s as the Lua module %[2]q.
+var LibLoader = packagelib.Loader{
+	Load: load,
+	Name: %[2]q,
+}
+
+// Registry keys for metatables of Go types.
+type metaKey string
+
+func meta(r *rt.Runtime, key metaKey) *rt.Table {
+	return r.Registry(rt.AsValue(key)).AsTable()
+}
+
+func load(r *rt.Runtime) (rt.Value, func()) {
+	pkg := rt.NewTable()
+%[3]s%[4]s
+%[5]s
+	return rt.TableValue(pkg), nil
+}
+`, cfg.Package, cfg.LuaName, methodsDecl, g.metaLoad.String(), g.load.String())
+	b.Write(g.decls.Bytes())
+	if len(g.skipped) > 0 {
+		b.WriteString("\n// Not bound:\n")
+		for _, s := range g.skipped {
+			fmt.Fprintf(&b, "//   - %s\n", s)
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,53 @@
+package gobind_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/arnodel/golua/gobind"
+	"github.com/arnodel/golua/gobind/internal/testpkg/testpkgbind"
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/luatesting"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const goldenFile = "internal/testpkg/testpkgbind/testpkgbind.go"
+
+// The binding used by the Lua tests must be what Generate currently produces.
+// Run "go generate ./gobind/..." to update it.
+func TestGenerateGolden(t *testing.T) {
+	src, err := gobind.Generate(gobind.Config{
+		Package:     "./internal/testpkg",
+		CommandLine: "golua bind -o testpkgbind/testpkgbind.go .",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, golden) {
+		t.Errorf("%s is out of date, run go generate", goldenFile)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if _, err := gobind.Generate(gobind.Config{}); err == nil {
+		t.Error("expected an error with no package")
+	}
+	if _, err := gobind.Generate(gobind.Config{Package: "./nosuchpackage"}); err == nil {
+		t.Error("expected an error for a missing package")
+	}
+}
+
+func setup(r *rt.Runtime) func() {
+	cleanup := lib.LoadAll(r)
+	testpkgbind.LibLoader.Run(r)
+	return cleanup
+}
+
+func TestBinding(t *testing.T) {
+	luatesting.RunLuaTestsInDir(t, "lua", setup)
+}
@@ -0,0 +1,197 @@
+// Package testpkg is bound to Lua by "golua bind" to test the generated code.
+// The binding is in the testpkgbind package; regenerate it with
+//
+//	go generate ./gobind/...
+package testpkg
+
+//go:generate go run ../../.. bind -o testpkgbind/testpkgbind.go .
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	Answer         = 42
+	Greeting       = "hello"
+	Pi             = 3.14
+	Big            = math.MaxUint64
+	Enabled        = true
+	Red      Color = 1
+)
+
+var (
+	Counter = 10
+	Default = &Point{X: 1, Y: 2}
+	hidden  = 5
+)
+
+// ErrNegative is returned by Sqrt.
+var ErrNegative = errors.New("negative number")
+
+// Color is an integer type, converted to and from Lua integers.
+type Color int
+
+// Point is a struct type with methods.
+type Point struct {
+	X, Y  int
+	Label string
+	Tags  []string
+	count int
+}
+
+// Add returns the sum of p and q.
+func (p Point) Add(q Point) Point {
+	return Point{X: p.X + q.X, Y: p.Y + q.Y}
+}
+
+// String implements fmt.Stringer.
+func (p Point) String() string {
+	return fmt.Sprintf("(%d, %d)", p.X, p.Y)
+}
+
+// Scale scales p by k.
+func (p *Point) Scale(k int) {
+	p.X *= k
+	p.Y *= k
+	p.count++
+}
+
+// Scaled returns how many times p was scaled.
+func (p *Point) Scaled() int {
+	return p.count
+}
+
+// Stack is a non-struct type with methods.
+type Stack []int
+
+// Push pushes items onto the stack.
+func (s *Stack) Push(items ...int) {
+	*s = append(*s, items...)
+}
+
+// Pop pops an item from the stack.
+func (s *Stack) Pop() (int, bool) {
+	n := len(*s)
+	if n == 0 {
+		return 0, false
+	}
+	x := (*s)[n-1]
+	*s = (*s)[:n-1]
+	return x, true
+}
+
+// NewStack returns an empty stack.
+func NewStack() *Stack {
+	return new(Stack)
+}
+
+// Shape is an interface type.
+type Shape interface {
+	Area() float64
+}
+
+// Area implements Shape.
+func (p Point) Area() float64 {
+	return float64(p.X * p.Y)
+}
+
+// TotalArea returns the sum of the areas of shapes.
+func TotalArea(shapes ...Shape) float64 {
+	var total float64
+	for _, s := range shapes {
+		total += s.Area()
+	}
+	return total
+}
+
+// Sqrt returns the square root of x or an error if x is negative.
+func Sqrt(x float64) (float64, error) {
+	if x < 0 {
+		return 0, ErrNegative
+	}
+	return math.Sqrt(x), nil
+}
+
+// Fail always fails.
+func Fail() error {
+	return errors.New("failed")
+}
+
+// Div returns the quotient and remainder of a by b.
+func Div(a, b int) (int, int) {
+	return a / b, a % b
+}
+
+// Widths takes integers of various widths.
+func Widths(a int8, b uint8, c int16, d uint32, e int64, f uint64) string {
+	return fmt.Sprint(a, b, c, d, e, f)
+}
+
+// Join joins strings.
+func Join(sep string, parts ...string) string {
+	return strings.Join(parts, sep)
+}
+
+// Upper returns the upper case of b.
+func Upper(b []byte) []byte {
+	return []byte(strings.ToUpper(string(b)))
+}
+
+// Not returns the negation of b.
+func Not(b bool) bool {
+	return !b
+}
+
+// Half halves x.
+func Half(x float32) float32 {
+	return x / 2
+}
+
+// Mix returns a color mixed from c.
+func Mix(c Color) Color {
+	return c * 2
+}
+
+// Sum adds up the integers of xs.
+func Sum(xs []int) int {
+	var total int
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+// Ints returns a slice of integers.
+func Ints(n int) []int {
+	xs := make([]int, n)
+	for i := range xs {
+		xs[i] = i
+	}
+	return xs
+}
+
+// After returns a duration, which is an int64 in Go.
+func After(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// Deadline returns a value of a type from another package.
+func Deadline() time.Time {
+	return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Callback takes a function, which cannot be passed from Lua.
+func Callback(f func(int) int) int {
+	return f(1)
+}
+
+// Private returns a value of an unexported type, so is not bound.
+func Private() private {
+	return private{}
+}
+
+type private struct{}
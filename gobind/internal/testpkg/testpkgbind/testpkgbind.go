@@ -0,0 +1,480 @@
+// Code generated by "golua bind -o testpkgbind/testpkgbind.go ."; DO NOT EDIT.
+
+package testpkgbind
+
+import (
+	testpkg "github.com/arnodel/golua/gobind/internal/testpkg"
+
+	"github.com/arnodel/golua/gobind/bindlib"
+	"github.com/arnodel/golua/lib/packagelib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// LibLoader loads the Go package github.com/arnodel/golua/gobind/internal/testpkg as the Lua module "testpkg".
+var LibLoader = packagelib.Loader{
+	Load: load,
+	Name: "testpkg",
+}
+
+// Registry keys for metatables of Go types.
+type metaKey string
+
+func meta(r *rt.Runtime, key metaKey) *rt.Table {
+	return r.Registry(rt.AsValue(key)).AsTable()
+}
+
+func load(r *rt.Runtime) (rt.Value, func()) {
+	pkg := rt.NewTable()
+	var methods *rt.Table
+
+	methods = rt.NewTable()
+	r.SetEnvGoFunc(methods, "Add", mPointAdd, 2, false)
+	r.SetEnvGoFunc(methods, "Area", mPointArea, 1, false)
+	r.SetEnvGoFunc(methods, "String", mPointString, 1, false)
+	r.SetRegistry(rt.AsValue(metaKey("Point")), rt.TableValue(bindlib.NewMeta(r, "testpkg.Point", methods, getPoint, setPoint)))
+
+	methods = rt.NewTable()
+	r.SetEnvGoFunc(methods, "Add", mPtrPointAdd, 2, false)
+	r.SetEnvGoFunc(methods, "Area", mPtrPointArea, 1, false)
+	r.SetEnvGoFunc(methods, "Scale", mPtrPointScale, 2, false)
+	r.SetEnvGoFunc(methods, "Scaled", mPtrPointScaled, 1, false)
+	r.SetEnvGoFunc(methods, "String", mPtrPointString, 1, false)
+	r.SetRegistry(rt.AsValue(metaKey("*Point")), rt.TableValue(bindlib.NewMeta(r, "*testpkg.Point", methods, getPtrPoint, setPtrPoint)))
+
+	methods = rt.NewTable()
+	r.SetRegistry(rt.AsValue(metaKey("Stack")), rt.TableValue(bindlib.NewMeta(r, "testpkg.Stack", methods, nil, nil)))
+
+	methods = rt.NewTable()
+	r.SetEnvGoFunc(methods, "Pop", mPtrStackPop, 1, false)
+	r.SetEnvGoFunc(methods, "Push", mPtrStackPush, 1, true)
+	r.SetRegistry(rt.AsValue(metaKey("*Stack")), rt.TableValue(bindlib.NewMeta(r, "*testpkg.Stack", methods, nil, nil)))
+
+	r.SetEnvGoFunc(pkg, "After", fnAfter, 1, false)
+	r.SetEnv(pkg, "Answer", rt.IntValue(int64(testpkg.Answer)))
+	r.SetEnv(pkg, "Big", rt.FloatValue(float64(testpkg.Big)))
+	r.SetEnvGoFunc(pkg, "Callback", fnCallback, 1, false)
+	r.SetEnv(pkg, "Counter", rt.IntValue(int64(testpkg.Counter)))
+	r.SetEnvGoFunc(pkg, "Deadline", fnDeadline, 0, false)
+	r.SetEnv(pkg, "Default", bindlib.NewValue(r, testpkg.Default, meta(r, "*Point")))
+	r.SetEnvGoFunc(pkg, "Div", fnDiv, 2, false)
+	r.SetEnv(pkg, "Enabled", rt.BoolValue(bool(testpkg.Enabled)))
+	r.SetEnv(pkg, "ErrNegative", bindlib.NewGoValue(r, testpkg.ErrNegative))
+	r.SetEnvGoFunc(pkg, "Fail", fnFail, 0, false)
+	r.SetEnv(pkg, "Greeting", rt.StringValue(string(testpkg.Greeting)))
+	r.SetEnvGoFunc(pkg, "Half", fnHalf, 1, false)
+	r.SetEnvGoFunc(pkg, "Ints", fnInts, 1, false)
+	r.SetEnvGoFunc(pkg, "Join", fnJoin, 1, true)
+	r.SetEnvGoFunc(pkg, "Mix", fnMix, 1, false)
+	r.SetEnvGoFunc(pkg, "NewStack", fnNewStack, 0, false)
+	r.SetEnvGoFunc(pkg, "Not", fnNot, 1, false)
+	r.SetEnv(pkg, "Pi", rt.FloatValue(float64(testpkg.Pi)))
+	r.SetEnvGoFunc(pkg, "Point", newPoint, 1, false)
+	r.SetEnv(pkg, "Red", rt.IntValue(int64(testpkg.Red)))
+	r.SetEnvGoFunc(pkg, "Sqrt", fnSqrt, 1, false)
+	r.SetEnvGoFunc(pkg, "Sum", fnSum, 1, false)
+	r.SetEnvGoFunc(pkg, "TotalArea", fnTotalArea, 0, true)
+	r.SetEnvGoFunc(pkg, "Upper", fnUpper, 1, false)
+	r.SetEnvGoFunc(pkg, "Widths", fnWidths, 6, false)
+
+	return rt.TableValue(pkg), nil
+}
+
+func fnAfter(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToInt(c.Arg(0), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.After(int(a0))
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r0))), nil
+}
+
+func fnCallback(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, ok := bindlib.ToGo(c.Arg(0)).(func(int) int)
+	if !ok && !c.Arg(0).IsNil() {
+		err := bindlib.TypeError("func(int) int")
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Callback(a0)
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r0))), nil
+}
+
+func fnDeadline(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	r0 := testpkg.Deadline()
+	return c.PushingNext1(t.Runtime, bindlib.NewGoValue(t.Runtime, r0)), nil
+}
+
+func fnDiv(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToInt(c.Arg(0), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	a1, err := bindlib.ToInt(c.Arg(1), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(1, err)
+	}
+	r0, r1 := testpkg.Div(int(a0), int(a1))
+	return c.PushingNext(t.Runtime, rt.IntValue(int64(r0)), rt.IntValue(int64(r1))), nil
+}
+
+func fnFail(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := testpkg.Fail(); err != nil {
+		return nil, err
+	}
+	return c.Next(), nil
+}
+
+func fnHalf(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToFloat(c.Arg(0))
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Half(float32(a0))
+	return c.PushingNext1(t.Runtime, rt.FloatValue(float64(r0))), nil
+}
+
+func fnInts(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToInt(c.Arg(0), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Ints(int(a0))
+	return c.PushingNext1(t.Runtime, bindlib.NewGoValue(t.Runtime, r0)), nil
+}
+
+func fnJoin(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToString(c.Arg(0))
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	etc := c.Etc()
+	va := make([]string, len(etc))
+	for i, v := range etc {
+		x, err := bindlib.ToString(v)
+		if err != nil {
+			return nil, bindlib.ArgError(1+i, err)
+		}
+		va[i] = x
+	}
+	r0 := testpkg.Join(a0, va...)
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(r0))), nil
+}
+
+func fnMix(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToInt(c.Arg(0), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Mix(testpkg.Color(a0))
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r0))), nil
+}
+
+func fnNewStack(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	r0 := testpkg.NewStack()
+	return c.PushingNext1(t.Runtime, bindlib.NewValue(t.Runtime, r0, meta(t.Runtime, "*Stack"))), nil
+}
+
+func fnNot(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToBool(c.Arg(0))
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Not(a0)
+	return c.PushingNext1(t.Runtime, rt.BoolValue(bool(r0))), nil
+}
+
+func mPointAdd(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("testpkg.Point"))
+	}
+	a0, ok := toPoint(c.Arg(1))
+	if !ok {
+		err := bindlib.TypeError("testpkg.Point")
+		return nil, bindlib.ArgError(1, err)
+	}
+	r0 := recv.Add(a0)
+	return c.PushingNext1(t.Runtime, bindlib.NewValue(t.Runtime, r0, meta(t.Runtime, "Point"))), nil
+}
+
+func mPointArea(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("testpkg.Point"))
+	}
+	r0 := recv.Area()
+	return c.PushingNext1(t.Runtime, rt.FloatValue(float64(r0))), nil
+}
+
+func mPointString(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("testpkg.Point"))
+	}
+	r0 := recv.String()
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(r0))), nil
+}
+
+func getPoint(r *rt.Runtime, x interface{}, name string) (rt.Value, bool) {
+	v := x.(testpkg.Point)
+	switch name {
+	case "X":
+		return rt.IntValue(int64(v.X)), true
+	case "Y":
+		return rt.IntValue(int64(v.Y)), true
+	case "Label":
+		return rt.StringValue(string(v.Label)), true
+	case "Tags":
+		return bindlib.NewGoValue(r, v.Tags), true
+	}
+	return rt.NilValue, false
+}
+
+func setPoint(x interface{}, name string, val rt.Value) (bool, error) {
+	return false, nil
+}
+
+func mPtrPointAdd(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Point"))
+	}
+	a0, ok := toPoint(c.Arg(1))
+	if !ok {
+		err := bindlib.TypeError("testpkg.Point")
+		return nil, bindlib.ArgError(1, err)
+	}
+	r0 := recv.Add(a0)
+	return c.PushingNext1(t.Runtime, bindlib.NewValue(t.Runtime, r0, meta(t.Runtime, "Point"))), nil
+}
+
+func mPtrPointArea(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Point"))
+	}
+	r0 := recv.Area()
+	return c.PushingNext1(t.Runtime, rt.FloatValue(float64(r0))), nil
+}
+
+func mPtrPointScale(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Point"))
+	}
+	a0, err := bindlib.ToInt(c.Arg(1), 0)
+	if err != nil {
+		return nil, bindlib.ArgError(1, err)
+	}
+	recv.Scale(int(a0))
+	return c.Next(), nil
+}
+
+func mPtrPointScaled(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Point"))
+	}
+	r0 := recv.Scaled()
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r0))), nil
+}
+
+func mPtrPointString(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Point)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Point"))
+	}
+	r0 := recv.String()
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(r0))), nil
+}
+
+func getPtrPoint(r *rt.Runtime, x interface{}, name string) (rt.Value, bool) {
+	v := x.(*testpkg.Point)
+	switch name {
+	case "X":
+		return rt.IntValue(int64(v.X)), true
+	case "Y":
+		return rt.IntValue(int64(v.Y)), true
+	case "Label":
+		return rt.StringValue(string(v.Label)), true
+	case "Tags":
+		return bindlib.NewGoValue(r, v.Tags), true
+	}
+	return rt.NilValue, false
+}
+
+func setPtrPoint(x interface{}, name string, val rt.Value) (bool, error) {
+	v := x.(*testpkg.Point)
+	switch name {
+	case "X":
+		x, err := bindlib.ToInt(val, 0)
+		if err != nil {
+			return true, err
+		}
+		v.X = int(x)
+		return true, nil
+	case "Y":
+		x, err := bindlib.ToInt(val, 0)
+		if err != nil {
+			return true, err
+		}
+		v.Y = int(x)
+		return true, nil
+	case "Label":
+		x, err := bindlib.ToString(val)
+		if err != nil {
+			return true, err
+		}
+		v.Label = x
+		return true, nil
+	case "Tags":
+		x, ok := bindlib.ToGo(val).([]string)
+		if !ok && !val.IsNil() {
+			err := bindlib.TypeError("[]string")
+			return true, err
+		}
+		v.Tags = x
+		return true, nil
+	}
+	return false, nil
+}
+
+func toPoint(v rt.Value) (testpkg.Point, bool) {
+	switch x := bindlib.ToGo(v).(type) {
+	case testpkg.Point:
+		return x, true
+	case *testpkg.Point:
+		if x != nil {
+			return *x, true
+		}
+	}
+	var zero testpkg.Point
+	return zero, false
+}
+
+func newPoint(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	x := new(testpkg.Point)
+	if tbl, ok := c.Arg(0).TryTable(); ok {
+		if err := bindlib.SetFields(x, tbl, setPtrPoint); err != nil {
+			return nil, bindlib.ArgError(0, err)
+		}
+	}
+	return c.PushingNext1(t.Runtime, bindlib.NewValue(t.Runtime, x, meta(t.Runtime, "*Point"))), nil
+}
+
+func fnSqrt(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToFloat(c.Arg(0))
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0, err := testpkg.Sqrt(a0)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, rt.FloatValue(float64(r0))), nil
+}
+
+func mPtrStackPop(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Stack)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Stack"))
+	}
+	r0, r1 := recv.Pop()
+	return c.PushingNext(t.Runtime, rt.IntValue(int64(r0)), rt.BoolValue(bool(r1))), nil
+}
+
+func mPtrStackPush(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	recv, ok := bindlib.ToGo(c.Arg(0)).(*testpkg.Stack)
+	if !ok {
+		return nil, bindlib.ArgError(0, bindlib.TypeError("*testpkg.Stack"))
+	}
+	etc := c.Etc()
+	va := make([]int, len(etc))
+	for i, v := range etc {
+		x, err := bindlib.ToInt(v, 0)
+		if err != nil {
+			return nil, bindlib.ArgError(1+i, err)
+		}
+		va[i] = int(x)
+	}
+	recv.Push(va...)
+	return c.Next(), nil
+}
+
+func toStack(v rt.Value) (testpkg.Stack, bool) {
+	switch x := bindlib.ToGo(v).(type) {
+	case testpkg.Stack:
+		return x, true
+	case *testpkg.Stack:
+		if x != nil {
+			return *x, true
+		}
+	}
+	var zero testpkg.Stack
+	return zero, false
+}
+
+func fnSum(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, ok := bindlib.ToGo(c.Arg(0)).([]int)
+	if !ok && !c.Arg(0).IsNil() {
+		err := bindlib.TypeError("[]int")
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Sum(a0)
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r0))), nil
+}
+
+func fnTotalArea(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	etc := c.Etc()
+	va := make([]testpkg.Shape, len(etc))
+	for i, v := range etc {
+		x, ok := bindlib.ToGo(v).(testpkg.Shape)
+		if !ok && !v.IsNil() {
+			err := bindlib.TypeError("testpkg.Shape")
+			return nil, bindlib.ArgError(i, err)
+		}
+		va[i] = x
+	}
+	r0 := testpkg.TotalArea(va...)
+	return c.PushingNext1(t.Runtime, rt.FloatValue(float64(r0))), nil
+}
+
+func fnUpper(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToBytes(c.Arg(0))
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	r0 := testpkg.Upper(a0)
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(r0))), nil
+}
+
+func fnWidths(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	a0, err := bindlib.ToInt(c.Arg(0), 8)
+	if err != nil {
+		return nil, bindlib.ArgError(0, err)
+	}
+	a1, err := bindlib.ToUint(c.Arg(1), 8)
+	if err != nil {
+		return nil, bindlib.ArgError(1, err)
+	}
+	a2, err := bindlib.ToInt(c.Arg(2), 16)
+	if err != nil {
+		return nil, bindlib.ArgError(2, err)
+	}
+	a3, err := bindlib.ToUint(c.Arg(3), 32)
+	if err != nil {
+		return nil, bindlib.ArgError(3, err)
+	}
+	a4, err := bindlib.ToInt(c.Arg(4), 64)
+	if err != nil {
+		return nil, bindlib.ArgError(4, err)
+	}
+	a5, err := bindlib.ToUint(c.Arg(5), 64)
+	if err != nil {
+		return nil, bindlib.ArgError(5, err)
+	}
+	r0 := testpkg.Widths(int8(a0), uint8(a1), int16(a2), uint32(a3), a4, a5)
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(r0))), nil
+}
+
+// Not bound:
+//   - Private: unexported type testpkg.private
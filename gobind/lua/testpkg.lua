@@ -0,0 +1,132 @@
+local tp = require "testpkg"
+
+print(tp == testpkg)
+--> =true
+
+-- Constants and variables
+
+print(tp.Answer, tp.Greeting, tp.Pi, tp.Enabled, tp.Red)
+--> =42	hello	3.14	true	1
+
+print(math.type(tp.Big), tp.Big == 2^64)
+--> =float	true
+
+print(tp.Counter)
+--> =10
+
+print(tp.Default.X, tp.Default.Y)
+--> =1	2
+
+print(tp.hidden, tp.Private)
+--> =nil	nil
+
+-- Functions
+
+print(tp.Div(17, 5))
+--> =3	2
+
+print(tp.Widths(-1, 255, -300, 4000000000, math.mininteger, 2^63))
+--> =-1 255 -300 4000000000 -9223372036854775808 9223372036854775808
+
+print(tp.Join(", ", "a", "b", 3))
+--> =a, b, 3
+
+print(tp.Join("-"))
+--> =
+
+print(tp.Upper("abc"), tp.Not(nil), tp.Half(3), tp.Mix(tp.Red))
+--> =ABC	true	1.5	2
+
+print(tp.After(2) == 2000000000)
+--> =true
+
+print(tp.Sqrt(16))
+--> =4
+
+print(pcall(tp.Sqrt, -1))
+--> ~false	.*negative number
+
+print(pcall(tp.Fail))
+--> ~false	.*failed
+
+-- Go values with no Lua equivalent are passed around as userdata.
+
+local ints = tp.Ints(4)
+print(tp.Sum(ints))
+--> =6
+
+print(tostring(tp.Deadline()))
+--> =2000-01-01 00:00:00 +0000 UTC
+
+print(tp.ErrNegative)
+--> =negative number
+
+-- Argument checking
+
+print(pcall(tp.Div, 1))
+--> ~false	.*#2 must be an integer
+
+print(pcall(tp.Div, 1, "x"))
+--> ~false	.*#2 must be an integer
+
+print(pcall(tp.Widths, 128, 0, 0, 0, 0, 0))
+--> ~false	.*#1 is out of range for int8
+
+print(pcall(tp.Widths, 0, -1, 0, 0, 0, 0))
+--> ~false	.*#2 is out of range for uint8
+
+print(pcall(tp.Join, ",", "a", {}))
+--> ~false	.*#3 must be a string
+
+print(pcall(tp.Sum, {1, 2}))
+--> ~false	.*#1 must be \[\]int
+
+print(pcall(tp.Not, 1))
+--> ~false	.*#1 must be a boolean
+
+-- Struct types
+
+local p = tp.Point{X = 3, Y = 4, Label = "p"}
+print(p, p.X, p.Y, p.Label)
+--> =(3, 4)	3	4	p
+
+p.X = 5
+p:Scale(2)
+print(p.X, p.Y, p:Scaled())
+--> =10	8	1
+
+local q = p:Add(tp.Point{X = 1})
+print(q, q.X)
+--> =(11, 8)	11
+
+print(pcall(function() q.X = 2 end))
+--> ~false\t.*cannot set field X of testpkg.Point
+
+print(pcall(function() p.X = "x" end))
+--> ~false\t.*field X must be an integer
+
+print(pcall(function() return p.Nope end))
+--> ~false\t.*has no field or method Nope
+
+print(pcall(tp.Point, {Nope = 1}))
+--> ~false	.*#1 no field Nope
+
+print(tp.TotalArea(p, q, tp.Point{X = 2, Y = 2}))
+--> =172
+
+print(pcall(tp.TotalArea, p, 1))
+--> ~false	.*#2 must be testpkg\.Shape
+
+print(p == p, p == tp.Point(), tp.Deadline() == tp.Deadline())
+--> =true	false	true
+
+-- Non-struct types with methods
+
+local s = tp.NewStack()
+s:Push(1, 2, 3)
+print(s:Pop())
+--> =3	true
+
+print(pcall(s.Push, s, "x"))
+--> ~false	.*#2 must be an integer
+
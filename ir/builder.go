@@ -27,6 +27,7 @@ type CodeBuilder struct {
 	code         []Instruction
 	lines        []int
 	labels       []bool
+	info         FunctionInfo
 	constantPool *ConstantPool
 }
 
@@ -174,6 +175,9 @@ func (c *CodeBuilder) PopContext() {
 	c.emitTruncate(context.top())
 	c.context = context
 	c.emitClearReg(top)
+	for i := len(top.locals) - 1; i >= 0; i-- {
+		c.EmitNoLine(EndLocalVar{Reg: top.locals[i]})
+	}
 	for _, tr := range top.reg {
 		c.ReleaseRegister(tr.reg)
 	}
@@ -224,9 +228,19 @@ func (c *CodeBuilder) EmitJump(lblName Name, line int) bool {
 	return false
 }
 
+// DeclareLocal makes name refer to reg in the current lexical scope.  It also
+// emits a DeclareLocalVar instruction so that the compiled code records where
+// the local variable is in scope (e.g. for debug.getlocal).
 func (c *CodeBuilder) DeclareLocal(name Name, reg Register) {
 	c.TakeRegister(reg)
 	c.context.addToTop(name, reg)
+	c.EmitNoLine(DeclareLocalVar{Name: name, Reg: reg})
+}
+
+// SetFunctionInfo records information about the function being built (number
+// of parameters, where it is defined in the source...).
+func (c *CodeBuilder) SetFunctionInfo(info FunctionInfo) {
+	c.info = info
 }
 
 func (c *CodeBuilder) MarkConstantReg(reg Register) {
@@ -263,6 +277,7 @@ func (c *CodeBuilder) getCode() *Code {
 		UpvalueDests: c.upvalueDests,
 		UpNames:      c.upnames,
 		Name:         c.chunkName,
+		FunctionInfo: c.info,
 	}
 }
 
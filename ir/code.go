@@ -66,6 +66,16 @@ type Code struct {
 	Registers    []RegData
 	UpNames      []string
 	Name         string
+	FunctionInfo
+}
+
+// FunctionInfo contains information about a function definition which is not
+// needed to run it but is useful for debugging.
+type FunctionInfo struct {
+	ParamCount      int  // Number of named parameters
+	IsVararg        bool // True if the function has a "..." parameter
+	LineDefined     int  // Line where the function definition starts (0 for a main chunk)
+	LastLineDefined int  // Line where the function definition ends (0 for a main chunk)
 }
 
 // ProcessConstant uses the given ConstantProcessor to process the receiver.
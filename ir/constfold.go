@@ -0,0 +1,269 @@
+package ir
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/arnodel/golua/ops"
+)
+
+// This file implements the computation of operations on constants at compile
+// time.  An operation is only folded when its result does not depend on the
+// runtime (e.g. metamethods, string coercions) and when it cannot fail.
+
+// foldBinOp returns (z, true) where z is the constant value of x op y, or
+// (nil, false) if it should be computed at runtime.
+func foldBinOp(op ops.Op, x, y Constant) (Constant, bool) {
+	switch op {
+	case ops.OpEq:
+		if eq, ok := constEqual(x, y); ok {
+			return Bool(eq), true
+		}
+	case ops.OpLt, ops.OpLeq:
+		return foldComparison(op, x, y)
+	case ops.OpConcat:
+		sx, okx := concatString(x)
+		sy, oky := concatString(y)
+		if okx && oky {
+			return String(sx + sy), true
+		}
+	case ops.OpBitAnd, ops.OpBitOr, ops.OpBitXor, ops.OpShiftL, ops.OpShiftR:
+		nx, okx := x.(Int)
+		ny, oky := y.(Int)
+		if okx && oky {
+			return foldBitwise(op, nx, ny), true
+		}
+	default:
+		return foldArith(op, x, y)
+	}
+	return nil, false
+}
+
+// foldUnOp returns (z, true) where z is the constant value of op x, or (nil,
+// false) if it should be computed at runtime.
+func foldUnOp(op ops.Op, x Constant) (Constant, bool) {
+	switch op {
+	case ops.OpId:
+		return x, true
+	case ops.OpNot:
+		return Bool(!isTruthy(x)), true
+	case ops.OpNeg:
+		switch n := x.(type) {
+		case Int:
+			return -n, true
+		case Float:
+			return checkFloat(-n)
+		}
+	case ops.OpLen:
+		if s, ok := x.(String); ok {
+			return Int(len(s)), true
+		}
+	case ops.OpBitNot:
+		if n, ok := x.(Int); ok {
+			return ^n, true
+		}
+	}
+	return nil, false
+}
+
+// isTruthy returns true if the constant k is neither nil nor false.
+func isTruthy(k Constant) bool {
+	switch x := k.(type) {
+	case NilType:
+		return false
+	case Bool:
+		return bool(x)
+	default:
+		return true
+	}
+}
+
+func foldArith(op ops.Op, x, y Constant) (Constant, bool) {
+	nx, okx := x.(Int)
+	ny, oky := y.(Int)
+	if okx && oky {
+		switch op {
+		case ops.OpAdd:
+			return nx + ny, true
+		case ops.OpSub:
+			return nx - ny, true
+		case ops.OpMul:
+			return nx * ny, true
+		case ops.OpFloorDiv:
+			if ny == 0 {
+				// This is an error at runtime.
+				return nil, false
+			}
+			return floordivInt(nx, ny), true
+		case ops.OpMod:
+			if ny == 0 {
+				// This is an error at runtime.
+				return nil, false
+			}
+			return modInt(nx, ny), true
+		}
+	}
+	fx, okx := toFloat(x)
+	fy, oky := toFloat(y)
+	if !okx || !oky {
+		return nil, false
+	}
+	switch op {
+	case ops.OpAdd:
+		return checkFloat(fx + fy)
+	case ops.OpSub:
+		return checkFloat(fx - fy)
+	case ops.OpMul:
+		return checkFloat(fx * fy)
+	case ops.OpDiv:
+		return checkFloat(fx / fy)
+	case ops.OpFloorDiv:
+		return checkFloat(Float(math.Floor(float64(fx / fy))))
+	case ops.OpMod:
+		return checkFloat(modFloat(fx, fy))
+	case ops.OpPow:
+		return checkFloat(Float(math.Pow(float64(fx), float64(fy))))
+	}
+	return nil, false
+}
+
+func foldBitwise(op ops.Op, x, y Int) Int {
+	switch op {
+	case ops.OpBitAnd:
+		return x & y
+	case ops.OpBitOr:
+		return x | y
+	case ops.OpBitXor:
+		return x ^ y
+	case ops.OpShiftL:
+		if y < 0 {
+			return Int(uint64(x) >> uint64(-y))
+		}
+		return Int(uint64(x) << uint64(y))
+	default: // ops.OpShiftR
+		if y < 0 {
+			return Int(uint64(x) << uint64(-y))
+		}
+		return Int(uint64(x) >> uint64(y))
+	}
+}
+
+// foldComparison folds comparisons between numbers of the same type and
+// between strings.
+func foldComparison(op ops.Op, x, y Constant) (Constant, bool) {
+	var lt, eq bool
+	switch vx := x.(type) {
+	case Int:
+		vy, ok := y.(Int)
+		if !ok {
+			return nil, false
+		}
+		lt, eq = vx < vy, vx == vy
+	case Float:
+		vy, ok := y.(Float)
+		if !ok {
+			return nil, false
+		}
+		lt, eq = vx < vy, vx == vy
+	case String:
+		vy, ok := y.(String)
+		if !ok {
+			return nil, false
+		}
+		lt, eq = vx < vy, vx == vy
+	default:
+		return nil, false
+	}
+	if op == ops.OpLt {
+		return Bool(lt), true
+	}
+	return Bool(lt || eq), true
+}
+
+// constEqual returns (x == y, true) if the equality can be decided at compile
+// time.  That is always the case as constants cannot have an __eq metamethod.
+func constEqual(x, y Constant) (bool, bool) {
+	switch vx := x.(type) {
+	case Int:
+		switch vy := y.(type) {
+		case Int:
+			return vx == vy, true
+		case Float:
+			return equalIntAndFloat(vx, vy), true
+		}
+	case Float:
+		switch vy := y.(type) {
+		case Int:
+			return equalIntAndFloat(vy, vx), true
+		case Float:
+			return vx == vy, true
+		}
+	case String, Bool, NilType:
+		return x == y, true
+	default:
+		return false, false
+	}
+	return false, true
+}
+
+func equalIntAndFloat(n Int, f Float) bool {
+	nf := int64(f)
+	return float64(nf) == float64(f) && Int(nf) == n
+}
+
+// concatString returns the string a constant is converted to by the ..
+// operator.  Floats are not converted as their formatting is done by the
+// runtime.
+func concatString(k Constant) (string, bool) {
+	switch x := k.(type) {
+	case String:
+		return string(x), true
+	case Int:
+		return strconv.FormatInt(int64(x), 10), true
+	}
+	return "", false
+}
+
+func toFloat(k Constant) (Float, bool) {
+	switch x := k.(type) {
+	case Int:
+		return Float(x), true
+	case Float:
+		return x, true
+	}
+	return 0, false
+}
+
+// checkFloat returns (f, true) unless f cannot be stored in the constant pool
+// without being confused with another value (NaN and -0).
+func checkFloat(f Float) (Constant, bool) {
+	if f != f || (f == 0 && math.Signbit(float64(f))) {
+		return nil, false
+	}
+	return f, true
+}
+
+func floordivInt(x, y Int) Int {
+	r := x % y
+	q := x / y
+	if r != 0 && (r < 0) != (y < 0) {
+		q--
+	}
+	return q
+}
+
+func modInt(x, y Int) Int {
+	r := x % y
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r
+}
+
+func modFloat(x, y Float) Float {
+	r := Float(math.Mod(float64(x), float64(y)))
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r
+}
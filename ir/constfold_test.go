@@ -0,0 +1,77 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/ops"
+)
+
+func TestFoldBinOp(t *testing.T) {
+	tests := []struct {
+		op     ops.Op
+		x, y   Constant
+		result Constant // nil if the operation should not be folded
+	}{
+		{ops.OpAdd, Int(1), Int(2), Int(3)},
+		{ops.OpAdd, Int(1), Float(2), Float(3)},
+		{ops.OpAdd, Int(1<<63 - 1), Int(1), Int(-1 << 63)},
+		{ops.OpDiv, Int(6), Int(3), Float(2)},
+		{ops.OpFloorDiv, Int(-7), Int(2), Int(-4)},
+		{ops.OpFloorDiv, Float(7), Int(2), Float(3)},
+		{ops.OpFloorDiv, Int(1), Int(0), nil},
+		{ops.OpMod, Int(-7), Int(3), Int(2)},
+		{ops.OpMod, Int(1), Int(0), nil},
+		{ops.OpMod, Float(5.5), Int(-2), Float(-0.5)},
+		{ops.OpDiv, Int(0), Int(0), nil},
+		{ops.OpMul, Float(-1), Int(0), nil},
+		{ops.OpPow, Int(2), Int(3), Float(8)},
+		{ops.OpAdd, String("1"), Int(1), nil},
+		{ops.OpShiftL, Int(1), Int(64), Int(0)},
+		{ops.OpShiftR, Int(-1), Int(63), Int(1)},
+		{ops.OpBitAnd, Float(3), Int(1), nil},
+		{ops.OpConcat, String("a"), Int(1), String("a1")},
+		{ops.OpConcat, String("a"), Float(1), nil},
+		{ops.OpEq, Int(1), Float(1), Bool(true)},
+		{ops.OpEq, String("1"), Int(1), Bool(false)},
+		{ops.OpEq, NilType{}, Bool(false), Bool(false)},
+		{ops.OpLt, String("a"), String("b"), Bool(true)},
+		{ops.OpLeq, Int(2), Int(2), Bool(true)},
+		{ops.OpLt, Int(1), Float(2), nil},
+		{ops.OpLt, Int(1), String("2"), nil},
+	}
+	for _, test := range tests {
+		result, ok := foldBinOp(test.op, test.x, test.y)
+		if !ok {
+			result = nil
+		}
+		if result != test.result {
+			t.Errorf("%s(%v, %v): got %#v, expected %#v", test.op, test.x, test.y, result, test.result)
+		}
+	}
+}
+
+func TestFoldUnOp(t *testing.T) {
+	tests := []struct {
+		op     ops.Op
+		x      Constant
+		result Constant // nil if the operation should not be folded
+	}{
+		{ops.OpNeg, Int(1), Int(-1)},
+		{ops.OpNeg, Float(0), nil},
+		{ops.OpNot, NilType{}, Bool(true)},
+		{ops.OpNot, Int(0), Bool(false)},
+		{ops.OpLen, String("abc"), Int(3)},
+		{ops.OpLen, Int(3), nil},
+		{ops.OpBitNot, Int(0), Int(-1)},
+		{ops.OpBitNot, Float(0), nil},
+	}
+	for _, test := range tests {
+		result, ok := foldUnOp(test.op, test.x)
+		if !ok {
+			result = nil
+		}
+		if result != test.result {
+			t.Errorf("%s(%v): got %#v, expected %#v", test.op, test.x, result, test.result)
+		}
+	}
+}
@@ -6,6 +6,7 @@ type lexicalScope struct {
 	reg    map[Name]taggedReg     // maps variable names to registers
 	label  map[Name]labelWithLine // maps label names to labels
 	height int                    // This is the height of the close stack in this scope
+	locals []Register             // registers of local variables declared in this scope
 }
 
 func (s lexicalScope) getLabel(name Name) (label Label, line int, ok bool) {
@@ -79,6 +80,7 @@ func (c lexicalContext) addToTop(name Name, reg Register) (ok bool) {
 	ok = len(c) > 0
 	if ok {
 		c[len(c)-1].reg[name] = taggedReg{reg, 0}
+		c[len(c)-1].locals = append(c[len(c)-1].locals, reg)
 	}
 	return
 }
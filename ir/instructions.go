@@ -52,6 +52,10 @@ type InstrProcessor interface {
 
 	// A label (for jumping to)
 	ProcessDeclareLabelInstr(DeclareLabel)
+
+	// These mark where local variables come in and out of scope.
+	ProcessDeclareLocalVarInstr(DeclareLocalVar)
+	ProcessEndLocalVarInstr(EndLocalVar)
 }
 
 // A Register is an IR register.  The number of IR registers is not bounded
@@ -502,6 +506,39 @@ func (l DeclareLabel) ProcessInstr(p InstrProcessor) {
 	p.ProcessDeclareLabelInstr(l)
 }
 
+// DeclareLocalVar is not a real instruction.  It marks the location where the
+// local variable Name, stored in Reg, comes into scope.  It allows the next
+// stage to record local variable names for debugging purposes.
+type DeclareLocalVar struct {
+	Name Name
+	Reg  Register
+}
+
+func (d DeclareLocalVar) String() string {
+	return fmt.Sprintf("local %s = %s", d.Name, d.Reg)
+}
+
+// ProcessInstr makes the InstrProcessor process this instruction.
+func (d DeclareLocalVar) ProcessInstr(p InstrProcessor) {
+	p.ProcessDeclareLocalVarInstr(d)
+}
+
+// EndLocalVar is not a real instruction.  It marks the location where the
+// local variable stored in Reg goes out of scope.  It should be preceded by a
+// DeclareLocalVar for the same register.
+type EndLocalVar struct {
+	Reg Register
+}
+
+func (e EndLocalVar) String() string {
+	return fmt.Sprintf("end local %s", e.Reg)
+}
+
+// ProcessInstr makes the InstrProcessor process this instruction.
+func (e EndLocalVar) ProcessInstr(p InstrProcessor) {
+	p.ProcessEndLocalVarInstr(e)
+}
+
 // PrepForLoop prepares a for loop
 type PrepForLoop struct {
 	Start, Stop, Step Register
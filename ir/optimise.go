@@ -0,0 +1,797 @@
+package ir
+
+import (
+	"github.com/arnodel/golua/ops"
+)
+
+// Optimisation levels that can be passed to Optimise.
+const (
+	// NoOptimisation leaves the code as the compiler emitted it.
+	NoOptimisation = 0
+
+	// BasicOptimisation folds operations on constants, removes truthiness
+	// tests whose outcome is known, removes unreachable code and threads
+	// jumps.  Registers are then allocated according to their liveness
+	// rather than their lexical scope, except for local variables which are
+	// visible to the debug library while they are in scope.
+	BasicOptimisation = 1
+
+	// FullOptimisation also propagates copies between registers.
+	FullOptimisation = 2
+)
+
+// The passes are repeated until they no longer change the code, but no more
+// than this number of times.
+const maxOptimisationRounds = 10
+
+// Optimise applies the optimisations selected by level to all the code
+// constants in consts.  The returned slice starts with the same constants (with
+// the code constants replaced by their optimised version), followed by new
+// constants which are the results of folding.
+//
+// The optimisations work with the register allocation made by ircomp, which
+// lets a register which is not taken share its storage with registers
+// allocated after it.  So they never make an instruction read a register
+// unless it is certain that it still holds the value it is expected to.
+func Optimise(consts []Constant, level int) []Constant {
+	if level <= NoOptimisation {
+		return consts
+	}
+	kp := &ConstantPool{
+		constants: append([]Constant(nil), consts...),
+		kmap:      make(map[Constant]uint, len(consts)),
+	}
+	for i, k := range consts {
+		if _, ok := kp.kmap[k]; !ok {
+			kp.kmap[k] = uint(i)
+		}
+	}
+	for i, k := range consts {
+		if c, ok := k.(*Code); ok {
+			oc := optimiseCode(*c, kp, level)
+			kp.constants[i] = &oc
+		}
+	}
+	return kp.constants
+}
+
+func optimiseCode(c Code, kp *ConstantPool, level int) Code {
+	o := newOptimiser(c, kp)
+	passes := []func() bool{
+		o.propagateConstants,
+		o.threadJumps,
+	}
+	if level >= FullOptimisation {
+		passes = append(passes, o.propagateCopies)
+	}
+	passes = append(passes, o.removeDeadCode)
+	for i := 0; i < maxOptimisationRounds; i++ {
+		changed := false
+		for _, pass := range passes {
+			if pass() {
+				changed = true
+			}
+			o.compact()
+		}
+		if !changed {
+			break
+		}
+	}
+	c.Instructions = o.instrs
+	c.Lines = o.lines
+	return allocateRegisters(FoldCode(c, FoldTakeRelease))
+}
+
+// An optimiser transforms the code of a function.  Passes remove instructions
+// by setting them to nil, and the code is compacted after each pass.
+type optimiser struct {
+	kp        *ConstantPool
+	regs      []RegData
+	instrs    []Instruction
+	lines     []int
+	pinned    []bool // Registers which must be kept as they are
+	nextLabel Label  // Next label number available for a new label
+
+	// Computed by analyse()
+	reads     []int         // Number of instructions reading each register
+	writes    []int         // Number of instructions writing to each register
+	labelRefs map[Label]int // Number of jumps to each label
+	labelPos  map[Label]int // Index of the declaration of each label
+
+	inserts map[int]Label // Labels to insert before some instructions
+}
+
+func newOptimiser(c Code, kp *ConstantPool) *optimiser {
+	o := &optimiser{
+		kp:      kp,
+		regs:    c.Registers,
+		instrs:  append([]Instruction(nil), c.Instructions...),
+		lines:   append([]int(nil), c.Lines...),
+		pinned:  make([]bool, len(c.Registers)),
+		inserts: map[int]Label{},
+	}
+	// Cells can be read and written by other functions and local variables
+	// are visible to the debug library, so they are left alone.
+	for r, data := range c.Registers {
+		o.pinned[r] = data.IsCell
+	}
+	for _, instr := range o.instrs {
+		switch x := instr.(type) {
+		case DeclareLocalVar:
+			o.pinned[x.Reg] = true
+		case DeclareLabel:
+			o.useLabel(x.Label)
+		case Jump:
+			o.useLabel(x.Label)
+		case JumpIf:
+			o.useLabel(x.Label)
+		}
+	}
+	return o
+}
+
+func (o *optimiser) useLabel(lbl Label) {
+	if lbl >= o.nextLabel {
+		o.nextLabel = lbl + 1
+	}
+}
+
+// analyse counts the register reads and writes and the label references.
+func (o *optimiser) analyse() {
+	o.reads = make([]int, len(o.regs))
+	o.writes = make([]int, len(o.regs))
+	o.labelRefs = map[Label]int{}
+	o.labelPos = map[Label]int{}
+	for i, instr := range o.instrs {
+		forEachRead(instr, func(r Register) { o.reads[r]++ })
+		forEachWrite(instr, func(r Register) { o.writes[r]++ })
+		switch x := instr.(type) {
+		case Jump:
+			o.labelRefs[x.Label]++
+		case JumpIf:
+			o.labelRefs[x.Label]++
+		case DeclareLabel:
+			o.labelPos[x.Label] = i
+		}
+	}
+}
+
+// compact removes the nil instructions and inserts the new labels.
+func (o *optimiser) compact() {
+	instrs := o.instrs[:0:0]
+	lines := o.lines[:0:0]
+	for i, instr := range o.instrs {
+		if lbl, ok := o.inserts[i]; ok {
+			instrs = append(instrs, DeclareLabel{Label: lbl})
+			lines = append(lines, 0)
+		}
+		if instr != nil {
+			instrs = append(instrs, instr)
+			lines = append(lines, o.lines[i])
+		}
+	}
+	if lbl, ok := o.inserts[len(o.instrs)]; ok {
+		instrs = append(instrs, DeclareLabel{Label: lbl})
+		lines = append(lines, 0)
+	}
+	o.instrs = instrs
+	o.lines = lines
+	o.inserts = map[int]Label{}
+}
+
+// isTemp returns true if r is a register holding an intermediate value, that
+// the passes can remove or replace.
+func (o *optimiser) isTemp(r Register) bool {
+	return !o.pinned[r]
+}
+
+// constant returns the value of the constant with index kidx.
+func (o *optimiser) constant(kidx uint) Constant {
+	return o.kp.constants[kidx]
+}
+
+// loadConst returns an instruction loading k into dst.
+func (o *optimiser) loadConst(dst Register, k Constant) Instruction {
+	return LoadConst{Dst: dst, Kidx: o.kp.GetConstantIndex(k)}
+}
+
+//
+// Constant propagation
+//
+
+// propagateConstants folds operations on registers holding known constants and
+// removes tests whose outcome is known.  The knowledge about registers is
+// limited to a basic block.
+func (o *optimiser) propagateConstants() bool {
+	o.analyse()
+	var (
+		changed bool
+		known   = map[Register]uint{} // Registers containing a known constant
+		truth   = map[Register]bool{} // Registers with a known truthiness
+	)
+	for i, instr := range o.instrs {
+		replaced := true
+		switch x := instr.(type) {
+		case Transform:
+			if kidx, ok := known[x.Src]; ok {
+				if k, ok := foldUnOp(x.Op, o.constant(kidx)); ok {
+					instr = o.loadConst(x.Dst, k)
+					break
+				}
+			}
+			replaced = false
+		case Combine:
+			kl, okl := known[x.Lsrc]
+			kr, okr := known[x.Rsrc]
+			if okl && okr {
+				if k, ok := foldBinOp(x.Op, o.constant(kl), o.constant(kr)); ok {
+					instr = o.loadConst(x.Dst, k)
+					break
+				}
+			}
+			replaced = false
+		case JumpIf:
+			t, ok := truth[x.Cond]
+			if kidx, isConst := known[x.Cond]; isConst {
+				t, ok = isTruthy(o.constant(kidx)), true
+			}
+			if ok && t != x.Not {
+				// The jump always happens.
+				instr = Jump{Label: x.Label}
+			} else if ok {
+				// The jump never happens.
+				instr = nil
+			} else if j, src, ok := o.negation(i, x.Cond); ok {
+				// (r1 := not(r2); jump if r1 is not b) ==> jump if r2 is not ~b
+				o.instrs[j] = nil
+				instr = JumpIf{Cond: src, Label: x.Label, Not: !x.Not}
+			} else {
+				replaced = false
+			}
+		default:
+			replaced = false
+		}
+		if replaced {
+			o.instrs[i] = instr
+			changed = true
+		}
+		switch x := instr.(type) {
+		case nil:
+			continue
+		case DeclareLabel, Jump, Call:
+			// Other paths join here or the registers may have been changed.
+			known = map[Register]uint{}
+			truth = map[Register]bool{}
+			continue
+		case JumpIf:
+			// The jump did not happen
+			if !o.regs[x.Cond].IsCell {
+				truth[x.Cond] = x.Not
+			}
+		}
+		forEachWrite(instr, func(r Register) {
+			delete(known, r)
+			delete(truth, r)
+		})
+		if l, ok := instr.(LoadConst); ok && !o.regs[l.Dst].IsCell {
+			known[l.Dst] = l.Kidx
+		}
+	}
+	return changed
+}
+
+// negation returns (j, src, true) if the instruction at j sets r to not(src),
+// only pseudo-instructions are between j and i and r is not read anywhere else
+// than at i.
+func (o *optimiser) negation(i int, r Register) (int, Register, bool) {
+	if !o.isTemp(r) || o.reads[r] != 1 || o.writes[r] != 1 {
+		return 0, 0, false
+	}
+	j := o.prevInstr(i, func(Instruction) bool { return true })
+	if j < 0 {
+		return 0, 0, false
+	}
+	t, ok := o.instrs[j].(Transform)
+	if !ok || t.Op != ops.OpNot || t.Dst != r || t.Src == r {
+		return 0, 0, false
+	}
+	return j, t.Src, true
+}
+
+// prevInstr returns the index of the last instruction before i which is not a
+// pseudo-instruction accepted by skip, or -1.
+func (o *optimiser) prevInstr(i int, skip func(Instruction) bool) int {
+	for j := i - 1; j >= 0; j-- {
+		instr := o.instrs[j]
+		if instr == nil {
+			continue
+		}
+		if !isPseudoInstr(instr) || !skip(instr) {
+			return j
+		}
+	}
+	return -1
+}
+
+//
+// Jump threading
+//
+
+// threadJumps makes jumps to another jump go directly to the final
+// destination.
+func (o *optimiser) threadJumps() bool {
+	o.analyse()
+	changed := false
+	for i, instr := range o.instrs {
+		switch x := instr.(type) {
+		case Jump:
+			if lbl := o.threadJump(x.Label, nil); lbl != x.Label {
+				o.instrs[i] = Jump{Label: lbl}
+				changed = true
+			}
+		case JumpIf:
+			if lbl := o.threadJump(x.Label, &x); lbl != x.Label {
+				x.Label = lbl
+				o.instrs[i] = x
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// threadJump returns the label where a jump to lbl eventually leads.  If cond
+// is not nil, the jump is a conditional jump, so it can also be threaded
+// through conditional jumps on the same register.
+func (o *optimiser) threadJump(lbl Label, cond *JumpIf) Label {
+	seen := map[Label]bool{}
+	for !seen[lbl] {
+		seen[lbl] = true
+		pos, ok := o.labelPos[lbl]
+		if !ok {
+			return lbl
+		}
+		j := o.nextInstr(pos)
+		if j < 0 {
+			return lbl
+		}
+		switch x := o.instrs[j].(type) {
+		case Jump:
+			lbl = x.Label
+		case JumpIf:
+			if cond == nil || x.Cond != cond.Cond {
+				return lbl
+			}
+			if x.Not != cond.Not {
+				// The test fails so carry on after it.
+				return o.labelAfter(j)
+			}
+			lbl = x.Label
+		default:
+			return lbl
+		}
+	}
+	return lbl
+}
+
+// nextInstr returns the index of the first instruction after i which is not a
+// label or a pseudo-instruction, or -1.
+func (o *optimiser) nextInstr(i int) int {
+	for j := i + 1; j < len(o.instrs); j++ {
+		switch instr := o.instrs[j].(type) {
+		case nil, DeclareLabel:
+		default:
+			if !isPseudoInstr(instr) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// labelAfter returns a label declared just after the instruction at i, adding
+// one if necessary.
+func (o *optimiser) labelAfter(i int) Label {
+	for j := i + 1; j < len(o.instrs); j++ {
+		switch x := o.instrs[j].(type) {
+		case nil:
+			continue
+		case DeclareLabel:
+			return x.Label
+		}
+		break
+	}
+	lbl, ok := o.inserts[i+1]
+	if !ok {
+		lbl = o.nextLabel
+		o.nextLabel++
+		o.inserts[i+1] = lbl
+	}
+	return lbl
+}
+
+//
+// Copy propagation
+//
+
+// propagateCopies removes moves between registers when possible.  There are
+// two cases.
+//
+// (r1 := X; r2 := r1) ==> r2 := X if r1 is not used elsewhere.
+//
+// (r2 := r1; ...; Y(r2)) ==> ...; Y(r1) if all the uses of r2 are in the same
+// basic block and r1 is taken until then and not changed.
+func (o *optimiser) propagateCopies() bool {
+	o.analyse()
+	changed := false
+	takes := make([]int, len(o.regs))
+	for i, instr := range o.instrs {
+		switch x := instr.(type) {
+		case TakeRegister:
+			takes[x.Reg]++
+		case ReleaseRegister:
+			takes[x.Reg]--
+		case Transform:
+			if x.Op != ops.OpId || x.Dst == x.Src {
+				break
+			}
+			if o.coalesceMove(i, x) || takes[x.Src] > 0 && o.forwardMove(i, x) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// coalesceMove makes the instruction setting the source of the move at i set
+// its destination instead, and removes the move.
+func (o *optimiser) coalesceMove(i int, move Transform) bool {
+	src := move.Src
+	if !o.isTemp(src) || o.reads[src] != 1 || o.writes[src] != 1 {
+		return false
+	}
+	skip := func(instr Instruction) bool {
+		switch x := instr.(type) {
+		case TakeRegister:
+			return x.Reg == src
+		case ReleaseRegister:
+			return x.Reg == src
+		}
+		return false
+	}
+	j := o.prevInstr(i, skip)
+	if j < 0 {
+		return false
+	}
+	setReg, ok := o.instrs[j].(SetRegInstruction)
+	if !ok || setReg.DestReg() != src {
+		return false
+	}
+	if m, ok := setReg.(MkClosure); ok {
+		for _, r := range m.Upvalues {
+			if r == move.Dst {
+				// The upvalues are set after the closure is stored
+				return false
+			}
+		}
+	}
+	o.instrs[j] = setReg.WithDestReg(move.Dst)
+	o.lines[j] = mergeLines(o.lines[j], o.lines[i])
+	for k := j + 1; k <= i; k++ {
+		o.instrs[k] = nil
+	}
+	o.reads[src], o.writes[src] = 0, 0
+	return true
+}
+
+// forwardMove replaces the reads of the destination of the move at i with reads
+// of its source, and removes the move.  The source must be taken at i.
+func (o *optimiser) forwardMove(i int, move Transform) bool {
+	dst, src := move.Dst, move.Src
+	if !o.isTemp(dst) || o.regs[src].IsCell || o.writes[dst] != 1 || o.reads[dst] == 0 {
+		return false
+	}
+	var uses []int
+	found := 0
+	for j := i + 1; j < len(o.instrs) && found < o.reads[dst]; j++ {
+		instr := o.instrs[j]
+		reads := false
+		forEachRead(instr, func(r Register) { reads = reads || r == dst })
+		if reads {
+			uses = append(uses, j)
+			found++
+		}
+		stop := false
+		switch x := instr.(type) {
+		case DeclareLabel, Jump:
+			stop = true
+		case Call:
+			stop = x.Tail
+		case ReleaseRegister:
+			stop = x.Reg == src
+		}
+		forEachWrite(instr, func(r Register) { stop = stop || r == src })
+		if stop {
+			break
+		}
+	}
+	if found != o.reads[dst] {
+		return false
+	}
+	for _, j := range uses {
+		o.instrs[j] = replaceReads(o.instrs[j], dst, src)
+	}
+	o.instrs[i] = nil
+	o.reads[dst], o.writes[dst] = 0, 0
+	o.reads[src] += found
+	return true
+}
+
+//
+// Dead code removal
+//
+
+// removeDeadCode removes
+//   - instructions which cannot be reached;
+//   - jumps to the next instruction;
+//   - labels which are not jumped to;
+//   - instructions without side effects whose result is not used;
+//   - hints about registers which are no longer used.
+func (o *optimiser) removeDeadCode() bool {
+	o.analyse()
+	changed := false
+	reachable := true
+	for i, instr := range o.instrs {
+		switch x := instr.(type) {
+		case DeclareLabel:
+			if o.labelRefs[x.Label] > 0 {
+				reachable = true
+			} else {
+				o.instrs[i] = nil
+				changed = true
+			}
+			continue
+		case Jump:
+			if o.jumpsToNext(i, x.Label) {
+				o.instrs[i] = nil
+				changed = true
+				continue
+			}
+		case JumpIf:
+			if o.jumpsToNext(i, x.Label) {
+				o.instrs[i] = nil
+				changed = true
+				continue
+			}
+		}
+		if isPseudoInstr(instr) {
+			continue
+		}
+		if !reachable || o.isDeadWrite(instr) {
+			o.instrs[i] = nil
+			changed = true
+			continue
+		}
+		switch x := instr.(type) {
+		case Jump:
+			reachable = false
+		case Call:
+			reachable = !x.Tail
+		}
+	}
+	if changed {
+		o.removeUnusedHints()
+	}
+	return changed
+}
+
+// jumpsToNext returns true if the jump at i is to lbl and lbl is declared
+// before the next instruction.
+func (o *optimiser) jumpsToNext(i int, lbl Label) bool {
+	for j := i + 1; j < len(o.instrs); j++ {
+		switch x := o.instrs[j].(type) {
+		case nil:
+		case DeclareLabel:
+			if x.Label == lbl {
+				return true
+			}
+		default:
+			if !isPseudoInstr(x) {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// isDeadWrite returns true if instr only sets a register which is never read.
+func (o *optimiser) isDeadWrite(instr Instruction) bool {
+	var dst Register
+	switch x := instr.(type) {
+	case LoadConst:
+		dst = x.Dst
+	case Transform:
+		if x.Op != ops.OpId && x.Op != ops.OpNot {
+			// Other operations can call metamethods.
+			return false
+		}
+		dst = x.Dst
+	case MkTable:
+		dst = x.Dst
+	case MkClosure:
+		dst = x.Dst
+	case EtcLookup:
+		dst = x.Dst
+	default:
+		return false
+	}
+	return o.isTemp(dst) && o.reads[dst] == 0
+}
+
+// removeUnusedHints removes TakeRegister and ReleaseRegister for registers no
+// longer used by any instruction.
+func (o *optimiser) removeUnusedHints() {
+	used := make([]bool, len(o.regs))
+	for _, instr := range o.instrs {
+		mark := func(r Register) { used[r] = true }
+		forEachRead(instr, mark)
+		forEachWrite(instr, mark)
+	}
+	for i, instr := range o.instrs {
+		var r Register
+		switch x := instr.(type) {
+		case TakeRegister:
+			r = x.Reg
+		case ReleaseRegister:
+			r = x.Reg
+		default:
+			continue
+		}
+		if o.isTemp(r) && !used[r] {
+			o.instrs[i] = nil
+		}
+	}
+}
+
+//
+// Instruction helpers
+//
+
+// isPseudoInstr returns true for instructions which do nothing at runtime.
+// Labels are not included as they change the flow of execution.
+func isPseudoInstr(instr Instruction) bool {
+	switch instr.(type) {
+	case TakeRegister, ReleaseRegister, DeclareLocalVar, EndLocalVar:
+		return true
+	}
+	return false
+}
+
+// forEachRead calls f for each register read by instr.
+func forEachRead(instr Instruction, f func(Register)) {
+	switch x := instr.(type) {
+	case Combine:
+		f(x.Lsrc)
+		f(x.Rsrc)
+	case Transform:
+		f(x.Src)
+	case Push:
+		f(x.Cont)
+		f(x.Item)
+	case JumpIf:
+		f(x.Cond)
+	case Call:
+		f(x.Cont)
+	case MkClosure:
+		for _, r := range x.Upvalues {
+			f(r)
+		}
+	case MkCont:
+		f(x.Closure)
+	case Lookup:
+		f(x.Table)
+		f(x.Index)
+	case SetIndex:
+		f(x.Table)
+		f(x.Index)
+		f(x.Src)
+	case EtcLookup:
+		f(x.Etc)
+	case FillTable:
+		f(x.Etc)
+		f(x.Dst)
+	case PushCloseStack:
+		f(x.Src)
+	case PrepForLoop:
+		f(x.Start)
+		f(x.Stop)
+		f(x.Step)
+	case AdvForLoop:
+		f(x.Start)
+		f(x.Stop)
+		f(x.Step)
+	}
+}
+
+// forEachWrite calls f for each register written to by instr.
+func forEachWrite(instr Instruction, f func(Register)) {
+	switch x := instr.(type) {
+	case SetRegInstruction:
+		f(x.DestReg())
+	case ClearReg:
+		f(x.Dst)
+	case MkTable:
+		f(x.Dst)
+	case Receive:
+		for _, r := range x.Dst {
+			f(r)
+		}
+	case ReceiveEtc:
+		for _, r := range x.Dst {
+			f(r)
+		}
+		f(x.Etc)
+	case PrepForLoop:
+		f(x.Start)
+		f(x.Stop)
+		f(x.Step)
+	case AdvForLoop:
+		f(x.Start)
+		f(x.Stop)
+		f(x.Step)
+	}
+}
+
+// replaceReads returns instr with reads of the register from replaced with
+// reads of the register to.
+func replaceReads(instr Instruction, from, to Register) Instruction {
+	r := func(reg Register) Register {
+		if reg == from {
+			return to
+		}
+		return reg
+	}
+	switch x := instr.(type) {
+	case Combine:
+		x.Lsrc, x.Rsrc = r(x.Lsrc), r(x.Rsrc)
+		return x
+	case Transform:
+		x.Src = r(x.Src)
+		return x
+	case Push:
+		x.Cont, x.Item = r(x.Cont), r(x.Item)
+		return x
+	case JumpIf:
+		x.Cond = r(x.Cond)
+		return x
+	case Call:
+		x.Cont = r(x.Cont)
+		return x
+	case MkClosure:
+		upvalues := make([]Register, len(x.Upvalues))
+		for i, reg := range x.Upvalues {
+			upvalues[i] = r(reg)
+		}
+		x.Upvalues = upvalues
+		return x
+	case MkCont:
+		x.Closure = r(x.Closure)
+		return x
+	case Lookup:
+		x.Table, x.Index = r(x.Table), r(x.Index)
+		return x
+	case SetIndex:
+		x.Table, x.Index, x.Src = r(x.Table), r(x.Index), r(x.Src)
+		return x
+	case EtcLookup:
+		x.Etc = r(x.Etc)
+		return x
+	case FillTable:
+		x.Etc, x.Dst = r(x.Etc), r(x.Dst)
+		return x
+	case PushCloseStack:
+		x.Src = r(x.Src)
+		return x
+	}
+	return instr
+}
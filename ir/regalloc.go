@@ -0,0 +1,461 @@
+package ir
+
+import (
+	"github.com/arnodel/golua/ops"
+)
+
+// This file implements register allocation based on the liveness of
+// registers.
+//
+// The code builder hands out a new register for each value and the register
+// compiler (in the ircomp package) lets registers share their storage
+// according to the TakeRegister and ReleaseRegister instructions, i.e. to the
+// lexical scope of the registers.  Instead allocateRegisters computes where the
+// value of each register is needed and merges registers whose live ranges do
+// not overlap, so that compiled functions need fewer registers.
+//
+// The following registers are treated specially.
+//
+//   - Cells are shared with closures so their lifetime is not known.  They are
+//     left to the register compiler.
+//
+//   - Local variables can be inspected and modified with the debug library
+//     while they are in scope, so they are kept live from their declaration
+//     to the end of their scope.
+//
+//   - The first register holds the continuation of the caller.  It is used by
+//     the runtime at any time and must be compiled to the first code register,
+//     so it is live everywhere and gets the first colour.
+//
+//   - Registers which can be read before being written to hold nil when the
+//     function starts, so they are considered written to at the start.
+
+// allocateRegisters returns a copy of c where registers which are never live
+// at the same time are replaced with the same register.  The TakeRegister and
+// ReleaseRegister instructions for non-cell registers are replaced with
+// TakeRegister instructions at the start of the code for each register left,
+// so the register compiler allocates them exactly once.
+func allocateRegisters(c Code) Code {
+	a := newRegAllocator(c)
+	a.computeLiveness()
+	a.computeInterference()
+	a.colour()
+	return a.rewrite()
+}
+
+// The register containing the continuation of the caller.
+const callerReg Register = 0
+
+// A regAllocator holds the state of the register allocation for a function.
+type regAllocator struct {
+	code   Code
+	instrs []Instruction
+
+	succs [][]int      // Indexes of the instructions which can follow each instruction
+	preds [][]int      // Indexes of the instructions which can precede each instruction
+	uses  [][]int      // Indexes of the instructions reading each register
+	live  [][]Register // Registers whose value is needed after each instruction
+	entry []Register   // Registers whose value is needed at the start
+
+	scopes [][]localScope // Scopes of the local variables in each register
+
+	// Registers which interfere with each register, i.e. cannot share its
+	// storage (there may be duplicates).
+	adj [][]Register
+
+	// Registers a register is moved from / to, which are good candidates to
+	// share its storage.
+	moves [][]Register
+
+	colours []int // Colour of each register (-1 if it has none)
+	ncolour int   // Number of colours used
+}
+
+func newRegAllocator(c Code) *regAllocator {
+	n := len(c.Instructions)
+	a := &regAllocator{
+		code:    c,
+		instrs:  c.Instructions,
+		succs:   make([][]int, n),
+		preds:   make([][]int, n),
+		uses:    make([][]int, len(c.Registers)),
+		live:    make([][]Register, n),
+		adj:     make([][]Register, len(c.Registers)),
+		moves:   make([][]Register, len(c.Registers)),
+		colours: make([]int, len(c.Registers)),
+
+		scopes: make([][]localScope, len(c.Registers)),
+	}
+	labelPos := map[Label]int{}
+	for i, instr := range a.instrs {
+		if lbl, ok := instr.(DeclareLabel); ok {
+			labelPos[lbl.Label] = i
+		}
+	}
+	for i, instr := range a.instrs {
+		next := true
+		switch x := instr.(type) {
+		case Jump:
+			a.succs[i] = append(a.succs[i], labelPos[x.Label])
+			next = false
+		case JumpIf:
+			a.succs[i] = append(a.succs[i], labelPos[x.Label])
+		case Call:
+			next = !x.Tail
+		}
+		if next && i+1 < n {
+			a.succs[i] = append(a.succs[i], i+1)
+		}
+		for _, j := range a.succs[i] {
+			a.preds[j] = append(a.preds[j], i)
+		}
+		forEachRead(instr, func(r Register) {
+			if a.isAllocated(r) {
+				a.uses[r] = append(a.uses[r], i)
+			}
+		})
+	}
+	for i, instr := range a.instrs {
+		decl, ok := instr.(DeclareLocalVar)
+		if !ok || !a.isAllocated(decl.Reg) {
+			continue
+		}
+		// The value of the variable must be available when it is declared,
+		// which is why the declaration counts as a use.  It does not when
+		// the variable is initialised after its declaration (e.g. a local
+		// function), which is only checked in straight line code.
+		if a.initialised(i, decl.Reg) {
+			a.uses[decl.Reg] = append(a.uses[decl.Reg], i)
+		}
+		end := i + 1
+		for end < n {
+			if e, ok := a.instrs[end].(EndLocalVar); ok && e.Reg == decl.Reg {
+				break
+			}
+			end++
+		}
+		a.scopes[decl.Reg] = append(a.scopes[decl.Reg], localScope{decl: i, end: end})
+	}
+	return a
+}
+
+// A localScope is the part of the code where a local variable is in scope,
+// from its DeclareLocalVar instruction to the instruction before end.
+type localScope struct {
+	decl, end int
+}
+
+// isAllocated returns true if r is allocated by the regAllocator (i.e. it is
+// not a cell).
+func (a *regAllocator) isAllocated(r Register) bool {
+	return !a.code.Registers[r].IsCell
+}
+
+// initialised returns true if r is written to before the instruction with
+// index i, with no label in between.
+func (a *regAllocator) initialised(i int, r Register) bool {
+	for i--; i >= 0; i-- {
+		instr := a.instrs[i]
+		if _, ok := instr.(DeclareLabel); ok {
+			return false
+		}
+		if writes(instr, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// writes returns true if instr writes to r.
+func writes(instr Instruction, r Register) bool {
+	found := false
+	forEachWrite(instr, func(w Register) {
+		if w == r {
+			found = true
+		}
+	})
+	return found
+}
+
+// computeLiveness computes the registers whose values are needed after each
+// instruction.  The liveness of each register is computed in turn by walking
+// backwards from the instructions reading it until instructions writing to it.
+func (a *regAllocator) computeLiveness() {
+	n := len(a.instrs)
+	liveIn := make([]Register, n)  // liveIn[i] == r+1 iff r is live before i
+	liveOut := make([]Register, n) // liveOut[i] == r+1 iff r is live after i
+	addLiveOut := func(i int, r Register) {
+		if liveOut[i] != r+1 {
+			liveOut[i] = r + 1
+			a.live[i] = append(a.live[i], r)
+		}
+	}
+	var stack []int
+	for r, uses := range a.uses {
+		reg := Register(r)
+		for _, i := range uses {
+			if liveIn[i] != reg+1 {
+				liveIn[i] = reg + 1
+				stack = append(stack, i)
+			}
+		}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if i == 0 {
+				a.entry = append(a.entry, reg)
+			}
+			for _, p := range a.preds[i] {
+				addLiveOut(p, reg)
+				if liveIn[p] != reg+1 && !writes(a.instrs[p], reg) {
+					liveIn[p] = reg + 1
+					stack = append(stack, p)
+				}
+			}
+		}
+		if reg == callerReg && a.isAllocated(reg) {
+			for i := range a.live {
+				addLiveOut(i, reg)
+			}
+		}
+		// Local variables are kept for their whole scope so the debug library
+		// can access them.
+		for _, s := range a.scopes[r] {
+			for i := s.decl; i < s.end; i++ {
+				addLiveOut(i, reg)
+			}
+		}
+	}
+}
+
+// computeInterference records which registers cannot share their storage.  A
+// register interferes with all the registers live after an instruction writing
+// to it.
+func (a *regAllocator) computeInterference() {
+	addEdge := func(r1, r2 Register) {
+		if r1 != r2 && a.isAllocated(r1) && a.isAllocated(r2) {
+			a.adj[r1] = append(a.adj[r1], r2)
+			a.adj[r2] = append(a.adj[r2], r1)
+		}
+	}
+	var defs []Register
+	for i, instr := range a.instrs {
+		defs = defs[:0]
+		forEachWrite(instr, func(r Register) { defs = append(defs, r) })
+		switch x := instr.(type) {
+		case DeclareLocalVar:
+			// The local variable may be modified by the debug library from
+			// now on.
+			defs = append(defs, x.Reg)
+		case MkClosure:
+			// The closure is written to its register before the upvalues are
+			// read.
+			for _, r := range x.Upvalues {
+				addEdge(x.Dst, r)
+			}
+		case Transform:
+			if x.Op == ops.OpId {
+				a.moves[x.Dst] = append(a.moves[x.Dst], x.Src)
+				a.moves[x.Src] = append(a.moves[x.Src], x.Dst)
+			}
+		}
+		for j, d := range defs {
+			for _, r := range a.live[i] {
+				addEdge(d, r)
+			}
+			for _, d2 := range defs[:j] {
+				addEdge(d, d2)
+			}
+		}
+	}
+	for j, r := range a.entry {
+		for _, r2 := range a.entry[:j] {
+			addEdge(r, r2)
+		}
+	}
+}
+
+// colour gives a colour to each register which is allocated, so that
+// interfering registers have different colours.  Registers are coloured in
+// order of appearance in the code, with the lowest colour available,
+// preferring the colour of a register they are moved from or to.
+func (a *regAllocator) colour() {
+	for r := range a.colours {
+		a.colours[r] = -1
+	}
+	var forbidden []int // forbidden[c] == r+1 iff c is forbidden for r
+	visit := func(r Register) {
+		if !a.isAllocated(r) || a.colours[r] >= 0 {
+			return
+		}
+		for _, r2 := range a.adj[r] {
+			if c := a.colours[r2]; c >= 0 {
+				forbidden[c] = int(r) + 1
+			}
+		}
+		colour := -1
+		for _, r2 := range a.moves[r] {
+			if c := a.colours[r2]; c >= 0 && forbidden[c] != int(r)+1 {
+				colour = c
+				break
+			}
+		}
+		if colour < 0 {
+			colour = 0
+			for colour < a.ncolour && forbidden[colour] == int(r)+1 {
+				colour++
+			}
+		}
+		if colour == a.ncolour {
+			a.ncolour++
+			forbidden = append(forbidden, 0)
+		}
+		a.colours[r] = colour
+	}
+	if int(callerReg) < len(a.colours) {
+		visit(callerReg)
+	}
+	for _, instr := range a.instrs {
+		switch instr.(type) {
+		case TakeRegister, ReleaseRegister:
+			continue
+		}
+		forEachRegister(instr, func(r Register) Register {
+			visit(r)
+			return r
+		})
+	}
+}
+
+// rewrite returns the code with each allocated register replaced with the
+// first register of its colour.
+func (a *regAllocator) rewrite() Code {
+	reps := make([]Register, a.ncolour)
+	for r := len(a.colours) - 1; r >= 0; r-- {
+		if c := a.colours[r]; c >= 0 {
+			reps[c] = Register(r)
+		}
+	}
+	rename := func(r Register) Register {
+		if c := a.colours[r]; c >= 0 {
+			return reps[c]
+		}
+		return r
+	}
+	instrs := make([]Instruction, 0, len(a.instrs)+len(reps))
+	lines := make([]int, 0, len(a.instrs)+len(reps))
+	for _, r := range reps {
+		instrs = append(instrs, TakeRegister{Reg: r})
+		lines = append(lines, 0)
+	}
+	for i, instr := range a.instrs {
+		switch x := instr.(type) {
+		case TakeRegister:
+			if a.isAllocated(x.Reg) {
+				continue
+			}
+		case ReleaseRegister:
+			if a.isAllocated(x.Reg) {
+				continue
+			}
+		case Transform:
+			if x.Op == ops.OpId && rename(x.Dst) == rename(x.Src) {
+				// The move has become a no-op.
+				continue
+			}
+		}
+		instrs = append(instrs, forEachRegister(instr, rename))
+		lines = append(lines, a.code.Lines[i])
+	}
+	c := a.code
+	c.Instructions = instrs
+	c.Lines = lines
+	return c
+}
+
+// forEachRegister returns instr with each register r it refers to replaced
+// with f(r).
+func forEachRegister(instr Instruction, f func(Register) Register) Instruction {
+	switch x := instr.(type) {
+	case Combine:
+		x.Dst, x.Lsrc, x.Rsrc = f(x.Dst), f(x.Lsrc), f(x.Rsrc)
+		return x
+	case Transform:
+		x.Dst, x.Src = f(x.Dst), f(x.Src)
+		return x
+	case LoadConst:
+		x.Dst = f(x.Dst)
+		return x
+	case Push:
+		x.Cont, x.Item = f(x.Cont), f(x.Item)
+		return x
+	case JumpIf:
+		x.Cond = f(x.Cond)
+		return x
+	case Call:
+		x.Cont = f(x.Cont)
+		return x
+	case MkClosure:
+		x.Dst = f(x.Dst)
+		x.Upvalues = mapRegisters(x.Upvalues, f)
+		return x
+	case MkCont:
+		x.Dst, x.Closure = f(x.Dst), f(x.Closure)
+		return x
+	case ClearReg:
+		x.Dst = f(x.Dst)
+		return x
+	case MkTable:
+		x.Dst = f(x.Dst)
+		return x
+	case Lookup:
+		x.Dst, x.Table, x.Index = f(x.Dst), f(x.Table), f(x.Index)
+		return x
+	case SetIndex:
+		x.Table, x.Index, x.Src = f(x.Table), f(x.Index), f(x.Src)
+		return x
+	case Receive:
+		x.Dst = mapRegisters(x.Dst, f)
+		return x
+	case ReceiveEtc:
+		x.Dst = mapRegisters(x.Dst, f)
+		x.Etc = f(x.Etc)
+		return x
+	case EtcLookup:
+		x.Etc, x.Dst = f(x.Etc), f(x.Dst)
+		return x
+	case FillTable:
+		x.Etc, x.Dst = f(x.Etc), f(x.Dst)
+		return x
+	case PushCloseStack:
+		x.Src = f(x.Src)
+		return x
+	case TakeRegister:
+		x.Reg = f(x.Reg)
+		return x
+	case ReleaseRegister:
+		x.Reg = f(x.Reg)
+		return x
+	case DeclareLocalVar:
+		x.Reg = f(x.Reg)
+		return x
+	case EndLocalVar:
+		x.Reg = f(x.Reg)
+		return x
+	case PrepForLoop:
+		x.Start, x.Stop, x.Step = f(x.Start), f(x.Stop), f(x.Step)
+		return x
+	case AdvForLoop:
+		x.Start, x.Stop, x.Step = f(x.Start), f(x.Stop), f(x.Step)
+		return x
+	}
+	return instr
+}
+
+func mapRegisters(regs []Register, f func(Register) Register) []Register {
+	mapped := make([]Register, len(regs))
+	for i, r := range regs {
+		mapped[i] = f(r)
+	}
+	return mapped
+}
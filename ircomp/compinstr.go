@@ -12,6 +12,7 @@ import (
 type instrCompiler struct {
 	*ConstantCompiler
 	*regAllocator
+	*localVarRecorder
 	line int
 }
 
@@ -248,6 +249,54 @@ func (ic instrCompiler) ProcessDeclareLabelInstr(l ir.DeclareLabel) {
 	ic.builder.EmitLabel(code.Label(l.Label))
 }
 
+func (ic instrCompiler) ProcessDeclareLocalVarInstr(d ir.DeclareLocalVar) {
+	ic.startLocal(string(d.Name), d.Reg, ic.codeReg(d.Reg), ic.builder.Pos())
+}
+
+func (ic instrCompiler) ProcessEndLocalVarInstr(e ir.EndLocalVar) {
+	ic.endLocal(e.Reg, ic.builder.Pos())
+}
+
+// A localVarRecorder keeps track of the scopes of local variables in the code
+// of a function.
+type localVarRecorder struct {
+	start  uint                // Offset of the start of the function
+	locals []code.LocalVar     // Local variables in order of declaration
+	open   map[ir.Register]int // Indexes of local variables still in scope
+}
+
+func newLocalVarRecorder(start uint) *localVarRecorder {
+	return &localVarRecorder{
+		start: start,
+		open:  make(map[ir.Register]int),
+	}
+}
+
+func (r *localVarRecorder) startLocal(name string, reg ir.Register, cr code.Reg, pos uint) {
+	r.open[reg] = len(r.locals)
+	r.locals = append(r.locals, code.LocalVar{
+		Name:        name,
+		Reg:         cr,
+		StartOffset: uint32(pos - r.start),
+	})
+}
+
+func (r *localVarRecorder) endLocal(reg ir.Register, pos uint) {
+	if i, ok := r.open[reg]; ok {
+		r.locals[i].EndOffset = uint32(pos - r.start)
+		delete(r.open, reg)
+	}
+}
+
+// close ends the scope of all the local variables still in scope at pos and
+// returns the recorded local variables.
+func (r *localVarRecorder) close(pos uint) []code.LocalVar {
+	for reg := range r.open {
+		r.endLocal(reg, pos)
+	}
+	return r.locals
+}
+
 type regAllocation struct {
 	r    code.Reg
 	done bool
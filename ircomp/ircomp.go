@@ -64,20 +64,27 @@ func (kc *ConstantCompiler) ProcessCode(c ir.Code) {
 	ic := instrCompiler{
 		ConstantCompiler: kc,
 		regAllocator:     regAllocator,
+		localVarRecorder: newLocalVarRecorder(start),
 	}
 	for i, instr := range c.Instructions {
 		ic.line = c.Lines[i]
 		instr.ProcessInstr(ic)
 	}
 	end := kc.builder.Offset()
+	locals := ic.close(end)
 	kc.addCompiled(code.Code{
-		Name:         c.Name,
-		StartOffset:  start,
-		EndOffset:    end,
-		UpvalueCount: int16(len(c.UpvalueDests)),
-		CellCount:    int16(len(regAllocator.cells)),
-		UpNames:      c.UpNames,
-		RegCount:     int16(len(regAllocator.regs)),
+		Name:            c.Name,
+		StartOffset:     start,
+		EndOffset:       end,
+		UpvalueCount:    int16(len(c.UpvalueDests)),
+		CellCount:       int16(len(regAllocator.cells)),
+		UpNames:         c.UpNames,
+		RegCount:        int16(len(regAllocator.regs)),
+		ParamCount:      int16(c.ParamCount),
+		IsVararg:        c.IsVararg,
+		LineDefined:     int32(c.LineDefined),
+		LastLineDefined: int32(c.LastLineDefined),
+		Locals:          locals,
 	})
 }
 
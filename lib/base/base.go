@@ -19,21 +19,31 @@ func Load(r *rt.Runtime) (rt.Value, func()) {
 	env := r.GlobalEnv()
 	r.SetEnv(env, "_G", rt.TableValue(env))
 	r.SetEnv(env, "_VERSION", rt.StringValue("Golua 5.4"))
-	r.SetEnv(env, "next", rt.FunctionValue(nextGoFunc))
+	nextf := nextFunc(r)
+	r.SetEnv(env, "next", rt.FunctionValue(nextf))
+	r.SetSnapshotName("ipairsiterator", rt.FunctionValue(ipairsIterator))
 
+	// The order in which next and pairs iterate is only deterministic if the
+	// runtime makes tables ordered.
+	iterators := []*rt.GoFunction{
+		nextf,
+		r.SetEnvGoFunc(env, "pairs", pairs, 1, false),
+	}
+	rt.SolemnlyDeclareRestartable(iterators...)
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe,
+		iterators...,
+	)
+	if r.TablesOrdered() {
+		rt.SolemnlyDeclareCompliance(rt.ComplyDetSafe, iterators...)
+	}
 
+	restartable := []*rt.GoFunction{
 		ipairsIterator,
-		nextGoFunc,
 		r.SetEnvGoFunc(env, "assert", assert, 1, true),
 		r.SetEnvGoFunc(env, "error", errorF, 2, false),
 		r.SetEnvGoFunc(env, "getmetatable", getmetatable, 1, false),
 		r.SetEnvGoFunc(env, "ipairs", ipairs, 1, false),
-		r.SetEnvGoFunc(env, "load", load, 4, false),
-		r.SetEnvGoFunc(env, "pairs", pairs, 1, false),
-		r.SetEnvGoFunc(env, "pcall", pcall, 1, true),
-		r.SetEnvGoFunc(env, "print", print, 0, true), // Not really iosafe/timesafe but used in all tests...
 		r.SetEnvGoFunc(env, "rawequal", rawequal, 2, false),
 		r.SetEnvGoFunc(env, "rawget", rawget, 2, false),
 		r.SetEnvGoFunc(env, "rawlen", rawlen, 1, false),
@@ -43,16 +53,29 @@ func Load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(env, "tonumber", tonumber, 2, false),
 		r.SetEnvGoFunc(env, "tostring", tostring, 1, false),
 		r.SetEnvGoFunc(env, "type", typeString, 1, false),
-		r.SetEnvGoFunc(env, "warn", warn, 0, true), // Added in Lua 5.4
-		r.SetEnvGoFunc(env, "xpcall", xpcall, 2, true),
+	}
+	rt.SolemnlyDeclareRestartable(restartable...)
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
+
+		append(restartable,
+			r.SetEnvGoFunc(env, "load", load, 4, false),
+			r.SetEnvGoFunc(env, "pcall", pcall, 1, true),
+			r.SetEnvGoFunc(env, "print", print, 0, true), // Not really iosafe/timesafe but used in all tests...
+			r.SetEnvGoFunc(env, "warn", warn, 0, true),   // Added in Lua 5.4
+			r.SetEnvGoFunc(env, "xpcall", xpcall, 2, true),
+		)...,
 	)
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 		r.SetEnvGoFunc(env, "dofile", dofile, 1, false),
 		r.SetEnvGoFunc(env, "loadfile", loadfile, 3, false),
 	)
-	// That's not safe!
-	r.SetEnvGoFunc(env, "collectgarbage", collectgarbage, 2, false)
+	// That's not safe!  It is deterministic though (see the "count" option).
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyDetSafe,
+		r.SetEnvGoFunc(env, "collectgarbage", collectgarbage, 2, false),
+	)
 	return rt.NilValue, nil
 }
 
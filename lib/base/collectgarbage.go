@@ -41,9 +41,16 @@ func collectgarbage(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	case "setstepmul":
 		// TODO: perhaps change gcPercent to reflect this?
 	case "count":
-		stats := runtime.MemStats{}
-		runtime.ReadMemStats(&stats)
-		t.Push1(next, rt.FloatValue(float64(stats.Alloc)/1024.0))
+		var alloc uint64
+		if t.Deterministic() {
+			// The memory allocated by Go depends on when the GC runs.
+			alloc = t.UsedMemory()
+		} else {
+			stats := runtime.MemStats{}
+			runtime.ReadMemStats(&stats)
+			alloc = stats.Alloc
+		}
+		t.Push1(next, rt.FloatValue(float64(alloc)/1024.0))
 	default:
 		return nil, errors.New("invalid option")
 	}
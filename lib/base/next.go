@@ -31,3 +31,15 @@ func next(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 var nextGoFunc = rt.NewGoFunction(next, "next", 2, false)
+
+// detNextGoFunc is the next function of runtimes where tables iterate in
+// insertion order, which complies with ComplyDetSafe.
+var detNextGoFunc = rt.NewGoFunction(next, "next", 2, false)
+
+// nextFunc returns the next function for r.
+func nextFunc(r *rt.Runtime) *rt.GoFunction {
+	if r.TablesOrdered() {
+		return detNextGoFunc
+	}
+	return nextGoFunc
+}
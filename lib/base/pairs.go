@@ -17,6 +17,6 @@ func pairs(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		t.Push(next, res.Etc()...)
 		return next, nil
 	}
-	t.Push(next, rt.FunctionValue(nextGoFunc), coll, rt.NilValue)
+	t.Push(next, rt.FunctionValue(nextFunc(t.Runtime)), coll, rt.NilValue)
 	return next, nil
 }
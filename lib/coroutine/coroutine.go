@@ -16,9 +16,7 @@ var LibLoader = packagelib.Loader{
 func load(r *rt.Runtime) (rt.Value, func()) {
 	pkg := rt.NewTable()
 
-	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
-
+	fs := []*rt.GoFunction{
 		r.SetEnvGoFunc(pkg, "close", close, 1, false), // Lua 5.4
 		r.SetEnvGoFunc(pkg, "create", create, 1, false),
 		r.SetEnvGoFunc(pkg, "isyieldable", isyieldable, 1, false),
@@ -27,7 +25,14 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "status", status, 1, false),
 		r.SetEnvGoFunc(pkg, "wrap", wrap, 1, false),
 		r.SetEnvGoFunc(pkg, "yield", yield, 0, true),
+	}
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
+		fs...,
 	)
+	// None of these call Lua code in the calling thread, so coroutines can
+	// resume each other and yield without goroutines.
+	rt.SolemnlyDeclareRestartable(fs...)
 
 	return rt.TableValue(pkg), nil
 }
@@ -88,11 +93,7 @@ func resume(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 func yield(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	res, err := t.Yield(c.Etc())
-	if err != nil {
-		return nil, err
-	}
-	return c.PushingNext(t.Runtime, res...), nil
+	return t.YieldCont(c.Next(), c.Etc())
 }
 
 func isyieldable(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -146,7 +147,8 @@ func wrap(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		}
 		return c.PushingNext(t.Runtime, res...), nil
 	}, "wrap", 0, true)
-	w.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe)
+	w.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe | rt.ComplyExecSafe | rt.ComplyDetSafe)
+	w.SolemnlyDeclareRestartable()
 	next := c.Next()
 	t.Push1(next, rt.FunctionValue(w))
 	return next, nil
@@ -0,0 +1,207 @@
+-- Coroutines run in the goroutine of the thread resuming them until a Go
+-- function is mid-stack.  These checks exercise the switches between the two
+-- modes.
+
+-- A generator only calling Lua code and restartable functions
+do
+    local gen = coroutine.wrap(function(n)
+        for i = 1, n do
+            coroutine.yield(i, math.floor(i / 2))
+        end
+        return "done"
+    end)
+    print(gen(3))
+    print(gen())
+    print(gen())
+    print(gen())
+    --> =1	0
+    --> =2	1
+    --> =3	1
+    --> =done
+end
+
+-- Yielding across pcall and then outside of it
+do
+    local co = coroutine.create(function()
+        local ok, x = pcall(function()
+            return coroutine.yield("in pcall") .. "!"
+        end)
+        coroutine.yield(ok, x)
+        error("oops")
+    end)
+    print(coroutine.resume(co))
+    print(coroutine.resume(co, "a"))
+    print(coroutine.resume(co))
+    print(coroutine.status(co))
+    --> =true	in pcall
+    --> =true	true	a!
+    --> ~false	.*oops
+    --> =dead
+end
+
+-- Yielding from metamethods called by Lua instructions does not run the
+-- instruction twice
+do
+    local calls = 0
+    local mt = {
+        __index = function(t, k)
+            calls = calls + 1
+            return coroutine.yield(k)
+        end,
+        __add = function(x, y)
+            calls = calls + 1
+            return coroutine.yield("add")
+        end,
+        __lt = function(x, y)
+            calls = calls + 1
+            return coroutine.yield("lt")
+        end,
+    }
+    local obj = setmetatable({}, mt)
+    local co = coroutine.wrap(function()
+        local n = 0
+        n = n + obj.foo
+        n = n + (obj + 1)
+        if obj < obj then
+            n = n + 100
+        end
+        return n
+    end)
+    print(co())
+    print(co(10))
+    print(co(20))
+    print(co(true))
+    print(calls)
+    --> =foo
+    --> =add
+    --> =lt
+    --> =130
+    --> =3
+end
+
+-- Yielding from a metamethod called by a restartable Go function
+do
+    local obj = setmetatable({}, {__tostring = function()
+        return coroutine.yield("tostring")
+    end})
+    local co = coroutine.wrap(function()
+        return "got " .. tostring(obj)
+    end)
+    print(co())
+    print(co("obj"))
+    --> =tostring
+    --> =got obj
+end
+
+-- Yielding from a function called by a Go function which is not restartable
+do
+    local co = coroutine.wrap(function()
+        local t = {3, 1, 2}
+        table.sort(t, function(x, y)
+            coroutine.yield("cmp")
+            return x < y
+        end)
+        return table.concat(t, ",")
+    end)
+    local res = co()
+    while res == "cmp" do
+        res = co()
+    end
+    print(res)
+    --> =1,2,3
+end
+
+-- To-be-closed variables
+do
+    local log = {}
+    local function closer(name)
+        return setmetatable({}, {__close = function()
+            log[#log+1] = name
+            coroutine.yield("closing " .. name)
+        end})
+    end
+    local co = coroutine.wrap(function()
+        do
+            local a <close> = closer("a")
+        end
+        local b <close> = closer("b")
+        return "end"
+    end)
+    print(co())
+    print(co())
+    print(co())
+    print(table.concat(log, " "))
+    --> =closing a
+    --> =closing b
+    --> =end
+    --> =a b
+end
+
+-- Closing a suspended coroutine runs pending __close metamethods
+do
+    local co = coroutine.create(function()
+        local x <close> = setmetatable({}, {__close = function()
+            print("closed x")
+        end})
+        coroutine.yield(1)
+    end)
+    print(coroutine.resume(co))
+    print(coroutine.close(co))
+    print(coroutine.status(co))
+    --> =true	1
+    --> =closed x
+    --> =true
+    --> =dead
+end
+
+-- Closing a coroutine suspended in a Go function
+do
+    local co = coroutine.create(function()
+        local x <close> = setmetatable({}, {__close = function()
+            print("closed y")
+        end})
+        pcall(coroutine.yield, 2)
+    end)
+    print(coroutine.resume(co))
+    print(coroutine.close(co))
+    print(coroutine.status(co))
+    --> =true	2
+    --> =closed y
+    --> =true
+    --> =dead
+end
+
+-- Coroutines resuming each other
+do
+    local function gen(n)
+        return coroutine.wrap(function()
+            for i = 1, n do
+                coroutine.yield(i)
+            end
+        end)
+    end
+    local sum = coroutine.wrap(function()
+        local s = 0
+        for i in gen(4) do
+            s = s + i
+            coroutine.yield(s)
+        end
+        return "total"
+    end)
+    print(sum(), sum(), sum(), sum(), sum())
+    --> =1	3	6	10	total
+end
+
+-- The traceback of a suspended coroutine shows where it yielded
+do
+    local co = coroutine.create(function()
+        local function f()
+            coroutine.yield()
+        end
+        f()
+    end)
+    coroutine.resume(co)
+    print(debug.traceback(co))
+    --> ~in function f \(file .*:199\)
+    --> ~in function <lua function> \(file .*:201\)
+end
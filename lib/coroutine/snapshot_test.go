@@ -0,0 +1,267 @@
+package coroutine_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func newRuntime(tb testing.TB) (*rt.Runtime, *bytes.Buffer) {
+	var out bytes.Buffer
+	r := rt.New(&out)
+	tb.Cleanup(lib.LoadAll(r))
+	return r, &out
+}
+
+func runChunk(tb testing.TB, r *rt.Runtime, src string) rt.Value {
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	v, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return v
+}
+
+func snapshot(tb testing.TB, r *rt.Runtime, src string) []byte {
+	co, ok := runChunk(tb, r, src).TryThread()
+	if !ok {
+		tb.Fatal("expected a coroutine")
+	}
+	var buf bytes.Buffer
+	if err := rt.MarshalThread(&buf, co); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func restore(tb testing.TB, r *rt.Runtime, data []byte) {
+	co, err := rt.UnmarshalThread(r, bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	r.SetEnv(r.GlobalEnv(), "co", rt.ThreadValue(co))
+}
+
+func TestSnapshotResumesInNewRuntime(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `
+count = 0
+local counter = {n = 0}
+local function step()
+	counter.n = counter.n + 1
+	return counter.n
+end
+co = coroutine.create(function(...)
+	local args = {...}
+	for i, x in ipairs(args) do
+		count = count + 1
+		coroutine.yield(string.format("%s:%d", x, step()))
+	end
+	return "done", counter.n
+end)
+print(coroutine.resume(co, "a", "b", "c"))
+return co`)
+
+	// The coroutine uses the globals of the new runtime.
+	r2, out := newRuntime(t)
+	runChunk(t, r2, `count = 10`)
+	restore(t, r2, data)
+	runChunk(t, r2, `
+print(count)
+print(coroutine.resume(co))
+print(coroutine.resume(co))
+print(coroutine.resume(co))
+print(coroutine.status(co), count)`)
+	const expected = `10
+true	b:2
+true	c:3
+true	done	3
+dead	12
+`
+	if got := out.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestSnapshotPreservesSharing(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `
+local x = 0
+local t = {}
+local function inc() x = x + 1; t[#t + 1] = x end
+local function get() return x, #t end
+local co = coroutine.create(function()
+	while true do
+		inc()
+		coroutine.yield(get())
+	end
+end)
+coroutine.resume(co)
+return co`)
+
+	r2, out := newRuntime(t)
+	restore(t, r2, data)
+	runChunk(t, r2, `
+print(coroutine.resume(co))
+print(coroutine.resume(co))`)
+	const expected = "true\t2\t2\ntrue\t3\t3\n"
+	if got := out.String(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSnapshotKeepsNamedTables(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `
+x = "old"
+string.extra = true
+local env = _ENV
+setmetatable(string, {})
+return coroutine.create(function()
+	coroutine.yield()
+	return env == _G, x, string.extra, getmetatable(string)
+end)`)
+
+	r2, out := newRuntime(t)
+	runChunk(t, r2, `x = "new"`)
+	restore(t, r2, data)
+	runChunk(t, r2, `print(coroutine.resume(co))
+print(coroutine.resume(co))`)
+	if got := out.String(); got != "true\ntrue\ttrue\tnew\tnil\tnil\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSnapshotNotStarted(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `
+return coroutine.create(function(x) return x * 2 end)`)
+
+	r2, out := newRuntime(t)
+	restore(t, r2, data)
+	runChunk(t, r2, `print(coroutine.resume(co, 21))`)
+	if got := out.String(); got != "true\t42\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSnapshotOrderedTable(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `
+local t = table.ordered()
+for _, k in ipairs{"z", "y", "x", "w", "v", "u", "t", "s", "r"} do
+	t[k] = true
+end
+return coroutine.create(function()
+	local keys = {}
+	for k in pairs(t) do keys[#keys + 1] = k end
+	t.q = true
+	return table.concat(keys)
+end)`)
+
+	r2, out := newRuntime(t)
+	restore(t, r2, data)
+	runChunk(t, r2, `print(coroutine.resume(co))`)
+	if got := out.String(); got != "true\tzyxwvutsr\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSnapshotOldVersion(t *testing.T) {
+	r1, _ := newRuntime(t)
+	data := snapshot(t, r1, `return coroutine.create(print)`)
+	// The version follows the marshal prefix.
+	data[3] = 1
+	r2, _ := newRuntime(t)
+	_, err := rt.UnmarshalThread(r2, bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot version 1") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{
+			name: "unnamed Go function",
+			src: `
+local gen = coroutine.wrap(function() end)
+local co = coroutine.create(function() coroutine.yield(gen) end)
+coroutine.resume(co)
+return co`,
+			err: `Go function "wrap"`,
+		},
+		{
+			name: "suspended in Go function",
+			src: `
+local co = coroutine.create(function() pcall(coroutine.yield) end)
+coroutine.resume(co)
+return co`,
+			err: "Go function mid-stack",
+		},
+		{
+			name: "dead thread",
+			src: `
+local co = coroutine.create(function() end)
+coroutine.resume(co)
+return co`,
+			err: "not suspended",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := newRuntime(t)
+			co := runChunk(t, r, test.src).AsThread()
+			err := rt.MarshalThread(&bytes.Buffer{}, co)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSnapshotSetSnapshotName(t *testing.T) {
+	double := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		n, err := c.IntArg(0)
+		if err != nil {
+			return nil, err
+		}
+		return c.PushingNext1(t.Runtime, rt.IntValue(2*n)), nil
+	}
+	r1, _ := newRuntime(t)
+	f1 := rt.NewGoFunction(double, "double", 1, false)
+	r1.SetSnapshotName("double", rt.FunctionValue(f1))
+	co := runChunk(t, r1, `
+return function(double)
+	local co = coroutine.create(function()
+		coroutine.yield()
+		return double(21)
+	end)
+	coroutine.resume(co)
+	return co
+end`)
+	co, err := rt.Call1(r1.MainThread(), co, rt.FunctionValue(f1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := rt.MarshalThread(&buf, co.AsThread()); err != nil {
+		t.Fatal(err)
+	}
+
+	r2, out := newRuntime(t)
+	f2 := rt.NewGoFunction(double, "double", 1, false)
+	r2.SetSnapshotName("double", rt.FunctionValue(f2))
+	restore(t, r2, buf.Bytes())
+	runChunk(t, r2, `print(coroutine.resume(co))`)
+	if got := out.String(); got != "true\t42\n" {
+		t.Errorf("got %q", got)
+	}
+}
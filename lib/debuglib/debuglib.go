@@ -2,6 +2,7 @@ package debuglib
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/arnodel/golua/lib/packagelib"
@@ -20,15 +21,17 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnv(r.GlobalEnv(), "debug", pkgVal)
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "gethook", gethook, 1, false),
 		r.SetEnvGoFunc(pkg, "getinfo", getinfo, 3, false),
+		r.SetEnvGoFunc(pkg, "getlocal", getlocal, 3, false),
 		r.SetEnvGoFunc(pkg, "getupvalue", getupvalue, 2, false),
 		r.SetEnvGoFunc(pkg, "setupvalue", setupvalue, 3, false),
 		r.SetEnvGoFunc(pkg, "upvaluejoin", upvaluejoin, 4, false),
 		r.SetEnvGoFunc(pkg, "setmetatable", setmetatable, 2, false),
 		r.SetEnvGoFunc(pkg, "sethook", sethook, 4, false),
+		r.SetEnvGoFunc(pkg, "setlocal", setlocal, 4, false),
 		r.SetEnvGoFunc(pkg, "traceback", traceback, 3, false),
 		r.SetEnvGoFunc(pkg, "upvalueid", upvalueid, 2, false),
 	)
@@ -44,7 +47,8 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		thread *rt.Thread
 		idx    int64
 		cont   rt.Cont
-		what   string
+		info   *rt.DebugInfo
+		what   = defaultGetinfoWhat
 		fIdx   int
 	)
 	thread, ok := c.Arg(0).TryThread()
@@ -56,12 +60,21 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if c.NArgs() < 1+fIdx {
 		return nil, errors.New("missing argument: f")
 	}
+	if c.NArgs() > 1+fIdx {
+		var err error
+		what, err = c.StringArg(1 + fIdx)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Trim(what, validGetinfoWhat) != "" {
+			return nil, fmt.Errorf("bad argument #%d (invalid option)", 2+fIdx)
+		}
+	}
 	switch arg := c.Arg(fIdx); arg.Type() {
 	case rt.IntType:
 		idx = arg.AsInt()
 	case rt.FunctionType:
-		term := rt.NewTerminationWith(c, 0, false)
-		cont = arg.AsCallable().Continuation(t, term)
+		info = functionDebugInfo(arg)
 	case rt.FloatType:
 		var tp rt.NumberType
 		idx, tp = rt.FloatToInt(arg.AsFloat())
@@ -71,30 +84,213 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	default:
 		return nil, errors.New("f should be an integer or function")
 	}
-	if cont == nil {
+	if info == nil {
 		cont = thread.CurrentCont()
+		for idx > 0 && cont != nil {
+			cont = cont.Parent()
+			idx--
+		}
+		if cont != nil {
+			info = cont.DebugInfo()
+		}
 	}
-	for idx > 0 && cont != nil {
-		cont = cont.Parent()
-		idx--
+	next := c.Next()
+	if info == nil {
+		t.Push1(next, rt.NilValue)
+		return next, nil
+	}
+	res := rt.NewTable()
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			t.SetEnv(res, "source", rt.StringValue(info.Source))
+			t.SetEnv(res, "short_src", rt.StringValue(shortSrc(info.Source)))
+			t.SetEnv(res, "what", rt.StringValue(info.What))
+			t.SetEnv(res, "linedefined", rt.IntValue(int64(info.LineDefined)))
+			t.SetEnv(res, "lastlinedefined", rt.IntValue(int64(info.LastLineDefined)))
+		case 'l':
+			t.SetEnv(res, "currentline", rt.IntValue(int64(info.CurrentLine)))
+		case 'u':
+			t.SetEnv(res, "nups", rt.IntValue(int64(info.NUpvalues)))
+			t.SetEnv(res, "nparams", rt.IntValue(int64(info.NParams)))
+			t.SetEnv(res, "isvararg", rt.BoolValue(info.IsVararg))
+		case 'n':
+			// The name is the one the function was defined with, there is no
+			// information about how the function was called.
+			t.SetEnv(res, "name", rt.StringValue(info.Name))
+			t.SetEnv(res, "namewhat", rt.StringValue(""))
+		case 't':
+			t.SetEnv(res, "istailcall", rt.BoolValue(info.IsTailCall))
+		case 'r':
+			ftransfer, ntransfer := thread.TransferInfo(cont)
+			t.SetEnv(res, "ftransfer", rt.IntValue(int64(ftransfer)))
+			t.SetEnv(res, "ntransfer", rt.IntValue(int64(ntransfer)))
+		case 'L':
+			if clos, ok := info.Function.TryClosure(); ok {
+				lines := rt.NewTable()
+				for _, l := range clos.ActiveLines() {
+					t.SetTable(lines, rt.IntValue(int64(l)), rt.BoolValue(true))
+				}
+				t.SetEnv(res, "activelines", rt.TableValue(lines))
+			}
+		case 'f':
+			t.SetEnv(res, "func", info.Function)
+		}
+	}
+	t.Push1(next, rt.TableValue(res))
+	return next, nil
+}
+
+const (
+	validGetinfoWhat   = "SlunrtLf"
+	defaultGetinfoWhat = "flnSrtu" // Like Lua, activelines are not included by default
+)
+
+// functionDebugInfo returns debug info about a function value (not a running
+// instance of it).
+func functionDebugInfo(f rt.Value) *rt.DebugInfo {
+	if clos, ok := f.TryClosure(); ok {
+		return clos.DebugInfo()
+	}
+	if gofunc, ok := f.AsCallable().(*rt.GoFunction); ok {
+		return gofunc.DebugInfo()
+	}
+	return nil
+}
+
+// maxShortSrcLen is the maximum length of the short_src field returned by
+// debug.getinfo (it is the default value of LUA_IDSIZE in C Lua, minus 1).
+const maxShortSrcLen = 59
+
+// shortSrc returns a printable version of the source, similar to what C Lua
+// does: a leading '=' or '@' is removed and the string is shortened if needed.
+func shortSrc(source string) string {
+	switch {
+	case strings.HasPrefix(source, "="):
+		source = source[1:]
+		if len(source) > maxShortSrcLen {
+			source = source[:maxShortSrcLen]
+		}
+	case strings.HasPrefix(source, "@"):
+		source = source[1:]
+		if len(source) > maxShortSrcLen {
+			// Keep the end of file names as it is the most informative part.
+			source = "..." + source[len(source)-maxShortSrcLen+3:]
+		}
+	case len(source) > maxShortSrcLen:
+		source = source[:maxShortSrcLen-3] + "..."
+	}
+	return source
+}
+
+func getlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset := optThreadArg(t, c)
+	if err := c.CheckNArgs(argOffset + 2); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
 	}
-	// TODO: support what arg
-	_ = what
 	next := c.Next()
-	if cont == nil {
+
+	// Special case: only parameter names are returned for functions
+	if f := c.Arg(argOffset); f.Type() == rt.FunctionType {
+		clos, ok := f.TryClosure()
+		if !ok {
+			t.Push1(next, rt.NilValue)
+			return next, nil
+		}
+		name, ok := clos.ParamName(int(n))
+		if !ok {
+			t.Push1(next, rt.NilValue)
+			return next, nil
+		}
+		t.Push1(next, rt.StringValue(name))
+		return next, nil
+	}
+
+	cont, err := levelArg(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	luaCont, ok := cont.(*rt.LuaCont)
+	if !ok {
 		t.Push1(next, rt.NilValue)
-	} else if info := cont.DebugInfo(); info == nil {
+		return next, nil
+	}
+	name, val, ok := luaCont.GetLocal(int(n))
+	if !ok {
 		t.Push1(next, rt.NilValue)
-	} else {
-		res := rt.NewTable()
-		t.SetEnv(res, "name", rt.StringValue(info.Name))
-		t.SetEnv(res, "currentline", rt.IntValue(int64(info.CurrentLine)))
-		t.SetEnv(res, "source", rt.StringValue(info.Source))
-		t.Push1(next, rt.TableValue(res))
+		return next, nil
+	}
+	t.Push(next, rt.StringValue(name), val)
+	return next, nil
+}
+
+func setlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset := optThreadArg(t, c)
+	if err := c.CheckNArgs(argOffset + 3); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
+	}
+	cont, err := levelArg(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next()
+	luaCont, ok := cont.(*rt.LuaCont)
+	if !ok {
+		t.Push1(next, rt.NilValue)
+		return next, nil
 	}
+	name, ok := luaCont.SetLocal(int(n), c.Arg(argOffset+2))
+	if !ok {
+		t.Push1(next, rt.NilValue)
+		return next, nil
+	}
+	t.Push1(next, rt.StringValue(name))
 	return next, nil
 }
 
+// optThreadArg returns the thread passed as first argument if there is one
+// (and 1 as the offset of the following arguments), otherwise the current
+// thread.
+func optThreadArg(t *rt.Thread, c *rt.GoCont) (*rt.Thread, int) {
+	if c.NArgs() > 0 {
+		if thread, ok := c.Arg(0).TryThread(); ok {
+			return thread, 1
+		}
+	}
+	return t, 0
+}
+
+// levelArg returns the continuation at the level given by the n-th argument in
+// the thread's call stack.
+func levelArg(thread *rt.Thread, c *rt.GoCont, n int) (rt.Cont, error) {
+	level, err := c.IntArg(n)
+	if err != nil {
+		return nil, err
+	}
+	cont := thread.CurrentCont()
+	for level > 0 && cont != nil {
+		cont = cont.Parent()
+		level--
+	}
+	if level < 0 || cont == nil {
+		return nil, fmt.Errorf("bad argument #%d (level out of range)", n+1)
+	}
+	// A termination stands for the continuation it was created from (e.g. in
+	// a hook, level 2 is the continuation being hooked).
+	if term, ok := cont.(*rt.Termination); ok && term.Origin() != nil {
+		cont = term.Origin()
+	}
+	return cont, nil
+}
+
 func getupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.CheckNArgs(2); err != nil {
 		return nil, err
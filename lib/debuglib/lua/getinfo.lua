@@ -19,7 +19,7 @@ foo(2)
 --> =foo	11	luatest
 
 foo(0)
---> =getinfo	0	[Go]
+--> =getinfo	-1	[Go]
 
 foo(10)
 --> =none
@@ -62,3 +62,102 @@ foo(co, 1.0)
 
 print(pcall(foo, co, 1.5))
 --> ~false\t.*
+
+-- The "what" argument
+
+local function bar(x, y, ...)
+    local z = x + y
+    return z
+end
+
+do
+    local i = debug.getinfo(bar, "S")
+    print(i.what, i.linedefined, i.lastlinedefined, i.source, i.short_src)
+    print(i.currentline, i.nparams, i.func)
+end
+--> =Lua	68	71	luatest	luatest
+--> =nil	nil	nil
+
+do
+    local i = debug.getinfo(bar, "u")
+    print(i.nups, i.nparams, i.isvararg)
+end
+--> =0	2	true
+
+do
+    local i = debug.getinfo(foo, "u")
+    print(i.nups, i.nparams, i.isvararg)
+end
+--> =1	0	true
+
+print(debug.getinfo(bar, "f").func == bar)
+--> =true
+
+do
+    local lines = {}
+    for l in pairs(debug.getinfo(bar, "L").activelines) do
+        lines[#lines + 1] = l
+    end
+    table.sort(lines)
+    print(table.concat(lines, " "))
+end
+--> =68 69 70
+
+do
+    local i = debug.getinfo(1, "S")
+    print(i.what, i.linedefined, i.lastlinedefined)
+end
+--> =main	0	0
+
+do
+    local i = debug.getinfo(print)
+    print(i.what, i.linedefined, i.short_src, i.currentline, i.activelines)
+end
+--> =C	-1	[Go]	-1	nil
+
+print(debug.getinfo(1, "l").currentline)
+--> =118
+
+print(pcall(debug.getinfo, 1, "S>"))
+--> ~false\t.*bad argument #2 \(invalid option\)
+
+-- Tail calls
+
+local function istail()
+    return debug.getinfo(1, "t").istailcall
+end
+
+local function tailcaller()
+    return istail()
+end
+
+local function nontailcaller()
+    local t = istail()
+    return t
+end
+
+print(tailcaller(), nontailcaller())
+--> =true	false
+
+-- Transfer info in call hooks
+
+local function baz(a, b, c) end
+
+debug.sethook(function(ev)
+    local i = debug.getinfo(2, "nr")
+    if i.name == "baz" then
+        print(ev, i.ftransfer, i.ntransfer)
+    end
+end, "c")
+baz(1, 2)
+debug.sethook()
+--> =call	1	3
+
+-- Chunks loaded from strings
+
+do
+    local f = load("local i = debug.getinfo(1, 'S') return i", "=mychunk")
+    local i = f()
+    print(i.source, i.short_src, i.what)
+end
+--> ==mychunk	mychunk	main
@@ -0,0 +1,178 @@
+local function perr(...)
+    local ok, err = pcall(...)
+    if not ok then
+        print(err)
+    end
+end
+
+-- getlocal tests
+do
+    local function f(a, b)
+        local c = a + b
+        do
+            local d = "inner"
+            for i = 1, 4 do
+                local name, val = debug.getlocal(1, i)
+                print(name, val)
+            end
+        end
+        print(debug.getlocal(1, 4))
+        return c
+    end
+    f(1, 2)
+    --> =a	1
+    --> =b	2
+    --> =c	3
+    --> =d	inner
+    --> =nil
+
+    local function g(x)
+        local y = x * 2
+        local function h()
+            print(debug.getlocal(2, 1))
+            print(debug.getlocal(2, 2))
+            print(debug.getlocal(2, 3))
+        end
+        h()
+        return y
+    end
+    g(5)
+    --> =x	5
+    --> =y	10
+    --> ~h\tfunction: .*
+end
+
+-- Locals not yet in scope are not visible
+do
+    local function f()
+        local a = 1
+        print(debug.getlocal(1, 2))
+        local b = 2
+        print(debug.getlocal(1, 2))
+        return a + b
+    end
+    f()
+    --> =nil
+    --> =b	2
+end
+
+-- Varargs
+do
+    local function f(...)
+        print(debug.getlocal(1, -1))
+        print(debug.getlocal(1, -2))
+        print(debug.getlocal(1, -3))
+        return ...
+    end
+    f("x", "y")
+    --> =(vararg)	x
+    --> =(vararg)	y
+    --> =nil
+end
+
+-- Parameter names of functions
+do
+    local function f(x, y, ...)
+        local z
+    end
+    print(debug.getlocal(f, 1))
+    --> =x
+    print(debug.getlocal(f, 2))
+    --> =y
+    print(debug.getlocal(f, 3))
+    --> =nil
+    print(debug.getlocal(print, 1))
+    --> =nil
+
+    -- Local variable info survives string.dump
+    local g = load(string.dump(f))
+    print(debug.getlocal(g, 2))
+    --> =y
+end
+
+-- Locals in a coroutine
+do
+    local co = coroutine.create(function(a)
+        local b = a .. "!"
+        coroutine.yield()
+    end)
+    coroutine.resume(co, "hello")
+    print(debug.getlocal(co, 1, 1))
+    --> =a	hello
+    print(debug.getlocal(co, 1, 2))
+    --> =b	hello!
+    print(debug.setlocal(co, 1, 2, "bye"))
+    --> =b
+    print(debug.getlocal(co, 1, 2))
+    --> =b	bye
+end
+
+-- setlocal tests
+do
+    local function f()
+        local a, b = 1, 2
+        print(debug.setlocal(1, 2, "two"))
+        print(debug.setlocal(1, 3, "three"))
+        print(a, b)
+    end
+    f()
+    --> =b
+    --> =nil
+    --> =1	two
+
+    -- Locals captured by closures can be changed too
+    local function g()
+        local x = 1
+        local function get() return x end
+        debug.setlocal(1, 1, 42)
+        print(get())
+    end
+    g()
+    --> =42
+
+    local function h(...)
+        debug.setlocal(1, -2, "B")
+        print(...)
+    end
+    h("a", "b", "c")
+    --> =a	B	c
+end
+
+-- Errors
+do
+    perr(debug.getlocal, 1)
+    --> ~.*: 2 arguments needed
+
+    perr(debug.getlocal, 100, 1)
+    --> ~.*: bad argument #1 \(level out of range\)
+
+    perr(debug.getlocal, "x", 1)
+    --> ~.*: #1 must be an integer
+
+    perr(debug.getlocal, 1, "x")
+    --> ~.*: #2 must be an integer
+
+    perr(debug.setlocal, 1, 1)
+    --> ~.*: 3 arguments needed
+
+    perr(debug.setlocal, 100, 1, 1)
+    --> ~.*: bad argument #1 \(level out of range\)
+end
+
+-- Locals can be inspected from a hook
+do
+    local function hooked(x)
+        local y = x * 2
+        return y
+    end
+    debug.sethook(function(ev, line)
+        local name, val = debug.getlocal(2, 1)
+        if name == "x" then
+            print(line, name, val, debug.getlocal(2, 2))
+        end
+    end, "l")
+    hooked(21)
+    debug.sethook()
+    --> ~\d+\tx\t21
+    --> ~\d+\tx\t21\ty\t42
+end
@@ -0,0 +1,114 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const deterministicSource = `
+local t = {}
+for i = 1, 100 do
+	t["k" .. i] = i
+	t[i * 1.5] = {}
+end
+t.k50 = nil
+t.k50 = 50
+local keys = {}
+for k in pairs(t) do
+	keys[#keys+1] = tostring(k)
+end
+print(table.concat(keys, " "))
+print(math.random(1000), math.random(1000))
+print(os.time(), os.clock(), os.date("%Y-%m-%d %H:%M"))
+print(collectgarbage("count"))
+math.randomseed()
+print(math.random(1000))
+`
+
+func runDeterministic(t *testing.T, src string, opts ...rt.RuntimeOption) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	r := rt.New(&out, opts...)
+	defer r.Close(nil)
+	cleanup := lib.LoadAll(r)
+	defer cleanup()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rt.Call1(r.MainThread(), rt.FunctionValue(clos))
+	return out.String(), err
+}
+
+func TestDeterministicMode(t *testing.T) {
+	out1, err := runDeterministic(t, deterministicSource, rt.WithDeterministicMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		out2, err := runDeterministic(t, deterministicSource, rt.WithDeterministicMode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out1 != out2 {
+			t.Fatalf("different outputs:\n%s\n%s", out1, out2)
+		}
+	}
+	if !strings.HasPrefix(out1, "k1 1.5 k2 3 k3 4.5 ") {
+		t.Errorf("not in insertion order: %s", out1)
+	}
+	if !strings.Contains(out1, " k50\n") {
+		t.Errorf("k50 should be last: %s", out1)
+	}
+	if !strings.Contains(out1, "\t2000-01-01 00:00\n") {
+		t.Errorf("virtual clock should start on 2000-01-01: %s", out1)
+	}
+	out3, err := runDeterministic(t, deterministicSource, rt.WithDeterministicMode(), rt.WithRandSeed(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out1 == out3 {
+		t.Errorf("different seeds, same output:\n%s", out1)
+	}
+}
+
+func TestDeterministicModeRejectsUnsafe(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	_, err := runDeterministic(t, `os.getenv("HOME")`, rt.WithDeterministicMode())
+	if err == nil || !strings.Contains(err.Error(), "missing flags: detsafe") {
+		t.Errorf("expected missing flags error, got %v", err)
+	}
+}
+
+func TestDetsafeContextKeepsTablesUnordered(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	r := rt.New(nil)
+	defer r.Close(nil)
+	tbl := rt.NewTable()
+	_, err := r.MainThread().CallContext(rt.RuntimeContextDef{RequiredFlags: rt.ComplyDetSafe}, func() error {
+		r.SetTable(tbl, rt.StringValue("x"), rt.IntValue(1))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.IsOrdered() {
+		t.Error("table made ordered by a detsafe context")
+	}
+
+	r = rt.New(nil, rt.WithDeterministicMode())
+	defer r.Close(nil)
+	tbl = rt.NewTable()
+	r.SetTable(tbl, rt.StringValue("x"), rt.IntValue(1))
+	if !tbl.IsOrdered() {
+		t.Error("table not ordered in a deterministic runtime")
+	}
+}
@@ -0,0 +1,88 @@
+package golib
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// chanMethod returns the method called name of the Go channel ch, or nil if
+// there is no such method.  Channels have the following methods:
+//
+//   - send(x) sends x on the channel;
+//   - recv() receives a value from the channel and returns it, and false if
+//     the channel was closed (true otherwise);
+//   - close() closes the channel.
+//
+// send and recv block until the operation can proceed, or until the runtime is
+// terminated because its Go context is done (see rt.Runtime.OnCancel).
+func chanMethod(ch reflect.Value, name string, meta *rt.Table) *rt.GoFunction {
+	dir := ch.Type().ChanDir()
+	switch name {
+	case "send":
+		return rt.NewGoFunction(func(t *rt.Thread, c *rt.GoCont) (next rt.Cont, err error) {
+			if dir&reflect.SendDir == 0 {
+				return nil, errors.New("cannot send to receive-only channel")
+			}
+			if err := c.Check1Arg(); err != nil {
+				return nil, err
+			}
+			x, err := valueToType(t, c.Arg(0), ch.Type().Elem())
+			if err != nil {
+				return nil, err
+			}
+			defer recoverChanPanic(&err)
+			if _, _, err := chanSelect(t.Runtime, reflect.SelectCase{Dir: reflect.SelectSend, Chan: ch, Send: x}); err != nil {
+				return nil, err
+			}
+			return c.Next(), nil
+		}, "send", 1, false)
+	case "recv":
+		return rt.NewGoFunction(func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+			if dir&reflect.RecvDir == 0 {
+				return nil, errors.New("cannot receive from send-only channel")
+			}
+			x, ok, err := chanSelect(t.Runtime, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: ch})
+			if err != nil {
+				return nil, err
+			}
+			return c.PushingNext(t.Runtime, reflectToValue(x, meta), rt.BoolValue(ok)), nil
+		}, "recv", 0, false)
+	case "close":
+		return rt.NewGoFunction(func(t *rt.Thread, c *rt.GoCont) (next rt.Cont, err error) {
+			if dir&reflect.SendDir == 0 {
+				return nil, errors.New("cannot close receive-only channel")
+			}
+			defer recoverChanPanic(&err)
+			ch.Close()
+			return c.Next(), nil
+		}, "close", 0, false)
+	}
+	return nil
+}
+
+// chanSelect performs the channel operation described by op, returning early
+// with an error if the runtime is hard-stopped.
+func chanSelect(r *rt.Runtime, op reflect.SelectCase) (reflect.Value, bool, error) {
+	cancel := make(chan struct{})
+	release := r.OnCancel(func() { close(cancel) })
+	defer release()
+	chosen, x, ok := reflect.Select([]reflect.SelectCase{
+		op,
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(cancel)},
+	})
+	if chosen == 1 {
+		return reflect.Value{}, false, errors.New("channel operation interrupted")
+	}
+	return x, ok, nil
+}
+
+// recoverChanPanic turns a panic caused by sending on or closing a closed
+// channel into an error.
+func recoverChanPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%v", r)
+	}
+}
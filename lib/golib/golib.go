@@ -34,6 +34,9 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnvGoFunc(meta, "__newindex", goValueSetIndex, 3, false)
 	r.SetEnvGoFunc(meta, "__call", goValueCall, 1, true)
 	r.SetEnvGoFunc(meta, "__tostring", goValueToString, 1, false)
+	r.SetEnvGoFunc(meta, "__len", goValueLen, 1, false)
+	r.SetEnvGoFunc(meta, "__pairs", goValuePairs, 1, false)
+	r.SetEnvGoFunc(meta, "__eq", goValueEq, 2, false)
 
 	r.SetRegistry(govalueKey, rt.TableValue(meta))
 
@@ -105,6 +108,46 @@ func goValueCall(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext(t.Runtime, res...), nil
 }
 
+func goValueLen(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	u, err := c.UserDataArg(0)
+	if err != nil {
+		return nil, err
+	}
+	n, lenErr := goLen(u)
+	if lenErr != nil {
+		return nil, lenErr
+	}
+	return c.PushingNext1(t.Runtime, n), nil
+}
+
+func goValuePairs(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	u, err := c.UserDataArg(0)
+	if err != nil {
+		return nil, err
+	}
+	next, pairsErr := goPairs(u)
+	if pairsErr != nil {
+		return nil, pairsErr
+	}
+	return c.PushingNext1(t.Runtime, rt.FunctionValue(next)), nil
+}
+
+func goValueEq(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	u1, ok1 := c.Arg(0).TryUserData()
+	u2, ok2 := c.Arg(1).TryUserData()
+	res := ok1 && ok2 && goEqual(u1.Value(), u2.Value())
+	return c.PushingNext1(t.Runtime, rt.BoolValue(res)), nil
+}
+
 func goimport(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if pluginsRoot == "" {
 		return nil, rt.NewError(rt.StringValue("cannot import go packages: plugins root not set"))
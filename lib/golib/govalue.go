@@ -3,6 +3,7 @@ package golib
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 
 	rt "github.com/arnodel/golua/runtime"
@@ -59,6 +60,13 @@ func goIndex(t *rt.Thread, u *rt.UserData, key rt.Value) (rt.Value, error) {
 			return rt.NilValue, errors.New("index out of slice bounds")
 		}
 		return reflectToValue(gv.Index(int(i)), meta), nil
+	case reflect.Chan:
+		if ok {
+			if m := chanMethod(gv, field, meta); m != nil {
+				return rt.FunctionValue(m), nil
+			}
+		}
+		return rt.NilValue, fmt.Errorf("no method with name %q", field)
 	}
 	return rt.NilValue, errors.New("unable to index")
 }
@@ -170,6 +178,17 @@ func goCall(t *rt.Thread, u *rt.UserData, args []rt.Value) (res []rt.Value, err
 	} else {
 		goRes = gv.Call(goArgs)
 	}
+	// A non-nil error as last result is raised as a Lua error if it is the only
+	// result, otherwise the function returns nil and the error message.
+	if n := len(goRes); n > 0 && f.Out(n-1) == errorType {
+		if goErr := goRes[n-1]; !goErr.IsNil() {
+			if n == 1 {
+				return nil, goErr.Interface().(error)
+			}
+			return []rt.Value{rt.NilValue, rt.StringValue(goErr.Interface().(error).Error())}, nil
+		}
+		goRes = goRes[:n-1]
+	}
 	res = make([]rt.Value, len(goRes))
 	for i, x := range goRes {
 		res[i] = reflectToValue(x, meta)
@@ -177,33 +196,92 @@ func goCall(t *rt.Thread, u *rt.UserData, args []rt.Value) (res []rt.Value, err
 	return
 }
 
-func fillStruct(t *rt.Thread, s reflect.Value, v rt.Value) error {
-	var ok bool
-	tbl, ok := v.TryTable()
-	if !ok {
-		return errors.New("fillStruct: can only fill from a table")
+// goLen returns the length of the Go value if it is a slice, array, map,
+// string or channel.
+func goLen(u *rt.UserData) (rt.Value, error) {
+	gv := reflect.ValueOf(u.Value())
+	if gv.Kind() == reflect.Ptr && gv.Type().Elem().Kind() == reflect.Array {
+		gv = gv.Elem()
 	}
-	var fk, fv rt.Value
-	for {
-		fk, fv, ok = tbl.Next(fk)
-		if !ok || fk.IsNil() {
-			break
+	switch gv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String, reflect.Chan:
+		return rt.IntValue(int64(gv.Len())), nil
+	}
+	return rt.NilValue, fmt.Errorf("cannot get the length of %s", gv.Type())
+}
+
+// goPairs returns an iterator function over the Go value, yielding the keys
+// and values of a map (in no particular order), the indices (starting from 0)
+// and items of a slice or array, or the names and values of the exported
+// fields of a struct.
+func goPairs(u *rt.UserData) (*rt.GoFunction, error) {
+	gv := reflect.ValueOf(u.Value())
+	meta := u.Metatable()
+	if gv.Kind() == reflect.Ptr && !gv.IsNil() {
+		if k := gv.Elem().Kind(); k == reflect.Struct || k == reflect.Array {
+			gv = gv.Elem()
 		}
-		name, ok := fk.TryString()
-		if !ok {
-			return errors.New("fillStruct: table fields must be strings")
+	}
+	var (
+		n    int
+		item func(i int) (reflect.Value, reflect.Value)
+	)
+	switch gv.Kind() {
+	case reflect.Map:
+		keys := gv.MapKeys()
+		n = len(keys)
+		item = func(i int) (reflect.Value, reflect.Value) {
+			return keys[i], gv.MapIndex(keys[i])
 		}
-		field := s.FieldByName(string(name))
-		if field == (reflect.Value{}) {
-			return fmt.Errorf("fillStruct: field %q does not exist in struct", name)
+	case reflect.Slice, reflect.Array:
+		n = gv.Len()
+		item = func(i int) (reflect.Value, reflect.Value) {
+			return reflect.ValueOf(i), gv.Index(i)
 		}
-		goFv, err := valueToType(t, fv, field.Type())
-		if err != nil {
-			return err
+	case reflect.Struct:
+		tp := gv.Type()
+		var fields []int
+		for i := 0; i < tp.NumField(); i++ {
+			if tp.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+		n = len(fields)
+		item = func(i int) (reflect.Value, reflect.Value) {
+			return reflect.ValueOf(tp.Field(fields[i]).Name), gv.Field(fields[i])
 		}
-		field.Set(goFv)
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", gv.Type())
 	}
-	return nil
+	i := 0
+	next := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		for ; i < n; i++ {
+			// Map entries deleted during the iteration are skipped.
+			if k, v := item(i); v.IsValid() {
+				i++
+				return c.PushingNext(t.Runtime, reflectToValue(k, meta), reflectToValue(v, meta)), nil
+			}
+		}
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	return rt.NewGoFunction(next, "next", 0, true), nil
+}
+
+// goEqual returns true if x and y have the same comparable Go type and are
+// equal.
+func goEqual(x, y interface{}) (res bool) {
+	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
+	if !vx.IsValid() || !vy.IsValid() || vx.Type() != vy.Type() || !vx.Type().Comparable() {
+		return false
+	}
+	// Values of comparable types may still contain incomparable values (e.g. a
+	// slice in an interface field), in which case comparing them panics.
+	defer func() {
+		if recover() != nil {
+			res = false
+		}
+	}()
+	return x == y
 }
 
 func valueToFunc(t *rt.Thread, v rt.Value, tp reflect.Type) (reflect.Value, error) {
@@ -231,9 +309,30 @@ func valueToFunc(t *rt.Thread, v rt.Value, tp reflect.Type) (reflect.Value, erro
 	return reflect.MakeFunc(tp, fn), nil
 }
 
-var runtimeValueType = reflect.TypeOf(rt.Value{})
+func fillStruct(t *rt.Thread, s reflect.Value, v rt.Value) error {
+	c := converter{t: t}
+	return c.fillStruct(s, v)
+}
 
 func valueToType(t *rt.Thread, v rt.Value, tp reflect.Type) (reflect.Value, error) {
+	c := converter{t: t}
+	return c.convert(v, tp)
+}
+
+var (
+	runtimeValueType = reflect.TypeOf(rt.Value{})
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// A converter converts Lua values to Go values.  It keeps track of the tables
+// being converted so that a table which contains itself is an error rather than
+// an infinite recursion.
+type converter struct {
+	t      *rt.Thread
+	tables map[*rt.Table]bool
+}
+
+func (c *converter) convert(v rt.Value, tp reflect.Type) (reflect.Value, error) {
 	if tp == runtimeValueType {
 		return reflect.ValueOf(v), nil
 	}
@@ -246,57 +345,191 @@ func valueToType(t *rt.Thread, v rt.Value, tp reflect.Type) (reflect.Value, erro
 		if gv.Type().ConvertibleTo(tp) {
 			return gv.Convert(tp), nil
 		}
-		return reflect.Value{}, fmt.Errorf("%+v is not assignable or convertible to %s", u.Value(), tp.Name())
+		return reflect.Value{}, fmt.Errorf("%+v is not assignable or convertible to %s", u.Value(), tp)
+	}
+	switch tp.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan:
+		if v.IsNil() {
+			return reflect.Zero(tp), nil
+		}
 	}
+	x := reflect.New(tp).Elem()
 	switch tp.Kind() {
 	case reflect.Ptr:
 		if tp.Elem().Kind() != reflect.Struct {
-			return reflect.Value{}, fmt.Errorf("lua value cannot be converted to %s", tp.Name())
+			return reflect.Value{}, fmt.Errorf("lua value cannot be converted to %s", tp)
 		}
 		p := reflect.New(tp.Elem())
-		if err := fillStruct(t, p.Elem(), v); err != nil {
+		if err := c.fillStruct(p.Elem(), v); err != nil {
 			return reflect.Value{}, err
 		}
 		return p, nil
 	case reflect.Struct:
-		s := reflect.Zero(tp)
-		if err := fillStruct(t, s, v); err != nil {
+		if err := c.fillStruct(x, v); err != nil {
 			return reflect.Value{}, err
 		}
-		return s, nil
+		return x, nil
 	case reflect.Func:
-		return valueToFunc(t, v, tp)
-	case reflect.Int:
-		x, ok := rt.ToInt(v)
-		if ok {
-			return reflect.ValueOf(int(x)), nil
+		return valueToFunc(c.t, v, tp)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := rt.ToInt(v)
+		if !ok {
+			break
 		}
-	case reflect.Float64:
-		x, ok := rt.ToFloat(v)
-		if ok {
-			return reflect.ValueOf(float64(x)), nil
+		if x.OverflowInt(n) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", n, tp)
+		}
+		x.SetInt(n)
+		return x, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Integers greater than math.MaxInt64 can only be represented as floats
+		// in Lua.
+		if f, ok := v.TryFloat(); ok && f >= 1<<63 && f < 1<<64 && f == math.Floor(f) {
+			x.SetUint(uint64(f))
+			return x, nil
+		}
+		n, ok := rt.ToInt(v)
+		if !ok {
+			break
+		}
+		if n < 0 || x.OverflowUint(uint64(n)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", n, tp)
+		}
+		x.SetUint(uint64(n))
+		return x, nil
+	case reflect.Float32, reflect.Float64:
+		f, ok := rt.ToFloat(v)
+		if !ok {
+			break
 		}
+		if x.OverflowFloat(f) {
+			return reflect.Value{}, fmt.Errorf("%g overflows %s", f, tp)
+		}
+		x.SetFloat(f)
+		return x, nil
 	case reflect.String:
-		x, ok := v.ToString()
+		s, ok := v.ToString()
 		if ok {
-			return reflect.ValueOf(string(x)), nil
+			x.SetString(s)
+			return x, nil
 		}
 	case reflect.Bool:
-		return reflect.ValueOf(rt.Truth(v)), nil
+		x.SetBool(rt.Truth(v))
+		return x, nil
 	case reflect.Slice:
 		if tp.Elem().Kind() == reflect.Uint8 {
 			s, ok := v.TryString()
 			if ok {
-				return reflect.ValueOf([]byte(s)), nil
+				x.SetBytes([]byte(s))
+				return x, nil
 			}
 		}
+		if tbl, ok := v.TryTable(); ok {
+			return x, c.withTable(tbl, func() error {
+				n := int(tbl.Len())
+				x.Set(reflect.MakeSlice(tp, n, n))
+				return c.fillSeq(x, tbl, n)
+			})
+		}
+	case reflect.Array:
+		if tbl, ok := v.TryTable(); ok {
+			return x, c.withTable(tbl, func() error {
+				n := int(tbl.Len())
+				if n > tp.Len() {
+					return fmt.Errorf("table of length %d does not fit in %s", n, tp)
+				}
+				return c.fillSeq(x, tbl, n)
+			})
+		}
+	case reflect.Map:
+		if tbl, ok := v.TryTable(); ok {
+			return x, c.withTable(tbl, func() error {
+				x.Set(reflect.MakeMap(tp))
+				return c.fillMap(x, tbl)
+			})
+		}
 	case reflect.Interface:
 		iface := v.Interface()
 		if reflect.TypeOf(iface).Implements(tp) {
 			return reflect.ValueOf(iface), nil
 		}
 	}
-	return reflect.Value{}, fmt.Errorf("%+v cannot be converted to %s", v, tp.Name())
+	return reflect.Value{}, fmt.Errorf("%s cannot be converted to %s", v.TypeName(), tp)
+}
+
+// withTable runs f, which converts the table tbl, unless tbl is already being
+// converted.
+func (c *converter) withTable(tbl *rt.Table, f func() error) error {
+	if c.tables[tbl] {
+		return errors.New("table contains itself")
+	}
+	if c.tables == nil {
+		c.tables = map[*rt.Table]bool{}
+	}
+	c.tables[tbl] = true
+	defer delete(c.tables, tbl)
+	return f()
+}
+
+func (c *converter) fillStruct(s reflect.Value, v rt.Value) error {
+	tbl, ok := v.TryTable()
+	if !ok {
+		return errors.New("fillStruct: can only fill from a table")
+	}
+	return c.withTable(tbl, func() error {
+		var fk, fv rt.Value
+		for {
+			fk, fv, ok = tbl.Next(fk)
+			if !ok || fk.IsNil() {
+				break
+			}
+			name, ok := fk.TryString()
+			if !ok {
+				return errors.New("fillStruct: table fields must be strings")
+			}
+			field := s.FieldByName(string(name))
+			if field == (reflect.Value{}) {
+				return fmt.Errorf("fillStruct: field %q does not exist in struct", name)
+			}
+			goFv, err := c.convert(fv, field.Type())
+			if err != nil {
+				return err
+			}
+			field.Set(goFv)
+		}
+		return nil
+	})
+}
+
+// fillSeq sets the first n items of the slice or array s from the sequence
+// tbl.  Lua index i goes to Go index i - 1.
+func (c *converter) fillSeq(s reflect.Value, tbl *rt.Table, n int) error {
+	elemType := s.Type().Elem()
+	for i := 0; i < n; i++ {
+		item, err := c.convert(tbl.Get(rt.IntValue(int64(i+1))), elemType)
+		if err != nil {
+			return fmt.Errorf("table item %d: %s", i+1, err)
+		}
+		s.Index(i).Set(item)
+	}
+	return nil
+}
+
+// fillMap adds all the key-value pairs of tbl to the map m.
+func (c *converter) fillMap(m reflect.Value, tbl *rt.Table) error {
+	keyType, elemType := m.Type().Key(), m.Type().Elem()
+	for k, v, ok := tbl.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = tbl.Next(k) {
+		goK, err := c.convert(k, keyType)
+		if err != nil {
+			return fmt.Errorf("table key: %s", err)
+		}
+		goV, err := c.convert(v, elemType)
+		if err != nil {
+			return fmt.Errorf("table value: %s", err)
+		}
+		m.SetMapIndex(goK, goV)
+	}
+	return nil
 }
 
 func reflectToValue(v reflect.Value, meta *rt.Table) rt.Value {
@@ -306,8 +539,13 @@ func reflectToValue(v reflect.Value, meta *rt.Table) rt.Value {
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return rt.IntValue(v.Int())
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return rt.IntValue(int64(v.Uint()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			// Too big for a Lua integer
+			return rt.FloatValue(float64(n))
+		}
+		return rt.IntValue(int64(n))
 	case reflect.Float32, reflect.Float64:
 		return rt.FloatValue(v.Float())
 	case reflect.String:
@@ -241,6 +241,71 @@ func Test_valueToType(t *testing.T) {
 			v:    rt.IntValue(10),
 			want: rt.IntValue(10),
 		},
+		{
+			name: "rt.Int to int8",
+			v:    int64(-128),
+			want: int8(-128),
+		},
+		{
+			name:    "rt.Int overflowing int8",
+			v:       int64(128),
+			want:    int8(0),
+			wantErr: true,
+		},
+		{
+			name:    "negative rt.Int to uint",
+			v:       int64(-1),
+			want:    uint(0),
+			wantErr: true,
+		},
+		{
+			name: "large rt.Float to uint64",
+			v:    float64(1 << 63),
+			want: uint64(1 << 63),
+		},
+		{
+			name: "rt.Float to float32",
+			v:    float64(0.5),
+			want: float32(0.5),
+		},
+		{
+			name:    "rt.Float overflowing float32",
+			v:       float64(1e300),
+			want:    float32(0),
+			wantErr: true,
+		},
+		{
+			name: "nil to map",
+			v:    nil,
+			want: map[string]int(nil),
+		},
+		{
+			name: "table to map",
+			v:    tabledef{"a": int64(1)}.table(),
+			want: map[string]int{"a": 1},
+		},
+		{
+			name:    "table to map with incompatible key",
+			v:       tabledef{"a": int64(1)}.table(),
+			want:    map[int]int{},
+			wantErr: true,
+		},
+		{
+			name: "table to slice",
+			v:    tabledef{int64(1): "x", int64(2): "y"}.table(),
+			want: []string{"x", "y"},
+		},
+		{
+			name: "table to array",
+			v:    tabledef{int64(1): int64(7)}.table(),
+			want: [2]int{7, 0},
+		},
+		{
+			name:    "table too long for array",
+			v:       tabledef{int64(1): int64(7), int64(2): int64(8)}.table(),
+			want:    [1]int{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
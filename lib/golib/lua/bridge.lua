@@ -0,0 +1,128 @@
+-- Lua tables are converted to Go slices, arrays and maps
+
+print(join({"a", "b", "c"}, "-"))
+--> =a-b-c
+
+print(join({}, "-") == "")
+--> =true
+
+print(sumvalues({x = 1, y = 2, z = 3}))
+--> =6
+
+print(sumarray({4, 5}))
+--> =9
+
+print(pcall(sumarray, {1, 2, 3, 4}))
+--> ~false\t.*table of length 4 does not fit in \[3\]int
+
+print(pcall(join, {"a", {}}, ""))
+--> ~false\t.*table item 2: table cannot be converted to string
+
+print(pcall(sumvalues, {x = "one"}))
+--> ~false\t.*table value: .*cannot be converted to int
+
+print(treesize({Children = {{}, {Children = {{}}}}}))
+--> =4
+
+do
+    local t = {}
+    t.Children = {t}
+    print(pcall(treesize, t))
+end
+--> ~false\t.*table contains itself
+
+-- All numeric kinds, with overflow checks
+
+print(widths(-128, 65535, 2^31 - 1, 2^63, 1.5))
+--> =-128 65535 2147483647 9223372036854775808 1.5
+
+print(pcall(widths, 128, 0, 0, 0, 0))
+--> ~false\t.*128 overflows int8
+
+print(pcall(widths, 0, -1, 0, 0, 0))
+--> ~false\t.*-1 overflows uint16
+
+print(pcall(widths, 0, 0, 0, 0, 1e300))
+--> ~false\t.*overflows float32
+
+print(maxuint64() == 2^64, math.type(maxuint64()))
+--> =true	float
+
+-- Errors
+
+print(atoi("42"))
+--> =42
+
+print(atoi("x"))
+--> =nil	strconv.Atoi: parsing "x": invalid syntax
+
+print(fail(""))
+--> =
+
+print(pcall(fail, "oops"))
+--> ~false\t.*oops
+
+-- Length, iteration and equality
+
+print(#slice, #mapping, #array)
+--> =3	1	3
+
+print(pcall(function() return #polly end))
+--> ~false\t.*cannot get the length of golib_test.TestStruct
+
+for i, v in pairs(slice) do
+    print(i, v)
+end
+--> =0	I
+--> =1	am
+--> =2	here
+
+for k, v in pairs(mapping) do
+    print(k, v)
+end
+--> =answer	42
+
+for k, v in pairs(polly) do
+    print(k, v)
+end
+--> =Age	10
+--> =Name	Polly
+
+print(pcall(pairs, double))
+--> ~false\t.*cannot iterate over func\(int\) int
+
+print(ben == getben(), ben == polly, polly == polly, slice == slice)
+--> =true	false	true	true
+
+print(array == array, ben == 1)
+--> =true	false
+
+-- Channels
+
+do
+    local ch = newchan(2)
+    ch.send(1)
+    ch.send(2)
+    print(#ch)
+    print(ch.recv())
+    ch.close()
+    print(ch.recv())
+    print(ch.recv())
+    print(pcall(ch.send, 3))
+    print(pcall(ch.send, "x"))
+end
+--> =2
+--> =1	true
+--> =2	true
+--> =0	false
+--> ~false\t.*send on closed channel
+--> ~false\t.*cannot be converted to int
+
+print(pcall(recvonly.send, 1))
+--> ~false\t.*cannot send to receive-only channel
+
+print(pcall(recvonly.close))
+--> ~false\t.*cannot close receive-only channel
+
+print(pcall(function() return recvonly.foo end))
+--> ~false\t.*no method with name "foo"
@@ -1,7 +1,11 @@
 package golib_test
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/arnodel/golua/lib"
@@ -32,13 +36,53 @@ func twice(f func(int) int) func(int) int {
 	}
 }
 
+type Tree struct {
+	Children []Tree
+}
+
+func (t Tree) Size() int {
+	n := 1
+	for _, c := range t.Children {
+		n += c.Size()
+	}
+	return n
+}
+
+func sumValues(m map[string]int) int {
+	var sum int
+	for _, v := range m {
+		sum += v
+	}
+	return sum
+}
+
 func setup(r *rt.Runtime) func() {
 	cleanup := lib.LoadAll(r)
 	g := r.GlobalEnv()
+	ben := &TestStruct{Age: 5, Name: "Ben"}
 	r.SetEnv(g, "hello", rt.StringValue("world"))
 	r.SetEnv(g, "double", golib.NewGoValue(r, func(x int) int { return 2 * x }))
 	r.SetEnv(g, "polly", golib.NewGoValue(r, TestStruct{Age: 10, Name: "Polly"}))
-	r.SetEnv(g, "ben", golib.NewGoValue(r, &TestStruct{Age: 5, Name: "Ben"}))
+	r.SetEnv(g, "ben", golib.NewGoValue(r, ben))
+	r.SetEnv(g, "getben", golib.NewGoValue(r, func() *TestStruct { return ben }))
+	r.SetEnv(g, "join", golib.NewGoValue(r, strings.Join))
+	r.SetEnv(g, "sumvalues", golib.NewGoValue(r, sumValues))
+	r.SetEnv(g, "widths", golib.NewGoValue(r, func(a int8, b uint16, c int32, d uint64, e float32) string {
+		return fmt.Sprint(a, b, c, d, e)
+	}))
+	r.SetEnv(g, "maxuint64", golib.NewGoValue(r, func() uint64 { return math.MaxUint64 }))
+	r.SetEnv(g, "atoi", golib.NewGoValue(r, strconv.Atoi))
+	r.SetEnv(g, "fail", golib.NewGoValue(r, func(msg string) error {
+		if msg == "" {
+			return nil
+		}
+		return errors.New(msg)
+	}))
+	r.SetEnv(g, "treesize", golib.NewGoValue(r, Tree.Size))
+	r.SetEnv(g, "array", golib.NewGoValue(r, [3]int{1, 2, 3}))
+	r.SetEnv(g, "sumarray", golib.NewGoValue(r, func(a [3]int) int { return a[0] + a[1] + a[2] }))
+	r.SetEnv(g, "newchan", golib.NewGoValue(r, func(n int) chan int { return make(chan int, n) }))
+	r.SetEnv(g, "recvonly", golib.NewGoValue(r, (<-chan int)(make(chan int))))
 	r.SetEnv(g, "mapping", golib.NewGoValue(r, map[string]int{"answer": 42}))
 	r.SetEnv(g, "slice", golib.NewGoValue(r, []string{"I", "am", "here"}))
 	r.SetEnv(g, "sprintf", golib.NewGoValue(r, fmt.Sprintf))
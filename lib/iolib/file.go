@@ -8,12 +8,15 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
+	"time"
 
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/safeio"
 	"github.com/arnodel/golua/scanner"
 	"github.com/arnodel/golua/token"
+	"github.com/arnodel/golua/vfs"
 )
 
 const (
@@ -30,12 +33,17 @@ var (
 	errInvalidBufferSize = errors.New("invalid buffer size")
 )
 
-// A File wraps an os.File for manipulation by iolib.
+// A File wraps a vfs.File (e.g. an *os.File) for manipulation by iolib.
 type File struct {
-	file   *os.File
+	file   vfs.File
 	status fileStatus
 	reader bufReader
 	writer bufWriter
+
+	cmd     *exec.Cmd // The process the file is a pipe to (see Popen)
+	waitErr error     // The result of waiting for cmd when the file is closed
+
+	fs vfs.FS // For temporary files, the filesystem to remove the file from
 }
 
 var _ rt.UserDataResourceReleaser = (*File)(nil)
@@ -48,8 +56,8 @@ const (
 	statusNotClosable
 )
 
-// NewFile returns a new *File from an *os.File.
-func NewFile(file *os.File, options int) *File {
+// NewFile returns a new *File from a vfs.File.
+func NewFile(file vfs.File, options int) *File {
 	f := &File{file: file}
 	// TODO: find out if there is mileage in having unbuffered readers.
 	if true || options&bufferedRead != 0 {
@@ -103,6 +111,62 @@ func OpenFile(r *rt.Runtime, name, mode string) (*File, error) {
 	return NewFile(f, options), nil
 }
 
+// Popen starts the shell command prog in a separate process and returns a
+// file to read from its standard output (if mode is "r") or to write to its
+// standard input (if mode is "w").  Closing the file waits for the process to
+// terminate.
+//
+// When mode is "w", the process writes directly to the standard output of the
+// Go process, as it runs concurrently with Lua code which may be writing to
+// the runtime's Stdout.
+func Popen(r *rt.Runtime, prog, mode string) (*File, error) {
+	if mode != "r" && mode != "w" {
+		return nil, errors.New("invalid mode")
+	}
+	cmd, err := safeio.ShellCommand(r, prog)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		f     *File
+		child *os.File // The end of the pipe used by the child process
+	)
+	if mode == "r" {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, pw, os.Stderr
+		f, child = NewFile(pr, bufferedRead), pw
+	} else {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = pr, os.Stdout, os.Stderr
+		f, child = NewFile(pw, bufferedWrite), pr
+	}
+	err = cmd.Start()
+	child.Close()
+	if err != nil {
+		f.file.Close()
+		return nil, err
+	}
+	f.cmd = cmd
+	return f, nil
+}
+
+// IsProcess returns true if the file is a pipe to a process started by Popen.
+func (f *File) IsProcess() bool {
+	return f.cmd != nil
+}
+
+// ExitStatus returns the exit status of the process started by Popen, once
+// the file is closed.  See safeio.ExitStatus for the meaning of the returned
+// values.
+func (f *File) ExitStatus() (what string, code int, err error) {
+	if f.cmd == nil || !f.IsClosed() {
+		return "", 0, errors.New("no process exit status")
+	}
+	return safeio.ExitStatus(f.waitErr)
+}
+
 // TempFile tries to make a temporary file, and if successful schedules the file
 // to be removed when the process dies.
 func TempFile(r *rt.Runtime) (*File, error) {
@@ -111,6 +175,7 @@ func TempFile(r *rt.Runtime) (*File, error) {
 		return nil, err
 	}
 	ff := NewFile(f, bufferedRead|bufferedWrite|tempFile)
+	ff.fs = r.FS()
 	return ff, nil
 }
 
@@ -163,6 +228,9 @@ func (f *File) Close() error {
 	f.status |= statusClosed
 	errFlush := f.writer.Flush()
 	err := f.file.Close()
+	if f.cmd != nil {
+		f.waitErr = f.cmd.Wait()
+	}
 	if err == nil {
 		return errFlush
 	}
@@ -174,9 +242,33 @@ func (f *File) Flush() error {
 	if err := f.writer.Flush(); err != nil {
 		return err
 	}
+	if f.cmd != nil {
+		// Pipes cannot be synced
+		return nil
+	}
 	return f.file.Sync()
 }
 
+// interruptible makes blocking IO on f return early if the runtime is
+// terminated because its Go context is done (see rt.Runtime.OnCancel).  This
+// works for files which support deadlines, such as pipes.  The returned
+// function must be called when the IO is finished.
+func (f *File) interruptible(r *rt.Runtime) func() {
+	d, ok := f.file.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return func() {}
+	}
+	release := r.OnCancel(func() {
+		d.SetDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		if release() {
+			// The file may be used again from another runtime context.
+			d.SetDeadline(time.Time{})
+		}
+	}
+}
+
 // ReadLine reads a line from the file.  If withEnd is true, it will include the
 // end of the line in the returned value.
 func (f *File) ReadLine(withEnd bool) (rt.Value, error) {
@@ -328,7 +420,7 @@ func (f *File) cleanup() {
 	if !f.IsClosed() {
 		f.Close()
 	}
-	if f.IsTemp() {
-		_ = os.Remove(f.Name())
+	if f.IsTemp() && f.fs != nil {
+		_ = f.fs.Remove(f.Name())
 	}
 }
@@ -0,0 +1,58 @@
+package iolib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func TestReadInterruptedByContext(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pw.Close()
+	defer pr.Close()
+
+	r := rt.New(nil, rt.WithCancelGracePeriod(10*time.Millisecond))
+	defer r.Close(nil)
+	pkg, cleanup := load(r)
+	defer cleanup()
+	env := r.GlobalEnv()
+	r.SetEnv(env, "io", pkg)
+	r.SetEnv(env, "pipe", r.NewUserDataValue(NewFile(pr, bufferedRead), getIoData(r).metatable))
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(`return pipe:read("l")`), rt.TableValue(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = rt.Call1WithContext(ctx, r.MainThread(), rt.FunctionValue(clos))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("took too long to stop: %s", d)
+	}
+
+	// The pipe can still be read from after the interruption.
+	if _, err := pw.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := v.TryString(); s != "hello" {
+		t.Errorf("unexpected result: %v", v)
+	}
+}
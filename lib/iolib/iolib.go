@@ -31,7 +31,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnv(meta, "__index", rt.TableValue(methods))
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(methods, "read", fileread, 1, true),
 		r.SetEnvGoFunc(methods, "lines", filelines, 1, true),
@@ -47,7 +47,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	)
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(meta, "__tostring", tostring, 1, false),
 	)
@@ -84,7 +84,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnv(pkg, "stderr", stderr)
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "close", ioclose, 1, false),
 		r.SetEnvGoFunc(pkg, "flush", ioflush, 0, false),
@@ -92,18 +92,26 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "lines", iolines, 1, true),
 		r.SetEnvGoFunc(pkg, "open", open, 2, false),
 		r.SetEnvGoFunc(pkg, "output", output, 1, false),
-		// TODO: popen,
 		r.SetEnvGoFunc(pkg, "read", ioread, 0, true),
 		r.SetEnvGoFunc(pkg, "tmpfile", tmpfile, 0, false),
 		r.SetEnvGoFunc(pkg, "write", iowrite, 0, true),
 	)
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "type", typef, 1, false),
 	)
 
+	// This returns an error rather than spawn a process when ComplyExecSafe is
+	// required (see safeio.ShellCommand).  As the process can do IO, it does
+	// not comply with ComplyIoSafe.
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyExecSafe,
+
+		r.SetEnvGoFunc(pkg, "popen", popen, 2, false),
+	)
+
 	// This function should make sure known buffers are flushed before quitting
 	var cleanup = func() {
 		getIoData(r).defaultOutputFile().Flush()
@@ -152,7 +160,19 @@ func ioclose(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 			return nil, err
 		}
 	}
-	return pushingNextIoResult(t.Runtime, c, f.Close())
+	err := f.Close()
+	if err != nil || !f.IsProcess() {
+		return pushingNextIoResult(t.Runtime, c, err)
+	}
+	what, code, err := f.ExitStatus()
+	if err != nil {
+		return t.ProcessIoError(c.Next(), err)
+	}
+	ok := rt.NilValue
+	if what == "exit" && code == 0 {
+		ok = rt.BoolValue(true)
+	}
+	return c.PushingNext(t.Runtime, ok, rt.StringValue(what), rt.IntValue(int64(code))), nil
 }
 
 func fileclose(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -331,7 +351,7 @@ func lines(r *rt.Runtime, f *File, readers []formatReader, flags int) *rt.GoFunc
 		return next, nil
 	}
 	iterGof := rt.NewGoFunction(iterator, "linesiterator", 0, false)
-	iterGof.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyIoSafe)
+	iterGof.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyIoSafe | rt.ComplyExecSafe | rt.ComplyDetSafe)
 	return iterGof
 
 }
@@ -359,6 +379,29 @@ func open(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext(t.Runtime, fv), nil
 }
 
+func popen(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	prog, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	mode := "r"
+	if c.NArgs() >= 2 {
+		mode, err = c.StringArg(1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, ioErr := Popen(t.Runtime, prog, mode)
+	if ioErr != nil {
+		return pushingNextIoResult(t.Runtime, c, ioErr)
+	}
+	fv := t.NewUserDataValue(f, getIoData(t.Runtime).metatable)
+	return c.PushingNext(t.Runtime, fv), nil
+}
+
 func typef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.Check1Arg(); err != nil {
 		return nil, err
@@ -394,6 +437,7 @@ func write(r *rt.Runtime, vf rt.Value, c *rt.GoCont) (rt.Cont, error) {
 	if f.IsClosed() {
 		return nil, errFileAlreadyClosed
 	}
+	defer f.interruptible(r)()
 	var err error
 	for _, val := range c.Etc() {
 		switch val.Type() {
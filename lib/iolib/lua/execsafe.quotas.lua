@@ -0,0 +1,18 @@
+-- When execsafe is on, io.popen is available but cannot spawn processes
+
+print(runtime.callcontext({flags="execsafe"}, io.popen, "echo hello"))
+--> ~error\t.*: safeio: operation not allowed
+
+-- Process files can still be used
+
+local f = io.popen("echo hello")
+print(runtime.callcontext({flags="execsafe"}, function()
+    local line = f:read("l")
+    return line, f:close()
+end))
+--> =done	hello	true	exit	0
+
+-- When iosafe is on, io.popen cannot be called as the process could do IO
+
+print(runtime.callcontext({flags="iosafe"}, pcall, io.popen, "echo hello"))
+--> ~done\tfalse\t.*: missing flags: iosafe
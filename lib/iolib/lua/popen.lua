@@ -0,0 +1,44 @@
+-- Reading from a process
+local f = io.popen("echo hello; echo world")
+print(io.type(f))
+--> =file
+print(f:read("l"))
+--> =hello
+print(f:read("a") == "world\n")
+--> =true
+print(f:close())
+--> =true	exit	0
+
+-- The default mode is "r"
+for line in io.popen("printf 'a\\nb\\n'", "r"):lines() do
+    print(line)
+end
+--> =a
+--> =b
+
+-- Exit status of a process
+print(io.popen("exit 3"):close())
+--> =nil	exit	3
+
+print(io.close(io.popen("kill -9 $$")))
+--> =nil	signal	9
+
+-- Writing to a process
+local f = io.popen("cat > files/popentest.txt", "w")
+f:write("to the ", "process")
+print(f:close())
+--> =true	exit	0
+
+print(io.open("files/popentest.txt"):read("a"))
+--> =to the process
+
+-- Closed process files
+print(f)
+--> =file (closed)
+
+print(pcall(f.close, f))
+--> ~false\t.*file already closed
+
+-- Invalid mode
+print(pcall(io.popen, "ls", "r+"))
+--> ~false\t.*invalid mode
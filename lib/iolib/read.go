@@ -89,6 +89,7 @@ func read(r *rt.Runtime, f *File, readers []formatReader, next rt.Cont) error {
 	if len(readers) == 0 {
 		readers = []formatReader{lineReader(false)}
 	}
+	defer f.interruptible(r)()
 	for i, reader := range readers {
 		val, readErr := reader(f)
 		if readErr == nil {
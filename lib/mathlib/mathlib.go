@@ -5,7 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
-	"math/rand"
+	"math/bits"
 
 	"github.com/arnodel/golua/lib/packagelib"
 	rt "github.com/arnodel/golua/runtime"
@@ -23,9 +23,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnv(pkg, "mininteger", rt.IntValue(math.MinInt64))
 	r.SetEnv(pkg, "pi", rt.FloatValue(math.Pi))
 
-	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
-
+	restartable := []*rt.GoFunction{
 		r.SetEnvGoFunc(pkg, "abs", abs, 1, false),
 		r.SetEnvGoFunc(pkg, "acos", acos, 1, false),
 		r.SetEnvGoFunc(pkg, "asin", asin, 1, false),
@@ -37,8 +35,6 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "floor", floor, 1, false),
 		r.SetEnvGoFunc(pkg, "fmod", fmod, 2, false),
 		r.SetEnvGoFunc(pkg, "log", log, 2, false),
-		r.SetEnvGoFunc(pkg, "max", max, 1, true),
-		r.SetEnvGoFunc(pkg, "min", min, 1, true),
 		r.SetEnvGoFunc(pkg, "modf", modf, 1, false),
 		r.SetEnvGoFunc(pkg, "rad", rad, 1, false),
 		r.SetEnvGoFunc(pkg, "random", random, 2, false),
@@ -49,6 +45,15 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "tointeger", tointeger, 1, false),
 		r.SetEnvGoFunc(pkg, "type", typef, 1, false),
 		r.SetEnvGoFunc(pkg, "ult", ult, 2, false),
+	}
+	rt.SolemnlyDeclareRestartable(restartable...)
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
+
+		append(restartable,
+			r.SetEnvGoFunc(pkg, "max", max, 1, true),
+			r.SetEnvGoFunc(pkg, "min", min, 1, true),
+		)...,
 	)
 
 	return rt.TableValue(pkg), nil
@@ -307,8 +312,11 @@ func rad(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext1(t.Runtime, y), nil
 }
 
-// TODO: have a per runtime random generator
+// random implements math.random the same way as Lua 5.4, so that it returns the
+// same numbers for the same seed when the runtime uses a Xoshiro256 source.
 func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	src := t.RandSource()
+	rv := src.Uint64()
 	var (
 		err error
 		m   int64 = 1
@@ -316,12 +324,12 @@ func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	)
 	switch c.NArgs() {
 	case 0:
-		return c.PushingNext1(t.Runtime, rt.FloatValue(rand.Float64())), nil
+		return c.PushingNext1(t.Runtime, rt.FloatValue(randFloat(rv))), nil
 	case 1:
 		n, err = c.IntArg(0)
 		// Special case, new in Lua 5.4: math.random(0) returns a uniform integer.
-		if n == 0 {
-			return c.PushingNext1(t.Runtime, rt.IntValue(int64(rand.Uint64()))), nil
+		if err == nil && n == 0 {
+			return c.PushingNext1(t.Runtime, rt.IntValue(int64(rv))), nil
 		}
 	case 2:
 		m, err = c.IntArg(0)
@@ -335,54 +343,65 @@ func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if m > n {
 		return nil, errors.New("#2 must be >= #1")
 	}
-	var r int64
-	if m <= 0 && m+math.MaxInt64 < n {
-		// There's >= 50% chance the loop stops at each iteration so we're OK!
-		for {
-			r = int64(rand.Uint64())
-			if r >= m && r <= n {
-				break
-			}
-		}
-	} else if m+math.MaxInt64 == n {
-		r = rand.Int63()
-	} else {
-		r = rand.Int63n(n - m + 1)
-	}
-	return c.PushingNext1(t.Runtime, rt.IntValue(m+r)), nil
+	r := project(src, rv, uint64(n)-uint64(m))
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r+uint64(m)))), nil
+}
+
+// randFloat converts a random integer to a float in [0, 1) using its 53 highest
+// bits.
+func randFloat(rv uint64) float64 {
+	return float64(rv>>11) * (1.0 / (1 << 53))
+}
+
+// project returns a random integer in [0, n], starting from the random integer
+// rv and drawing more integers from src if needed.
+func project(src rt.RandSource, rv uint64, n uint64) uint64 {
+	if n&(n+1) == 0 {
+		// n + 1 is a power of 2
+		return rv & n
+	}
+	// The smallest 2^b - 1 not smaller than n
+	lim := uint64(1)<<bits.Len64(n) - 1
+	for rv &= lim; rv > n; rv &= lim {
+		rv = src.Uint64()
+	}
+	return rv
 }
 
 func randomseed(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
-		seed int64
-		err  error
+		seed1, seed2 int64
+		err          error
 	)
 	switch c.NArgs() {
 	case 0:
+		if t.Deterministic() {
+			// The seed must not depend on anything outside the runtime.
+			src := t.RandSource()
+			seed1, seed2 = int64(src.Uint64()), int64(src.Uint64())
+			break
+		}
 		// We need something as random as possible to make a seed.
-		readErr := binary.Read(crypto.Reader, binary.LittleEndian, &seed)
+		var seeds [2]int64
+		readErr := binary.Read(crypto.Reader, binary.LittleEndian, &seeds)
 		if readErr != nil {
 			return nil, errors.New("unable to get random seed")
 		}
-	case 1:
-		seed, err = c.IntArg(0)
-		if err != nil {
-			return nil, err
-		}
-	case 2:
-		seed, err = c.IntArg(0)
+		seed1, seed2 = seeds[0], seeds[1]
+	default:
+		seed1, err = c.IntArg(0)
 		if err != nil {
 			return nil, err
 		}
-		seed2, err := c.IntArg(1)
-		if err != nil {
-			return nil, err
+		if c.NArgs() >= 2 {
+			seed2, err = c.IntArg(1)
+			if err != nil {
+				return nil, err
+			}
 		}
-		// In Go the seed is only 64 bits so we mangle the seeds
-		seed ^= seed2
 	}
-	rand.Seed(seed)
-	return c.PushingNext(t.Runtime, rt.IntValue(seed), rt.IntValue(0)), nil
+	t.RandSource().Seed(uint64(seed1), uint64(seed2))
+	return c.PushingNext(t.Runtime, rt.IntValue(seed1), rt.IntValue(seed2)), nil
 }
 
 func sin(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -0,0 +1,135 @@
+package mathlib_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const randomSource = `
+local t = {}
+for i = 1, 5 do
+	t[#t+1] = math.random(1000)
+end
+t[#t+1] = math.random(0)
+t[#t+1] = math.random()
+print(table.concat(t, " "))
+`
+
+func runRandom(t *testing.T, opts ...rt.RuntimeOption) string {
+	t.Helper()
+	var out bytes.Buffer
+	r := rt.New(&out, opts...)
+	defer r.Close(nil)
+	cleanup := lib.LoadAll(r)
+	defer cleanup()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(randomSource), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRandSeed(t *testing.T) {
+	out1 := runRandom(t, rt.WithRandSeed(42, 0))
+	out2 := runRandom(t, rt.WithRandSeed(42, 0))
+	if out1 != out2 {
+		t.Errorf("same seed, different numbers:\n%s%s", out1, out2)
+	}
+	if out3 := runRandom(t, rt.WithRandSeed(43, 0)); out1 == out3 {
+		t.Errorf("different seeds, same numbers:\n%s", out1)
+	}
+	if out4 := runRandom(t); out1 == out4 {
+		t.Errorf("default seed is not random:\n%s", out1)
+	}
+}
+
+// A RandSource which always returns the same number.
+type constSource uint64
+
+func (s constSource) Uint64() uint64 { return uint64(s) }
+
+func (s constSource) Seed(n1, n2 uint64) {}
+
+func TestRandSource(t *testing.T) {
+	out := runRandom(t, rt.WithRandSource(constSource(1<<62+7)))
+	expected := "8 8 8 8 8 4611686018427387911 0.25\n"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestRandomRuntimesIndependent(t *testing.T) {
+	// Numbers drawn in one runtime do not affect the numbers drawn in another.
+	expected := runRandom(t, rt.WithRandSeed(1, 2))
+	var out1, out2 bytes.Buffer
+	r1 := rt.New(&out1, rt.WithRandSeed(1, 2))
+	defer r1.Close(nil)
+	defer lib.LoadAll(r1)()
+	r2 := rt.New(&out2, rt.WithRandSeed(1, 2))
+	defer r2.Close(nil)
+	defer lib.LoadAll(r2)()
+	for _, r := range []*rt.Runtime{r1, r2, r1} {
+		clos, err := r.CompileAndLoadLuaChunk("test", []byte(randomSource), rt.TableValue(r.GlobalEnv()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out2.String() != expected || out1.String()[:len(expected)] != expected {
+		t.Errorf("runtimes interfere:\n%s%s", out1.String(), out2.String())
+	}
+}
+
+const luaSeedSource = `
+math.randomseed(seed)
+local t = {}
+for i = 1, 3 do
+	t[#t+1] = string.format("%.17g", math.random())
+end
+for i = 1, 5 do
+	t[#t+1] = math.random(1, 100)
+end
+for i = 1, 3 do
+	t[#t+1] = math.random(-10, 10)
+end
+t[#t+1] = math.random(6)
+t[#t+1] = math.random(0)
+t[#t+1] = math.random(math.mininteger, math.maxinteger)
+print(table.concat(t, " "))
+`
+
+func TestRandomSameAsLua(t *testing.T) {
+	// The numbers Lua 5.4 returns after math.randomseed(seed), computed with the
+	// algorithms of its lmathlib.c (xoshiro256**, "project" for integer
+	// ranges and the 53 highest bits for floats).
+	for seed, expected := range map[int64]string{
+		0:  "0.2469118419609948 0.23482927841848023 0.069275939529084729 22 48 88 57 87 5 6 6 6 7307161717203809450 -509584769231359838\n",
+		42: "0.93081217803956817 0.45178389935924312 0.54688311243421495 86 54 64 7 25 -8 -10 -10 6 -1260109890143060121 7692127127180538080\n",
+		-7: "0.50186044324719181 0.43988754089507931 0.14072832343139086 38 75 30 10 70 -7 6 -9 1 5446074733380413988 -2502503409063460092\n",
+	} {
+		var out bytes.Buffer
+		r := rt.New(&out)
+		cleanup := lib.LoadAll(r)
+		r.GlobalEnv().Set(rt.StringValue("seed"), rt.IntValue(seed))
+		clos, err := r.CompileAndLoadLuaChunk("test", []byte(luaSeedSource), rt.TableValue(r.GlobalEnv()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+			t.Fatal(err)
+		}
+		cleanup()
+		r.Close(nil)
+		if out.String() != expected {
+			t.Errorf("seed %d: expected %q, got %q", seed, expected, out.String())
+		}
+	}
+}
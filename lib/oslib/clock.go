@@ -5,13 +5,11 @@ package oslib
 
 import (
 	"syscall"
-
-	rt "github.com/arnodel/golua/runtime"
 )
 
-func clock(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+// cpuTime returns the CPU time used by the program in seconds.
+func cpuTime() float64 {
 	var rusage syscall.Rusage
 	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &rusage) // ignore errors
-	time := float64(rusage.Utime.Sec+rusage.Stime.Sec) + float64(rusage.Utime.Usec+rusage.Stime.Usec)/1000000.0
-	return c.PushingNext1(t.Runtime, rt.FloatValue(time)), nil
+	return float64(rusage.Utime.Sec+rusage.Stime.Sec) + float64(rusage.Utime.Usec+rusage.Stime.Usec)/1000000.0
 }
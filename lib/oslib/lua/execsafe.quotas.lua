@@ -0,0 +1,17 @@
+-- When execsafe is on, no shell is available
+
+print(runtime.callcontext({flags="execsafe"}, os.execute))
+--> =done	false
+
+print(runtime.callcontext({flags="execsafe"}, os.execute, "true"))
+--> ~error\t.*: safeio: operation not allowed
+
+-- But other os functions are still available
+
+print(runtime.callcontext({flags="execsafe"}, os.getenv, "GOLUA_NOT_SET"))
+--> =done	nil
+
+-- When iosafe is on, os.execute cannot be called as the process could do IO
+
+print(runtime.callcontext({flags="iosafe"}, pcall, os.execute, "true"))
+--> ~done\tfalse\t.*: missing flags: iosafe
@@ -0,0 +1,18 @@
+print(os.execute())
+--> =true
+
+print(os.execute("exit 0"))
+--> =true	exit	0
+
+print(os.execute("exit 5"))
+--> =nil	exit	5
+
+print(os.execute("kill -9 $$"))
+--> =nil	signal	9
+
+-- The output of the command goes to the runtime's output
+os.execute("echo hello from sh")
+--> =hello from sh
+
+print(pcall(os.execute, {}))
+--> ~false\t.*must be a string
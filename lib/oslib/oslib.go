@@ -21,17 +21,31 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	pkg := rt.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "clock", clock, 0, false),
 		r.SetEnvGoFunc(pkg, "date", date, 2, false),
 		r.SetEnvGoFunc(pkg, "difftime", difftime, 2, false),
 		r.SetEnvGoFunc(pkg, "time", timef, 1, false),
-		r.SetEnvGoFunc(pkg, "getenv", getenv, 1, false),
-		r.SetEnvGoFunc(pkg, "tmpname", tmpname, 0, false),
 		r.SetEnvGoFunc(pkg, "remove", remove, 1, false),
 		r.SetEnvGoFunc(pkg, "rename", rename, 2, false),
 	)
+	// These depend on the host environment so do not comply with
+	// ComplyDetSafe.
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe,
+
+		r.SetEnvGoFunc(pkg, "getenv", getenv, 1, false),
+		r.SetEnvGoFunc(pkg, "tmpname", tmpname, 0, false),
+	)
+	// This returns an error rather than spawn a process when ComplyExecSafe is
+	// required (see safeio.ShellCommand).  As the process can do IO, it does
+	// not comply with ComplyIoSafe.
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyExecSafe,
+
+		r.SetEnvGoFunc(pkg, "execute", execute, 1, false),
+	)
 	// These functions are not safe - I don't know what compliance category to
 	// put them in.
 	r.SetEnvGoFunc(pkg, "setlocale", setlocale, 2, false)
@@ -39,6 +53,16 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	return rt.TableValue(pkg), nil
 }
 
+func clock(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	var secs float64
+	if t.Deterministic() {
+		secs = (time.Duration(t.CPUTicks()) * rt.VirtualTickDuration).Seconds()
+	} else {
+		secs = cpuTime()
+	}
+	return c.PushingNext1(t.Runtime, rt.FloatValue(secs)), nil
+}
+
 func date(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
 		err    error
@@ -70,10 +94,12 @@ func date(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		}
 		now = time.Unix(t, 0)
 	} else {
-		now = time.Now()
+		now = t.Now()
 	}
 	if utc {
 		now = now.UTC()
+	} else {
+		now = now.In(location(t.Runtime))
 	}
 	switch format {
 	case "*t":
@@ -130,9 +156,36 @@ func exit(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return nil, nil
 }
 
+func execute(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if c.NArgs() == 0 || c.Arg(0).IsNil() {
+		return c.PushingNext1(t.Runtime, rt.BoolValue(safeio.ShellAvailable(t.Runtime))), nil
+	}
+	command, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	cmd, ioErr := safeio.ShellCommand(t.Runtime, command)
+	if ioErr != nil {
+		return t.ProcessIoError(c.Next(), ioErr)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, t.Stdout, os.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	what, code, ioErr := safeio.ExitStatus(cmd.Run())
+	if ioErr != nil {
+		return t.ProcessIoError(c.Next(), ioErr)
+	}
+	ok := rt.NilValue
+	if what == "exit" && code == 0 {
+		ok = rt.BoolValue(true)
+	}
+	return c.PushingNext(t.Runtime, ok, rt.StringValue(what), rt.IntValue(int64(code))), nil
+}
+
 func timef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if c.NArgs() == 0 {
-		now := time.Now().Unix()
+		now := t.Now().Unix()
 		return c.PushingNext1(t.Runtime, rt.IntValue(now)), nil
 	}
 	tbl, err := c.TableArg(0)
@@ -179,7 +232,7 @@ func timef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	}
 	// TODO: deal with DST - I have no idea how to do that.
 
-	date := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+	date := time.Date(year, time.Month(month), day, hour, min, sec, 0, location(t.Runtime))
 	setTableFields(t.Runtime, tbl, date)
 	return c.PushingNext1(t.Runtime, rt.IntValue(date.Unix())), nil
 }
@@ -265,6 +318,15 @@ func rename(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 // Utils
 //
 
+// location returns the location of local times, which is UTC if the runtime is
+// deterministic as the host's time zone may vary.
+func location(r *rt.Runtime) *time.Location {
+	if r.Deterministic() {
+		return time.UTC
+	}
+	return time.Local
+}
+
 func setTableFields(r *rt.Runtime, tbl *rt.Table, now time.Time) {
 	r.SetEnv(tbl, "year", rt.IntValue(int64(now.Year())))
 	r.SetEnv(tbl, "month", rt.IntValue(int64(now.Month())))
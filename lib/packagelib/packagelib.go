@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/safeio"
 )
 
 var (
@@ -199,7 +200,7 @@ func searchpath(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 	conf.dirSep = string(rep)
-	found, templates := searchPath(string(name), string(path), string(sep), &conf)
+	found, templates := searchPath(t.Runtime, string(name), string(path), string(sep), &conf)
 	next := c.Next()
 	if found != "" {
 		t.Push1(next, rt.StringValue(found))
@@ -209,14 +210,14 @@ func searchpath(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return next, nil
 }
 
-func searchPath(name, path, dot string, conf *config) (string, []string) {
+func searchPath(r *rt.Runtime, name, path, dot string, conf *config) (string, []string) {
 	namePath := strings.Replace(name, dot, conf.dirSep, -1)
 	templates := strings.Split(path, conf.pathSep)
 	for i, template := range templates {
 		searchpath := strings.Replace(template, conf.placeholder, namePath, -1)
-		f, err := os.Open(searchpath)
-		f.Close()
+		f, err := safeio.OpenFile(r, searchpath, os.O_RDONLY, 0)
 		if err == nil {
+			f.Close()
 			return searchpath, nil
 		}
 		templates[i] = searchpath
@@ -250,7 +251,7 @@ func searchLua(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, errors.New("package.path must be a string")
 	}
 	conf := getConfig(pkg)
-	found, templates := searchPath(string(s), string(path), ".", conf)
+	found, templates := searchPath(t.Runtime, string(s), string(path), ".", conf)
 	next := c.Next()
 	if found == "" {
 		t.Push1(next, rt.StringValue(strings.Join(templates, "\n")))
@@ -276,7 +277,7 @@ func loadLua(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err != nil {
 		return nil, err
 	}
-	src, readErr := ioutil.ReadFile(string(filePath))
+	src, readErr := readFile(t.Runtime, string(filePath))
 	if readErr != nil {
 		return nil, fmt.Errorf("error reading file: %s", readErr)
 	}
@@ -287,6 +288,16 @@ func loadLua(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return rt.Continue(t, rt.FunctionValue(clos), c.Next())
 }
 
+// readFile reads the named file from the runtime's filesystem.
+func readFile(r *rt.Runtime, name string) ([]byte, error) {
+	f, err := safeio.OpenFile(r, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
 func pkgTable(r *rt.Runtime) *rt.Table {
 	return r.Registry(pkgKey).AsTable()
 }
@@ -13,7 +13,7 @@ func createContextMetatable(r *rt.Runtime) {
 	contextMeta := rt.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(contextMeta, "__index", context__index, 2, false),
 		r.SetEnvGoFunc(contextMeta, "__tostring", context__tostring, 1, false),
@@ -21,7 +21,7 @@ func createContextMetatable(r *rt.Runtime) {
 
 	resourcesMeta := rt.NewTable()
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(resourcesMeta, "__index", resources__index, 2, false),
 		r.SetEnvGoFunc(resourcesMeta, "__tostring", resources__tostring, 1, false),
@@ -117,6 +117,10 @@ func context__index(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		val = rt.NilValue
 	case "flags":
 		val = rt.StringValue(strings.Join(ctx.RequiredFlags().Names(), " "))
+	case "gcpolicy":
+		if s := ctx.GCPolicy().String(); s != "" {
+			val = rt.StringValue(s)
+		}
 	case "due":
 		val = rt.BoolValue(ctx.Due())
 	case "killnow":
@@ -238,7 +242,7 @@ var (
 
 func init() {
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 		killnowGoF,
 		stopnowGoF,
 		dueGoF,
@@ -0,0 +1,32 @@
+-- Code running in a context with the "detsafe" flag is deterministic.
+
+-- The clock is virtual and advances with the CPU used.
+print(runtime.callcontext({flags="detsafe"}, function()
+    local t0, c0 = os.time(), os.clock()
+    for i = 1, 10000 do end
+    return os.date("!%Y-%m-%d", t0), os.clock() > c0
+end))
+--> =done	2000-01-01	true
+
+-- Time limits use the virtual clock.
+print(runtime.callcontext({flags="detsafe", kill={seconds=0.01}}, function()
+    while true do end
+end))
+--> =killed
+
+-- Tables only iterate in insertion order in deterministic runtimes, as it
+-- changes them for good.  Elsewhere next and pairs are not deterministic.
+print(runtime.callcontext({flags="detsafe"}, pairs, {}))
+--> ~error\t.*missing flags: detsafe
+
+print(runtime.callcontext({flags="detsafe"}, next, {}))
+--> ~error\t.*missing flags: detsafe
+
+-- Functions which are not deterministic cannot be called.
+print(runtime.callcontext({flags="detsafe"}, os.getenv, "HOME"))
+--> ~error\t.*missing flags: detsafe
+
+print(runtime.callcontext({flags="detsafe"}, function()
+    return runtime.context().flags
+end))
+--> =done	detsafe
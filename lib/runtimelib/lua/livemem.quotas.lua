@@ -0,0 +1,52 @@
+-- By default, the memory used in a context is the total amount of memory
+-- allocated.  With the "livemem" gcpolicy, the memory charged for tables,
+-- closures and coroutines is credited back when they are collected.
+
+local function garbage(n)
+    for i = 1, n do
+        local t = {i, i}
+        local f = function() return t end
+        local co = coroutine.create(f)
+    end
+end
+
+print(runtime.callcontext({kill={memory=100000}}, garbage, 10000))
+--> =killed
+
+local ctx = runtime.callcontext({kill={memory=100000}, gcpolicy="livemem"}, garbage, 10000)
+print(ctx, ctx.gcpolicy, ctx.used.memory < 100000)
+--> =done	livemem	true
+
+-- Live objects are still accounted for.
+print(runtime.callcontext({kill={memory=100000}, gcpolicy="livemem"}, function()
+    local t = {}
+    for i = 1, 10000 do
+        t[i] = {i}
+    end
+end))
+--> =killed
+
+-- Contexts nested in a "livemem" context credit memory back too.
+print(runtime.callcontext({kill={memory=100000}, gcpolicy="livemem"}, function()
+    for i = 1, 10 do
+        runtime.callcontext({kill={memory=50000}}, garbage, 1000)
+    end
+    return runtime.callcontext({kill={memory=50000}}, garbage, 10000)
+end))
+--> =done	done
+
+-- Memory charged in a nested context is credited to the parent context once the
+-- nested context is over.
+print(runtime.callcontext({kill={memory=100000}, gcpolicy="livemem"}, function()
+    local t
+    for i = 1, 100 do
+        runtime.callcontext({}, function() t = {i, i, i, i} end)
+    end
+    garbage(10000)
+    return t[1]
+end))
+--> =done	100
+
+-- The gcpolicy must be valid
+print(pcall(runtime.callcontext, {gcpolicy="foo"}, print))
+--> ~false\t.*unknown gcpolicy: "foo"
@@ -21,7 +21,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	pkg := rt.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "callcontext", callcontext, 2, true),
 		r.SetEnvGoFunc(pkg, "context", context, 0, false),
@@ -49,11 +49,13 @@ func callcontext(t *rt.Thread, c *rt.GoCont) (next rt.Cont, retErr error) {
 		flagsV      = quotas.Get(rt.StringValue("flags"))
 		limitsV     = quotas.Get(rt.StringValue("kill"))
 		softLimitsV = quotas.Get(rt.StringValue("stop"))
+		gcPolicyV   = quotas.Get(rt.StringValue("gcpolicy"))
 		hardLimits  rt.RuntimeResources
 		softLimits  rt.RuntimeResources
 		f           = c.Arg(1)
 		fArgs       = c.Etc()
 		flags       rt.ComplianceFlags
+		gcPolicy    rt.GCPolicy
 	)
 	if !limitsV.IsNil() {
 		var err error
@@ -81,6 +83,16 @@ func callcontext(t *rt.Thread, c *rt.GoCont) (next rt.Cont, retErr error) {
 			}
 		}
 	}
+	if !gcPolicyV.IsNil() {
+		name, ok := gcPolicyV.TryString()
+		if !ok {
+			return nil, errors.New("gcpolicy must be a string")
+		}
+		gcPolicy, ok = rt.GCPolicyWithName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown gcpolicy: %q", name)
+		}
+	}
 
 	next = c.Next()
 	res := rt.NewTerminationWith(c, 0, true)
@@ -89,6 +101,7 @@ func callcontext(t *rt.Thread, c *rt.GoCont) (next rt.Cont, retErr error) {
 		HardLimits:    hardLimits,
 		SoftLimits:    softLimits,
 		RequiredFlags: flags,
+		GCPolicy:      gcPolicy,
 	}, func() error {
 		return rt.Call(t, f, fArgs, res)
 	})
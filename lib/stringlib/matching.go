@@ -191,7 +191,8 @@ func gmatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return next, nil
 	}
 	iterGof := rt.NewGoFunction(iterator, "gmatchiterator", 0, false)
-	iterGof.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe)
+	iterGof.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe | rt.ComplyExecSafe | rt.ComplyDetSafe)
+	iterGof.SolemnlyDeclareRestartable()
 	return c.PushingNext(t.Runtime, rt.FunctionValue(iterGof)), nil
 }
 
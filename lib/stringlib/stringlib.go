@@ -20,15 +20,11 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	pkg := rt.NewTable()
 	pkgVal := rt.TableValue(pkg)
 
-	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
-
+	restartable := []*rt.GoFunction{
 		r.SetEnvGoFunc(pkg, "byte", bytef, 3, false),
 		r.SetEnvGoFunc(pkg, "char", char, 0, true),
-		r.SetEnvGoFunc(pkg, "dump", dump, 2, false),
 		r.SetEnvGoFunc(pkg, "find", find, 4, false),
 		r.SetEnvGoFunc(pkg, "gmatch", gmatch, 3, false),
-		r.SetEnvGoFunc(pkg, "gsub", gsub, 4, false),
 		r.SetEnvGoFunc(pkg, "len", lenf, 1, false),
 		r.SetEnvGoFunc(pkg, "lower", lower, 1, false),
 		r.SetEnvGoFunc(pkg, "match", match, 3, false),
@@ -36,17 +32,26 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "rep", rep, 3, false),
 		r.SetEnvGoFunc(pkg, "reverse", reverse, 1, false),
 		r.SetEnvGoFunc(pkg, "sub", sub, 3, false),
-		r.SetEnvGoFunc(pkg, "format", format, 1, true),
 		r.SetEnvGoFunc(pkg, "pack", pack, 1, true),
 		r.SetEnvGoFunc(pkg, "packsize", packsize, 1, false),
 		r.SetEnvGoFunc(pkg, "unpack", unpack, 3, false),
+	}
+	rt.SolemnlyDeclareRestartable(restartable...)
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
+
+		append(restartable,
+			r.SetEnvGoFunc(pkg, "dump", dump, 2, false),
+			r.SetEnvGoFunc(pkg, "gsub", gsub, 4, false),
+			r.SetEnvGoFunc(pkg, "format", format, 1, true),
+		)...,
 	)
 
 	stringMeta := rt.NewTable()
 	r.SetEnv(stringMeta, "__index", pkgVal)
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(stringMeta, "__add", string__add, 2, false),
 		r.SetEnvGoFunc(stringMeta, "__sub", string__sub, 2, false),
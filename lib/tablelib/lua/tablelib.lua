@@ -135,6 +135,50 @@ do
     --> ~false\t.*interval too large
 end
 
+do
+    local t = table.ordered()
+    for _, k in ipairs{"z", "y", 1, "x", 2.0, true, 3.5, "w", 2} do
+        t[k] = tostring(k)
+    end
+    t.y = nil
+    t.x = "X"
+    t.y = "Y"
+    local s = {}
+    for k, v in pairs(t) do
+        s[#s+1] = tostring(k) .. "=" .. v
+    end
+    print(table.concat(s, " "))
+    --> =z=z 1=1 x=X 2=2 true=true 3.5=3.5 w=w y=Y
+
+    print(#t, rawlen(t), t[2.0], next(t), next(t, "w"), next(t, "y"))
+    --> =2	2	2	z	y	nil
+
+    print(pcall(next, t, "foo"))
+    --> ~false\t.*invalid key for 'next'
+
+    -- Removing keys during traversal is allowed
+    for k in pairs(t) do
+        t[k] = nil
+    end
+    print(next(t), #t)
+    --> =nil	0
+
+    -- Ordered tables work as sequences
+    for i = 1, 10 do
+        table.insert(t, i)
+    end
+    table.remove(t, 1)
+    print(#t, table.concat(t, ","))
+    --> =9	2,3,4,5,6,7,8,9,10
+
+    -- They cannot be weak
+    setmetatable(t, {__mode="k"})
+    t[{}] = 1
+    collectgarbage()
+    print(#t, next(t, 10) ~= nil)
+    --> =9	true
+end
+
 do
     local t = table.pack(3, 2, 1, 4, 5)
     print(t.n, #t)
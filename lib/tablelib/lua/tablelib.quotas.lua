@@ -89,6 +89,36 @@ do
     --> =killed
 end
 
+-- table.ordered
+do
+    -- ordered tables consume memory for each key
+
+    local function fill(n)
+        local t = table.ordered()
+        for i = 1, n do
+            t["x" .. i] = i
+        end
+    end
+
+    print(runtime.callcontext({kill={memory=10000}}, fill, 10))
+    --> =done
+
+    print(runtime.callcontext({kill={memory=10000}}, fill, 1000))
+    --> =killed
+
+    -- setting existing keys does not consume memory
+    local t = table.ordered()
+    for i = 1, 100 do
+        t["x" .. i] = i
+    end
+    print(runtime.callcontext({kill={memory=1000}}, function()
+        for i = 1, 100 do
+            t["x" .. i] = i + 1
+        end
+    end))
+    --> =done
+end
+
 -- table.pack
 do
     --table.pack consumes memory
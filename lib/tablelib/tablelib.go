@@ -22,11 +22,12 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	pkg := rt.NewTable()
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "concat", concat, 4, false),
 		r.SetEnvGoFunc(pkg, "insert", insert, 3, false),
 		r.SetEnvGoFunc(pkg, "move", move, 5, false),
+		r.SetEnvGoFunc(pkg, "ordered", ordered, 0, false),
 		r.SetEnvGoFunc(pkg, "pack", pack, 0, true),
 		r.SetEnvGoFunc(pkg, "remove", remove, 2, false),
 		r.SetEnvGoFunc(pkg, "sort", sortf, 2, false),
@@ -256,6 +257,12 @@ func move(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext1(t.Runtime, dstVal), nil
 }
 
+// ordered returns a new empty table which iterates over its keys in the order
+// they were inserted.
+func ordered(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	return c.PushingNext1(t.Runtime, rt.TableValue(rt.NewOrderedTable())), nil
+}
+
 func pack(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	tbl := rt.NewTable()
 	// We can use t.SetTable() because tbl has no metatable
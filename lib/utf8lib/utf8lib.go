@@ -22,7 +22,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 	r.SetEnv(pkg, "charpattern", rt.StringValue("[\x00-\x7F\xC2-\xFD][\x80-\xBF]*"))
 
 	rt.SolemnlyDeclareCompliance(
-		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe|rt.ComplyExecSafe|rt.ComplyDetSafe,
 
 		r.SetEnvGoFunc(pkg, "char", char, 0, true),
 		r.SetEnvGoFunc(pkg, "codes", codes, 2, false),
@@ -98,7 +98,7 @@ func codes(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return next, nil
 	}
 	var iter = rt.NewGoFunction(iterF, "codesiterator", 0, false)
-	iter.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe)
+	iter.SolemnlyDeclareCompliance(rt.ComplyCpuSafe | rt.ComplyMemSafe | rt.ComplyTimeSafe | rt.ComplyIoSafe | rt.ComplyExecSafe | rt.ComplyDetSafe)
 	return c.PushingNext1(t.Runtime, rt.FunctionValue(iter)), nil
 }
 
@@ -0,0 +1,89 @@
+package lib_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
+)
+
+func runWithFS(t *testing.T, fsys vfs.FS, source string) string {
+	t.Helper()
+	var out bytes.Buffer
+	r := rt.New(&out, rt.WithFS(fsys))
+	defer r.Close(nil)
+	cleanup := lib.LoadAll(r)
+	defer cleanup()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(source), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	fsys.WriteFile("lib/greet.lua", []byte(`return function(n) return "hello " .. n end`))
+	fsys.WriteFile("script.lua", []byte(`return 42`))
+	out := runWithFS(t, fsys, `
+package.path = "lib/?.lua"
+print(require("greet")("vfs"))
+print(dofile("script.lua"), loadfile("script.lua")())
+print(package.searchpath("greet", package.path))
+print(package.searchpath("nope", package.path))
+
+local f = io.open("out.txt", "w")
+f:write("line 1\n", "line 2\n")
+f:close()
+for l in io.lines("out.txt") do print(l) end
+print(os.rename("out.txt", "moved.txt"))
+print(io.open("out.txt"))
+print(os.remove("moved.txt"))
+print(os.remove("moved.txt"))
+
+local name = os.tmpname()
+print(io.open(name) ~= nil)
+`)
+	expected := `hello vfs
+42	42
+lib/greet.lua
+nil	tried: lib/nope.lua
+line 1
+line 2
+true
+nil	open out.txt: file does not exist	2
+true
+nil	remove moved.txt: file does not exist	2
+true
+`
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if _, err := fsys.ReadFile("out.txt"); err == nil {
+		t.Error("out.txt should have been renamed")
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys := vfs.FromFS(fstest.MapFS{
+		"data.txt": {Data: []byte("some data")},
+	})
+	out := runWithFS(t, fsys, `
+print(io.open("/data.txt"):read("a"))
+print(io.open("data.txt", "w"))
+print(os.remove("data.txt"))
+`)
+	expected := `some data
+nil	open data.txt: permission denied	13
+nil	remove data.txt: permission denied	13
+`
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,341 @@
+package luaconv
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Decode stores the Lua value v into the Go value pointed to by out, which must
+// be a non-nil pointer.  See the package documentation for how Lua values map to
+// Go values.
+//
+// Lua values are not converted implicitly, e.g. a Lua string is not decoded
+// into a Go int.  Keys of Lua tables which do not match a struct field are
+// ignored, and struct fields with no matching key are left untouched.  A Lua
+// float can be decoded into a Go integer if it has an exact integer
+// representation which fits.
+//
+// Tables are read without invoking metamethods.  It is an error for a table to
+// contain itself.
+func Decode(v rt.Value, out interface{}) error {
+	p := reflect.ValueOf(out)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("cannot decode into %T: a non-nil pointer is needed", out)
+	}
+	d := decoder{visiting: map[*rt.Table]bool{}}
+	return asError(d.decode(v, p.Elem()))
+}
+
+type decoder struct {
+	visiting map[*rt.Table]bool // Tables being decoded
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+
+	errCycle = errors.New("table contains itself")
+)
+
+func (d *decoder) decode(v rt.Value, dst reflect.Value) error {
+	tp := dst.Type()
+	if tp == valueType {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+	// Userdata which contain a Go value of the right type are decoded to that
+	// value.
+	if u, ok := v.TryUserData(); ok {
+		if x := reflect.ValueOf(u.Value()); x.IsValid() && x.Type().AssignableTo(tp) {
+			dst.Set(x)
+			return nil
+		}
+	}
+	if tp.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			dst.Set(reflect.Zero(tp))
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(tp.Elem()))
+		}
+		return d.decode(v, dst.Elem())
+	}
+	if reflect.PtrTo(tp).Implements(textUnmarshalerType) && tp.Kind() != reflect.Interface {
+		if tp == timeType {
+			if n, ok := numberToFloat(v); ok {
+				sec, frac := math.Modf(n)
+				dst.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9)).UTC()))
+				return nil
+			}
+		}
+		s, ok := v.TryString()
+		if !ok {
+			return typeError(v, "string")
+		}
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		b, ok := v.TryBool()
+		if !ok {
+			return typeError(v, "boolean")
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt(v)
+		if err == nil && dst.OverflowInt(n) {
+			err = fmt.Errorf("%d overflows %s", n, tp)
+		}
+		if err != nil {
+			return err
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := toInt(v)
+		if err == nil && (n < 0 || dst.OverflowUint(uint64(n))) {
+			err = fmt.Errorf("%d overflows %s", n, tp)
+		}
+		if err != nil {
+			return err
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, ok := numberToFloat(v)
+		if !ok {
+			return typeError(v, "number")
+		}
+		if dst.OverflowFloat(f) {
+			return fmt.Errorf("%g overflows %s", f, tp)
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		s, ok := v.TryString()
+		if !ok {
+			return typeError(v, "string")
+		}
+		dst.SetString(s)
+	case reflect.Interface:
+		return d.decodeInterface(v, dst)
+	case reflect.Struct:
+		return d.withTable(v, func(t *rt.Table) error {
+			return d.decodeStruct(t, dst)
+		})
+	case reflect.Map:
+		if v.IsNil() {
+			dst.Set(reflect.Zero(tp))
+			return nil
+		}
+		return d.withTable(v, func(t *rt.Table) error {
+			return d.decodeMap(t, dst)
+		})
+	case reflect.Slice:
+		if v.IsNil() {
+			dst.Set(reflect.Zero(tp))
+			return nil
+		}
+		if tp.Elem().Kind() == reflect.Uint8 {
+			if s, ok := v.TryString(); ok {
+				dst.SetBytes([]byte(s))
+				return nil
+			}
+		}
+		return d.withTable(v, func(t *rt.Table) error {
+			n := int(t.Len())
+			if dst.Cap() < n {
+				dst.Set(reflect.MakeSlice(tp, n, n))
+			} else {
+				dst.SetLen(n)
+			}
+			return d.decodeSeq(t, dst)
+		})
+	case reflect.Array:
+		return d.withTable(v, func(t *rt.Table) error {
+			if n := t.Len(); n > int64(dst.Len()) {
+				return fmt.Errorf("sequence of length %d does not fit in %s", n, tp)
+			}
+			return d.decodeSeq(t, dst)
+		})
+	default:
+		return fmt.Errorf("cannot decode into value of type %s", tp)
+	}
+	return nil
+}
+
+// withTable calls f with the table v, or returns an error if v is not a table
+// or if it is already being decoded.
+func (d *decoder) withTable(v rt.Value, f func(t *rt.Table) error) error {
+	t, ok := v.TryTable()
+	if !ok {
+		return typeError(v, "table")
+	}
+	if d.visiting[t] {
+		return errCycle
+	}
+	d.visiting[t] = true
+	defer delete(d.visiting, t)
+	return f(t)
+}
+
+func (d *decoder) decodeStruct(t *rt.Table, dst reflect.Value) error {
+	for _, f := range structFields(dst.Type()) {
+		v := t.Get(rt.StringValue(f.name))
+		if v.IsNil() {
+			continue
+		}
+		if err := d.decode(v, fieldByIndex(dst, f.index, true)); err != nil {
+			return wrapPath(err, "."+f.name)
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeMap(t *rt.Table, dst reflect.Value) error {
+	tp := dst.Type()
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMap(tp))
+	}
+	for k, v, ok := t.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = t.Next(k) {
+		gk := reflect.New(tp.Key()).Elem()
+		if err := d.decode(k, gk); err != nil {
+			return wrapPath(fmt.Errorf("invalid key: %w", err), keySegment(k))
+		}
+		gv := reflect.New(tp.Elem()).Elem()
+		if err := d.decode(v, gv); err != nil {
+			return wrapPath(err, keySegment(k))
+		}
+		dst.SetMapIndex(gk, gv)
+	}
+	return nil
+}
+
+func (d *decoder) decodeSeq(t *rt.Table, dst reflect.Value) error {
+	for i := 0; i < dst.Len(); i++ {
+		if err := d.decode(t.Get(rt.IntValue(int64(i+1))), dst.Index(i)); err != nil {
+			return wrapPath(err, fmt.Sprintf("[%d]", i+1))
+		}
+	}
+	return nil
+}
+
+// decodeInterface decodes v into an interface value.  If the interface is not
+// empty, v must be a userdata containing a Go value which implements it.
+// Otherwise, Lua values are decoded to the following Go types:
+//
+//   - nil to nil
+//   - booleans to bool
+//   - integers to int64 and floats to float64
+//   - strings to string
+//   - sequences to []interface{}
+//   - other tables to map[string]interface{} if all their keys are strings,
+//     map[interface{}]interface{} otherwise
+//   - other values to rt.Value
+func (d *decoder) decodeInterface(v rt.Value, dst reflect.Value) error {
+	if dst.NumMethod() > 0 {
+		return typeError(v, dst.Type().String())
+	}
+	x, err := d.toInterface(v)
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+	} else {
+		dst.Set(reflect.ValueOf(x))
+	}
+	return nil
+}
+
+func (d *decoder) toInterface(v rt.Value) (interface{}, error) {
+	switch x := v.Interface().(type) {
+	case nil, bool, int64, float64, string:
+		return x, nil
+	case *rt.Table:
+		var res interface{}
+		err := d.withTable(v, func(t *rt.Table) (err error) {
+			res, err = d.tableToInterface(t)
+			return
+		})
+		return res, err
+	default:
+		return v, nil
+	}
+}
+
+func (d *decoder) tableToInterface(t *rt.Table) (interface{}, error) {
+	n := t.Len()
+	count := int64(0)
+	stringKeys := true
+	for k, _, ok := t.Next(rt.NilValue); ok && !k.IsNil(); k, _, ok = t.Next(k) {
+		count++
+		if _, ok := k.TryString(); !ok {
+			stringKeys = false
+		}
+	}
+	switch {
+	case n > 0 && n == count:
+		seq := make([]interface{}, n)
+		for i := range seq {
+			x, err := d.toInterface(t.Get(rt.IntValue(int64(i + 1))))
+			if err != nil {
+				return nil, wrapPath(err, fmt.Sprintf("[%d]", i+1))
+			}
+			seq[i] = x
+		}
+		return seq, nil
+	case stringKeys:
+		m := make(map[string]interface{}, count)
+		for k, v, ok := t.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = t.Next(k) {
+			x, err := d.toInterface(v)
+			if err != nil {
+				return nil, wrapPath(err, keySegment(k))
+			}
+			m[k.AsString()] = x
+		}
+		return m, nil
+	default:
+		m := make(map[interface{}]interface{}, count)
+		for k, v, ok := t.Next(rt.NilValue); ok && !k.IsNil(); k, v, ok = t.Next(k) {
+			gk, err := d.toInterface(k)
+			if err == nil {
+				var x interface{}
+				x, err = d.toInterface(v)
+				m[gk] = x
+			}
+			if err != nil {
+				return nil, wrapPath(err, keySegment(k))
+			}
+		}
+		return m, nil
+	}
+}
+
+// toInt converts a Lua number with an exact integer representation to an
+// int64.
+func toInt(v rt.Value) (int64, error) {
+	switch x := v.Interface().(type) {
+	case int64:
+		return x, nil
+	case float64:
+		if n, tp := rt.FloatToInt(x); tp == rt.IsInt {
+			return n, nil
+		}
+		return 0, fmt.Errorf("%g has no integer representation", x)
+	}
+	return 0, typeError(v, "integer")
+}
+
+func numberToFloat(v rt.Value) (float64, bool) {
+	switch x := v.Interface().(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
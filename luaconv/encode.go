@@ -0,0 +1,212 @@
+// Package luaconv converts between Go values and Lua values, in the spirit of
+// encoding/json.
+//
+// Go structs map to Lua tables whose keys are the names of the exported fields.
+// As in encoding/json, the key can be changed with a struct tag, fields tagged
+// "-" are ignored and fields with the "omitempty" option are not encoded when
+// they have a zero value:
+//
+//	type Config struct {
+//		Host    string        `lua:"host"`
+//		Port    int           `lua:"port,omitempty"`
+//		Started time.Time     `lua:"started"`
+//		Secret  string        `lua:"-"`
+//	}
+//
+// Other values are mapped as follows.
+//
+//   - Booleans, strings and numbers map to Lua booleans, strings and numbers.
+//     Integer types map to Lua integers, with range checks.
+//   - Maps map to Lua tables.
+//   - Slices and arrays map to Lua sequences.  []byte maps to a Lua string.
+//   - Pointers and interfaces map to the value they point to, nil maps to nil.
+//   - Types implementing encoding.TextMarshaler and
+//     encoding.TextUnmarshaler map to Lua strings.  In particular time.Time
+//     maps to a RFC 3339 string, but it can also be decoded from a number of
+//     seconds since the Unix epoch.
+//   - runtime.Value maps to itself.
+package luaconv
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Encode converts the Go value x to a Lua value.  Memory used by new tables is
+// accounted for in r.
+//
+// It returns an error if x contains a value which cannot be converted (e.g. a
+// function or a channel), or if it contains a cycle.
+func Encode(r *rt.Runtime, x interface{}) (rt.Value, error) {
+	e := encoder{r: r, visiting: map[visitKey]bool{}}
+	v, err := e.encode(reflect.ValueOf(x))
+	return v, asError(err)
+}
+
+type encoder struct {
+	r        *rt.Runtime
+	visiting map[visitKey]bool // Pointers, maps and slices being encoded
+}
+
+// Identifies a Go pointer, map or slice in order to detect cycles.
+type visitKey struct {
+	ptr uintptr
+	tp  reflect.Type
+	len int
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	valueType           = reflect.TypeOf(rt.Value{})
+)
+
+func (e *encoder) encode(v reflect.Value) (rt.Value, error) {
+	if !v.IsValid() {
+		return rt.NilValue, nil
+	}
+	tp := v.Type()
+	if tp == valueType {
+		return v.Interface().(rt.Value), nil
+	}
+	if tp.Implements(textMarshalerType) && !(tp.Kind() == reflect.Ptr && v.IsNil()) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return rt.NilValue, err
+		}
+		return rt.StringValue(string(b)), nil
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return rt.BoolValue(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rt.IntValue(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			return rt.NilValue, fmt.Errorf("%d overflows a Lua integer", n)
+		}
+		return rt.IntValue(int64(n)), nil
+	case reflect.Float32, reflect.Float64:
+		return rt.FloatValue(v.Float()), nil
+	case reflect.String:
+		return rt.StringValue(v.String()), nil
+	case reflect.Interface:
+		return e.encode(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		k, err := e.enter(v, 0)
+		if err != nil {
+			return rt.NilValue, err
+		}
+		defer delete(e.visiting, k)
+		return e.encode(v.Elem())
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		k, err := e.enter(v, 0)
+		if err != nil {
+			return rt.NilValue, err
+		}
+		defer delete(e.visiting, k)
+		return e.encodeMap(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			return rt.NilValue, nil
+		}
+		if tp.Elem().Kind() == reflect.Uint8 {
+			return rt.StringValue(string(v.Bytes())), nil
+		}
+		k, err := e.enter(v, v.Len())
+		if err != nil {
+			return rt.NilValue, err
+		}
+		defer delete(e.visiting, k)
+		return e.encodeSeq(v)
+	case reflect.Array:
+		return e.encodeSeq(v)
+	}
+	return rt.NilValue, fmt.Errorf("cannot encode value of type %s", tp)
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) (rt.Value, error) {
+	t := rt.NewTable()
+	for _, f := range structFields(v.Type()) {
+		fv := fieldByIndex(v, f.index, false)
+		if !fv.IsValid() || f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		lv, err := e.encode(fv)
+		if err != nil {
+			return rt.NilValue, wrapPath(err, "."+f.name)
+		}
+		e.r.SetTable(t, rt.StringValue(f.name), lv)
+	}
+	return rt.TableValue(t), nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) (rt.Value, error) {
+	t := rt.NewTable()
+	iter := v.MapRange()
+	for iter.Next() {
+		k, err := e.encode(iter.Key())
+		if err == nil && k.IsNil() {
+			err = errNilKey
+		}
+		if err != nil {
+			return rt.NilValue, wrapPath(err, fmt.Sprintf("[%v]", iter.Key()))
+		}
+		lv, err := e.encode(iter.Value())
+		if err != nil {
+			return rt.NilValue, wrapPath(err, keySegment(k))
+		}
+		if err := e.r.SetTableCheck(t, k, lv); err != nil {
+			return rt.NilValue, wrapPath(err, keySegment(k))
+		}
+	}
+	return rt.TableValue(t), nil
+}
+
+func (e *encoder) encodeSeq(v reflect.Value) (rt.Value, error) {
+	t := rt.NewTable()
+	for i := 0; i < v.Len(); i++ {
+		lv, err := e.encode(v.Index(i))
+		if err != nil {
+			return rt.NilValue, wrapPath(err, fmt.Sprintf("[%d]", i+1))
+		}
+		e.r.SetTable(t, rt.IntValue(int64(i+1)), lv)
+	}
+	return rt.TableValue(t), nil
+}
+
+// enter records that v is being encoded, returning an error if it is already
+// being encoded, which means there is a cycle.  Slices are identified by their
+// length as well, as a slice of another one may start at the same address.
+func (e *encoder) enter(v reflect.Value, n int) (visitKey, error) {
+	k := visitKey{ptr: v.Pointer(), tp: v.Type(), len: n}
+	if e.visiting[k] {
+		return k, fmt.Errorf("cycle detected in value of type %s", v.Type())
+	}
+	e.visiting[k] = true
+	return k, nil
+}
+
+// isEmpty reports whether v is a zero value for the omitempty option.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,75 @@
+package luaconv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arnodel/golua/luastrings"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Error is returned by Encode and Decode when they fail.  Path locates the
+// offending value within the value being converted, e.g. ".servers[2].port".
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", strings.TrimPrefix(e.Path, "."), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var errNilKey = errors.New("table index is nil")
+
+// asError makes sure err is an *Error, unless it is nil.
+func asError(err error) error {
+	var pe *Error
+	if err == nil || errors.As(err, &pe) {
+		return err
+	}
+	return &Error{Err: err}
+}
+
+// wrapPath adds a path segment in front of the path of err.
+func wrapPath(err error, segment string) error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		pe.Path = segment + pe.Path
+		return pe
+	}
+	return &Error{Path: segment, Err: err}
+}
+
+// keySegment returns a path segment for the Lua table key k.
+func keySegment(k rt.Value) string {
+	switch x := k.Interface().(type) {
+	case string:
+		return "." + x
+	case int64:
+		return fmt.Sprintf("[%d]", x)
+	case float64:
+		return fmt.Sprintf("[%g]", x)
+	case bool:
+		return fmt.Sprintf("[%t]", x)
+	default:
+		return "[" + k.TypeName() + "]"
+	}
+}
+
+// typeError returns an error for when the Lua value v cannot be decoded into a
+// Go value because it is not of the expected type.
+func typeError(v rt.Value, expected string) error {
+	got := v.TypeName()
+	if s, ok := v.TryString(); ok && len(s) <= 20 {
+		got = "string " + luastrings.Quote(s, '"')
+	}
+	return fmt.Errorf("expected %s, got %s", expected, got)
+}
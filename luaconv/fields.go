@@ -0,0 +1,115 @@
+package luaconv
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A field of a struct type, as mapped to a Lua table key.
+type field struct {
+	name      string // Key in the Lua table
+	index     []int  // For reflect.Value.FieldByIndex
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // reflect.Type => []field
+
+// structFields returns the fields of the struct type tp which map to Lua table
+// keys.  Like in encoding/json, the fields of embedded structs without a tag
+// are promoted, unless they are shadowed by a field with the same name at a
+// shallower depth.
+func structFields(tp reflect.Type) []field {
+	if fs, ok := fieldCache.Load(tp); ok {
+		return fs.([]field)
+	}
+	var fields []field
+	depths := map[string]int{}
+	var collect func(tp reflect.Type, index []int)
+	collect = func(tp reflect.Type, index []int) {
+		for i := 0; i < tp.NumField(); i++ {
+			sf := tp.Field(i)
+			tag, hasTag := sf.Tag.Lookup("lua")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := cut(tag, ",")
+			fIndex := append(index[:len(index):len(index)], i)
+			if sf.Anonymous && !hasTag {
+				ftp := sf.Type
+				if ftp.Kind() == reflect.Ptr {
+					ftp = ftp.Elem()
+				}
+				if ftp.Kind() == reflect.Struct {
+					if sf.PkgPath == "" {
+						collect(ftp, fIndex)
+					}
+					continue
+				}
+			}
+			if sf.PkgPath != "" {
+				// Unexported field
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			if d, ok := depths[name]; ok && d <= len(fIndex) {
+				continue
+			}
+			depths[name] = len(fIndex)
+			f := field{name: name, index: fIndex, omitEmpty: hasOption(opts, "omitempty")}
+			replaced := false
+			for j := range fields {
+				if fields[j].name == name {
+					fields[j] = f
+					replaced = true
+				}
+			}
+			if !replaced {
+				fields = append(fields, f)
+			}
+		}
+	}
+	collect(tp, nil)
+	fieldCache.Store(tp, fields)
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but returns an invalid value
+// instead of panicking when going through a nil embedded pointer.  If alloc is
+// true, nil embedded pointers are allocated instead.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func hasOption(opts, opt string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = cut(opts, ",")
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// cut is strings.Cut, which is not available in go 1.17.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
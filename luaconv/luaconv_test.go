@@ -0,0 +1,237 @@
+package luaconv
+
+import (
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+type Address struct {
+	Host string `lua:"host"`
+	Port uint16 `lua:"port,omitempty"`
+}
+
+type Base struct {
+	ID   int64  `lua:"id"`
+	Kind string `lua:"kind"`
+}
+
+type Config struct {
+	Base
+	Name     string            `lua:"name"`
+	Enabled  bool              `lua:"enabled"`
+	Ratio    float32           `lua:"ratio"`
+	Servers  []Address         `lua:"servers"`
+	Primary  *Address          `lua:"primary,omitempty"`
+	Labels   map[string]string `lua:"labels,omitempty"`
+	Weights  map[int]float64   `lua:"weights"`
+	Coords   [2]int8           `lua:"coords"`
+	Started  time.Time         `lua:"started"`
+	IP       net.IP            `lua:"ip"`
+	Data     []byte            `lua:"data"`
+	Extra    interface{}       `lua:"extra"`
+	Raw      rt.Value          `lua:"raw"`
+	Kind     string            `lua:"kind"` // Shadows Base.Kind
+	Secret   string            `lua:"-"`
+	Untagged int
+	private  int
+}
+
+func runLua(t *testing.T, src string) rt.Value {
+	r := rt.New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDecodeConfig(t *testing.T) {
+	v := runLua(t, `return {
+	id = 12, kind = "config",
+	name = "test", enabled = true, ratio = 0.5,
+	servers = {{host = "a", port = 80}, {host = "b"}},
+	primary = {host = "p", port = 8080},
+	labels = {env = "prod"},
+	weights = {[1] = 1.5, [2] = 2},
+	coords = {3, -4},
+	started = "2021-06-01T10:00:00Z",
+	ip = "10.0.0.1",
+	data = "bytes",
+	extra = {1, "two", {x = 3}},
+	raw = 42,
+	Secret = "no",
+	Untagged = 7.0,
+	unknown = "ignored",
+}`)
+	var cfg Config
+	if err := Decode(v, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{
+		Base:     Base{ID: 12},
+		Kind:     "config",
+		Name:     "test",
+		Enabled:  true,
+		Ratio:    0.5,
+		Servers:  []Address{{"a", 80}, {"b", 0}},
+		Primary:  &Address{"p", 8080},
+		Labels:   map[string]string{"env": "prod"},
+		Weights:  map[int]float64{1: 1.5, 2: 2},
+		Coords:   [2]int8{3, -4},
+		Started:  time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+		IP:       net.IPv4(10, 0, 0, 1),
+		Data:     []byte("bytes"),
+		Extra:    []interface{}{int64(1), "two", map[string]interface{}{"x": int64(3)}},
+		Raw:      rt.IntValue(42),
+		Untagged: 7,
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v", expected, cfg)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	r := rt.New(nil)
+	cfg := Config{
+		Base:    Base{ID: 1, Kind: "hidden"},
+		Kind:    "k",
+		Name:    "n",
+		Servers: []Address{{Host: "h"}},
+		Weights: map[int]float64{3: 0.25},
+		Coords:  [2]int8{-1, 1},
+		Started: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		IP:      net.IPv4(1, 2, 3, 4),
+		Extra:   map[string]interface{}{"a": true},
+		Raw:     rt.StringValue("raw"),
+		Secret:  "s",
+	}
+	v, err := Encode(r, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := v.AsTable()
+	for _, k := range []string{"primary", "labels", "Secret", "private"} {
+		if x := tbl.Get(rt.StringValue(k)); !x.IsNil() {
+			t.Errorf("%s should not be encoded, got %v", k, x)
+		}
+	}
+	if port := tbl.Get(rt.StringValue("servers")).AsTable().Get(rt.IntValue(1)).AsTable().Get(rt.StringValue("port")); !port.IsNil() {
+		t.Errorf("empty port should be omitted")
+	}
+	var cfg2 Config
+	if err := Decode(v, &cfg2); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Base.Kind = ""
+	cfg.Secret = ""
+	if !reflect.DeepEqual(cfg, cfg2) {
+		t.Errorf("expected\n%+v\ngot\n%+v", cfg, cfg2)
+	}
+}
+
+func TestDecodeTimeFromNumber(t *testing.T) {
+	var tm time.Time
+	if err := Decode(rt.FloatValue(1.5), &tm); err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Unix(1, 5e8).UTC(); !tm.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, tm)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		out  interface{}
+		err  string
+	}{
+		{"type mismatch", `return {servers = {{host = "a"}, {host = 1}}}`, new(Config), `servers[2].host: expected string, got number`},
+		{"string for int", `return {id = "12"}`, new(Config), `id: expected integer, got string "12"`},
+		{"float for int", `return {id = 1.5}`, new(Config), `id: 1.5 has no integer representation`},
+		{"overflow", `return {coords = {1, 200}}`, new(Config), `coords[2]: 200 overflows int8`},
+		{"negative uint", `return {primary = {port = -1}}`, new(Config), `primary.port: -1 overflows uint16`},
+		{"array too long", `return {coords = {1, 2, 3}}`, new(Config), `coords: sequence of length 3 does not fit in [2]int8`},
+		{"bad key", `return {weights = {x = 1}}`, new(Config), `weights.x: invalid key: expected integer, got string "x"`},
+		{"bad time", `return {started = "yesterday"}`, new(Config), `started: parsing time`},
+		{"not a table", `return 42`, new(Config), `expected table, got number`},
+		{"cycle", `local t = {}; t.next = t; return t`, new(map[string]interface{}), `next: table contains itself`},
+		{"nested cycle", `local a = {}; a.servers = {a}; return a`, new(struct {
+			Servers []map[string]interface{} `lua:"servers"`
+		}), `servers[1]: table contains itself`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Decode(runLua(t, test.src), test.out)
+			var pe *Error
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+	if err := Decode(rt.NilValue, Config{}); err == nil {
+		t.Error("expected an error decoding into a non-pointer")
+	}
+}
+
+type node struct {
+	Value int   `lua:"value"`
+	Next  *node `lua:"next"`
+}
+
+func TestEncodeErrors(t *testing.T) {
+	cyclic := &node{Value: 1}
+	cyclic.Next = &node{Value: 2, Next: cyclic}
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	tests := []struct {
+		name string
+		x    interface{}
+		err  string
+	}{
+		{"func", struct{ F func() }{}, "F: cannot encode value of type func()"},
+		{"chan", map[string]interface{}{"c": make(chan int)}, "c: cannot encode value of type chan int"},
+		{"uint overflow", []uint64{1, math.MaxUint64}, "[2]: 18446744073709551615 overflows a Lua integer"},
+		{"cyclic pointers", cyclic, "next.next: cycle detected in value of type *luaconv.node"},
+		{"cyclic map", cyclicMap, "self: cycle detected"},
+		{"NaN key", map[float64]int{math.NaN(): 1}, "table index is NaN"},
+	}
+	r := rt.New(nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Encode(r, test.x)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestEncodeSharedIsNotCycle(t *testing.T) {
+	shared := &Address{Host: "h"}
+	v, err := Encode(rt.New(nil), []*Address{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []Address
+	if err := Decode(v, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[1].Host != "h" {
+		t.Errorf("unexpected %+v", out)
+	}
+}
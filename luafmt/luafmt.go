@@ -0,0 +1,93 @@
+// Package luafmt prints Lua source code from an AST in a canonical layout.
+//
+// The output of Fprint can be parsed back into an equivalent AST.  If the AST
+// was obtained with parsing.ParseChunkWithComments, the comments and the
+// empty lines between statements are preserved.  A few constructs are
+// normalised, as the AST does not distinguish them from their canonical form:
+//
+//   - f"s" and f{...} are printed as f("s") and f({...});
+//   - t["x"] is printed as t.x when x is a name;
+//   - t.f = function(...) ... end is printed as function t.f(...) ... end (and
+//     t.f = function(self, ...) as function t:f(...));
+//   - numbers are printed in decimal (integers too large for an int64 are
+//     printed in hexadecimal);
+//   - strings are printed with the configured quote, or as long strings if
+//     they span several lines;
+//   - only necessary brackets are kept in expressions;
+//   - empty statements are removed.
+//
+// Comments are attached to the statement or table field they are next to, so
+// a comment inside an expression may be moved after the statement containing
+// it.
+package luafmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+)
+
+// Config controls the output of the printer.
+type Config struct {
+	Indent string // The string used for one level of indentation
+	Quote  byte   // The quote used for strings, '"' or '\''
+}
+
+// DefaultConfig is the configuration used for the zero fields of a Config.
+var DefaultConfig = Config{
+	Indent: "    ",
+	Quote:  '"',
+}
+
+func (c Config) withDefaults() Config {
+	if c.Indent == "" {
+		c.Indent = DefaultConfig.Indent
+	}
+	if c.Quote != '"' && c.Quote != '\'' {
+		c.Quote = DefaultConfig.Quote
+	}
+	return c
+}
+
+// Fprint writes the Lua source code for block to w.
+func Fprint(w io.Writer, block *ast.BlockStat, cfg Config) error {
+	p := newPrinter(cfg.withDefaults())
+	p.chunk(*block)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// Source formats the Lua chunk src, keeping comments.  A first line starting
+// with '#' (e.g. "#!/usr/bin/env golua") is kept as is.  The name is used in
+// syntax error messages.
+func Source(name string, src []byte, cfg Config) ([]byte, error) {
+	var out bytes.Buffer
+	var opts = []scanner.Option{scanner.KeepComments()}
+	if len(src) > 0 && src[0] == '#' {
+		end := bytes.IndexByte(src, '\n') + 1
+		if end == 0 {
+			end = len(src)
+		}
+		out.Write(bytes.TrimRight(src[:end], " \t\r\n"))
+		out.WriteByte('\n')
+		src = src[end:]
+		opts = append(opts, scanner.WithStartLine(2))
+	}
+	block, err := parsing.ParseChunkWithComments(scanner.New(name, src, opts...))
+	if err != nil {
+		var parseErr parsing.Error
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%s:%s", name, parseErr)
+		}
+		return nil, err
+	}
+	if err := Fprint(&out, &block, cfg); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,188 @@
+package luafmt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		src, out string
+	}{
+		{
+			name: "comments",
+			src: `#!/usr/bin/env golua
+-- Header comment
+
+local x=1 -- trailing
+local t = { a=1, -- about a
+   ["b c"]=2,
+   -- before c
+   c = {1,2,3};
+   -- at the end
+}
+--[[ long
+comment ]]
+function t.f(self,x) return x end
+if x>0 then -- a comment
+  print "positive"
+elseif x<0 then
+else
+  -- nothing
+end
+`,
+			out: `#!/usr/bin/env golua
+-- Header comment
+
+local x = 1 -- trailing
+local t = {
+    a = 1, -- about a
+    ["b c"] = 2,
+    -- before c
+    c = {1, 2, 3},
+    -- at the end
+}
+--[[ long
+comment ]]
+function t:f(x)
+    return x
+end
+if x > 0 then
+    -- a comment
+    print("positive")
+elseif x < 0 then
+else
+    -- nothing
+end
+`,
+		},
+		{
+			name: "expressions",
+			cfg:  Config{Indent: "\t", Quote: '\''},
+			src: `local a = (1+2)*3 - -(-x) .. "it's" .. [[
+two
+lines]]
+local b = 2^(3^4), (2^3)^4, -2^2, (-2)^2, not (a and b) or c
+local c = (f()), ("x"):rep(2), a.b["c d"][1]
+local d = function(...) local x <const> = ... end
+f(x);(g or h)()
+for i=1,10 do end
+for i=10,1,-1 do break end
+`,
+			out: `local a = (1 + 2) * 3 - - -x .. 'it\'s' .. [[
+two
+lines]]
+local b = 2 ^ 3 ^ 4, (2 ^ 3) ^ 4, -2 ^ 2, (-2) ^ 2, not (a and b) or c
+local c = (f()), ('x'):rep(2), a.b['c d'][1]
+local d = function(...)
+	local x <const> = ...
+end
+f(x)
+;(g or h)()
+for i = 1, 10 do
+end
+for i = 10, 1, -1 do
+	break
+end
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := Source("test", []byte(test.src), test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.out {
+				t.Errorf("unexpected output:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	_, err := Source("test", []byte("x = "), Config{})
+	if err == nil || err.Error() != "test:1:5: unexpected symbol near <eof>" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// TestRoundTrip checks that formatting the Lua files in this repository gives
+// equivalent code and that formatting is idempotent.
+func TestRoundTrip(t *testing.T) {
+	var files []string
+	filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".lua") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if len(files) == 0 {
+		t.Fatal("no Lua files found")
+	}
+	for _, path := range files {
+		t.Run(path, func(t *testing.T) {
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(src) > 0 && src[0] == '#' {
+				src = src[bytes.IndexByte(src, '\n')+1:]
+			}
+			dump, err := astDump(src)
+			if err != nil {
+				t.Skip("does not parse")
+			}
+			out, err := Source(path, src, Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			outDump, err := astDump(out)
+			if err != nil {
+				t.Fatalf("output does not parse: %s\n%s", err, out)
+			}
+			if outDump != dump {
+				t.Fatalf("output is not equivalent:\n%s", out)
+			}
+			out2, err := Source(path, out, Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, out2) {
+				t.Fatalf("formatting is not idempotent:\n%s\n\n%s", out, out2)
+			}
+		})
+	}
+}
+
+// Positions appear in the dump of local statements.
+var posRegexp = regexp.MustCompile(`&\{\d+ \d+ \d+\}`)
+
+// astDump returns a description of the AST for src, without empty statements
+// which are not preserved by the printer.
+func astDump(src []byte) (string, error) {
+	block, err := parsing.ParseChunk(scanner.New("test", src))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	block.HWrite(ast.NewIndentWriter(&b))
+	var lines []string
+	for _, l := range strings.Split(posRegexp.ReplaceAllString(b.String(), ""), "\n") {
+		if strings.TrimSpace(l) != "empty stat" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
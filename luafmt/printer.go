@@ -0,0 +1,793 @@
+package luafmt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/luastrings"
+	"github.com/arnodel/golua/ops"
+)
+
+// A printer writes Lua source code into a buffer.  It implements
+// ast.StatProcessor and ast.ExpProcessor.
+type printer struct {
+	cfg     Config
+	buf     bytes.Buffer
+	depth   int  // Current indentation level
+	indent  bool // True if the indentation of the current line is pending
+	started bool // True if something has been written
+}
+
+var _ ast.StatProcessor = (*printer)(nil)
+var _ ast.ExpProcessor = (*printer)(nil)
+
+func newPrinter(cfg Config) *printer {
+	return &printer{cfg: cfg}
+}
+
+// sub returns a printer which writes into a separate buffer, as if it was
+// writing at the current position of p.
+func (p *printer) sub() *printer {
+	return &printer{cfg: p.cfg, depth: p.depth, started: true}
+}
+
+func (p *printer) write(s string) {
+	if s == "" {
+		return
+	}
+	if p.indent {
+		for i := 0; i < p.depth; i++ {
+			p.buf.WriteString(p.cfg.Indent)
+		}
+		p.indent = false
+	}
+	p.buf.WriteString(s)
+	p.started = true
+}
+
+// newline starts a new line (unless nothing has been written yet).
+func (p *printer) newline() {
+	if !p.started {
+		return
+	}
+	p.buf.WriteByte('\n')
+	p.indent = true
+}
+
+//
+// Statements
+//
+
+// chunk prints the top level block.
+func (p *printer) chunk(b ast.BlockStat) {
+	p.depth--
+	p.block(b, false)
+	p.depth++
+	if p.started {
+		p.buf.WriteByte('\n')
+	}
+}
+
+// block prints the statements in b, each on a new line, one level of
+// indentation deeper.  A function body ends with an implicit bare return,
+// which is not printed.
+func (p *printer) block(b ast.BlockStat, funcBody bool) {
+	p.depth++
+	defer func() { p.depth-- }()
+	first := true
+	for i, s := range b.Stats {
+		c := itemComments(b.StatComments, i)
+		if _, ok := s.(ast.EmptyStat); ok {
+			p.looseComments(c.Leading, first)
+			p.looseComments(c.Trailing, false)
+			first = first && len(c.Leading)+len(c.Trailing) == 0
+			continue
+		}
+		p.item(c, first, func() {
+			if !first && startsWithBracket(s) {
+				// Otherwise it would be parsed as a call to the previous
+				// statement.
+				p.write(";")
+			}
+			s.ProcessStat(p)
+		})
+		first = false
+	}
+	if b.Return != nil {
+		c := b.ReturnComments
+		if funcBody && len(b.Return) == 0 {
+			p.looseComments(c.Leading, first)
+			p.looseComments(c.Trailing, false)
+			first = first && len(c.Leading)+len(c.Trailing) == 0
+		} else {
+			p.item(c, first, func() {
+				p.write("return")
+				if len(b.Return) > 0 {
+					p.write(" ")
+					p.expList(b.Return)
+				}
+			})
+			first = false
+		}
+	}
+	p.looseComments(b.EndComments, first)
+}
+
+// isEmptyBlock returns true if there is nothing to print in the block.
+func isEmptyBlock(b ast.BlockStat, funcBody bool) bool {
+	if len(b.Stats) > 0 || len(b.EndComments) > 0 || len(b.StatComments) > 0 {
+		return false
+	}
+	if b.Return == nil {
+		return true
+	}
+	c := b.ReturnComments
+	return funcBody && len(b.Return) == 0 && len(c.Leading)+len(c.Trailing) == 0
+}
+
+// item prints a statement or table field on a new line, with its comments.
+func (p *printer) item(c ast.Comments, first bool, print func()) {
+	p.looseComments(c.Leading, first)
+	if c.BlankLineBefore && !(first && len(c.Leading) == 0) {
+		p.newline()
+	}
+	p.newline()
+	print()
+	p.trailingComments(c.Trailing)
+}
+
+// looseComments prints comments each on a new line.
+func (p *printer) looseComments(comments []ast.Comment, first bool) {
+	for i, c := range comments {
+		if c.BlankLineBefore && !(first && i == 0) {
+			p.newline()
+		}
+		p.newline()
+		p.comment(c)
+	}
+}
+
+// trailingComments prints comments at the end of the current line.
+func (p *printer) trailingComments(comments []ast.Comment) {
+	for i, c := range comments {
+		if i > 0 && !comments[i-1].IsLong() {
+			p.newline()
+		} else {
+			p.write(" ")
+		}
+		p.comment(c)
+	}
+}
+
+func (p *printer) comment(c ast.Comment) {
+	if c.IsLong() {
+		p.write(string(luastrings.NormalizeNewLines([]byte(c.Text))))
+	} else {
+		p.write(strings.TrimRight(c.Text, " \t\r"))
+	}
+}
+
+func itemComments(comments []ast.Comments, i int) ast.Comments {
+	if i < len(comments) {
+		return comments[i]
+	}
+	return ast.Comments{}
+}
+
+// startsWithBracket returns true if the statement would be printed starting
+// with "(".
+func startsWithBracket(s ast.Stat) bool {
+	switch x := s.(type) {
+	case ast.FunctionCall:
+		return leftmostIsBracket(x)
+	case ast.AssignStat:
+		if _, ok := functionStatName(x); ok {
+			return false
+		}
+		return leftmostIsBracket(x.Dest[0])
+	}
+	return false
+}
+
+func leftmostIsBracket(e ast.ExpNode) bool {
+	for {
+		switch x := e.(type) {
+		case ast.Name:
+			return false
+		case ast.IndexExp:
+			e = x.Coll
+		case ast.FunctionCall:
+			e = x.Target
+		default:
+			return true
+		}
+	}
+}
+
+func (p *printer) ProcessAssignStat(s ast.AssignStat) {
+	if names, ok := functionStatName(s); ok {
+		f := s.Src[0].(ast.Function)
+		p.write("function ")
+		last := len(names) - 1
+		if last > 0 && len(f.Params) > 0 && f.Params[0].Val == "self" {
+			p.write(strings.Join(names[:last], ".") + ":" + names[last])
+			f.Params = f.Params[1:]
+		} else {
+			p.write(strings.Join(names, "."))
+		}
+		p.functionBody(f)
+		return
+	}
+	for i, v := range s.Dest {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.exp(v)
+	}
+	p.write(" = ")
+	p.expList(s.Src)
+}
+
+// functionStatName returns the names making up the function name if s can be
+// printed as a function statement, e.g. "function a.b.c() end".
+func functionStatName(s ast.AssignStat) ([]string, bool) {
+	if len(s.Dest) != 1 || len(s.Src) != 1 {
+		return nil, false
+	}
+	if _, ok := s.Src[0].(ast.Function); !ok {
+		return nil, false
+	}
+	var names []string
+	var e ast.ExpNode = s.Dest[0]
+	for {
+		switch x := e.(type) {
+		case ast.Name:
+			names = append(names, x.Val)
+			for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+				names[i], names[j] = names[j], names[i]
+			}
+			return names, true
+		case ast.IndexExp:
+			k, ok := x.Idx.(ast.String)
+			if !ok || !isName(k.Val) {
+				return nil, false
+			}
+			names = append(names, string(k.Val))
+			e = x.Coll
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (p *printer) ProcessBlockStat(s ast.BlockStat) {
+	p.write("do")
+	p.blockEnd(s, "end")
+}
+
+// blockEnd prints a block followed by a closing keyword.
+func (p *printer) blockEnd(b ast.BlockStat, end string) {
+	p.block(b, false)
+	p.newline()
+	p.write(end)
+}
+
+func (p *printer) ProcessBreakStat(s ast.BreakStat) {
+	p.write("break")
+}
+
+func (p *printer) ProcessEmptyStat(s ast.EmptyStat) {
+	p.write(";")
+}
+
+func (p *printer) ProcessForInStat(s ast.ForInStat) {
+	p.write("for ")
+	for i, v := range s.Vars {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(v.Val)
+	}
+	p.write(" in ")
+	p.expList(s.Params)
+	p.write(" do")
+	p.blockEnd(s.Body, "end")
+}
+
+func (p *printer) ProcessForStat(s ast.ForStat) {
+	p.write("for " + s.Var.Val + " = ")
+	p.exp(s.Start)
+	p.write(", ")
+	p.exp(s.Stop)
+	// The parser makes up a step of 1 when there is none.
+	if step, ok := s.Step.(ast.Int); !ok || step.Val != 1 || step.StartPos() != nil {
+		p.write(", ")
+		p.exp(s.Step)
+	}
+	p.write(" do")
+	p.blockEnd(s.Body, "end")
+}
+
+func (p *printer) ProcessFunctionCallStat(s ast.FunctionCall) {
+	p.ProcessFunctionCallExp(s)
+}
+
+func (p *printer) ProcessGotoStat(s ast.GotoStat) {
+	p.write("goto " + s.Label.Val)
+}
+
+func (p *printer) ProcessIfStat(s ast.IfStat) {
+	p.write("if ")
+	p.exp(s.If.Cond)
+	p.write(" then")
+	p.block(s.If.Body, false)
+	for _, elseIf := range s.ElseIfs {
+		p.newline()
+		p.write("elseif ")
+		p.exp(elseIf.Cond)
+		p.write(" then")
+		p.block(elseIf.Body, false)
+	}
+	if s.Else != nil {
+		p.newline()
+		p.write("else")
+		p.block(*s.Else, false)
+	}
+	p.newline()
+	p.write("end")
+}
+
+func (p *printer) ProcessLabelStat(s ast.LabelStat) {
+	p.write("::" + s.Name.Val + "::")
+}
+
+func (p *printer) ProcessLocalFunctionStat(s ast.LocalFunctionStat) {
+	p.write("local function " + s.Name.Val)
+	p.functionBody(s.Function)
+}
+
+func (p *printer) ProcessLocalStat(s ast.LocalStat) {
+	p.write("local ")
+	for i, na := range s.NameAttribs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(na.Name.Val)
+		switch na.Attrib {
+		case ast.ConstAttrib:
+			p.write(" <const>")
+		case ast.CloseAttrib:
+			p.write(" <close>")
+		}
+	}
+	if len(s.Values) > 0 {
+		p.write(" = ")
+		p.expList(s.Values)
+	}
+}
+
+func (p *printer) ProcessRepeatStat(s ast.RepeatStat) {
+	p.write("repeat")
+	p.blockEnd(s.Body, "until ")
+	p.exp(s.Cond)
+}
+
+func (p *printer) ProcessWhileStat(s ast.WhileStat) {
+	p.write("while ")
+	p.exp(s.Cond)
+	p.write(" do")
+	p.blockEnd(s.Body, "end")
+}
+
+//
+// Expressions
+//
+
+func (p *printer) exp(e ast.ExpNode) {
+	e.ProcessExp(p)
+}
+
+func (p *printer) expList(exps []ast.ExpNode) {
+	for i, e := range exps {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.exp(e)
+	}
+}
+
+// prefixExp prints an expression which is called or indexed, adding brackets
+// if needed.
+func (p *printer) prefixExp(e ast.ExpNode) {
+	switch e.(type) {
+	case ast.Name, ast.IndexExp, ast.FunctionCall, ast.BFunctionCall, *ast.BFunctionCall:
+		p.exp(e)
+	default:
+		p.bracketed(e)
+	}
+}
+
+func (p *printer) bracketed(e ast.ExpNode) {
+	p.write("(")
+	p.exp(e)
+	p.write(")")
+}
+
+// Precedence of expressions which are not operations.
+const atomPrecedence = 12
+
+// Precedence of unary operators
+var unopPrecedence = ops.OpNeg.Precedence()
+
+func precedence(e ast.ExpNode) int {
+	switch x := e.(type) {
+	case ast.BinOp:
+		return x.OpType.Precedence()
+	case *ast.BinOp:
+		return x.OpType.Precedence()
+	case ast.UnOp, *ast.UnOp:
+		return unopPrecedence
+	}
+	return atomPrecedence
+}
+
+// operand prints an operand, bracketed if its precedence is too low.
+func (p *printer) operand(e ast.ExpNode, minPrec int) {
+	if precedence(e) < minPrec {
+		p.bracketed(e)
+	} else {
+		p.exp(e)
+	}
+}
+
+func isRightAssociative(op ops.Op) bool {
+	return op == ops.OpConcat || op == ops.OpPow
+}
+
+func (p *printer) ProcessBinOpExp(b ast.BinOp) {
+	prec := b.OpType.Precedence()
+	rightAssoc := isRightAssociative(b.OpType)
+	// The operations are applied left to right, so for right associative
+	// operators the intermediate results must be bracketed.
+	if rightAssoc {
+		p.write(strings.Repeat("(", len(b.Right)-1))
+		p.operand(b.Left, prec+1)
+	} else {
+		p.operand(b.Left, prec)
+	}
+	for i, r := range b.Right {
+		p.write(" " + opStrings[r.Op] + " ")
+		if rightAssoc {
+			p.operand(r.Operand, prec)
+			if i < len(b.Right)-1 {
+				p.write(")")
+			}
+		} else {
+			p.operand(r.Operand, prec+1)
+		}
+	}
+}
+
+func (p *printer) ProcesBoolExp(b ast.Bool) {
+	if b.Val {
+		p.write("true")
+	} else {
+		p.write("false")
+	}
+}
+
+func (p *printer) ProcessEtcExp(e ast.Etc) {
+	p.write("...")
+}
+
+func (p *printer) ProcessFunctionExp(f ast.Function) {
+	p.write("function")
+	p.functionBody(f)
+}
+
+// functionBody prints the parameters and the body of a function.
+func (p *printer) functionBody(f ast.Function) {
+	p.write("(")
+	for i, param := range f.Params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Val)
+	}
+	if f.HasDots {
+		if len(f.Params) > 0 {
+			p.write(", ")
+		}
+		p.write("...")
+	}
+	p.write(")")
+	if isEmptyBlock(f.Body, true) {
+		p.write(" end")
+		return
+	}
+	p.block(f.Body, true)
+	p.newline()
+	p.write("end")
+}
+
+func (p *printer) ProcessBFunctionCallExp(f ast.BFunctionCall) {
+	p.write("(")
+	p.ProcessFunctionCallExp(ast.FunctionCall{BFunctionCall: &f})
+	p.write(")")
+}
+
+func (p *printer) ProcessFunctionCallExp(f ast.FunctionCall) {
+	p.prefixExp(f.Target)
+	if f.Method.Val != "" {
+		p.write(":" + f.Method.Val)
+	}
+	p.write("(")
+	p.expList(f.Args)
+	p.write(")")
+}
+
+func (p *printer) ProcessIndexExp(e ast.IndexExp) {
+	p.prefixExp(e.Coll)
+	if k, ok := e.Idx.(ast.String); ok && isName(k.Val) {
+		p.write("." + string(k.Val))
+		return
+	}
+	p.write("[")
+	p.exp(e.Idx)
+	p.write("]")
+}
+
+func (p *printer) ProcessNameExp(n ast.Name) {
+	p.write(n.Val)
+}
+
+func (p *printer) ProcessNilExp(n ast.Nil) {
+	p.write("nil")
+}
+
+func (p *printer) ProcessIntExp(n ast.Int) {
+	if n.Val > math.MaxInt64 {
+		// In decimal it would be read as a float.
+		p.write(fmt.Sprintf("0x%X", n.Val))
+	} else {
+		p.write(strconv.FormatUint(n.Val, 10))
+	}
+}
+
+func (p *printer) ProcessFloatExp(f ast.Float) {
+	switch {
+	case math.IsInf(f.Val, 1):
+		p.write("1e999")
+	case math.IsInf(f.Val, -1):
+		p.write("-1e999")
+	case math.IsNaN(f.Val):
+		p.write("(0/0)")
+	default:
+		s := strconv.FormatFloat(f.Val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		p.write(s)
+	}
+}
+
+func (p *printer) ProcessStringExp(s ast.String) {
+	p.write(quoteString(s.Val, p.cfg.Quote))
+}
+
+func (p *printer) ProcessTableConstructorExp(t ast.TableConstructor) {
+	if len(t.Fields) == 0 && len(t.EndComments) == 0 {
+		p.write("{}")
+		return
+	}
+	if len(t.FieldComments) == 0 && len(t.EndComments) == 0 && !fieldsOnNewLine(t) {
+		p.write("{")
+		for i, f := range t.Fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.field(f)
+		}
+		p.write("}")
+		return
+	}
+	p.write("{")
+	p.depth++
+	for i, f := range t.Fields {
+		p.item(itemComments(t.FieldComments, i), i == 0, func() {
+			p.field(f)
+			p.write(",")
+		})
+	}
+	p.looseComments(t.EndComments, len(t.Fields) == 0)
+	p.depth--
+	p.newline()
+	p.write("}")
+}
+
+// fieldsOnNewLine returns true if the first field of the table starts on a
+// later line than the opening brace, in which case the table is printed with
+// one field per line.
+func fieldsOnNewLine(t ast.TableConstructor) bool {
+	start := t.StartPos()
+	if start == nil || len(t.Fields) == 0 {
+		return false
+	}
+	f := t.Fields[0]
+	fieldStart := f.Key.Locate().StartPos()
+	if _, ok := f.Key.(ast.NoTableKey); ok || fieldStart == nil {
+		fieldStart = f.Value.Locate().StartPos()
+	}
+	return fieldStart != nil && fieldStart.Line > start.Line
+}
+
+func (p *printer) field(f ast.TableField) {
+	switch k := f.Key.(type) {
+	case ast.NoTableKey:
+	case ast.String:
+		if isName(k.Val) {
+			p.write(string(k.Val) + " = ")
+			break
+		}
+		p.write("[")
+		p.exp(k)
+		p.write("] = ")
+	default:
+		p.write("[")
+		p.exp(k)
+		p.write("] = ")
+	}
+	p.exp(f.Value)
+}
+
+func (p *printer) ProcessUnOpExp(u ast.UnOp) {
+	op := opStrings[u.Op]
+	p.write(op)
+	if u.Op == ops.OpNot {
+		p.write(" ")
+	}
+	sub := p.sub()
+	sub.operand(u.Operand, unopPrecedence)
+	s := sub.buf.String()
+	if u.Op == ops.OpNeg && strings.HasPrefix(s, "-") {
+		// "--" would start a comment
+		p.write(" ")
+	}
+	p.operand(u.Operand, unopPrecedence)
+}
+
+var opStrings = map[ops.Op]string{
+	ops.OpOr:       "or",
+	ops.OpAnd:      "and",
+	ops.OpLt:       "<",
+	ops.OpLeq:      "<=",
+	ops.OpGt:       ">",
+	ops.OpGeq:      ">=",
+	ops.OpEq:       "==",
+	ops.OpNeq:      "~=",
+	ops.OpBitOr:    "|",
+	ops.OpBitXor:   "~",
+	ops.OpBitAnd:   "&",
+	ops.OpShiftL:   "<<",
+	ops.OpShiftR:   ">>",
+	ops.OpConcat:   "..",
+	ops.OpAdd:      "+",
+	ops.OpSub:      "-",
+	ops.OpMul:      "*",
+	ops.OpDiv:      "/",
+	ops.OpFloorDiv: "//",
+	ops.OpMod:      "%",
+	ops.OpNeg:      "-",
+	ops.OpNot:      "not",
+	ops.OpLen:      "#",
+	ops.OpBitNot:   "~",
+	ops.OpPow:      "^",
+}
+
+//
+// Names and strings
+//
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+// isName returns true if s is a valid Lua name (and not a keyword).
+func isName(s []byte) bool {
+	if len(s) == 0 || keywords[string(s)] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// quoteString returns a Lua literal for s.  Strings spanning several lines
+// are written as long strings if possible.
+func quoteString(s []byte, quote byte) string {
+	if long, ok := longString(s); ok {
+		return long
+	}
+	var b strings.Builder
+	b.WriteByte(quote)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case quote, '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c < utf8.RuneSelf {
+				if c < ' ' || c == 0x7f {
+					fmt.Fprintf(&b, `\x%02X`, c)
+				} else {
+					b.WriteByte(c)
+				}
+				break
+			}
+			r, n := utf8.DecodeRune(s[i:])
+			if r == utf8.RuneError && n <= 1 {
+				fmt.Fprintf(&b, `\x%02X`, c)
+				break
+			}
+			b.Write(s[i : i+n])
+			i += n
+			continue
+		}
+		i++
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
+
+// longString returns a long string literal for s if s spans several lines and
+// can be written as a long string.
+func longString(s []byte) (string, bool) {
+	if bytes.IndexByte(s, '\n') < 0 || !utf8.Valid(s) {
+		return "", false
+	}
+	for _, c := range s {
+		if c < ' ' && c != '\n' && c != '\t' || c == 0x7f {
+			return "", false
+		}
+	}
+	level := 0
+	for {
+		eq := strings.Repeat("=", level)
+		closing := "]" + eq + "]"
+		if !bytes.Contains(append(s[:len(s):len(s)], ']'), []byte(closing)) {
+			// The first newline after the opening bracket is skipped.
+			return "[" + eq + "[\n" + string(s) + closing, true
+		}
+		level++
+	}
+}
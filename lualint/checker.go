@@ -0,0 +1,600 @@
+package lualint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/token"
+)
+
+type varKind uint8
+
+const (
+	localVar varKind = iota
+	loopVar
+	localFunc
+	param
+)
+
+// A variable is a local variable being tracked by the checker.
+type variable struct {
+	name   ast.Name
+	kind   varKind
+	attrib ast.LocalAttrib
+	used   bool // True if the variable is read
+
+	// To check calls to local functions: the function the variable is
+	// initialised with, whether it is reassigned and the calls made to it.
+	fn         *ast.Function
+	reassigned bool
+	calls      []call
+}
+
+// A call to a local function.
+type call struct {
+	pos   *token.Pos
+	nArgs int
+}
+
+type scope struct {
+	parent *scope
+	vars   []*variable
+}
+
+// A global is a global variable used in the chunk.
+type global struct {
+	reads   []*token.Pos // Where it is read
+	defined *token.Pos   // Where it is first assigned to
+}
+
+// A checker walks the AST of a chunk, keeping track of the variables in scope,
+// and records the diagnostics.
+type checker struct {
+	file    string
+	known   map[string]bool // Known globals
+	scope   *scope
+	globals map[string]*global
+	names   []string // Names of globals, in order of first use
+	diags   []Diagnostic
+}
+
+var _ ast.StatProcessor = (*checker)(nil)
+var _ ast.ExpProcessor = (*checker)(nil)
+var _ ast.VarProcessor = (*checker)(nil)
+
+func newChecker(file string, cfg Config) *checker {
+	c := &checker{
+		file:    file,
+		known:   map[string]bool{},
+		globals: map[string]*global{},
+	}
+	if !cfg.NoDefaultGlobals {
+		for _, name := range DefaultGlobals {
+			c.known[name] = true
+		}
+	}
+	for _, name := range cfg.Globals {
+		c.known[name] = true
+	}
+	return c
+}
+
+//
+// Scopes
+//
+
+func (c *checker) openScope() {
+	c.scope = &scope{parent: c.scope}
+}
+
+// closeScope reports the unused variables of the current scope and checks
+// calls to the local functions it declares.
+func (c *checker) closeScope() {
+	for _, v := range c.scope.vars {
+		c.checkVariable(v)
+	}
+	c.scope = c.scope.parent
+}
+
+func (c *checker) checkVariable(v *variable) {
+	pos := v.name.StartPos()
+	if pos == nil {
+		// An implicit variable, e.g. self in a method.
+		return
+	}
+	if !v.used && v.attrib != ast.CloseAttrib && !strings.HasPrefix(v.name.Val, "_") {
+		switch v.kind {
+		case param:
+			c.report(pos, UnusedParameter, "unused parameter %s", v.name.Val)
+		case loopVar:
+			c.report(pos, UnusedLocal, "unused loop variable %s", v.name.Val)
+		case localFunc:
+			c.report(pos, UnusedLocal, "unused local function %s", v.name.Val)
+		default:
+			c.report(pos, UnusedLocal, "unused local variable %s", v.name.Val)
+		}
+	}
+	if v.fn != nil && !v.reassigned && !v.fn.HasDots {
+		nParams := len(v.fn.Params)
+		for _, call := range v.calls {
+			if call.nArgs > nParams {
+				c.report(call.pos, SuspiciousCall, "%s takes %s but is called with %d",
+					v.name.Val, plural(nParams, "argument"), call.nArgs)
+			}
+		}
+	}
+}
+
+// declare adds a new variable to the current scope, reporting it if it shadows
+// another one.
+func (c *checker) declare(name ast.Name, kind varKind) *variable {
+	if prev := c.lookup(name.Val); prev != nil && !strings.HasPrefix(name.Val, "_") && name.StartPos() != nil {
+		if prevPos := prev.name.StartPos(); prevPos != nil {
+			c.report(name.StartPos(), Shadowing, "%s shadows local %s declared on line %d", name.Val, name.Val, prevPos.Line)
+		} else {
+			c.report(name.StartPos(), Shadowing, "%s shadows implicit local %s", name.Val, name.Val)
+		}
+	}
+	v := &variable{name: name, kind: kind}
+	c.scope.vars = append(c.scope.vars, v)
+	return v
+}
+
+// lookup returns the local variable with the given name in scope, or nil.
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if v := s.vars[i]; v.name.Val == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// globalAccess returns the global with the given name, or nil if globals are
+// accessed through a local _ENV variable.
+func (c *checker) globalAccess(name string) *global {
+	if env := c.lookup("_ENV"); env != nil {
+		env.used = true
+		return nil
+	}
+	g := c.globals[name]
+	if g == nil {
+		g = new(global)
+		c.globals[name] = g
+		c.names = append(c.names, name)
+	}
+	return g
+}
+
+// checkGlobals reports undefined and unused globals, once the whole chunk has
+// been walked.
+func (c *checker) checkGlobals() {
+	for _, name := range c.names {
+		g := c.globals[name]
+		if c.known[name] {
+			continue
+		}
+		if g.defined == nil {
+			for _, pos := range g.reads {
+				c.report(pos, UndefinedGlobal, "undefined global %s", name)
+			}
+		} else if len(g.reads) == 0 {
+			c.report(g.defined, UnusedGlobal, "global %s is defined but never used", name)
+		}
+	}
+}
+
+//
+// Functions and blocks
+//
+
+func (c *checker) function(f ast.Function) {
+	c.openScope()
+	for _, p := range f.Params {
+		c.declare(p, param)
+	}
+	c.stats(f.Body)
+	c.closeScope()
+}
+
+// block processes a block in its own scope.
+func (c *checker) block(b ast.BlockStat) {
+	c.openScope()
+	c.stats(b)
+	c.closeScope()
+}
+
+// stats processes the statements of a block in the current scope, reporting
+// the first unreachable statement.
+func (c *checker) stats(b ast.BlockStat) {
+	var after string // Set to what ended the flow of execution
+	for _, s := range b.Stats {
+		switch s.(type) {
+		case ast.LabelStat:
+			// Can be reached by goto
+			after = ""
+		case ast.EmptyStat:
+		default:
+			if after != "" {
+				c.report(statPos(s), UnreachableCode, "unreachable code after %s", after)
+				after = ""
+			}
+		}
+		s.ProcessStat(c)
+		if a := terminator(s); a != "" {
+			after = a
+		}
+	}
+	c.exps(b.Return)
+}
+
+// terminator returns "break", "goto" or "return" if the flow of execution
+// cannot continue after s because of such a statement, "" otherwise.
+func terminator(s ast.Stat) string {
+	switch x := s.(type) {
+	case ast.BreakStat:
+		return "break"
+	case ast.GotoStat:
+		return "goto"
+	case ast.BlockStat:
+		return blockTerminator(x)
+	case ast.IfStat:
+		if x.Else == nil {
+			return ""
+		}
+		t := blockTerminator(*x.Else)
+		for _, b := range append([]ast.CondStat{x.If}, x.ElseIfs...) {
+			if t == "" {
+				break
+			}
+			if bt := blockTerminator(b.Body); bt != t {
+				t = ""
+			}
+		}
+		return t
+	}
+	return ""
+}
+
+func blockTerminator(b ast.BlockStat) string {
+	if b.Return != nil {
+		return "return"
+	}
+	if n := len(b.Stats); n > 0 {
+		return terminator(b.Stats[n-1])
+	}
+	return ""
+}
+
+// statPos returns the start position of s, or nil if it has none.
+func statPos(s ast.Stat) *token.Pos {
+	if pos := s.Locate().StartPos(); pos != nil {
+		return pos
+	}
+	if b, ok := s.(ast.BlockStat); ok {
+		for _, s := range b.Stats {
+			if pos := statPos(s); pos != nil {
+				return pos
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) exps(es []ast.ExpNode) {
+	for _, e := range es {
+		e.ProcessExp(c)
+	}
+}
+
+//
+// Statements
+//
+
+// ProcessAssignStat processes an assignment statement.
+func (c *checker) ProcessAssignStat(s ast.AssignStat) {
+	c.exps(s.Src)
+	for _, v := range s.Dest {
+		v.ProcessVar(c)
+	}
+}
+
+// ProcessBlockStat processes a do ... end statement.
+func (c *checker) ProcessBlockStat(s ast.BlockStat) {
+	c.block(s)
+}
+
+// ProcessBreakStat processes a break statement.
+func (c *checker) ProcessBreakStat(s ast.BreakStat) {}
+
+// ProcessEmptyStat processes an empty statement.
+func (c *checker) ProcessEmptyStat(s ast.EmptyStat) {}
+
+// ProcessForInStat processes a for ... in statement.
+func (c *checker) ProcessForInStat(s ast.ForInStat) {
+	c.exps(s.Params)
+	c.openScope()
+	for _, v := range s.Vars {
+		c.declare(v, loopVar)
+	}
+	c.block(s.Body)
+	c.closeScope()
+}
+
+// ProcessForStat processes a numeric for statement.
+func (c *checker) ProcessForStat(s ast.ForStat) {
+	c.exps([]ast.ExpNode{s.Start, s.Stop, s.Step})
+	c.openScope()
+	c.declare(s.Var, loopVar)
+	c.block(s.Body)
+	c.closeScope()
+}
+
+// ProcessFunctionCallStat processes a function call statement.
+func (c *checker) ProcessFunctionCallStat(s ast.FunctionCall) {
+	c.ProcessFunctionCallExp(s)
+}
+
+// ProcessGotoStat processes a goto statement.
+func (c *checker) ProcessGotoStat(s ast.GotoStat) {}
+
+// ProcessIfStat processes an if statement.
+func (c *checker) ProcessIfStat(s ast.IfStat) {
+	for _, cond := range append([]ast.CondStat{s.If}, s.ElseIfs...) {
+		cond.Cond.ProcessExp(c)
+		c.block(cond.Body)
+	}
+	if s.Else != nil {
+		c.block(*s.Else)
+	}
+}
+
+// ProcessLabelStat processes a label.
+func (c *checker) ProcessLabelStat(s ast.LabelStat) {}
+
+// ProcessLocalFunctionStat processes a local function definition.  The function
+// is in scope in its body.
+func (c *checker) ProcessLocalFunctionStat(s ast.LocalFunctionStat) {
+	v := c.declare(s.Name, localFunc)
+	f := s.Function
+	v.fn = &f
+	c.function(f)
+}
+
+// ProcessLocalStat processes a local variable declaration.  The variables are
+// not in scope in the values.
+func (c *checker) ProcessLocalStat(s ast.LocalStat) {
+	c.exps(s.Values)
+	for i, na := range s.NameAttribs {
+		v := c.declare(na.Name, localVar)
+		v.attrib = na.Attrib
+		if i < len(s.Values) && len(s.NameAttribs) == len(s.Values) {
+			if f, ok := s.Values[i].(ast.Function); ok {
+				v.fn = &f
+			}
+		}
+	}
+}
+
+// ProcessRepeatStat processes a repeat ... until statement.  The locals of the
+// body are in scope in the condition.
+func (c *checker) ProcessRepeatStat(s ast.RepeatStat) {
+	c.openScope()
+	c.stats(s.Body)
+	s.Cond.ProcessExp(c)
+	c.closeScope()
+}
+
+// ProcessWhileStat processes a while statement.
+func (c *checker) ProcessWhileStat(s ast.WhileStat) {
+	s.Cond.ProcessExp(c)
+	c.block(s.Body)
+}
+
+//
+// Assignment targets
+//
+
+// ProcessIndexExpVar processes an assignment to a table field.
+func (c *checker) ProcessIndexExpVar(e ast.IndexExp) {
+	c.ProcessIndexExp(e)
+}
+
+// ProcessNameVar processes an assignment to a variable.
+func (c *checker) ProcessNameVar(n ast.Name) {
+	if v := c.lookup(n.Val); v != nil {
+		v.reassigned = true
+		switch v.attrib {
+		case ast.ConstAttrib:
+			c.report(n.StartPos(), ConstAssign, "assignment to const variable %s", n.Val)
+		case ast.CloseAttrib:
+			c.report(n.StartPos(), ConstAssign, "assignment to close variable %s", n.Val)
+		}
+		return
+	}
+	if g := c.globalAccess(n.Val); g != nil && g.defined == nil {
+		g.defined = n.StartPos()
+	}
+}
+
+//
+// Expressions
+//
+
+// ProcessBFunctionCallExp processes a function call in brackets.
+func (c *checker) ProcessBFunctionCallExp(f ast.BFunctionCall) {
+	c.ProcessFunctionCallExp(ast.FunctionCall{BFunctionCall: &f})
+}
+
+// ProcessBinOpExp processes an operation with a binary operator.
+func (c *checker) ProcessBinOpExp(b ast.BinOp) {
+	b.Left.ProcessExp(c)
+	for _, r := range b.Right {
+		r.Operand.ProcessExp(c)
+	}
+}
+
+// ProcesBoolExp processes a boolean literal.
+func (c *checker) ProcesBoolExp(b ast.Bool) {}
+
+// ProcessEtcExp processes "...".
+func (c *checker) ProcessEtcExp(e ast.Etc) {}
+
+// ProcessFunctionExp processes a function definition.
+func (c *checker) ProcessFunctionExp(f ast.Function) {
+	c.function(f)
+}
+
+// ProcessFunctionCallExp processes a function call.
+func (c *checker) ProcessFunctionCallExp(f ast.FunctionCall) {
+	f.Target.ProcessExp(c)
+	c.exps(f.Args)
+	c.checkCall(f)
+}
+
+// ProcessIndexExp processes an indexing expression.
+func (c *checker) ProcessIndexExp(e ast.IndexExp) {
+	e.Coll.ProcessExp(c)
+	e.Idx.ProcessExp(c)
+}
+
+// ProcessNameExp processes a variable read.
+func (c *checker) ProcessNameExp(n ast.Name) {
+	if v := c.lookup(n.Val); v != nil {
+		v.used = true
+		return
+	}
+	if g := c.globalAccess(n.Val); g != nil {
+		g.reads = append(g.reads, n.StartPos())
+	}
+}
+
+// ProcessNilExp processes nil.
+func (c *checker) ProcessNilExp(n ast.Nil) {}
+
+// ProcessIntExp processes an integer literal.
+func (c *checker) ProcessIntExp(n ast.Int) {}
+
+// ProcessFloatExp processes a float literal.
+func (c *checker) ProcessFloatExp(f ast.Float) {}
+
+// ProcessStringExp processes a string literal.
+func (c *checker) ProcessStringExp(s ast.String) {}
+
+// ProcessTableConstructorExp processes a table constructor.
+func (c *checker) ProcessTableConstructorExp(t ast.TableConstructor) {
+	for _, f := range t.Fields {
+		if _, ok := f.Key.(ast.NoTableKey); !ok {
+			f.Key.ProcessExp(c)
+		}
+		f.Value.ProcessExp(c)
+	}
+}
+
+// ProcessUnOpExp processes an operation with a unary operator.
+func (c *checker) ProcessUnOpExp(u ast.UnOp) {
+	u.Operand.ProcessExp(c)
+}
+
+//
+// Calls
+//
+
+// checkCall reports calls which are likely to fail or to be mistakes.
+func (c *checker) checkCall(f ast.FunctionCall) {
+	pos := f.StartPos()
+	if f.Method.Val != "" {
+		if s, ok := f.Target.(ast.String); ok && f.Method.Val == "format" {
+			c.checkFormat(pos, s.Val, f.Args)
+		}
+		return
+	}
+	switch t := f.Target.(type) {
+	case ast.Int, ast.Float:
+		c.report(pos, SuspiciousCall, "attempt to call a number")
+	case ast.String:
+		c.report(pos, SuspiciousCall, "attempt to call a string")
+	case ast.Bool:
+		c.report(pos, SuspiciousCall, "attempt to call a boolean")
+	case ast.Nil:
+		c.report(pos, SuspiciousCall, "attempt to call nil")
+	case ast.TableConstructor:
+		c.report(pos, SuspiciousCall, "attempt to call a table")
+	case ast.Name:
+		if v := c.lookup(t.Val); v != nil && v.fn != nil {
+			v.calls = append(v.calls, call{pos: pos, nArgs: len(f.Args)})
+		}
+	case ast.IndexExp:
+		coll, ok := t.Coll.(ast.Name)
+		if !ok || coll.Val != "string" || c.lookup("string") != nil {
+			return
+		}
+		if idx, ok := t.Idx.(ast.String); ok && string(idx.Val) == "format" && len(f.Args) > 0 {
+			if s, ok := f.Args[0].(ast.String); ok {
+				c.checkFormat(pos, s.Val, f.Args[1:])
+			}
+		}
+	}
+}
+
+// checkFormat reports a mismatch between the number of values a format string
+// needs and the number of args given.  Too few args is not reported when the
+// last one may produce several values.
+func (c *checker) checkFormat(pos *token.Pos, format []byte, args []ast.ExpNode) {
+	n := formatDirectiveCount(format)
+	if n < 0 {
+		return
+	}
+	switch {
+	case len(args) > n:
+		c.report(pos, SuspiciousCall, "format string needs %s but %d given", plural(n, "value"), len(args))
+	case len(args) < n && !isMultiValue(args):
+		c.report(pos, SuspiciousCall, "format string needs %s but %d given", plural(n, "value"), len(args))
+	}
+}
+
+// formatDirectiveCount returns the number of values needed by a
+// string.format format string, or -1 if it is not a valid format.
+func formatDirectiveCount(format []byte) int {
+	n := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			return -1
+		}
+		n++
+	}
+	return n
+}
+
+// isMultiValue returns true if the last of the args may produce several
+// values.
+func isMultiValue(args []ast.ExpNode) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[len(args)-1].(type) {
+	case ast.FunctionCall, ast.Etc:
+		return true
+	}
+	return false
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
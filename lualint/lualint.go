@@ -0,0 +1,155 @@
+// Package lualint reports likely mistakes in Lua source code without running
+// it, e.g. reading a global variable which is never defined or declaring a
+// local variable which is never used.
+//
+// Each problem found is reported as a Diagnostic, whose Code says what kind of
+// problem it is:
+//
+//   - syntax-error: the code cannot be parsed (no other check is done);
+//   - undefined-global: a global variable is read but not defined in the
+//     chunk, and it is not a known global (see Config);
+//   - unused-global: a global variable is defined in the chunk but not read,
+//     and it is not a known global;
+//   - unused-local: a local variable, local function or loop variable is
+//     never read;
+//   - unused-parameter: a function parameter is never read;
+//   - shadowing: a local variable has the same name as another local variable
+//     in scope;
+//   - unreachable-code: a statement follows a break, goto or return;
+//   - const-assign: a <const> or <close> local variable is assigned to;
+//   - suspicious-call: a call which is likely to fail or not do what is
+//     intended, e.g. calling a number, passing more arguments than a local
+//     function has parameters or not passing as many arguments to
+//     string.format as the format string needs.
+//
+// Local variables and parameters whose name starts with "_" are not reported
+// as unused or shadowing.
+package lualint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+	"github.com/arnodel/golua/token"
+)
+
+// Codes of the diagnostics.
+const (
+	SyntaxError     = "syntax-error"
+	UndefinedGlobal = "undefined-global"
+	UnusedGlobal    = "unused-global"
+	UnusedLocal     = "unused-local"
+	UnusedParameter = "unused-parameter"
+	Shadowing       = "shadowing"
+	UnreachableCode = "unreachable-code"
+	ConstAssign     = "const-assign"
+	SuspiciousCall  = "suspicious-call"
+)
+
+// A Diagnostic is a problem found in the code.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"col"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// String returns the diagnostic in the usual "file:line:col: message" format.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.File, d.Line, d.Column, d.Message, d.Code)
+}
+
+// Config controls the checks.
+type Config struct {
+	// Globals defined outside the code being checked (e.g. by the host
+	// program), in addition to DefaultGlobals.
+	Globals []string
+
+	// If true, DefaultGlobals are not known globals.
+	NoDefaultGlobals bool
+
+	// Codes of diagnostics not to report.
+	Disable []string
+}
+
+// DefaultGlobals are the globals defined by the golua standard library.
+var DefaultGlobals = []string{
+	"_G", "_VERSION",
+	"assert", "collectgarbage", "dofile", "error", "getmetatable", "ipairs",
+	"load", "loadfile", "next", "pairs", "pcall", "print", "rawequal", "rawget",
+	"rawlen", "rawset", "require", "select", "setmetatable", "tonumber",
+	"tostring", "type", "warn", "xpcall",
+	"coroutine", "debug", "golib", "io", "math", "os", "package", "runtime",
+	"string", "table", "utf8",
+}
+
+// Check parses the Lua chunk src and returns the problems found in it, sorted
+// by position.  The name is used as the file name of the diagnostics.  A first
+// line starting with '#' (e.g. "#!/usr/bin/env golua") is ignored.
+func Check(name string, src []byte, cfg Config) []Diagnostic {
+	var opts []scanner.Option
+	if len(src) > 0 && src[0] == '#' {
+		end := len(src)
+		if i := strings.IndexByte(string(src), '\n'); i >= 0 {
+			end = i + 1
+		}
+		src = src[end:]
+		opts = append(opts, scanner.WithStartLine(2))
+	}
+	block, err := parsing.ParseChunk(scanner.New(name, src, opts...))
+	if err != nil {
+		d := Diagnostic{File: name, Line: 1, Column: 1, Code: SyntaxError, Message: err.Error()}
+		var parseErr parsing.Error
+		if errors.As(err, &parseErr) {
+			d.Line, d.Column = parseErr.Got.Line, parseErr.Got.Column
+			d.Message = strings.TrimPrefix(d.Message, fmt.Sprintf("%d:%d: ", d.Line, d.Column))
+		}
+		return []Diagnostic{d}
+	}
+	return CheckChunk(name, block, cfg)
+}
+
+// CheckChunk returns the problems found in the parsed Lua chunk block, sorted
+// by position.
+func CheckChunk(name string, block ast.BlockStat, cfg Config) []Diagnostic {
+	c := newChecker(name, cfg)
+	c.function(ast.Function{ParList: ast.ParList{HasDots: true}, Body: block})
+	c.checkGlobals()
+
+	disabled := map[string]bool{}
+	for _, code := range cfg.Disable {
+		disabled[code] = true
+	}
+	var diags []Diagnostic
+	for _, d := range c.diags {
+		if !disabled[d.Code] {
+			diags = append(diags, d)
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		di, dj := diags[i], diags[j]
+		if di.Line != dj.Line {
+			return di.Line < dj.Line
+		}
+		return di.Column < dj.Column
+	})
+	return diags
+}
+
+func (c *checker) report(pos *token.Pos, code, format string, args ...interface{}) {
+	d := Diagnostic{
+		File:    c.file,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if pos != nil {
+		d.Line, d.Column = pos.Line, pos.Column
+	}
+	c.diags = append(c.diags, d)
+}
@@ -0,0 +1,227 @@
+package lualint
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		src   string
+		diags []string // As "line:col:code"
+	}{
+		{
+			name: "clean",
+			src: `local t = {}
+function t.add(x, y) return x + y end
+local function fib(n)
+    if n < 2 then return n end
+    return fib(n - 1) + fib(n - 2)
+end
+print(t.add(1, fib(10)), string.format("%d%%", 5))
+`,
+		},
+		{
+			name: "syntax error",
+			src:  "local x = = 1",
+			diags: []string{
+				"1:11:syntax-error",
+			},
+		},
+		{
+			name: "shebang",
+			src:  "#!/usr/bin/env golua\nprint(y)",
+			diags: []string{
+				"2:7:undefined-global",
+			},
+		},
+		{
+			name: "globals",
+			src: `counter = 0
+function incr() counter = counter + 1 end
+unused = true
+print(undefined, host)
+`,
+			cfg: Config{Globals: []string{"host"}},
+			diags: []string{
+				"2:10:unused-global",
+				"3:1:unused-global",
+				"4:7:undefined-global",
+			},
+		},
+		{
+			name: "no default globals",
+			src:  "print(1)",
+			cfg:  Config{NoDefaultGlobals: true},
+			diags: []string{
+				"1:1:undefined-global",
+			},
+		},
+		{
+			name: "local _ENV",
+			src: `local _ENV = {print = print}
+print(x)
+`,
+		},
+		{
+			name: "unused",
+			src: `local a, b = 1, 2
+local function f(x, _y, ...) end
+for i, v in pairs(a) do print(v) end
+for k = 1, 10 do end
+local c <close> = nil
+local _d = 4
+local e = 1
+e = 2
+`,
+			diags: []string{
+				"1:10:unused-local",
+				"2:16:unused-local",
+				"2:18:unused-parameter",
+				"3:5:unused-local",
+				"4:5:unused-local",
+				"7:7:unused-local",
+			},
+		},
+		{
+			name: "shadowing",
+			src: `local x = 1
+local function f(x)
+    local x = x + 1
+    return x
+end
+local t = {}
+function t:m() local self = 1 return self end
+local _ = 1
+local _ = 2
+do local x = x end
+print(f, t)
+`,
+			diags: []string{
+				"2:18:shadowing",
+				"3:11:shadowing",
+				"7:22:shadowing",
+				"10:10:shadowing",
+				"10:10:unused-local",
+			},
+		},
+		{
+			name: "unreachable code",
+			src: `local function f(x)
+    while true do
+        break
+        print(x)
+    end
+    goto done
+    print("skipped")
+    ::done::
+    do return end
+    print("unreachable")
+    print("not reported twice")
+end
+local function g(x)
+    if x then return 1 else return 2 end
+    print("unreachable")
+end
+local function h(x)
+    if x then return 1 end
+    print("reachable")
+end
+f(1) g(1) h(1)
+`,
+			diags: []string{
+				"4:9:unreachable-code",
+				"7:5:unreachable-code",
+				"10:5:unreachable-code",
+				"15:5:unreachable-code",
+			},
+		},
+		{
+			name: "const assign",
+			src: `local x <const> = 1
+local f <close> = nil
+x, f = 2, 3
+local function g() x = 4 end
+g()
+`,
+			diags: []string{
+				"1:7:unused-local",
+				"3:1:const-assign",
+				"3:4:const-assign",
+				"4:20:const-assign",
+			},
+		},
+		{
+			name: "suspicious calls",
+			src: `local function f(a) return a end
+local g = function(a, ...) return a, ... end
+local h = function(a) return a end
+h = print
+f(1, 2)
+g(1, 2, 3)
+h(1, 2, 3)
+local n = (1)()
+string.format("%s %d", 1)
+string.format("%s %d", f(1))
+string.format("%-5s %5.2f %%", 1, 2, 3)
+print(("%s"):format(1, 2), n)
+`,
+			diags: []string{
+				"5:1:suspicious-call",
+				"8:12:suspicious-call",
+				"9:1:suspicious-call",
+				"11:1:suspicious-call",
+				"12:8:suspicious-call",
+			},
+		},
+		{
+			name: "shadowed string",
+			src: `local string = {format = print}
+string.format("%s", 1, 2)
+`,
+		},
+		{
+			name: "disable",
+			src:  "x = 1",
+			cfg:  Config{Disable: []string{UnusedGlobal}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, d := range Check("test.lua", []byte(test.src), test.cfg) {
+				if d.File != "test.lua" {
+					t.Errorf("wrong file name in %s", d)
+				}
+				got = append(got, strings.Join([]string{strconv.Itoa(d.Line), strconv.Itoa(d.Column), d.Code}, ":"))
+			}
+			if !reflect.DeepEqual(got, test.diags) {
+				t.Errorf("got %q, want %q", got, test.diags)
+			}
+		})
+	}
+}
+
+func TestDiagnosticFormat(t *testing.T) {
+	diags := Check("f.lua", []byte("local x = 1"), Config{})
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diags)
+	}
+	d := diags[0]
+	if s := d.String(); s != "f.lua:1:7: unused local variable x (unused-local)" {
+		t.Errorf("wrong string: %s", s)
+	}
+	out, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"file":"f.lua","line":1,"col":7,"code":"unused-local","message":"unused local variable x"}`
+	if string(out) != want {
+		t.Errorf("wrong JSON: %s", out)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/arnodel/golua/coverage"
 	"github.com/arnodel/golua/runtime"
 )
 
@@ -28,9 +30,24 @@ func RunSource(r *runtime.Runtime, source []byte) {
 	}
 }
 
+// CoverageProfile, if not nil, accumulates the code coverage of the Lua code
+// run by RunLuaTest, RunLuaTestFile and RunLuaTestsInDir.  Test files appear
+// under their path in the profile (or "luatest" for code run by RunLuaTest),
+// other chunks under their chunk name.
+//
+// It can be set in TestMain, then written e.g. with its WriteLCOV method after
+// the tests have run.
+var CoverageProfile *coverage.Profile
+
+var coverageMu sync.Mutex
+
 // RunLuaTest runs the lua test code in source, running setup if non-nil
 // beforehand (with the Runtime instance that will be used in the test).
 func RunLuaTest(source []byte, setup func(*runtime.Runtime) func()) error {
+	return runLuaTest("luatest", source, setup)
+}
+
+func runLuaTest(path string, source []byte, setup func(*runtime.Runtime) func()) error {
 	outputBuf := new(bytes.Buffer)
 	r := runtime.New(outputBuf)
 	r.SetWarner(runtime.NewLogWarner(outputBuf, "Test warning: "))
@@ -38,9 +55,18 @@ func RunLuaTest(source []byte, setup func(*runtime.Runtime) func()) error {
 		cleanup := setup(r)
 		defer cleanup()
 	}
+	if CoverageProfile != nil {
+		_ = r.StartCoverage()
+	}
 	checkers := ExtractLineCheckers(source)
 	RunSource(r, source)
 	r.Close(nil)
+	if prof := r.StopCoverage(); prof != nil {
+		prof.Rename("luatest", path)
+		coverageMu.Lock()
+		CoverageProfile.Merge(prof)
+		coverageMu.Unlock()
+	}
 	return CheckLines(outputBuf.Bytes(), checkers)
 }
 
@@ -49,6 +75,7 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 		return
 	}
 	isQuotasTest := strings.HasSuffix(path, ".quotas.lua")
+	isWeakTest := strings.HasSuffix(path, ".weak.lua")
 	t.Run(path, func(t *testing.T) {
 		if isQuotasTest {
 			if !runtime.QuotasAvailable {
@@ -56,12 +83,18 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 				return
 			}
 		}
+		if isWeakTest {
+			if !runtime.WeakTablesAvailable {
+				t.Skip("Skipping weak tables test as build does not support them")
+				return
+			}
+		}
 		src, err := ioutil.ReadFile(path)
 		if err != nil {
 			t.Error(err)
 			return
 		}
-		err = RunLuaTest(src, setup)
+		err = runLuaTest(path, src, setup)
 		if err != nil {
 			t.Error(err)
 		}
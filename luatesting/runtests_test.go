@@ -3,6 +3,7 @@ package luatesting_test
 import (
 	"testing"
 
+	"github.com/arnodel/golua/coverage"
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/luatesting"
 )
@@ -30,3 +31,16 @@ error("hello")
 func TestLua(t *testing.T) {
 	luatesting.RunLuaTestsInDir(t, "lua", lib.LoadAll)
 }
+
+func TestCoverageProfile(t *testing.T) {
+	luatesting.CoverageProfile = coverage.NewProfile()
+	defer func() { luatesting.CoverageProfile = nil }()
+	luatesting.RunLuaTestsInDir(t, "lua", lib.LoadAll)
+	f := luatesting.CoverageProfile.Files["lua/long_brackets.lua"]
+	if f == nil {
+		t.Fatalf("no coverage for lua/long_brackets.lua")
+	}
+	if f.LinesCovered() == 0 || f.LinesCovered() != len(f.Lines) {
+		t.Errorf("expected all lines to be covered, got %d/%d", f.LinesCovered(), len(f.Lines))
+	}
+}
@@ -11,6 +11,9 @@ import (
 )
 
 func main() {
+	if retcode, ok := runSubcommand(); ok {
+		os.Exit(retcode)
+	}
 	cmd := new(luaCmd)
 	cmd.setFlags()
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
@@ -14,6 +14,16 @@ import (
 // Parser can parse lua statements or expressions
 type Parser struct {
 	scanner Scanner
+
+	// When keepComments is true, comments are attached to the statements and
+	// table fields they are next to.  This is only needed to reproduce the
+	// source (e.g. to format it).
+	keepComments bool
+	cur          *token.Token  // The last token returned by Scan
+	comments     []ast.Comment // Comments not yet attached to a node
+	nAfterPrev   int           // Number of comments after the token before cur
+	prevEndLine  int           // Line where the token before cur ends
+	blankLine    bool          // True if there is an empty line before cur
 }
 
 type Scanner interface {
@@ -59,7 +69,7 @@ func ParseExp(scanner Scanner) (exp ast.ExpNode, err error) {
 			}
 		}
 	}()
-	parser := &Parser{scanner}
+	parser := &Parser{scanner: scanner}
 	var t *token.Token
 	exp, t = parser.Exp(parser.Scan())
 	expectType(t, token.EOF, "<eof>")
@@ -69,6 +79,17 @@ func ParseExp(scanner Scanner) (exp ast.ExpNode, err error) {
 // ParseChunk takes in a function that returns tokens and builds a BlockStat for it
 // (or returns an error).
 func ParseChunk(scanner Scanner) (stat ast.BlockStat, err error) {
+	return parseChunk(&Parser{scanner: scanner})
+}
+
+// ParseChunkWithComments is like ParseChunk but it also records the comments
+// (scanned as COMMENT tokens, see scanner.KeepComments) and empty lines in the
+// BlockStat nodes and table constructors.
+func ParseChunkWithComments(scanner Scanner) (stat ast.BlockStat, err error) {
+	return parseChunk(&Parser{scanner: scanner, keepComments: true})
+}
+
+func parseChunk(parser *Parser) (stat ast.BlockStat, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			stat = ast.BlockStat{}
@@ -79,7 +100,6 @@ func ParseChunk(scanner Scanner) (stat ast.BlockStat, err error) {
 			}
 		}
 	}()
-	parser := &Parser{scanner}
 	var t *token.Token
 	stat, t = parser.Block(parser.Scan())
 	expectType(t, token.EOF, "<eof>")
@@ -88,11 +108,34 @@ func ParseChunk(scanner Scanner) (stat ast.BlockStat, err error) {
 
 // Scan returns the next token.
 func (p *Parser) Scan() *token.Token {
-	tok := p.scanner.Scan()
-	if tok.Type == token.INVALID {
-		panic(Error{Got: tok, Expected: p.scanner.ErrorMsg()})
+	var prevEndLine int
+	if p.cur != nil {
+		prevEndLine = endLine(p.cur)
+	}
+	if p.keepComments {
+		p.prevEndLine = prevEndLine
+		p.nAfterPrev = 0
+	}
+	for {
+		tok := p.scanner.Scan()
+		switch tok.Type {
+		case token.INVALID:
+			panic(Error{Got: tok, Expected: p.scanner.ErrorMsg()})
+		case token.COMMENT:
+			if p.keepComments {
+				blank := prevEndLine > 0 && tok.Line > prevEndLine+1
+				p.comments = append(p.comments, ast.NewComment(tok, blank))
+				p.nAfterPrev++
+				prevEndLine = endLine(tok)
+			}
+			continue
+		}
+		if p.keepComments {
+			p.blankLine = prevEndLine > 0 && tok.Line > prevEndLine+1
+		}
+		p.cur = tok
+		return tok
 	}
-	return tok
 }
 
 // Stat parses any statement.
@@ -280,15 +323,31 @@ func (p *Parser) FunctionStat(*token.Token) (ast.Stat, *token.Token) {
 func (p *Parser) Block(t *token.Token) (ast.BlockStat, *token.Token) {
 	var stats []ast.Stat
 	var next ast.Stat
+	var comments commentsBuilder
 	for {
 		switch t.Type {
 		case token.KwReturn:
+			var block ast.BlockStat
+			c := p.leadingComments()
 			ret, t := p.Return(t)
-			return ast.NewBlockStat(stats, ret), t
+			if p.keepComments {
+				c.Trailing = p.trailingComments()
+				comments.add(c, len(stats))
+			}
+			block = ast.NewBlockStat(stats, ret)
+			p.setBlockComments(&block, comments)
+			return block, t
 		case token.KwEnd, token.KwElse, token.KwElseIf, token.KwUntil, token.EOF:
-			return ast.NewBlockStat(stats, nil), t
+			block := ast.NewBlockStat(stats, nil)
+			p.setBlockComments(&block, comments)
+			return block, t
 		default:
+			c := p.leadingComments()
 			next, t = p.Stat(t)
+			if p.keepComments {
+				c.Trailing = p.trailingComments()
+				comments.add(c, len(stats))
+			}
 			stats = append(stats, next)
 		}
 	}
@@ -564,21 +623,32 @@ func (p *Parser) ExpList(t *token.Token) ([]ast.ExpNode, *token.Token) {
 func (p *Parser) TableConstructor(opTok *token.Token) (ast.TableConstructor, *token.Token) {
 	t := p.Scan()
 	var fields []ast.TableField
-	if t.Type != token.SgCloseBrace {
+	var comments commentsBuilder
+	for t.Type != token.SgCloseBrace {
 		var field ast.TableField
+		c := p.leadingComments()
 		field, t = p.Field(t)
-		fields = []ast.TableField{field}
-		for t.Type == token.SgComma || t.Type == token.SgSemicolon {
+		fields = append(fields, field)
+		sep := t.Type == token.SgComma || t.Type == token.SgSemicolon
+		if sep {
 			t = p.Scan()
-			if t.Type == token.SgCloseBrace {
-				break
-			}
-			field, t = p.Field(t)
-			fields = append(fields, field)
+		}
+		if p.keepComments {
+			// This includes the comments after the separator.
+			c.Trailing = p.trailingComments()
+			comments.add(c, len(fields)-1)
+		}
+		if !sep {
+			break
 		}
 	}
 	expectType(t, token.SgCloseBrace, "'}'")
-	return ast.NewTableConstructor(opTok, t, fields), p.Scan()
+	table := ast.NewTableConstructor(opTok, t, fields)
+	if p.keepComments {
+		table.FieldComments = comments.get(len(fields))
+		table.EndComments = p.takeComments()
+	}
+	return table, p.Scan()
 }
 
 // Field parses a table constructor field.
@@ -632,6 +702,110 @@ func (p *Parser) NameAttrib(t *token.Token) (ast.NameAttrib, *token.Token) {
 	return ast.NewNameAttrib(name, attribName, attrib), t
 }
 
+// leadingComments returns the comments before the current token (and not
+// attached to another node), to be attached to the node starting at the
+// current token.
+func (p *Parser) leadingComments() ast.Comments {
+	if !p.keepComments {
+		return ast.Comments{}
+	}
+	return ast.Comments{
+		Leading:         p.takeComments(),
+		BlankLineBefore: p.blankLine,
+	}
+}
+
+// trailingComments returns the comments on the same line as the end of the
+// node which has just been parsed (i.e. the token before the current one).
+// The comments which are inside the node and have not been attached to any
+// other node are also returned, as there is nowhere else to put them.
+func (p *Parser) trailingComments() []ast.Comment {
+	n := len(p.comments) - p.nAfterPrev
+	for n < len(p.comments) && p.comments[n].StartPos().Line == p.prevEndLine {
+		n++
+	}
+	comments := p.comments[:n:n]
+	p.comments = p.comments[n:]
+	p.nAfterPrev = len(p.comments)
+	if len(comments) == 0 {
+		return nil
+	}
+	return comments
+}
+
+// takeComments returns all the comments not attached to a node yet.
+func (p *Parser) takeComments() []ast.Comment {
+	comments := p.comments
+	p.comments = nil
+	p.nAfterPrev = 0
+	return comments
+}
+
+func (p *Parser) setBlockComments(block *ast.BlockStat, comments commentsBuilder) {
+	if !p.keepComments {
+		return
+	}
+	n := len(block.Stats)
+	block.StatComments = comments.get(n)
+	if block.Return != nil {
+		block.ReturnComments = comments.at(n)
+	}
+	block.EndComments = p.takeComments()
+}
+
+// A commentsBuilder collects the comments attached to a list of nodes (e.g.
+// statements in a block).  It only allocates if there are comments.
+type commentsBuilder struct {
+	items []ast.Comments
+}
+
+func (b *commentsBuilder) add(c ast.Comments, i int) {
+	if c.IsEmpty() {
+		return
+	}
+	for len(b.items) <= i {
+		b.items = append(b.items, ast.Comments{})
+	}
+	item := &b.items[i]
+	item.Leading = append(item.Leading, c.Leading...)
+	item.Trailing = append(item.Trailing, c.Trailing...)
+	item.BlankLineBefore = item.BlankLineBefore || c.BlankLineBefore
+}
+
+// get returns the comments for n nodes, or nil if there are none.
+func (b *commentsBuilder) get(n int) []ast.Comments {
+	if b.items == nil {
+		return nil
+	}
+	for len(b.items) < n {
+		b.items = append(b.items, ast.Comments{})
+	}
+	return b.items[:n]
+}
+
+func (b *commentsBuilder) at(i int) ast.Comments {
+	if i < len(b.items) {
+		return b.items[i]
+	}
+	return ast.Comments{}
+}
+
+// endLine returns the line where the token ends.
+func endLine(t *token.Token) int {
+	line := t.Line
+	lit := t.Lit
+	for i := 0; i < len(lit); i++ {
+		// Line endings can be "\n", "\r", "\r\n" or "\n\r".
+		if c := lit[i]; c == '\n' || c == '\r' {
+			line++
+			if i+1 < len(lit) && lit[i+1] == '\n'+'\r'-c {
+				i++
+			}
+		}
+	}
+	return line
+}
+
 func expectIdent(t *token.Token) {
 	expectType(t, token.IDENT, "name")
 }
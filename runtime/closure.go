@@ -8,19 +8,21 @@ type Closure struct {
 	*Code
 	Upvalues     []Cell
 	upvalueIndex int
+	memTracker   *memTracker // Memory charged for the upvalues (see memaccount.go)
 }
 
 var _ Callable = (*Closure)(nil)
 
 // NewClosure returns a pointer to a new Closure instance for the given code.
 func NewClosure(r *Runtime, c *Code) *Closure {
-	if c.UpvalueCount > 0 {
-		r.RequireArrSize(unsafe.Sizeof(Cell{}), int(c.UpvalueCount))
-	}
-	return &Closure{
+	clos := &Closure{
 		Code:     c,
 		Upvalues: make([]Cell, c.UpvalueCount),
 	}
+	if c.UpvalueCount > 0 {
+		r.requireTrackedMem(&clos.memTracker, uint64(unsafe.Sizeof(Cell{}))*uint64(c.UpvalueCount))
+	}
+	return clos
 }
 
 // Equals returns a true if it can assert that c and c1 implement the same
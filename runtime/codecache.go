@@ -0,0 +1,152 @@
+package runtime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/arnodel/golua/scanner"
+)
+
+// BytecodeVersion is the version of the format of compiled code.  It is part
+// of the keys of compiled chunks in a CodeCache, so that code compiled by a
+// different version of golua is never loaded.  It must be incremented when the
+// opcodes or the serialization format of compiled code change.
+const BytecodeVersion = 1
+
+// A CodeCache stores compiled Lua chunks so that they do not need to be
+// compiled again, e.g. when many short-lived runtimes load the same modules.
+// Keys are derived from the content of the source, its chunk name, the
+// optimisation level and BytecodeVersion.  Values are functions serialized with MarshalConst.
+//
+// Implementations must be safe for concurrent use if they are shared between
+// runtimes running concurrently.  Failing to store a value is not an error as
+// it just means the chunk will be compiled again next time.
+type CodeCache interface {
+	Get(key string) (data []byte, ok bool)
+	Put(key string, data []byte)
+}
+
+// codeCacheKey returns the key identifying a chunk in a CodeCache.
+func codeCacheKey(name string, source []byte, firstLineSkipped bool, optimisationLevel int) string {
+	h := sha256.New()
+	var hdr [13]byte
+	binary.LittleEndian.PutUint32(hdr[:], BytecodeVersion)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(name)))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(optimisationLevel))
+	if firstLineSkipped {
+		hdr[12] = 1
+	}
+	h.Write(hdr[:])
+	h.Write([]byte(name))
+	h.Write(source)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// compileAndLoadCachedLuaChunk is like CompileAndLoadLuaChunk but looks up
+// the compiled chunk in the runtime's code cache first, and stores it there
+// after compiling it.
+func (r *Runtime) compileAndLoadCachedLuaChunk(name string, source []byte, env Value, firstLineSkipped bool, scannerOptions ...scanner.Option) (*Closure, error) {
+	key := codeCacheKey(name, source, firstLineSkipped, r.optimisationLevel)
+	if data, ok := r.codeCache.Get(key); ok && HasMarshalPrefix(data) {
+		clos, err := r.loadMarshaledCode(data, env)
+		if err == nil {
+			return clos, nil
+		}
+		// The cached data is invalid, it will be replaced below.
+	}
+	clos, err := r.CompileAndLoadLuaChunk(name, source, env, scannerOptions...)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	used, err := MarshalConst(&buf, CodeValue(r.RefactorCodeConsts(clos.Code)), r.LinearUnused(10))
+	r.LinearRequire(10, used)
+	if err == nil {
+		r.codeCache.Put(key, buf.Bytes())
+	}
+	return clos, nil
+}
+
+// MemCodeCache is a CodeCache which keeps compiled chunks in memory.  It is
+// safe for concurrent use.
+type MemCodeCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+var _ CodeCache = (*MemCodeCache)(nil)
+
+// NewMemCodeCache returns a new empty MemCodeCache.
+func NewMemCodeCache() *MemCodeCache {
+	return &MemCodeCache{entries: map[string][]byte{}}
+}
+
+// Get implements CodeCache.Get.
+func (c *MemCodeCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+// Put implements CodeCache.Put.
+func (c *MemCodeCache) Put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = data
+}
+
+// DirCodeCache is a CodeCache which stores compiled chunks as files in a
+// directory.  It is safe for concurrent use, including by several processes.
+type DirCodeCache struct {
+	dir string
+}
+
+var _ CodeCache = (*DirCodeCache)(nil)
+
+// NewDirCodeCache returns a DirCodeCache storing files in dir, which is
+// created if it does not exist.
+func NewDirCodeCache(dir string) (*DirCodeCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirCodeCache{dir: dir}, nil
+}
+
+// Get implements CodeCache.Get.
+func (c *DirCodeCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put implements CodeCache.Put.  The file is written atomically so that
+// concurrent readers never see a partially written file.
+func (c *DirCodeCache) Put(key string, data []byte) {
+	f, err := ioutil.TempFile(c.dir, key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+func (c *DirCodeCache) path(key string) string {
+	return filepath.Join(c.dir, key+".luac")
+}
@@ -0,0 +1,99 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/ir"
+)
+
+// countingCodeCache wraps a CodeCache and counts cache hits and misses.
+type countingCodeCache struct {
+	CodeCache
+	hits, misses int
+}
+
+func (c *countingCodeCache) Get(key string) ([]byte, bool) {
+	data, ok := c.CodeCache.Get(key)
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return data, ok
+}
+
+const codeCacheTestSource = `#!/usr/bin/env golua
+local function add(x, y)
+    return x + y
+end
+local n = add(1, 2)
+return ("n=" .. n)() -- Error on line 6 as the first line is skipped
+`
+
+func runCachedChunk(t *testing.T, cache CodeCache, name string, source string, opts ...RuntimeOption) string {
+	t.Helper()
+	r := New(nil, append(opts, WithCodeCache(cache))...)
+	defer r.Close(nil)
+	clos, err := r.LoadFromSourceOrCode(name, []byte(source), "bt", TableValue(r.GlobalEnv()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Call1(r.MainThread(), FunctionValue(clos))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	return err.Error()
+}
+
+func testCodeCache(t *testing.T, cache CodeCache) {
+	c := &countingCodeCache{CodeCache: cache}
+	for i := 0; i < 3; i++ {
+		if msg := runCachedChunk(t, c, "test", codeCacheTestSource); msg != "error: test:6: attempt to call a string value" {
+			t.Fatalf("unexpected error: %q", msg)
+		}
+	}
+	if c.hits != 2 || c.misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %d and %d", c.hits, c.misses)
+	}
+
+	// The same source with a different name is cached separately.
+	if msg := runCachedChunk(t, c, "other", codeCacheTestSource); msg != "error: other:6: attempt to call a string value" {
+		t.Fatalf("unexpected error: %q", msg)
+	}
+	if c.misses != 2 {
+		t.Errorf("expected 2 misses, got %d", c.misses)
+	}
+
+	// Code compiled at a different optimisation level is cached separately.
+	if msg := runCachedChunk(t, c, "test", codeCacheTestSource, WithOptimisationLevel(ir.FullOptimisation)); msg != "error: test:6: attempt to call a string value" {
+		t.Fatalf("unexpected error: %q", msg)
+	}
+	if c.misses != 3 {
+		t.Errorf("expected 3 misses, got %d", c.misses)
+	}
+}
+
+func TestMemCodeCache(t *testing.T) {
+	testCodeCache(t, NewMemCodeCache())
+}
+
+func TestDirCodeCache(t *testing.T) {
+	cache, err := NewDirCodeCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCodeCache(t, cache)
+}
+
+func TestCodeCacheInvalidData(t *testing.T) {
+	cache := NewMemCodeCache()
+	key := codeCacheKey("test", []byte(strings.TrimPrefix(codeCacheTestSource, "#!/usr/bin/env golua\n")), true, 0)
+	cache.Put(key, []byte("invalid"))
+	if msg := runCachedChunk(t, cache, "test", codeCacheTestSource); msg != "error: test:6: attempt to call a string value" {
+		t.Fatalf("unexpected error: %q", msg)
+	}
+	if data, _ := cache.Get(key); !HasMarshalPrefix(data) {
+		t.Error("expected invalid data to be replaced")
+	}
+}
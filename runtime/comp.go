@@ -92,12 +92,8 @@ func eq(t *Thread, x, y Value) (bool, error) {
 	if res, ok := RawEqual(x, y); ok {
 		return res, nil
 	}
-	if _, ok := x.TryTable(); ok {
-		if _, ok := y.TryTable(); !ok {
-			return false, nil
-		}
-	} else {
-		// TODO: deal with UserData
+	// The __eq metamethod is only tried for two tables or two userdata.
+	if tp := x.Type(); (tp != TableType && tp != UserDataType) || tp != y.Type() {
 		return false, nil
 	}
 	res, err, ok := metabin(t, "__eq", x, y)
@@ -1,6 +1,8 @@
 package runtime
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"syscall"
 )
@@ -69,12 +71,32 @@ func (r *Runtime) PushIoError(c Cont, ioErr error) error {
 	}
 	r.Push1(c, NilValue)
 	r.Push1(c, StringValue(ioErr.Error()))
-	if errno, ok := err.(syscall.Errno); ok {
+	if errno, ok := ioErrno(err); ok {
 		r.Push1(c, IntValue(int64(errno)))
 	}
 	return nil
 }
 
+// ioErrno returns the errno corresponding to err.  Errors which are not
+// syscall.Errno values (e.g. errors from a virtual filesystem) are mapped to
+// an errno when they match one of the generic fs errors.
+func ioErrno(err error) (syscall.Errno, bool) {
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno, true
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT, true
+	case errors.Is(err, fs.ErrExist):
+		return syscall.EEXIST, true
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES, true
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL, true
+	}
+	return 0, false
+}
+
 // ProcessIoError is like PushIoError but its signature makes it convenient to
 // use in a return statement from a GoFunc implementation.
 func (r *Runtime) ProcessIoError(c Cont, ioErr error) (Cont, error) {
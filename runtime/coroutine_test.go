@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"runtime"
+	"testing"
+)
+
+// coroutineRuntime returns a runtime providing minimal create, resume and
+// yield functions, so that coroutines can be tested without the coroutine
+// library.
+func coroutineRuntime(goroutines bool) (*Runtime, *Table) {
+	r := New(nil)
+	r.coroutineGoroutines = goroutines
+	env := NewTable()
+	create := func(t *Thread, c *GoCont) (Cont, error) {
+		f, err := c.CallableArg(0)
+		if err != nil {
+			return nil, err
+		}
+		co := NewThread(t.Runtime)
+		co.Start(f)
+		return c.PushingNext1(t.Runtime, ThreadValue(co)), nil
+	}
+	resume := func(t *Thread, c *GoCont) (Cont, error) {
+		co, err := c.ThreadArg(0)
+		if err != nil {
+			return nil, err
+		}
+		res, err := co.Resume(t, c.Etc())
+		if err != nil {
+			return nil, err
+		}
+		return c.PushingNext(t.Runtime, res...), nil
+	}
+	yield := func(t *Thread, c *GoCont) (Cont, error) {
+		return t.YieldCont(c.Next(), c.Etc())
+	}
+	setmetatable := func(t *Thread, c *GoCont) (Cont, error) {
+		t.SetRawMetatable(c.Arg(0), c.Arg(1).AsTable())
+		return c.PushingNext1(t.Runtime, c.Arg(0)), nil
+	}
+	SolemnlyDeclareRestartable(
+		r.SetEnvGoFunc(env, "setmetatable", setmetatable, 2, false),
+		r.SetEnvGoFunc(env, "create", create, 1, false),
+		r.SetEnvGoFunc(env, "resume", resume, 1, true),
+		r.SetEnvGoFunc(env, "yield", yield, 0, true),
+	)
+	return r, env
+}
+
+func runCoroutineCode(tb testing.TB, goroutines bool, src string) Value {
+	r, env := coroutineRuntime(goroutines)
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), TableValue(env))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	v, err := Call1(r.MainThread(), FunctionValue(clos))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return v
+}
+
+func TestSuspendedCoroutinesHaveNoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	v := runCoroutineCode(t, false, `
+local cos = {}
+for i = 1, 100 do
+	cos[i] = create(function(x) yield(x + 1) end)
+	resume(cos[i], i)
+end
+return cos`)
+	if n := runtime.NumGoroutine() - before; n >= 100 {
+		t.Errorf("%d goroutines for suspended coroutines", n)
+	}
+	if n := v.AsTable().Len(); n != 100 {
+		t.Errorf("expected 100 coroutines, got %d", n)
+	}
+}
+
+func TestCoroutineModes(t *testing.T) {
+	const src = `
+local obj = setmetatable({}, {__index = function(t, k) return yield(k) end})
+local co = create(function(n)
+	local s = 0
+	for i = 1, n do
+		s = s + yield(i)
+	end
+	return s + obj.x
+end)
+local s = resume(co, 5)
+while s ~= "x" do
+	s = resume(co, s * 10)
+end
+return resume(co, 1000)`
+	for _, goroutines := range []bool{false, true} {
+		if v := runCoroutineCode(t, goroutines, src); v.AsInt() != 1150 {
+			t.Errorf("goroutines=%t: expected 1150, got %v", goroutines, v)
+		}
+	}
+}
+
+// Compare the performance of coroutines running without a goroutine with how
+// they used to work (always in a goroutine of their own).
+func benchmarkCoroutines(b *testing.B, src string) {
+	for _, mode := range []struct {
+		name       string
+		goroutines bool
+	}{{"inline", false}, {"goroutine", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			r, env := coroutineRuntime(mode.goroutines)
+			clos, err := r.CompileAndLoadLuaChunk("bench", []byte(src), TableValue(env))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f, err := Call1(r.MainThread(), FunctionValue(clos))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			if _, err := Call1(r.MainThread(), f, IntValue(int64(b.N))); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func BenchmarkCoroutineResumeYield(b *testing.B) {
+	benchmarkCoroutines(b, `
+local co = create(function()
+	while true do yield(1) end
+end)
+return function(n)
+	for i = 1, n do resume(co) end
+end`)
+}
+
+func BenchmarkCoroutineCreate(b *testing.B) {
+	benchmarkCoroutines(b, `
+local function f(x) return x end
+return function(n)
+	for i = 1, n do resume(create(f), i) end
+end`)
+}
+
+func BenchmarkCoroutineGenerator(b *testing.B) {
+	benchmarkCoroutines(b, `
+local function range(n)
+	local co = create(function()
+		for i = 1, n do yield(i) end
+	end)
+	return function() return resume(co) end
+end
+return function(n)
+	local s = 0
+	for i in range(n) do s = s + i end
+	return s
+end`)
+}
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/coverage"
+)
+
+// A coverageRecorder records which lines of Lua code are executed and which
+// way conditional jumps go.
+//
+// Counts are kept per instruction of each function and only turned into line
+// and branch counts when the recording stops.  When a function is executed
+// for the first time, all the functions in the same compilation unit are
+// registered so that the lines which are never executed are known.
+type coverageRecorder struct {
+	codes   map[*Code]*codeCoverage
+	threads []*Thread // Threads with coverage enabled
+
+	// Cache the last looked up code as it is likely to be looked up again.
+	lastCode *Code
+	lastCov  *codeCoverage
+}
+
+// codeCoverage contains the counts for the instructions of a function.
+type codeCoverage struct {
+	counts []pcCounts // One entry per instruction
+}
+
+type pcCounts struct {
+	line     int64 // Times execution entered a new line at this instruction
+	notTaken int64 // For conditional jumps, times the jump was not taken
+	taken    int64 // For conditional jumps, times the jump was taken
+}
+
+// StartCoverage starts recording the code coverage of the Lua code running in
+// the runtime.  StopCoverage returns the coverage profile.
+//
+// Threads running when the recording is started are not covered, except for
+// the main thread and the thread running finalizers.
+func (r *Runtime) StartCoverage() error {
+	if r.cover != nil {
+		return errors.New("coverage already enabled")
+	}
+	r.cover = &coverageRecorder{codes: map[*Code]*codeCoverage{}}
+	r.cover.enable(r.mainThread)
+	r.cover.enable(r.gcThread)
+	return nil
+}
+
+// StopCoverage stops recording the code coverage and returns the profile
+// recorded since StartCoverage was called (nil if coverage was not enabled).
+func (r *Runtime) StopCoverage() *coverage.Profile {
+	cr := r.cover
+	if cr == nil {
+		return nil
+	}
+	r.cover = nil
+	for _, t := range cr.threads {
+		t.DebugHookFlags &= ^hookFlagCoverage
+	}
+	return cr.profile()
+}
+
+// enable turns on coverage in the thread.
+func (cr *coverageRecorder) enable(t *Thread) {
+	t.DebugHookFlags |= hookFlagCoverage
+	cr.threads = append(cr.threads, t)
+}
+
+func (cr *coverageRecorder) get(c *Code) *codeCoverage {
+	if c == cr.lastCode {
+		return cr.lastCov
+	}
+	cov, ok := cr.codes[c]
+	if !ok {
+		cr.register(c)
+		cov = cr.codes[c]
+	}
+	cr.lastCode, cr.lastCov = c, cov
+	return cov
+}
+
+// register registers c and the functions whose code is in its constants.
+func (cr *coverageRecorder) register(c *Code) {
+	if _, ok := cr.codes[c]; ok {
+		return
+	}
+	cr.codes[c] = &codeCoverage{counts: make([]pcCounts, len(c.code))}
+	for _, k := range c.consts {
+		if kc, ok := k.TryCode(); ok {
+			cr.register(kc)
+		}
+	}
+}
+
+// profile returns the recorded coverage as a profile.
+func (cr *coverageRecorder) profile() *coverage.Profile {
+	codes := make([]*Code, 0, len(cr.codes))
+	for c := range cr.codes {
+		codes = append(codes, c)
+	}
+	// Sort functions so that branches are numbered consistently.
+	sort.Slice(codes, func(i, j int) bool {
+		x, y := codes[i], codes[j]
+		if x.source != y.source {
+			return x.source < y.source
+		}
+		if x.lineDefined != y.lineDefined {
+			return x.lineDefined < y.lineDefined
+		}
+		return x.lastLineDefined < y.lastLineDefined
+	})
+	p := coverage.NewProfile()
+	var (
+		f      *coverage.File
+		blocks map[int]int // Number of conditional jumps seen so far on a line
+	)
+	for _, c := range codes {
+		if f == nil || f.Name != c.source {
+			f = p.File(c.source)
+			blocks = map[int]int{}
+		}
+		cov := cr.codes[c]
+		for pc, line := range c.lines {
+			if line <= 0 {
+				continue
+			}
+			l := int(line)
+			counts := cov.counts[pc]
+			f.AddLine(l, counts.line)
+			if opcode := c.code[pc]; opcode.TypePfx() == code.Type5Pfx && opcode.GetJ() == code.OpJumpIf {
+				block := blocks[l]
+				blocks[l]++
+				f.AddBranch(coverage.BranchID{Line: l, Block: block, Branch: 0}, counts.notTaken)
+				f.AddBranch(coverage.BranchID{Line: l, Block: block, Branch: 1}, counts.taken)
+			}
+		}
+	}
+	return p
+}
+
+// coverLine records that execution entered a new line at instruction pc of
+// c.  It must only be called if coverage is enabled in the thread.
+func (t *Thread) coverLine(c *Code, pc int16) {
+	if cr := t.cover; cr != nil {
+		cr.get(c).counts[pc].line++
+	}
+}
+
+// coverBranch records whether the conditional jump at instruction pc of c is
+// taken.  It must only be called if coverage is enabled in the thread.
+func (t *Thread) coverBranch(c *Code, pc int16, taken bool) {
+	if cr := t.cover; cr != nil {
+		counts := &cr.get(c).counts[pc]
+		if taken {
+			counts.taken++
+		} else {
+			counts.notTaken++
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arnodel/golua/coverage"
+)
+
+func TestCoverage(t *testing.T) {
+	r := New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("cov", []byte(`
+local function sign(n)
+    if n < 0 then
+        return -1
+    end
+    return 1
+end
+local function unused()
+    return 0
+end
+local s = 0
+for i = 1, 3 do
+    s = s + sign(i)
+end
+return s
+`), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartCoverage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartCoverage(); err == nil {
+		t.Error("expected coverage to be already started")
+	}
+	if _, err := Call1(r.MainThread(), FunctionValue(clos)); err != nil {
+		t.Fatal(err)
+	}
+	prof := r.StopCoverage()
+	if r.MainThread().DebugHookFlags&hookFlagCoverage != 0 {
+		t.Error("coverage still enabled in the main thread")
+	}
+	if r.StopCoverage() != nil {
+		t.Error("expected nil profile when coverage is not enabled")
+	}
+
+	f := prof.Files["cov"]
+	if f == nil || len(prof.Files) != 1 {
+		t.Fatalf("unexpected files in profile: %v", prof.Files)
+	}
+	expectedLines := map[int]int64{2: 1, 3: 3, 4: 0, 6: 3, 8: 1, 9: 0, 11: 1, 12: 4, 13: 3, 15: 1}
+	if !reflect.DeepEqual(f.Lines, expectedLines) {
+		t.Errorf("expected lines %v, got %v", expectedLines, f.Lines)
+	}
+	expectedBranches := map[coverage.BranchID]int64{
+		{Line: 3, Block: 0, Branch: 0}: 0, // Jump not taken: n < 0
+		{Line: 3, Block: 0, Branch: 1}: 3, // Jump over the if body
+	}
+	if !reflect.DeepEqual(f.Branches, expectedBranches) {
+		t.Errorf("expected branches %v, got %v", expectedBranches, f.Branches)
+	}
+}
@@ -8,9 +8,64 @@ type DebugInfo struct {
 	Source      string
 	Name        string
 	CurrentLine int32
+
+	// The fields below are mostly there to implement debug.getinfo.
+
+	What            string // "Lua", "main" or "C" (Go functions are reported as "C" for compatibility with Lua code)
+	LineDefined     int32  // Line where the function definition starts (-1 for Go functions)
+	LastLineDefined int32  // Line where the function definition ends (-1 for Go functions)
+	NUpvalues       int    // Number of upvalues of the function
+	NParams         int    // Number of named parameters of the function
+	IsVararg        bool   // True if the function accepts a variable number of arguments
+	IsTailCall      bool   // True if the continuation was started by a tail call
+	Function        Value  // The function that the continuation is running
 }
 
 // String formats the data contained in DebugInfo in a human-readable way.
 func (i DebugInfo) String() string {
 	return fmt.Sprintf("file=%s func=%s line=%d", i.Source, i.Name, i.CurrentLine)
 }
+
+// DebugInfo returns debug info about the closure (there is no current line so
+// CurrentLine is -1).
+func (c *Closure) DebugInfo() *DebugInfo {
+	name := c.name
+	if name == "" {
+		name = "<lua function>"
+	}
+	what := "Lua"
+	if c.lineDefined == 0 {
+		what = "main"
+	}
+	return &DebugInfo{
+		Source:          c.source,
+		Name:            name,
+		CurrentLine:     -1,
+		What:            what,
+		LineDefined:     c.lineDefined,
+		LastLineDefined: c.lastLineDefined,
+		NUpvalues:       int(c.UpvalueCount),
+		NParams:         int(c.ParamCount),
+		IsVararg:        c.IsVararg,
+		Function:        FunctionValue(c),
+	}
+}
+
+// DebugInfo returns debug info about the Go function.
+func (f *GoFunction) DebugInfo() *DebugInfo {
+	name := f.name
+	if name == "" {
+		name = "<go function>"
+	}
+	return &DebugInfo{
+		Source:          "[Go]",
+		Name:            name,
+		CurrentLine:     -1,
+		What:            "C",
+		LineDefined:     -1,
+		LastLineDefined: -1,
+		NParams:         f.nArgs,
+		IsVararg:        f.hasEtc,
+		Function:        FunctionValue(f),
+	}
+}
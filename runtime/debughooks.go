@@ -18,11 +18,13 @@ approach.
 type DebugHookFlags uint8
 
 const (
-	hookFlagInHook DebugHookFlags = 1 << iota // This flag allows knowing when we are in hook callback
-	HookFlagCall                              // call hook
-	HookFlagReturn                            // return hook
-	HookFlagLine                              // line hook
-	HookFlagCount                             // count hook
+	hookFlagInHook   DebugHookFlags = 1 << iota // This flag allows knowing when we are in hook callback
+	HookFlagCall                                // call hook
+	HookFlagReturn                              // return hook
+	HookFlagLine                                // line hook
+	HookFlagCount                               // count hook
+	hookFlagProfile                             // Lua profiling is enabled (see profile.go)
+	hookFlagCoverage                            // Code coverage is enabled (see coverage.go)
 )
 
 // DebugHooks contains data specifying a debug hooks configuration.
@@ -30,6 +32,9 @@ type DebugHooks struct {
 	DebugHookFlags DebugHookFlags // hooks enabled
 	HookLineCount  int            // number of lines for count hook
 	Hook           Value          // The hook callback
+
+	hookCont  Cont  // When in a hook callback, the continuation standing for the hooked function
+	hookEvent Value // When in a hook callback, the event that triggered it
 }
 
 func (h *DebugHooks) callHook(t *Thread, c Cont, args ...Value) error {
@@ -37,18 +42,47 @@ func (h *DebugHooks) callHook(t *Thread, c Cont, args ...Value) error {
 		return nil
 	}
 	h.DebugHookFlags |= hookFlagInHook
-	defer func() { h.DebugHookFlags &= ^hookFlagInHook }()
 	term := NewTerminationWith(c, 0, false)
+	h.hookCont, h.hookEvent = term, args[0]
+	defer func() {
+		h.DebugHookFlags &= ^hookFlagInHook
+		h.hookCont, h.hookEvent = nil, NilValue
+	}()
 	return Call(t, h.Hook, args, term)
 }
 
+// TransferInfo returns the index of the first value transferred and the number
+// of values transferred to the continuation c, if c is the function for which a
+// call hook is being run.  Otherwise it returns 0, 0.  This is what
+// debug.getinfo reports as ftransfer and ntransfer.
+//
+// Values returned by functions are not available to return hooks so they are
+// not reported.
+func (h *DebugHooks) TransferInfo(c Cont) (ftransfer, ntransfer int) {
+	if c == nil || c != h.hookCont {
+		return 0, 0
+	}
+	if h.hookEvent != callHookString && h.hookEvent != tailCallHookString {
+		return 0, 0
+	}
+	switch cc := h.hookCont.(*Termination).parent.(type) {
+	case *LuaCont:
+		return 1, int(cc.ParamCount)
+	case *GoCont:
+		return 1, cc.NArgs() + len(cc.Etc())
+	}
+	return 0, 0
+}
+
 // SetupHooks configures the debug hooks to use.  It does nothing if we are in a
 // hook callback.
 func (h *DebugHooks) SetupHooks(newHooks DebugHooks) {
 	if h.DebugHookFlags&hookFlagInHook != 0 {
 		return
 	}
+	internal := h.DebugHookFlags & (hookFlagProfile | hookFlagCoverage)
 	*h = newHooks
+	h.DebugHookFlags |= internal
 }
 
 var (
@@ -0,0 +1,76 @@
+package runtime
+
+import "time"
+
+// In deterministic mode (see WithDeterministicMode), the runtime removes the
+// sources of non-determinism which are under its control, so that running the
+// same code with the same inputs produces the same outputs.  This is useful to
+// replay the execution of sandboxed code.
+//
+//   - The time is given by a virtual clock which advances with the CPU ticks
+//     consumed (see Runtime.Now).
+//   - The PRNG is seeded with a fixed value.
+//   - Tables iterate over their keys in insertion order (only in runtimes
+//     created with WithDeterministicMode, as this changes tables for good).
+//   - The memory count is the memory required from the runtime context rather
+//     than the memory allocated by Go (see Runtime.UsedMemory).
+//   - Lua finalizers only run when the runtime context is popped or the runtime
+//     is closed, and the '__mode' metafield is ignored (also only in runtimes
+//     created with WithDeterministicMode).
+
+// VirtualClockStart is the time at which the virtual clock of deterministic
+// runtimes starts.
+var VirtualClockStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// VirtualTickDuration is how much the virtual clock of deterministic runtimes
+// advances for each CPU tick consumed.
+const VirtualTickDuration = time.Microsecond
+
+// WithDeterministicMode makes the runtime deterministic.  All the code it runs
+// must comply with ComplyDetSafe.  Unless WithRandSeed or WithRandSource is
+// also given, the PRNG is seeded with (0, 0).
+func WithDeterministicMode() RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.deterministic = true
+	}
+}
+
+// Deterministic returns true if the runtime is deterministic, i.e. if it was
+// created with WithDeterministicMode or if the current runtime context requires
+// ComplyDetSafe.  In the latter case, tables keep their usual iteration order
+// (see TablesOrdered).
+func (r *Runtime) Deterministic() bool {
+	return r.deterministic || r.RequiredFlags()&ComplyDetSafe != 0
+}
+
+// TablesOrdered returns true if the runtime makes tables iterate over their keys
+// in insertion order (see SetTable), i.e. if it was created with
+// WithDeterministicMode.  Otherwise iterating over a table is not
+// deterministic, even in a context which requires ComplyDetSafe.
+func (r *Runtime) TablesOrdered() bool {
+	return r.deterministic
+}
+
+// Now returns the current time.  If the runtime is deterministic, it is the
+// virtual time, which is VirtualClockStart plus VirtualTickDuration for each
+// CPU tick consumed so far.
+func (r *Runtime) Now() time.Time {
+	if !r.Deterministic() {
+		return time.Now()
+	}
+	return VirtualClockStart.Add(time.Duration(r.CPUTicks()) * VirtualTickDuration)
+}
+
+// CPUTicks returns the number of CPU ticks consumed so far, in the current
+// runtime context and its parents.  It is always 0 when the noquotas build tag
+// is set.
+func (r *Runtime) CPUTicks() uint64 {
+	return r.totalUsedResources().Cpu
+}
+
+// UsedMemory returns the amount of memory required so far, in the current
+// runtime context and its parents.  It is always 0 when the noquotas build tag
+// is set.
+func (r *Runtime) UsedMemory() uint64 {
+	return r.totalUsedResources().Memory
+}
@@ -13,6 +13,8 @@ type GoCont struct {
 	args  []Value
 	etc   *[]Value
 	nArgs int
+
+	tailCall bool // true if c was started by a tail call
 }
 
 var _ Cont = (*GoCont)(nil)
@@ -101,12 +103,16 @@ func (c *GoCont) RunInThread(t *Thread) (next Cont, err error) {
 		return nil, errors.New("stack overflow")
 	}
 	next, err = c.f(t, c)
+	if t.areFlagsEnabled(hookFlagProfile) {
+		t.profileSample(c)
+	}
 	_ = t.triggerReturn(t, c)
 
-	if err != nil {
+	if err != nil || t.yielding {
 		// If there is an error, c is still potentially needed for error
 		// handling, so do not return it to the pool.  It will get GCed when no
-		// longer referenced, so it's OK.
+		// longer referenced, so it's OK.  Likewise if the thread is being
+		// suspended, c stays its current continuation.
 		return
 	}
 	if c.args != nil {
@@ -130,15 +136,9 @@ func (c *GoCont) Parent() Cont {
 
 // DebugInfo returns c's debug info.
 func (c *GoCont) DebugInfo() *DebugInfo {
-	name := c.name
-	if name == "" {
-		name = "<go function>"
-	}
-	return &DebugInfo{
-		Source:      "[Go]",
-		CurrentLine: 0,
-		Name:        name,
-	}
+	info := c.GoFunction.DebugInfo()
+	info.IsTailCall = c.tailCall
+	return info
 }
 
 // NArgs returns the number of args pushed to the continuation.
@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCancelGracePeriod is the time given to Lua code to stop after a soft
+// stop caused by the cancellation of a Go context, before it is hard-stopped
+// (see Thread.CallWithContext).
+const DefaultCancelGracePeriod = 100 * time.Millisecond
+
+// CallWithContext is like CallContext, except that the runtime context is
+// stopped when the Go context ctx is done (e.g. because it is cancelled or its
+// deadline has passed).
+//
+// When ctx is done, the runtime context is first soft-stopped, so that Lua code
+// which checks runtime.contextdue() can finish cleanly.  If it is still running
+// after the grace period (see WithCancelGracePeriod), it is hard-stopped:
+// execution is terminated, blocking IO registered with OnCancel is interrupted
+// and the returned error is a ContextTerminationError which wraps ctx.Err(), so
+// errors.Is(err, context.Canceled) or errors.Is(err,
+// context.DeadlineExceeded) can be used to tell it apart from other errors.
+//
+// If ctx is already done, f is run in a hard-stopped context so will be
+// terminated as soon as it requires CPU.
+//
+// When quotas are not available (see QuotasAvailable), Lua code cannot be
+// stopped and ctx is ignored.
+func (t *Thread) CallWithContext(ctx context.Context, def RuntimeContextDef, f func() error) (RuntimeContext, error) {
+	if ctx.Done() == nil || !QuotasAvailable {
+		return t.CallContext(def, f)
+	}
+	s := new(stopSignal)
+	if err := ctx.Err(); err != nil {
+		s.stop(SoftStop|HardStop, err)
+	} else {
+		done := make(chan struct{})
+		defer close(done)
+		go s.watch(ctx, t.cancelGracePeriod, done)
+	}
+	return t.CallContext(def, func() error {
+		t.setStopSignal(s)
+		err := f()
+		// If f was interrupted while blocking, it may have returned normally.
+		t.pollStopSignal()
+		return err
+	})
+}
+
+// OnCancel arranges for interrupt to be called if the running code is
+// hard-stopped because the Go context passed to CallWithContext is done.  It is
+// meant for Go functions which may block (typically on IO), so that they can
+// return early.  The interrupt function is called from another goroutine, or
+// immediately if the code is already hard-stopped.
+//
+// The returned release function must be called when the blocking operation is
+// over.  It reports whether interrupt was called, and after it returns it is
+// guaranteed that interrupt is not running and will not be called.
+func (r *Runtime) OnCancel(interrupt func()) (release func() bool) {
+	s := r.stopSignal()
+	if s == nil {
+		return noRelease
+	}
+	return s.onStop(interrupt)
+}
+
+func noRelease() bool {
+	return false
+}
+
+// A stopSignal is how a goroutine watching a Go context tells the runtime to
+// stop.  The runtime polls the level when it requires CPU.
+type stopSignal struct {
+	level uint32 // Accessed atomically, a StopLevel
+
+	mu      sync.Mutex
+	err     error // Why the runtime is stopped (set before level)
+	stopped bool  // True when the interrupt functions have been called
+	hooks   map[*func()]struct{}
+}
+
+// load returns the current stop level and the error that caused it.
+func (s *stopSignal) load() (StopLevel, error) {
+	lvl := StopLevel(atomic.LoadUint32(&s.level))
+	if lvl == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return lvl, s.err
+}
+
+func (s *stopSignal) stop(lvl StopLevel, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	atomic.StoreUint32(&s.level, atomic.LoadUint32(&s.level)|uint32(lvl))
+	if lvl&HardStop != 0 && !s.stopped {
+		s.stopped = true
+		for h := range s.hooks {
+			(*h)()
+		}
+		s.hooks = nil
+	}
+}
+
+func (s *stopSignal) watch(ctx context.Context, grace time.Duration, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	if grace > 0 {
+		s.stop(SoftStop, ctx.Err())
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+	}
+	s.stop(SoftStop|HardStop, ctx.Err())
+}
+
+func (s *stopSignal) onStop(interrupt func()) func() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		interrupt()
+		return func() bool { return true }
+	}
+	if s.hooks == nil {
+		s.hooks = map[*func()]struct{}{}
+	}
+	h := &interrupt
+	s.hooks[h] = struct{}{}
+	return func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.hooks, h)
+		return s.stopped
+	}
+}
@@ -0,0 +1,115 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func runWithContext(ctx context.Context, src string, opts ...RuntimeOption) (Value, error) {
+	r := New(nil, opts...)
+	defer r.Close(nil)
+	env := NewTable()
+	due := func(t *Thread, c *GoCont) (Cont, error) {
+		return c.PushingNext1(t.Runtime, BoolValue(t.RuntimeContext().Due())), nil
+	}
+	r.SetEnvGoFunc(env, "due", due, 0, false)
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), TableValue(env))
+	if err != nil {
+		return NilValue, err
+	}
+	return Call1WithContext(ctx, r.MainThread(), FunctionValue(clos))
+}
+
+func TestCallWithContextTimeout(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := runWithContext(ctx, `while true do end`, WithCancelGracePeriod(10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	var termErr ContextTerminationError
+	if !errors.As(err, &termErr) {
+		t.Errorf("expected a ContextTerminationError, got %T", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("took too long to stop: %s", d)
+	}
+}
+
+func TestCallWithContextAlreadyCancelled(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := runWithContext(ctx, `while true do end`)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+}
+
+func TestCallWithContextSoftStop(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	v, err := runWithContext(ctx, `
+local n = 0
+while not due() do n = n + 1 end
+return "stopped"`, WithCancelGracePeriod(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := v.TryString(); s != "stopped" {
+		t.Errorf("unexpected result: %v", v)
+	}
+}
+
+func TestCallWithContextNotCancelled(t *testing.T) {
+	v, err := runWithContext(context.Background(), `return 1 + 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AsInt() != 3 {
+		t.Errorf("unexpected result: %v", v)
+	}
+	_, err = runWithContext(context.Background(), `error("boom")`)
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("expected a Lua error, got %v", err)
+	}
+}
+
+func TestOnCancel(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	r := New(nil, WithCancelGracePeriod(0))
+	defer r.Close(nil)
+	if release := r.OnCancel(func() { t.Error("should not be called") }); release() {
+		t.Error("interrupted outside of CallWithContext")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := make(chan struct{})
+	var released bool
+	_, err := r.MainThread().CallWithContext(ctx, RuntimeContextDef{}, func() error {
+		release := r.OnCancel(func() { close(interrupted) })
+		cancel()
+		<-interrupted // This would block forever without the interruption
+		released = release()
+		r.RequireCPU(1)
+		return nil
+	})
+	if !released {
+		t.Error("release should report the interruption")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+}
@@ -9,6 +9,7 @@ type GoFunction struct {
 	name        string
 	nArgs       int
 	hasEtc      bool
+	restartable bool
 }
 
 var _ Callable = (*GoFunction)(nil)
@@ -47,3 +48,21 @@ func SolemnlyDeclareCompliance(flags ComplianceFlags, fs ...*GoFunction) {
 		f.SolemnlyDeclareCompliance(flags)
 	}
 }
+
+// SolemnlyDeclareRestartable marks f as restartable: it makes at most one call
+// back into Lua code in the thread running it (e.g. via a metamethod), and it
+// has no side effects before that call.  Coroutines can run such functions
+// without a goroutine of their own because if the call back needs one after
+// all, f can be abandoned and run again from the start in a new goroutine (see
+// Thread.Resume).
+func (f *GoFunction) SolemnlyDeclareRestartable() {
+	f.restartable = true
+}
+
+// SolemnlyDeclareRestartable is a convenience function that declares a number
+// of functions restartable.
+func SolemnlyDeclareRestartable(fs ...*GoFunction) {
+	for _, f := range fs {
+		f.SolemnlyDeclareRestartable()
+	}
+}
@@ -21,6 +21,12 @@ const uintptrLen = 8 * unsafe.Sizeof(uintptr(0))
 type mixedTable struct {
 	*hashTable
 	*array
+
+	// Incremented each time the hash table part is modified, so that inline
+	// caches can tell that what they recorded about the table is still valid.
+	version uint64
+
+	ephemerons ephemerons // Values associated with the table in weak tables
 }
 
 // Return v such that k => v, else return nil.
@@ -50,6 +56,7 @@ func (t *mixedTable) insert(k, v Value) {
 	if ok {
 		k = IntValue(i)
 	}
+	t.version++
 	t.hashTable.set(k, v)
 }
 
@@ -66,7 +73,11 @@ func (t *mixedTable) reset(k, v Value) (wasSet bool) {
 	if ok {
 		k = IntValue(i)
 	}
-	return t.hashTable.reset(k, v)
+	wasSet = t.hashTable.reset(k, v)
+	if wasSet {
+		t.version++
+	}
+	return
 }
 
 // Set k => nil, return true if there was v such that k => v.
@@ -78,8 +89,54 @@ func (t *mixedTable) remove(k Value) (wasSet bool) {
 		}
 		k = IntValue(i)
 	}
+	wasSet = t.hashTable.removeKey(k)
+	if wasSet {
+		t.version++
+	}
+	return
+}
+
+// hashSlot returns the index of the slot containing k in the hash table part,
+// or -1 if there is none.  It is only useful for keys which cannot be stored in
+// the array part (e.g. strings).
+func (t *mixedTable) hashSlot(k Value) int {
+	if t.hashTable == nil {
+		return -1
+	}
+	it, i := findSlot(t.hashTable.slots, (1<<t.hashTable.base)-1, k)
+	if it == nil {
+		return -1
+	}
+	return int(i)
+}
 
-	return t.hashTable.removeKey(k)
+// slotValue returns the value in the given slot of the hash table part if its
+// key is k, otherwise it returns nil.
+func (t *mixedTable) slotValue(slot int, k Value) Value {
+	if t.hashTable == nil || slot >= len(t.hashTable.slots) {
+		return NilValue
+	}
+	it := &t.hashTable.slots[slot]
+	if !it.key.Equals(k) {
+		return NilValue
+	}
+	return it.value
+}
+
+// resetSlot sets the value in the given slot of the hash table part to v,
+// provided its key is k and its value is not nil.  It returns true if that is
+// the case.
+func (t *mixedTable) resetSlot(slot int, k, v Value) bool {
+	if t.hashTable == nil || slot >= len(t.hashTable.slots) {
+		return false
+	}
+	it := &t.hashTable.slots[slot]
+	if it.value.IsNil() || !it.key.Equals(k) {
+		return false
+	}
+	it.value = v
+	t.version++
+	return true
 }
 
 // Return the "length" of the table, which is a positive integer such i => v but
@@ -150,6 +207,8 @@ func (t *mixedTable) next(k Value) (next Value, v Value, ok bool) {
 func (t *mixedTable) grow() {
 	var idxCountByLen [uintptrLen]uintptr
 
+	t.version++
+
 	// Classify the keys in the hashtable
 	idxCount := t.hashTable.classifyIndices(&idxCountByLen)
 
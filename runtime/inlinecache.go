@@ -0,0 +1,271 @@
+package runtime
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/arnodel/golua/code"
+)
+
+// Inline caches speed up table lookups with constant string keys in Lua code,
+// e.g. obj.field or obj:method().  Each opcode looking up or setting a table
+// index has its own cache, which remembers where the value was found the last
+// time.
+//
+// - If the value was found in the table itself, the cache records the slot of
+//   the hash table containing the key.  Tables built the same way have the same
+//   layout, so this works for all the instances of a "class".  The key is
+//   checked each time as the table may be different.
+//
+// - If the value was found by following the chain of '__index' tables (up to
+//   maxCachedIndexHops of them), the cache records the metatables and
+//   '__index' tables of the chain with their version (which changes each time
+//   a table is modified), and the value found.  The lookup in the table itself
+//   still takes place, but not the lookups in the '__index' chain.
+//
+// Values found in weak tables are not cached, as they can disappear without
+// the tables being modified.
+//
+// A cache is only valid for one runtime.  The caches of code owned by a
+// runtime are kept in the Code, whereas code shared by several runtimes (see
+// Program) gets caches for each runtime.
+//
+// Caches consume the same amount of CPU as the lookups they save.
+
+// Maximum number of '__index' tables followed by a cache.
+const maxCachedIndexHops = 2
+
+// When a cache has been refilled that many times, the opcode is no longer
+// cached (e.g. its key is not a constant).
+const maxIndexCacheMisses = 16
+
+// An indexCache is the inline cache of a table lookup or setting opcode.
+type indexCache struct {
+	key    Value // Key of the lookup (a string), nil if the cache is empty
+	slot   int   // Slot containing the key in the hash table, if hops == 0
+	hops   int   // Number of '__index' tables to follow
+	misses int   // Number of times the cache was (re)filled
+
+	chain [maxCachedIndexHops]indexCacheHop
+	value Value // Value found at the end of the chain, if hops > 0
+}
+
+// An indexCacheHop records how an '__index' table was reached.
+type indexCacheHop struct {
+	meta        *Table // Metatable containing the '__index' field
+	metaVersion uint64
+	table       *Table // Value of the '__index' field
+	version     uint64
+}
+
+// initIndexSites gives an index to each table lookup / setting opcode, which
+// is the index of its cache, and allocates the caches.  The caller is
+// responsible for accounting for their memory (see indexCachesSize).
+func (c *Code) initIndexSites() {
+	c.indexSites = make([]uint16, len(c.code))
+	c.indexSiteCount = 0
+	for pc, op := range c.code {
+		if op.TypePfx() == code.Type2Pfx {
+			c.indexSites[pc] = uint16(c.indexSiteCount)
+			c.indexSiteCount++
+		}
+	}
+	if c.indexSiteCount > 0 {
+		c.indexCaches = make([]indexCache, c.indexSiteCount)
+	}
+}
+
+// indexCachesSize returns the amount of memory needed for the inline caches
+// and their indexes for the given opcodes.
+func indexCachesSize(opcodes []code.Opcode) uint64 {
+	var n uint64
+	for _, op := range opcodes {
+		if op.TypePfx() == code.Type2Pfx {
+			n++
+		}
+	}
+	return 2*uint64(len(opcodes)) + n*uint64(unsafe.Sizeof(indexCache{}))
+}
+
+// markShared marks c and all the code it contains as shared between runtimes.
+// Its caches are dropped as each runtime gets its own (see
+// loadSharedIndexCaches).
+func (c *Code) markShared() {
+	c.shared = true
+	c.indexCaches = nil
+	for _, k := range c.consts {
+		if kc, ok := k.TryCode(); ok && !kc.shared {
+			kc.markShared()
+		}
+	}
+}
+
+// loadSharedIndexCaches allocates the inline caches of this runtime for
+// shared code c and all the code it contains, if not done already.
+func (r *Runtime) loadSharedIndexCaches(c *Code) {
+	if r.noInlineCaches {
+		return
+	}
+	if _, ok := r.sharedIndexCaches[c]; ok {
+		return
+	}
+	if r.sharedIndexCaches == nil {
+		r.sharedIndexCaches = map[*Code][]indexCache{}
+	}
+	r.RequireArrSize(unsafe.Sizeof(indexCache{}), c.indexSiteCount)
+	r.sharedIndexCaches[c] = make([]indexCache, c.indexSiteCount)
+	for _, k := range c.consts {
+		if kc, ok := k.TryCode(); ok {
+			r.loadSharedIndexCaches(kc)
+		}
+	}
+}
+
+// getIndexCaches returns the inline caches for running c in this runtime, or
+// nil if the code should run without inline caches.
+func (r *Runtime) getIndexCaches(c *Code) []indexCache {
+	if c.indexSiteCount == 0 || r.noInlineCaches {
+		return nil
+	}
+	if c.shared {
+		return r.sharedIndexCaches[c]
+	}
+	return c.indexCaches
+}
+
+// cachedIndex is like Index but uses the cache ic.
+func (t *Thread) cachedIndex(ic *indexCache, coll Value, k Value) (Value, error) {
+	if ic.misses >= maxIndexCacheMisses {
+		return Index(t, coll, k)
+	}
+	if k.Equals(ic.key) {
+		if val, ok := t.getCached(ic, coll, k); ok {
+			return val, nil
+		}
+	}
+	return t.indexAndCache(ic, coll, k)
+}
+
+// getCached returns the value of coll[k] if ic contains it.
+func (t *Thread) getCached(ic *indexCache, coll Value, k Value) (Value, bool) {
+	tbl, isTable := coll.TryTable()
+	if ic.hops == 0 {
+		if !isTable || tbl.weak != nil {
+			return NilValue, false
+		}
+		val := tbl.slotValue(ic.slot, k)
+		if val.IsNil() {
+			return NilValue, false
+		}
+		t.RequireCPU(1)
+		return val, true
+	}
+	if isTable && !tbl.Get(k).IsNil() {
+		return NilValue, false
+	}
+	meta := t.RawMetatable(coll)
+	for i := 0; i < ic.hops; i++ {
+		hop := &ic.chain[i]
+		if meta != hop.meta || meta.version != hop.metaVersion || hop.table.version != hop.version {
+			return NilValue, false
+		}
+		meta = hop.table.meta
+	}
+	t.RequireCPU(uint64(ic.hops) + 1)
+	return ic.value, true
+}
+
+// indexAndCache does the same as Index, and fills ic with what it finds if
+// possible.
+func (t *Thread) indexAndCache(ic *indexCache, coll Value, k Value) (Value, error) {
+	if _, ok := k.TryString(); !ok {
+		// Only string keys are cached.
+		ic.misses = maxIndexCacheMisses
+		return Index(t, coll, k)
+	}
+	var (
+		chain [maxCachedIndexHops]indexCacheHop
+		hops  int
+	)
+	for i := 0; i < maxIndexChainLength; i++ {
+		t.RequireCPU(1)
+		tbl, ok := coll.TryTable()
+		if ok {
+			if val := RawGet(tbl, k); !val.IsNil() {
+				if i == 0 {
+					if tbl.weak == nil {
+						ic.fill(k, tbl.hashSlot(k), 0, nil, NilValue)
+					}
+				} else if hops == i && tbl.weak == nil {
+					ic.fill(k, 0, hops, &chain, val)
+				}
+				return val, nil
+			}
+		}
+		meta := t.RawMetatable(coll)
+		metaIdx := RawGet(meta, StringValue("__index"))
+		if metaIdx.IsNil() {
+			if ok {
+				return NilValue, nil
+			}
+			return NilValue, indexError(coll)
+		}
+		if idxTbl, ok := metaIdx.TryTable(); ok {
+			if hops < maxCachedIndexHops {
+				chain[hops] = indexCacheHop{
+					meta:        meta,
+					metaVersion: meta.version,
+					table:       idxTbl,
+					version:     idxTbl.version,
+				}
+				hops++
+			}
+			coll = metaIdx
+		} else {
+			res := NewTerminationWith(t.CurrentCont(), 1, false)
+			if err := Call(t, metaIdx, []Value{coll, k}, res); err != nil {
+				return NilValue, err
+			}
+			return res.Get(0), nil
+		}
+	}
+	return NilValue, fmt.Errorf("'__index' chain too long; possible loop")
+}
+
+// fill records in ic where the value for key k was found.
+func (ic *indexCache) fill(k Value, slot int, hops int, chain *[maxCachedIndexHops]indexCacheHop, val Value) {
+	if slot < 0 {
+		return
+	}
+	ic.misses++
+	ic.key = k
+	ic.slot = slot
+	ic.hops = hops
+	if chain != nil {
+		ic.chain = *chain
+	}
+	ic.value = val
+}
+
+// cachedSetIndex is like SetIndex but uses the cache ic.  Only keys already
+// present in the table itself are cached.
+func (t *Thread) cachedSetIndex(ic *indexCache, coll Value, k Value, val Value) error {
+	if ic.misses >= maxIndexCacheMisses {
+		return SetIndex(t, coll, k, val)
+	}
+	tbl, isTable := coll.TryTable()
+	isTable = isTable && tbl.weak == nil
+	if isTable && ic.hops == 0 && !val.IsNil() && k.Equals(ic.key) && tbl.resetSlot(ic.slot, k, val) {
+		t.RequireCPU(1)
+		return nil
+	}
+	if err := SetIndex(t, coll, k, val); err != nil {
+		return err
+	}
+	if _, ok := k.TryString(); !ok {
+		ic.misses = maxIndexCacheMisses
+	} else if isTable {
+		ic.fill(k, tbl.hashSlot(k), 0, nil, NilValue)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/ir"
+)
+
+func TestProgramInlineCaches(t *testing.T) {
+	// The program code is shared, but each runtime has its own caches.
+	p, err := CompileProgram("test", []byte(`
+local obj = ...
+local s = 0
+for i = 1, 10 do
+	s = s + obj.x
+end
+return s`), ir.NoOptimisation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fields := range []map[string]int64{{"x": 1}, {"a": 1, "b": 2, "x": 3}} {
+		r := New(nil)
+		obj := NewTable()
+		for k, v := range fields {
+			obj.Set(StringValue(k), IntValue(v))
+		}
+		clos := r.LoadProgram(p, TableValue(NewTable()))
+		v, err := Call1(r.MainThread(), FunctionValue(clos), TableValue(obj))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.AsInt() != 10*fields["x"] {
+			t.Errorf("runtime %d: expected %d, got %v", i, 10*fields["x"], v)
+		}
+		if len(r.sharedIndexCaches) == 0 {
+			t.Errorf("runtime %d: no inline caches for the program", i)
+		}
+	}
+}
+
+// Compare the performance of table lookups with and without inline caches.
+func benchmarkInlineCaches(b *testing.B, src string) {
+	for _, mode := range []struct {
+		name     string
+		noCaches bool
+	}{{"cached", false}, {"uncached", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			r, env := coroutineRuntime(false)
+			r.noInlineCaches = mode.noCaches
+			clos, err := r.CompileAndLoadLuaChunk("bench", []byte(src), TableValue(env))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f, err := Call1(r.MainThread(), FunctionValue(clos))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			if _, err := Call1(r.MainThread(), f, IntValue(int64(b.N))); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func BenchmarkInlineCacheFields(b *testing.B) {
+	benchmarkInlineCaches(b, `
+local p = {x=1, y=2, z=3, name="p"}
+return function(n)
+	local s = 0
+	for i = 1, n do
+		p.x = p.x + 1
+		s = s + p.y + p.z
+	end
+	return s
+end`)
+}
+
+func BenchmarkInlineCacheMethods(b *testing.B) {
+	benchmarkInlineCaches(b, `
+local Point = {}
+Point.__index = Point
+function Point:getx() return self.x end
+function Point:gety() return self.y end
+local ps = {}
+for i = 1, 10 do
+	ps[i] = setmetatable({x=i, y=-i}, Point)
+end
+return function(n)
+	local s = 0
+	for i = 1, n do
+		local p = ps[i % 10 + 1]
+		s = s + p:getx() + p:gety()
+	end
+	return s
+end`)
+}
+
+func BenchmarkInlineCacheInheritance(b *testing.B) {
+	benchmarkInlineCaches(b, `
+local Base = {}
+Base.__index = Base
+function Base:value() return self.v end
+local Derived = setmetatable({}, Base)
+Derived.__index = Derived
+local obj = setmetatable({v=1}, Derived)
+return function(n)
+	local s = 0
+	for i = 1, n do
+		s = s + obj:value()
+	end
+	return s
+end`)
+}
@@ -1,8 +1,13 @@
 //go:build !safepool
+// +build !safepool
 
 package luagc
 
-// NewDefaultPool returns a new WeakRefPool with an appropriate implementation.
+// NewDefaultPool returns a new Pool with an appropriate implementation.
 func NewDefaultPool() Pool {
-	return NewClonePool()
+	return NewUnsafePool()
 }
+
+// DefaultPoolHasWeakRefs is true if the pools returned by NewDefaultPool
+// provide WeakRefs that let their values be GCed.
+const DefaultPoolHasWeakRefs = true
@@ -1,8 +1,13 @@
 //go:build safepool
+// +build safepool
 
 package luagc
 
-// NewPool returns a new Pool with an appropriate implementation.
+// NewDefaultPool returns a new Pool with an appropriate implementation.
 func NewDefaultPool() Pool {
-	return NewUnsafePool()
+	return NewClonePool()
 }
+
+// DefaultPoolHasWeakRefs is true if the pools returned by NewDefaultPool
+// provide WeakRefs that let their values be GCed.
+const DefaultPoolHasWeakRefs = false
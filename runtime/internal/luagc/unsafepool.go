@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -33,10 +34,16 @@ func NewUnsafePool() *UnsafePool {
 	return &UnsafePool{weakrefs: make(map[uintptr]*weakRef)}
 }
 
-// Get returns a WeakRef for v if possible.
+// Get returns a WeakRef for v if possible.  Values are not finalized or
+// released unless they are also marked with Mark().
 func (p *UnsafePool) Get(v Value) WeakRef {
 	p.mx.Lock()
 	defer p.mx.Unlock()
+	if p.weakrefs == nil {
+		// ExtractAllMarkedRelease() has been called, the pool is no longer in
+		// use.
+		return nil
+	}
 	return p.get(v)
 }
 
@@ -49,8 +56,9 @@ func (p *UnsafePool) get(v Value) *weakRef {
 	if r == nil {
 		setFinalizer(v, p.goFinalizer)
 		r = &weakRef{
-			w:    w,
-			pool: p,
+			w:     w,
+			flags: wrFinalized | wrReleased,
+			pool:  p,
 		}
 		p.weakrefs[id] = r
 	}
@@ -182,7 +190,12 @@ func (p *UnsafePool) ExtractAllMarkedRelease() []Value {
 			// important the finalizer is holding a reference to the pool
 			// (although that may not affect its reachability?)
 			setFinalizer(iface, nil)
+		} else {
+			setFinalizer(r.w.iface(), nil)
 		}
+		// Without a finalizer we can no longer tell when the value is GCed, so
+		// the weak ref must no longer return it.
+		r.setFlag(wrDead)
 	}
 	p.pendingRelease = nil
 	p.weakrefs = nil
@@ -208,6 +221,7 @@ func (p *UnsafePool) goFinalizer(v Value) {
 	if r.hasFlag(wrResurrected) {
 		r.clearFlag(wrResurrected)
 		setFinalizer(v, p.goFinalizer)
+		atomic.AddUint64(&resurrectedCount, 1)
 		return
 	}
 
@@ -227,6 +241,7 @@ func (p *UnsafePool) goFinalizer(v Value) {
 
 	// This is a point of no return, this value is now dead to the Lua runtime.
 	r.setFlag(wrDead)
+	atomic.AddUint64(&deadCount, 1)
 
 	// A not yet released value is added to the pendingRelease list.
 	if !r.hasFlag(wrReleased) {
@@ -237,6 +252,28 @@ func (p *UnsafePool) goFinalizer(v Value) {
 	delete(p.weakrefs, id)
 }
 
+// Number of values with weak refs which have become dead (see DeadCount).
+var deadCount uint64
+
+// Number of values whose go finalizer was reinstated because they were obtained
+// from a weak ref (see ResurrectedCount).
+var resurrectedCount uint64
+
+// DeadCount returns the number of values which have become dead to the Lua
+// runtime since the program started, across all UnsafePool instances.  When it
+// changes, some WeakRefs may have started to return nil.
+func DeadCount() uint64 {
+	return atomic.LoadUint64(&deadCount)
+}
+
+// ResurrectedCount returns the number of times a value found unreachable by the
+// Go GC was kept alive because it had been obtained from a WeakRef since it was
+// last checked, across all UnsafePool instances.  When it changes, another GC
+// cycle is needed to collect those values if they are still unreachable.
+func ResurrectedCount() uint64 {
+	return atomic.LoadUint64(&resurrectedCount)
+}
+
 //
 // WeakRef implementation for UnsafePool
 //
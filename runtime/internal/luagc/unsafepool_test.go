@@ -288,6 +288,77 @@ func TestUnsafePoolFinalizeRelease(t *testing.T) {
 	}
 }
 
+func TestUnsafePoolResurrectedCount(t *testing.T) {
+
+	// A value obtained from a weakref survives the next GC cycle.
+	c := installTestCollector()
+	p := NewUnsafePool()
+	n := newIntPtr(1)
+	w := p.Get(n)
+	if w.Value() == nil {
+		t.Fatal("Expected weakref to be live")
+	}
+	rc := ResurrectedCount()
+	c.GC(n)
+	if ResurrectedCount() == rc {
+		t.Fatal("Expected the resurrected count to change")
+	}
+	if w.Value() == nil {
+		t.Fatal("Expected weakref to still be live")
+	}
+	rc = ResurrectedCount()
+	c.GC(n)
+	c.GC(n)
+	if ResurrectedCount() != rc+1 {
+		t.Fatal("Expected the resurrected count to change once")
+	}
+	if v := w.Value(); v != nil {
+		t.Fatalf("Expected weakref to be nil, got %v", v)
+	}
+}
+
+func TestUnsafePoolWeakRefOnly(t *testing.T) {
+
+	// Values which are not marked are neither finalized nor released.
+	c := installTestCollector()
+	p := NewUnsafePool()
+	n := newIntPtr(1)
+	w := p.Get(n)
+	if p.Get(n) != w {
+		t.Fatal("Expected the same weakref")
+	}
+	dc := DeadCount()
+	c.GC(n)
+	if v := w.Value(); v != nil {
+		t.Fatalf("Expected weakref to be nil, got %v", v)
+	}
+	if DeadCount() == dc {
+		t.Fatal("Expected the dead count to change")
+	}
+	if pf := p.ExtractPendingFinalize(); len(pf) != 0 {
+		t.Fatalf("Expected 0 pending finalize, got %d", len(pf))
+	}
+	if pr := p.ExtractPendingRelease(); len(pr) != 0 {
+		t.Fatalf("Expected 0 pending release, got %d", len(pr))
+	}
+
+	// Once the pool has released all values, weakrefs are dead and no new ones
+	// are returned.
+	m := newIntPtr(2)
+	w = p.Get(m)
+	p.ExtractAllMarkedFinalize()
+	p.ExtractAllMarkedRelease()
+	if v := w.Value(); v != nil {
+		t.Fatalf("Expected weakref to be nil, got %v", v)
+	}
+	if c.FinalizerCount() != 0 {
+		t.Fatalf("Expected no finalizers, got %d", c.FinalizerCount())
+	}
+	if w := p.Get(m); w != nil {
+		t.Fatalf("Expected no weakref, got %v", w)
+	}
+}
+
 func newIntPtr(n int) *intVal {
 	v := intVal(n)
 	return &v
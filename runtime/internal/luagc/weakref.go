@@ -4,7 +4,7 @@
 // Two interfaces WeakRef and Pool are defined and the packages provides three
 // implementations of Pool.  The Golua runtime has a Pool instance that
 // it uses to help with finalizing of Lua values and making sure finalizers do
-// not run after the runtime has finished.
+// not run after the runtime has finished, and to implement weak tables.
 //
 // SafePool is a simple implementation whose strategy is to keep all
 // values alive as long as they have live WeakRefs.
@@ -16,6 +16,9 @@
 // ClonePool also lets values be GCed when they are unreachable outside of the
 // pool and does so on any compliant Go implementation.  However it does not
 // support WeakRefs (i.e. Get(v) always returns nil).
+//
+// The runtime uses UnsafePool by default and ClonePool when built with the
+// safepool tag (in which case tables cannot have weak keys or values).
 package luagc
 
 // Value is the interface that must be implemented by values managed by a Pool.
@@ -0,0 +1,228 @@
+// Package luaprof builds CPU profiles of Lua code and encodes them in the
+// pprof format (gzipped protocol buffers, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto) so they can be
+// analysed with `go tool pprof`.
+package luaprof
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Frame is an entry in a call stack.
+type Frame struct {
+	Function  string // Name of the function
+	File      string // Source of the function
+	StartLine int64  // Line where the function is defined
+	Line      int64  // Line being executed
+}
+
+type functionKey struct {
+	name, file string
+	startLine  int64
+}
+
+type locationKey struct {
+	functionID uint64
+	line       int64
+}
+
+type sample struct {
+	locationIDs []uint64
+	count       int64
+}
+
+// A Builder accumulates samples to build a profile.
+type Builder struct {
+	period    time.Duration
+	start     time.Time
+	strings   []string
+	stringIDs map[string]int64
+	functions map[functionKey]uint64
+	funcList  []functionKey
+	locations map[locationKey]uint64
+	locList   []locationKey
+	samples   map[string]*sample
+	sampleIDs []string // To output samples in a deterministic order
+}
+
+// NewBuilder returns a new Builder for a profile where a sample is taken every
+// period.
+func NewBuilder(period time.Duration) *Builder {
+	return &Builder{
+		period:    period,
+		start:     time.Now(),
+		strings:   []string{""},
+		stringIDs: map[string]int64{"": 0},
+		functions: map[functionKey]uint64{},
+		locations: map[locationKey]uint64{},
+		samples:   map[string]*sample{},
+	}
+}
+
+// Add records count samples for the given call stack (the first frame is the
+// innermost one).
+func (b *Builder) Add(stack []Frame, count int64) {
+	var key strings.Builder
+	ids := make([]uint64, len(stack))
+	for i, f := range stack {
+		ids[i] = b.locationID(f)
+		key.WriteString(strconv.FormatUint(ids[i], 10))
+		key.WriteByte(',')
+	}
+	k := key.String()
+	s, ok := b.samples[k]
+	if !ok {
+		s = &sample{locationIDs: ids}
+		b.samples[k] = s
+		b.sampleIDs = append(b.sampleIDs, k)
+	}
+	s.count += count
+}
+
+func (b *Builder) locationID(f Frame) uint64 {
+	fk := functionKey{name: f.Function, file: f.File, startLine: f.StartLine}
+	fid, ok := b.functions[fk]
+	if !ok {
+		b.funcList = append(b.funcList, fk)
+		fid = uint64(len(b.funcList))
+		b.functions[fk] = fid
+	}
+	lk := locationKey{functionID: fid, line: f.Line}
+	lid, ok := b.locations[lk]
+	if !ok {
+		b.locList = append(b.locList, lk)
+		lid = uint64(len(b.locList))
+		b.locations[lk] = lid
+	}
+	return lid
+}
+
+func (b *Builder) stringID(s string) int64 {
+	id, ok := b.stringIDs[s]
+	if !ok {
+		id = int64(len(b.strings))
+		b.strings = append(b.strings, s)
+		b.stringIDs[s] = id
+	}
+	return id
+}
+
+// Write writes the profile to w in the pprof format.
+func (b *Builder) Write(w io.Writer) error {
+	var p encoder
+
+	// Field numbers are from profile.proto.
+	samplesType := valueType(b.stringID("samples"), b.stringID("count"))
+	cpuType := valueType(b.stringID("cpu"), b.stringID("nanoseconds"))
+	p.bytes(1, samplesType)
+	p.bytes(1, cpuType)
+	for _, k := range b.sampleIDs {
+		s := b.samples[k]
+		var e encoder
+		e.packedUint64s(1, s.locationIDs)
+		e.packedInt64s(2, []int64{s.count, s.count * int64(b.period)})
+		p.bytes(2, e.buf)
+	}
+	for i, lk := range b.locList {
+		var line encoder
+		line.uint64(1, lk.functionID)
+		line.int64(2, lk.line)
+		var e encoder
+		e.uint64(1, uint64(i+1))
+		e.bytes(4, line.buf)
+		p.bytes(4, e.buf)
+	}
+	for i, fk := range b.funcList {
+		var e encoder
+		e.uint64(1, uint64(i+1))
+		e.int64(2, b.stringID(fk.name))
+		e.int64(3, b.stringID(fk.name))
+		e.int64(4, b.stringID(fk.file))
+		e.int64(5, fk.startLine)
+		p.bytes(5, e.buf)
+	}
+	// The string table must come after all the calls to stringID.
+	for _, s := range b.strings {
+		p.string(6, s)
+	}
+	p.int64(9, b.start.UnixNano())
+	p.int64(10, int64(time.Since(b.start)))
+	p.bytes(11, cpuType)
+	p.int64(12, int64(b.period))
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(p.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func valueType(typ, unit int64) []byte {
+	var e encoder
+	e.int64(1, typ)
+	e.int64(2, unit)
+	return e.buf
+}
+
+// An encoder encodes protocol buffer fields.
+type encoder struct {
+	buf []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (e *encoder) varint(x uint64) {
+	for x >= 0x80 {
+		e.buf = append(e.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	e.buf = append(e.buf, byte(x))
+}
+
+func (e *encoder) tag(field int, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) uint64(field int, x uint64) {
+	e.tag(field, wireVarint)
+	e.varint(x)
+}
+
+func (e *encoder) int64(field int, x int64) {
+	e.uint64(field, uint64(x))
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) packedUint64s(field int, xs []uint64) {
+	var p encoder
+	for _, x := range xs {
+		p.varint(x)
+	}
+	e.bytes(field, p.buf)
+}
+
+func (e *encoder) packedInt64s(field int, xs []int64) {
+	var p encoder
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	e.bytes(field, p.buf)
+}
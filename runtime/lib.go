@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -158,6 +159,25 @@ func Call1(t *Thread, f Value, args ...Value) (Value, error) {
 	return term.Get(0), nil
 }
 
+// CallWithContext calls f with arguments args like Call, in a new runtime
+// context which is stopped when ctx is done (see Thread.CallWithContext).
+func CallWithContext(ctx context.Context, t *Thread, f Value, args []Value, next Cont) error {
+	_, err := t.CallWithContext(ctx, RuntimeContextDef{}, func() error {
+		return Call(t, f, args, next)
+	})
+	return err
+}
+
+// Call1WithContext is like Call1 but the call is stopped when ctx is done (see
+// Thread.CallWithContext).
+func Call1WithContext(ctx context.Context, t *Thread, f Value, args ...Value) (Value, error) {
+	term := NewTerminationWith(t.CurrentCont(), 1, false)
+	if err := CallWithContext(ctx, t, f, args, term); err != nil {
+		return NilValue, err
+	}
+	return term.Get(0), nil
+}
+
 // Concat returns x .. y, possibly calling the '__concat' metamethod.
 func Concat(t *Thread, x, y Value) (Value, error) {
 	var sx, sy string
@@ -263,17 +283,25 @@ func (r *Runtime) ParseLuaChunk(name string, source []byte, scannerOptions ...sc
 	statSize = uint64(len(source))
 	r.LinearRequire(4, uint64(len(source))) // 4 is a factor pulled out of thin air
 
-	stat = new(ast.BlockStat)
-	*stat, err = parsing.ParseChunk(s)
+	stat, err = parseLuaChunk(name, s)
 	if err != nil {
 		r.ReleaseMem(statSize)
+		return nil, 0, err
+	}
+	return
+}
+
+// parseLuaChunk parses a Lua chunk from s and returns the AST.
+func parseLuaChunk(name string, s *scanner.Scanner) (*ast.BlockStat, error) {
+	stat, err := parsing.ParseChunk(s)
+	if err != nil {
 		var parseErr parsing.Error
 		if !errors.As(err, &parseErr) {
-			return nil, 0, err
+			return nil, err
 		}
-		return nil, 0, NewSyntaxError(name, parseErr)
+		return nil, NewSyntaxError(name, parseErr)
 	}
-	return
+	return &stat, nil
 }
 
 // ParseLuaExp parses a string as a Lua expression and returns the AST.
@@ -312,31 +340,24 @@ func (r *Runtime) compileLuaStat(name string, stat *ast.BlockStat, statSize uint
 	defer r.ReleaseMem(constsSize)
 
 	// Compile ast to ir
-	kidx, constants, err := astcomp.CompileLuaChunk(name, *stat)
+	kidx, constants, err := compileLuaStatToIR(name, stat, r.optimisationLevel)
 
 	// We no longer need the AST (whether that succeeded or not)
 	r.ReleaseMem(statSize)
 
 	if err != nil {
-		return nil, 0, fmt.Errorf("%s:%s", name, err)
+		return nil, 0, err
 	}
 
 	statSize = 0 // So that the deferred function above doesn't release the memory again.
 
-	// "Optimise" the ir code
-	constants = ir.FoldConstants(constants, ir.DefaultFold)
-
-	// Set up the IR to code compiler
-	kc := ircomp.NewConstantCompiler(constants, code.NewBuilder(name))
-	kc.QueueConstant(kidx)
-
 	// Account for CPU and memory needed to compile IR to a code unit.  This is
 	// an estimate, but unitSize is proportional to the size of the IR consts.
 	unitSize := constsSize
 	r.LinearRequire(4, unitSize) // 4 is a factor pulled out of thin air
 
 	// Compile IR to code
-	unit, err := kc.CompileQueue()
+	unit, err := compileIRToUnit(name, kidx, constants)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -345,6 +366,29 @@ func (r *Runtime) compileLuaStat(name string, stat *ast.BlockStat, statSize uint
 	return unit, unitSize, nil
 }
 
+// compileLuaStatToIR compiles the AST of a chunk to IR constants, kidx being
+// the index of the constant for the chunk's function.  The IR code is
+// optimised according to optLevel (see ir.Optimise).
+func compileLuaStatToIR(name string, stat *ast.BlockStat, optLevel int) (kidx uint, constants []ir.Constant, err error) {
+	kidx, constants, err = astcomp.CompileLuaChunk(name, *stat)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s:%s", name, err)
+	}
+
+	// "Optimise" the ir code
+	constants = ir.FoldConstants(constants, ir.DefaultFold)
+	constants = ir.Optimise(constants, optLevel)
+	return kidx, constants, nil
+}
+
+// compileIRToUnit compiles IR constants to a code unit whose main function is
+// the constant with index kidx.
+func compileIRToUnit(name string, kidx uint, constants []ir.Constant) (*code.Unit, error) {
+	kc := ircomp.NewConstantCompiler(constants, code.NewBuilder(name))
+	kc.QueueConstant(kidx)
+	return kc.CompileQueue()
+}
+
 func (r *Runtime) CompileLuaChunkOrExp(name string, source []byte, scannerOptions ...scanner.Option) (unit *code.Unit, sz uint64, err error) {
 	var statErr error
 	stat, statSize, expErr := r.ParseLuaExp(name, source, scannerOptions...)
@@ -417,25 +461,7 @@ func (r *Runtime) LoadFromSourceOrCode(name string, source []byte, mode string,
 
 	switch {
 	case canBeBinary && HasMarshalPrefix(source):
-		buf := bytes.NewBuffer(source)
-		k, used, err := UnmarshalConst(buf, r.LinearUnused(10))
-		r.LinearRequire(10, used)
-		if err != nil {
-			return nil, err
-		}
-		code, ok := k.TryCode()
-		if !ok {
-			return nil, errors.New("Expected function to load")
-		}
-		clos := NewClosure(r, code)
-		if code.UpvalueCount > 0 {
-			clos.AddUpvalue(newCell(env))
-			r.RequireCPU(uint64(code.UpvalueCount))
-			for i := int16(1); i < code.UpvalueCount; i++ {
-				clos.AddUpvalue(newCell(NilValue))
-			}
-		}
-		return clos, nil
+		return r.loadMarshaledCode(source, env)
 	case HasMarshalPrefix(source):
 		return nil, errors.New("attempt to load a binary chunk")
 	case !canBeText:
@@ -445,10 +471,43 @@ func (r *Runtime) LoadFromSourceOrCode(name string, source []byte, mode string,
 		if firstLineSkipped {
 			opts = append(opts, scanner.WithStartLine(2))
 		}
+		if r.codeCache != nil {
+			return r.compileAndLoadCachedLuaChunk(name, source, env, firstLineSkipped, opts...)
+		}
 		return r.CompileAndLoadLuaChunk(name, source, env, opts...)
 	}
 }
 
+// loadMarshaledCode loads a function serialized with MarshalConst and returns
+// a closure for it in the given global environment.
+func (r *Runtime) loadMarshaledCode(source []byte, env Value) (*Closure, error) {
+	buf := bytes.NewBuffer(source)
+	k, used, err := UnmarshalConst(buf, r.LinearUnused(10))
+	r.LinearRequire(10, used)
+	if err != nil {
+		return nil, err
+	}
+	code, ok := k.TryCode()
+	if !ok {
+		return nil, errors.New("Expected function to load")
+	}
+	return r.newChunkClosure(code, env), nil
+}
+
+// newChunkClosure returns a closure for the code of a chunk.  Its first upvalue
+// (if any) is set to env and the others to nil.
+func (r *Runtime) newChunkClosure(code *Code, env Value) *Closure {
+	clos := NewClosure(r, code)
+	if code.UpvalueCount > 0 {
+		clos.AddUpvalue(newCell(env))
+		r.RequireCPU(uint64(code.UpvalueCount))
+		for i := int16(1); i < code.UpvalueCount; i++ {
+			clos.AddUpvalue(newCell(NilValue))
+		}
+	}
+	return clos
+}
+
 func stripFirstLineComment(chunk []byte) ([]byte, bool) {
 	// Skip BOM
 	if bytes.HasPrefix(chunk, []byte{0xEF, 0xBB, 0xBF}) {
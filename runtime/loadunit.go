@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"sort"
 	"unsafe"
 
 	"github.com/arnodel/golua/code"
@@ -17,6 +18,50 @@ type Code struct {
 	UpNames      []string
 	RegCount     int16
 	CellCount    int16
+	ParamCount   int16
+	IsVararg     bool
+
+	lineDefined, lastLineDefined int32
+	locals                       []code.LocalVar
+
+	// Inline caches (see inlinecache.go)
+	indexSites     []uint16     // Index of the cache of each table lookup / setting opcode
+	indexSiteCount int          // Number of table lookup / setting opcodes
+	indexCaches    []indexCache // Allocated when the code is loaded, nil if shared
+	shared         bool         // True if the code may be run by several runtimes
+}
+
+// ParamName returns the name of the n-th parameter of the function (starting
+// from 1).  If there is no such parameter, ok is false.
+func (c *Code) ParamName(n int) (name string, ok bool) {
+	if n < 1 || n > int(c.ParamCount) {
+		return "", false
+	}
+	for _, v := range c.locals {
+		if v.IsHidden() {
+			continue
+		}
+		n--
+		if n == 0 {
+			return v.Name, true
+		}
+	}
+	return "", false
+}
+
+// ActiveLines returns the lines in the source code which contain code of the
+// function, in increasing order.
+func (c *Code) ActiveLines() []int32 {
+	seen := map[int32]bool{}
+	var lines []int32
+	for _, l := range c.lines {
+		if l > 0 && !seen[l] {
+			seen[l] = true
+			lines = append(lines, l)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
 }
 
 // RefactorConsts returns an equivalent *Code this consts "refactored", which
@@ -57,22 +102,43 @@ func (r *Runtime) RefactorCodeConsts(c *Code) *Code {
 	cc := *c
 	cc.code = opcodes
 	cc.consts = consts
+	cc.indexCaches = nil // Only used for dumping the code
 	return &cc
 }
 
 // LoadLuaUnit turns a code unit into a closure given an environment env.
 func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 	r.RequireArrSize(unsafe.Sizeof(Value{}), len(unit.Constants))
-	constants := make([]Value, len(unit.Constants))
 
 	// Require memory for all the code at once, rather than in bits in the
 	// code.Code case below
 	r.RequireArrSize(unsafe.Sizeof(code.Opcode(0)), len(unit.Code))
 	r.RequireArrSize(4, len(unit.Lines))
+	r.RequireMem(indexCachesSize(unit.Code)) // For the inline caches
 
-	// Require CPU for the loop below
+	// Require CPU for the loop in loadUnitCode
 	r.RequireCPU(uint64(len(unit.Constants)))
 
+	// Require memory for the Code values made by loadUnitCode
+	codeCount := 0
+	for _, ck := range unit.Constants {
+		if _, ok := ck.(code.Code); ok {
+			codeCount++
+		}
+	}
+	r.RequireArrSize(unsafe.Sizeof(Code{}), codeCount)
+
+	mainCode := loadUnitCode(unit)
+	clos := NewClosure(r, mainCode)
+	if mainCode.UpvalueCount > 0 {
+		clos.AddUpvalue(Cell{&env})
+	}
+	return clos
+}
+
+// loadUnitCode turns a code unit into the Code of its main function.
+func loadUnitCode(unit *code.Unit) *Code {
+	constants := make([]Value, len(unit.Constants))
 	for i, ck := range unit.Constants {
 		switch k := ck.(type) {
 		case code.Int:
@@ -87,30 +153,31 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 		case code.NilType:
 			// Do nothing as constants[i] == nil
 		case code.Code:
-			r.RequireSize(unsafe.Sizeof(Code{}))
 			var lines []int32
 			if unit.Lines != nil {
 				lines = unit.Lines[k.StartOffset:k.EndOffset]
 			}
-			constants[i] = CodeValue(&Code{
-				source:       unit.Source,
-				name:         k.Name,
-				code:         unit.Code[k.StartOffset:k.EndOffset],
-				lines:        lines,
-				consts:       constants,
-				UpvalueCount: k.UpvalueCount,
-				UpNames:      k.UpNames,
-				RegCount:     k.RegCount,
-				CellCount:    k.CellCount,
-			})
+			c := &Code{
+				source:          unit.Source,
+				name:            k.Name,
+				code:            unit.Code[k.StartOffset:k.EndOffset],
+				lines:           lines,
+				consts:          constants,
+				UpvalueCount:    k.UpvalueCount,
+				UpNames:         k.UpNames,
+				RegCount:        k.RegCount,
+				CellCount:       k.CellCount,
+				ParamCount:      k.ParamCount,
+				IsVararg:        k.IsVararg,
+				lineDefined:     k.LineDefined,
+				lastLineDefined: k.LastLineDefined,
+				locals:          k.Locals,
+			}
+			c.initIndexSites()
+			constants[i] = CodeValue(c)
 		default:
 			panic("Unsupported constant type")
 		}
 	}
-	mainCode := constants[0].AsCode() // It must be some code
-	clos := NewClosure(r, mainCode)
-	if mainCode.UpvalueCount > 0 {
-		clos.AddUpvalue(Cell{&env})
-	}
-	return clos
+	return constants[0].AsCode() // It must be some code
 }
@@ -0,0 +1,108 @@
+-- Table lookups with constant keys are cached.  These tests check that the
+-- caches are invalidated when the tables change.
+
+local Point = {}
+Point.__index = Point
+
+function Point.new(x, y)
+    return setmetatable({x=x, y=y}, Point)
+end
+
+function Point:norm1()
+    return math.abs(self.x) + math.abs(self.y)
+end
+
+local function norms(ps)
+    local s = 0
+    for _, p in ipairs(ps) do
+        s = s + p:norm1()
+    end
+    return s
+end
+
+local ps = {}
+for i = 1, 10 do
+    ps[i] = Point.new(i, -i)
+end
+print(norms(ps))
+--> =110
+
+-- Redefining a method
+function Point:norm1()
+    return self.x
+end
+print(norms(ps))
+--> =55
+
+-- Shadowing a method in an instance
+ps[1].norm1 = function() return 1000 end
+print(norms(ps))
+--> =1054
+
+-- Removing a field from an instance
+ps[1].norm1 = nil
+print(norms(ps))
+--> =55
+
+-- Changing __index
+local Point3 = setmetatable({}, {__index = Point})
+function Point3:norm1()
+    return self.x * 2
+end
+Point.__index = Point3
+print(norms(ps))
+--> =110
+
+-- Two hops: the method comes from the grand-parent
+Point3.norm1 = nil
+print(norms(ps))
+--> =55
+
+-- Changing the grand-parent
+function Point:norm1()
+    return self.y
+end
+print(norms(ps))
+--> =-55
+
+-- Changing the metatable of an instance
+setmetatable(ps[10], {__index = function() return function() return 0 end end})
+print(norms(ps))
+--> =-45
+
+-- Tables with fields in different slots
+local function getx(t) return t.x end
+local t1 = {x=1}
+local t2 = {a=1, b=2, c=3, x=2}
+local t3 = {}
+print(getx(t1), getx(t2), getx(t3), getx(t1), getx(t2))
+--> =1	2	nil	1	2
+
+-- Setting fields
+local function setx(t, v) t.x = v end
+setx(t1, 10)
+setx(t2, 20)
+setx(t3, 30)
+setx(t1, nil)
+print(t1.x, t2.x, t3.x, next(t1))
+--> =nil	20	30	nil
+
+-- Setting a field which is in the table's __newindex
+local log = {}
+local t4 = setmetatable({}, {__newindex = function(t, k, v) log[#log+1] = k end})
+setx(t4, 1)
+setx(t4, 2)
+print(#log, t4.x)
+--> =2	nil
+
+-- Non-constant keys
+local function get(t, k) return t[k] end
+local t5 = {a=1, b=2, c=3, [1]="one"}
+local s = ""
+for i = 1, 20 do
+    for _, k in ipairs{"a", "b", "c", 1} do
+        s = s .. tostring(get(t5, k))
+    end
+end
+print(#s, s:sub(1, 12))
+--> =120	123one123one
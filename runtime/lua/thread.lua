@@ -150,3 +150,38 @@ pcall(function()
     print(coroutine.status(co))
     --> =dead
 end)
+
+-- A __close metamethod cannot yield while its coroutine is being closed.
+do
+    local co = coroutine.create(function()
+        local x <close> = setmetatable({}, {__close = function()
+            coroutine.yield(1)
+        end})
+        coroutine.yield(0)
+    end)
+    print(coroutine.resume(co))
+    --> =true	0
+    print(pcall(coroutine.close, co))
+    --> ~true\tfalse\t.*attempt to yield across a Go function call
+    print(coroutine.status(co))
+    --> =dead
+end
+
+-- The same goes when the coroutine was suspended by a Go function.
+do
+    local co = coroutine.create(function()
+        local x <close> = setmetatable({}, {__close = function()
+            coroutine.yield(1)
+        end})
+        table.sort({2, 1}, function(a, b)
+            coroutine.yield(0)
+            return a < b
+        end)
+    end)
+    print(coroutine.resume(co))
+    --> =true	0
+    print(pcall(coroutine.close, co))
+    --> ~true\tfalse\t.*attempt to yield across a Go function call
+    print(coroutine.status(co))
+    --> =dead
+end
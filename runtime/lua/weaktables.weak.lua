@@ -0,0 +1,186 @@
+-- Entries of weak tables are removed when their keys or values are collected.
+-- Objects are created in functions so that no register keeps them alive.
+
+local function count(t)
+    local n = 0
+    for _ in pairs(t) do n = n + 1 end
+    return n
+end
+
+local keep = {}
+
+-- Weak keys
+local wk = setmetatable({}, {__mode = "k"})
+do
+    local function fill()
+        wk[keep] = 1
+        wk.str = 2
+        wk[10] = {}
+        for i = 1, 100 do wk[{}] = i end
+    end
+    fill()
+    print(count(wk))
+    --> =103
+    collectgarbage()
+    print(count(wk), wk[keep], wk.str, type(wk[10]))
+    --> =3	1	2	table
+end
+
+-- Weak values
+local wv = setmetatable({}, {__mode = "v"})
+do
+    local function fill()
+        for i = 1, 10 do wv[i] = {} end
+        wv[3] = keep
+        wv.x = "str"
+        wv.y = {}
+        wv[keep] = {}
+    end
+    fill()
+    print(count(wv))
+    --> =13
+    collectgarbage()
+    print(count(wv), wv[3] == keep, wv.x, wv.y, wv[keep])
+    --> =2	true	str	nil	nil
+end
+
+-- Weak keys and values
+local wkv = setmetatable({}, {__mode = "kv"})
+do
+    local function fill()
+        wkv[keep] = keep
+        wkv[1] = keep
+        wkv[keep] = {}
+        wkv[{}] = 1
+        wkv.a = {}
+        wkv.b = "b"
+    end
+    fill()
+    print(count(wkv))
+    --> =5
+    collectgarbage()
+    print(count(wkv), wkv[1] == keep, wkv.b)
+    --> =2	true	b
+end
+
+-- Weak keys: a value is only kept alive by its key, so chains of entries are
+-- collected too, but one link per GC cycle and a collection runs at most two
+-- cycles.  Unlike in Lua, an entry whose value refers to its key is never
+-- collected (see runtime/weaktable.go).
+local eph = setmetatable({}, {__mode = "k"})
+do
+    local function fill()
+        local k1, k2, k3 = {}, {}, {}
+        eph[k1] = k2
+        eph[k2] = k3
+        eph[k3] = "end"
+        eph[keep] = {}
+        eph[eph[keep]] = "kept"
+    end
+    fill()
+    print(count(eph))
+    --> =5
+    for i = 1, 3 do
+        collectgarbage()
+    end
+    print(count(eph), eph[eph[keep]])
+    --> =2	kept
+end
+
+-- Userdata are held weakly too
+do
+    local u = setmetatable({}, {__mode = "v"})
+    local function fill()
+        u[1] = testudata("weak")
+    end
+    fill()
+    collectgarbage()
+    print(u[1])
+end
+--> =**release weak**
+--> =nil
+
+-- Removing entries and traversing weak tables
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local k1, k2, k3 = {}, {}, {}
+    t[k1], t[k2], t[k3] = 1, 2, 3
+    t[k2] = nil
+    print(t[k1], t[k2], t[k3], count(t))
+    --> =1	nil	3	2
+    local s = 0
+    for k, v in pairs(t) do
+        t[k] = nil
+        s = s + v
+    end
+    print(s, next(t))
+    --> =4	nil
+end
+
+-- Keys which are not tables or userdata are not weak
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local function fill()
+        t[1] = 1
+        t[2.5] = 2
+        t.a = 3
+        t[true] = 4
+        t[print] = 5
+    end
+    fill()
+    collectgarbage()
+    print(count(t))
+    --> =5
+end
+
+-- Length of a table with weak values
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local function fill()
+        for i = 1, 5 do t[i] = keep end
+        for i = 6, 10 do t[i] = {} end
+    end
+    fill()
+    print(#t)
+    --> =10
+    collectgarbage()
+    print(#t)
+    --> =5
+end
+
+-- Existing entries are converted when the weakness changes
+do
+    local t = {}
+    local function fill()
+        t[keep] = {}
+        t[{}] = keep
+    end
+    fill()
+    setmetatable(t, {__mode = "v"})
+    collectgarbage()
+    print(count(t), t[keep])
+    --> =1	nil
+    setmetatable(t, nil)
+    local function refill()
+        t[{}] = {}
+    end
+    refill()
+    collectgarbage()
+    print(count(t))
+    --> =2
+end
+
+-- Weak tables can grow and reuse the slots of collected entries
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local function fill(n)
+        for i = 1, n do t[{}] = i end
+    end
+    for i = 1, 10 do
+        fill(100)
+        collectgarbage()
+    end
+    t[keep] = true
+    print(count(t), t[keep])
+    --> =1	true
+end
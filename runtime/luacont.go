@@ -18,7 +18,9 @@ type LuaCont struct {
 	acc            []Value
 	running        bool
 	borrowedCells  bool
+	tailCall       bool // true if c was started by a tail call
 	closeStackBase int
+	indexCaches    []indexCache // Inline caches of the code (may be nil)
 }
 
 var _ Cont = (*LuaCont)(nil)
@@ -52,6 +54,7 @@ func NewLuaCont(t *Thread, clos *Closure, next Cont) *LuaCont {
 		cells:          cells,
 		borrowedCells:  borrowCells,
 		closeStackBase: t.closeStack.size(),
+		indexCaches:    t.getIndexCaches(clos.Code),
 	}
 	return cont
 }
@@ -110,6 +113,10 @@ func (c *LuaCont) RunInThread(t *Thread) (Cont, error) {
 	consts := c.consts
 	lines := c.lines
 	var lastLine int32
+	if pc > 0 && lines != nil {
+		// Resuming after a call, the line of the call was already entered.
+		lastLine = lines[pc-1]
+	}
 	c.running = true
 	opcodes := c.code
 	regs := c.registers
@@ -118,10 +125,22 @@ RunLoop:
 	for {
 		t.RequireCPU(1)
 
-		if t.DebugHooks.areFlagsEnabled(HookFlagLine) {
+		// Keep c.pc up to date so that the hook / profiler can inspect c, and
+		// so that the instruction can be run again if a coroutine needs to
+		// move to its own goroutine in the middle of it (see
+		// Thread.runInline).  So instructions must not have side effects
+		// before they call a metamethod.
+		c.pc = pc
+		if t.DebugHooks.areFlagsEnabled(HookFlagLine | hookFlagProfile | hookFlagCoverage) {
+			if t.DebugHookFlags&hookFlagProfile != 0 {
+				t.profileSample(c)
+			}
 			line := lines[pc]
-			if line > 0 && line != lastLine {
+			if line > 0 && line != lastLine && t.DebugHookFlags&(HookFlagLine|hookFlagCoverage) != 0 {
 				lastLine = line
+				if t.DebugHookFlags&hookFlagCoverage != 0 {
+					t.coverLine(c.Code, pc)
+				}
 				if err := t.triggerLine(t, c, line); err != nil {
 					return nil, err
 				}
@@ -234,14 +253,25 @@ RunLoop:
 			coll := getReg(regs, cells, opcode.GetB())
 			idx := getReg(regs, cells, opcode.GetC())
 			if !opcode.GetF() {
-				val, err := Index(t, coll, idx)
+				var val Value
+				var err error
+				if c.indexCaches != nil {
+					val, err = t.cachedIndex(&c.indexCaches[c.indexSites[pc]], coll, idx)
+				} else {
+					val, err = Index(t, coll, idx)
+				}
 				if err != nil {
 					c.pc = pc
 					return nil, err
 				}
 				setReg(regs, cells, reg, val)
 			} else {
-				err := SetIndex(t, coll, idx, getReg(regs, cells, reg))
+				var err error
+				if c.indexCaches != nil {
+					err = t.cachedSetIndex(&c.indexCaches[c.indexSites[pc]], coll, idx, getReg(regs, cells, reg))
+				} else {
+					err = SetIndex(t, coll, idx, getReg(regs, cells, reg))
+				}
 				if err != nil {
 					c.pc = pc
 					return nil, err
@@ -298,6 +328,7 @@ RunLoop:
 				case code.OpTailCont:
 					var cont Cont
 					cont, err = Continue(t, val, c.Next())
+					markTailCall(cont)
 					res = ContValue(cont)
 				case code.OpId:
 					res = val
@@ -362,6 +393,9 @@ RunLoop:
 				continue RunLoop
 			case code.OpJumpIf:
 				test := Truth(getReg(regs, cells, opcode.GetA()))
+				if t.DebugHooks.areFlagsEnabled(hookFlagCoverage) {
+					t.coverBranch(c.Code, pc, test == opcode.GetF())
+				}
 				if test == opcode.GetF() {
 					pc += int16(opcode.GetOffset())
 				} else {
@@ -369,26 +403,30 @@ RunLoop:
 				}
 				continue RunLoop
 			case code.OpCall:
+				contReg := opcode.GetA()
+				isTail := opcode.GetF() // Can mean tail call or simple return
+				next := getReg(regs, cells, contReg).AsCont()
+
+				var closeErr error
+				if isTail {
+					// As we're leaving this continuation for good, perform all
+					// the pending close actions.  It must be done before debug
+					// hooks are called, and before c is changed below.
+					closeErr = t.cleanupCloseStack(c, c.closeStackBase, nil)
+				}
+
 				pc++
 				c.pc = pc
 				c.acc = nil
 				c.running = false
-				contReg := opcode.GetA()
-				isTail := opcode.GetF() // Can mean tail call or simple return
-				next := getReg(regs, cells, contReg).AsCont()
 
 				// We clear the register containing the continuation to allow
 				// garbage collection.  A continuation can only be called once
 				// anyway, so that's ok semantically.
 				c.clearReg(contReg)
 
-				if isTail {
-					// As we're leaving this continuation for good, perform all
-					// the pending close actions.  It must be done before debug
-					// hooks are called.
-					if err := t.cleanupCloseStack(c, c.closeStackBase, nil); err != nil {
-						return nil, err
-					}
+				if closeErr != nil {
+					return nil, closeErr
 				}
 
 				if t.areFlagsEnabled(HookFlagCall | HookFlagReturn) {
@@ -532,23 +570,103 @@ RunLoop:
 
 // DebugInfo implements Cont.DebugInfo.
 func (c *LuaCont) DebugInfo() *DebugInfo {
-	pc := c.pc
-	if !c.running {
-		pc--
-	}
-	var currentLine int32 = -1
+	info := c.Closure.DebugInfo()
+	pc := c.currentPC()
 	if pc >= 0 && int(pc) < len(c.lines) {
-		currentLine = c.lines[pc]
+		info.CurrentLine = c.lines[pc]
 	}
-	name := c.name
-	if name == "" {
-		name = "<lua function>"
+	info.IsTailCall = c.tailCall
+	return info
+}
+
+// GetLocal returns the name and value of the n-th local variable (starting
+// from 1) in scope at the current point of execution of c.  If n is negative,
+// it returns the (-n)-th vararg instead, with the name "(vararg)".  If there is
+// no such variable, ok is false.
+func (c *LuaCont) GetLocal(n int) (name string, val Value, ok bool) {
+	if n < 0 {
+		etc, ok := c.varargs()
+		if !ok || -n > len(etc) {
+			return "", NilValue, false
+		}
+		return varargName, etc[-n-1], true
+	}
+	v, ok := c.localVar(n)
+	if !ok {
+		return "", NilValue, false
+	}
+	return v.Name, getReg(c.registers, c.cells, v.Reg), true
+}
+
+// SetLocal sets the value of the n-th local variable (starting from 1) in scope
+// at the current point of execution of c, with the same conventions as
+// GetLocal.  It returns the name of the variable, or ok=false if there is no
+// such variable (in which case nothing is set).
+func (c *LuaCont) SetLocal(n int, val Value) (name string, ok bool) {
+	if n < 0 {
+		etc, ok := c.varargs()
+		if !ok || -n > len(etc) {
+			return "", false
+		}
+		etc[-n-1] = val
+		return varargName, true
+	}
+	v, ok := c.localVar(n)
+	if !ok {
+		return "", false
 	}
-	return &DebugInfo{
-		Source:      c.source,
-		Name:        name,
-		CurrentLine: currentLine,
+	setReg(c.registers, c.cells, v.Reg, val)
+	return v.Name, true
+}
+
+const varargName = "(vararg)"
+
+// currentPC returns the offset of the instruction being executed (or, if c is
+// not running, of the last instruction that was executed).
+func (c *LuaCont) currentPC() int16 {
+	if c.running {
+		return c.pc
+	}
+	return c.pc - 1
+}
+
+// markTailCall records that cont was started by a tail call, so that debug info
+// can report it.
+func markTailCall(cont Cont) {
+	switch cc := cont.(type) {
+	case *LuaCont:
+		cc.tailCall = true
+	case *GoCont:
+		cc.tailCall = true
+	}
+}
+
+func (c *LuaCont) localVar(n int) (code.LocalVar, bool) {
+	pc := int(c.currentPC())
+	if n < 1 {
+		return code.LocalVar{}, false
+	}
+	for _, v := range c.locals {
+		if v.IsHidden() || !v.InScope(pc) {
+			continue
+		}
+		n--
+		if n == 0 {
+			return v, true
+		}
+	}
+	return code.LocalVar{}, false
+}
+
+func (c *LuaCont) varargs() ([]Value, bool) {
+	pc := int(c.currentPC())
+	for _, v := range c.locals {
+		if v.Name == "..." && v.InScope(pc) {
+			etc, ok := getReg(c.registers, c.cells, v.Reg).Interface().([]Value)
+			return etc, ok
+		}
 	}
+	return nil, false
 }
 
 func (c *LuaCont) getRegCell(reg code.Reg) Cell {
@@ -9,7 +9,10 @@ import (
 	"github.com/arnodel/golua/code"
 )
 
-var marshalPrefix = []byte{6, 0, 4}
+// The last byte of the prefix is the version of the format, which must be
+// incremented when it changes (along with BytecodeVersion) so that values
+// marshalled with another format are rejected.
+var marshalPrefix = []byte{6, 0, 6}
 var ErrInvalidMarshalPrefix = errors.New("Invalid marshal prefix")
 
 // HasMarshalPrefix returns true if the byte slice passed starts witht the magic
@@ -113,6 +116,24 @@ func (w *bwriter) writeCode(c *Code) {
 	for _, n := range c.UpNames {
 		w.writeString(n)
 	}
+	w.consumeBudget(2 + 1 + 4 + 4 + 8)
+	w.write(
+		c.ParamCount,
+		c.IsVararg,
+		c.lineDefined,
+		c.lastLineDefined,
+		int64(len(c.locals)),
+	)
+	for _, v := range c.locals {
+		w.consumeBudget(0 + 1 + 1 + 4 + 4)
+		w.write(
+			v.Name,
+			v.Reg.RegType(),
+			v.Reg.Idx(),
+			v.StartOffset,
+			v.EndOffset,
+		)
+	}
 }
 
 func (w *bwriter) write(xs ...interface{}) {
@@ -217,6 +238,8 @@ func (r *breader) readCode(c *Code) {
 		c.code,
 		&sz,
 	)
+	r.consumeBudget(indexCachesSize(c.code))
+	c.initIndexSites()
 	c.lines = make([]int32, sz)
 	r.read(
 		4*uint64(sz)+8,
@@ -238,6 +261,38 @@ func (r *breader) readCode(c *Code) {
 	for i := range c.UpNames {
 		c.UpNames[i] = r.readString()
 	}
+	r.read(
+		2+1+4+4+8,
+		&c.ParamCount,
+		&c.IsVararg,
+		&c.lineDefined,
+		&c.lastLineDefined,
+		&sz,
+	)
+	if r.err != nil {
+		return
+	}
+	c.locals = make([]code.LocalVar, sz)
+	for i := range c.locals {
+		var (
+			v   = &c.locals[i]
+			tp  code.RegType
+			idx uint8
+		)
+		r.read(
+			0+1+1+4+4,
+			&v.Name,
+			&tp,
+			&idx,
+			&v.StartOffset,
+			&v.EndOffset,
+		)
+		if tp == code.CellRegType {
+			v.Reg = code.CellReg(idx)
+		} else {
+			v.Reg = code.ValueReg(idx)
+		}
+	}
 }
 
 func (r *breader) read(sz uint64, xs ...interface{}) {
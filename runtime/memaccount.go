@@ -0,0 +1,86 @@
+package runtime
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// In a context with the LiveMemGCPolicy (and in the contexts nested in it), the
+// memory charged for some objects is credited back to the context when they are
+// collected, so that the memory quota bounds the memory that is live rather
+// than the total memory allocated.  Those objects are tables (for their
+// entries), closures (for their upvalues) and threads.
+//
+// Each tracked object points to its own memTracker, which is only reachable
+// from the object.  When the object is collected, so is its tracker, whose Go
+// finalizer credits the memory back to a memAccount.  The finalizer is not set
+// on the object itself because the luagc.Pool may already have set one.
+//
+// Credits are added to the account concurrently (as Go finalizers run in their
+// own goroutine), and the runtime takes them from the account when it requires
+// memory.
+
+// A memAccount receives the memory credited back for a context.
+type memAccount struct {
+	credits uint64      // Accessed atomically
+	closed  uint32      // Accessed atomically, set when the context is popped
+	parent  *memAccount // Receives the credits once the account is closed
+}
+
+func newMemAccount(parent *memAccount) *memAccount {
+	return &memAccount{parent: parent}
+}
+
+// credit adds mem to the credits of the account, or of its first ancestor
+// which is not closed.  It is safe to call concurrently.
+func (a *memAccount) credit(mem uint64) {
+	for a != nil && mem > 0 {
+		atomic.AddUint64(&a.credits, mem)
+		if atomic.LoadUint32(&a.closed) == 0 {
+			return
+		}
+		// The context has been popped, so its memory has been added to the
+		// parent context.  Whatever is left is for the parent.
+		mem = atomic.SwapUint64(&a.credits, 0)
+		a = a.parent
+	}
+}
+
+// take returns the credits of the account and resets them to 0.
+func (a *memAccount) take() uint64 {
+	if atomic.LoadUint64(&a.credits) == 0 {
+		return 0
+	}
+	return atomic.SwapUint64(&a.credits, 0)
+}
+
+// close forwards the credits of the account to its parent from now on.
+func (a *memAccount) close() {
+	atomic.StoreUint32(&a.closed, 1)
+	a.parent.credit(a.take())
+}
+
+// A memTracker records the memory charged for an object to an account.
+type memTracker struct {
+	account *memAccount
+	mem     uint64
+}
+
+// trackMem records that mem was charged to the account for the object whose
+// tracker is *tr.  If the object already has a tracker for another account, the
+// memory is not tracked (it is charged for good, as with the default policy).
+func trackMem(tr **memTracker, account *memAccount, mem uint64) {
+	t := *tr
+	if t == nil {
+		t = &memTracker{account: account}
+		runtime.SetFinalizer(t, (*memTracker).release)
+		*tr = t
+	} else if t.account != account {
+		return
+	}
+	t.mem += mem
+}
+
+func (t *memTracker) release() {
+	t.account.credit(t.mem)
+}
@@ -5,9 +5,11 @@ type Metatabler interface {
 }
 
 const (
-	MetaFieldGcString = "__gc"
+	MetaFieldGcString   = "__gc"
+	MetaFieldModeString = "__mode"
 )
 
 var (
-	MetaFieldGcValue = StringValue(MetaFieldGcString)
+	MetaFieldGcValue   = StringValue(MetaFieldGcString)
+	MetaFieldModeValue = StringValue(MetaFieldModeString)
 )
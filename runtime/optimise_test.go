@@ -0,0 +1,187 @@
+package runtime_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/ir"
+	"github.com/arnodel/golua/luatesting"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the tests")
+
+// TestOptimiseGolden checks the disassembly of the code compiled from each Lua
+// file in testdata/optimise against the golden file NAME.O<level>.golden.  Run
+// the test with -update to write the golden files.
+func TestOptimiseGolden(t *testing.T) {
+	paths, err := filepath.Glob("testdata/optimise/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, level := range []int{ir.BasicOptimisation, ir.FullOptimisation} {
+			golden := fmt.Sprintf("%s.O%d.golden", strings.TrimSuffix(path, ".lua"), level)
+			t.Run(golden, func(t *testing.T) {
+				r := rt.New(nil, rt.WithOptimisationLevel(level))
+				unit, _, err := r.CompileLuaChunk(filepath.Base(path), src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				unit.Disassemble(&buf)
+				if *updateGolden {
+					if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				expected, err := ioutil.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf.Bytes(), expected) {
+					t.Errorf("disassembly differs from %s, got:\n%s", golden, buf.Bytes())
+				}
+			})
+		}
+	}
+}
+
+// TestRuntimeOptimised runs the Lua tests of the runtime and of the standard
+// library with the code optimised, except for the quotas tests as optimised
+// code uses less CPU.  The golib tests are left out as they need Go values set
+// up by their package.
+func TestRuntimeOptimised(t *testing.T) {
+	luaDirs, err := filepath.Glob("../lib/*/lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, level := range []int{ir.BasicOptimisation, ir.FullOptimisation} {
+		optSetup := func(r *rt.Runtime) func() {
+			r.SetOptimisationLevel(level)
+			return setup(r)
+		}
+		for _, luaDir := range append([]string{"lua"}, luaDirs...) {
+			dir := filepath.Dir(filepath.Join(wd, luaDir))
+			if filepath.Base(dir) == "golib" {
+				continue
+			}
+			// The tests use paths relative to the directory of their package.
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			paths, err := filepath.Glob("lua/*.lua")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Run(fmt.Sprintf("O%d/%s", level, filepath.Base(dir)), func(t *testing.T) {
+				for _, path := range paths {
+					if !strings.HasSuffix(path, ".quotas.lua") {
+						luatesting.RunLuaTestFile(t, path, optSetup)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestOptimisedOutput checks that the Lua files in testdata/optimise produce
+// the same output whatever the optimisation level.
+func TestOptimisedOutput(t *testing.T) {
+	paths, err := filepath.Glob("testdata/optimise/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(src []byte, level int) string {
+		var out bytes.Buffer
+		r := rt.New(&out, rt.WithOptimisationLevel(level))
+		defer r.Close(nil)
+		setup(r)
+		luatesting.RunSource(r, src)
+		return out.String()
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := run(src, ir.NoOptimisation)
+		for _, level := range []int{ir.BasicOptimisation, ir.FullOptimisation} {
+			if out := run(src, level); out != expected {
+				t.Errorf("%s: output at level %d is %q, expected %q", path, level, out, expected)
+			}
+		}
+	}
+}
+
+// TestRegisterAllocation checks that optimised functions do not need more
+// registers than unoptimised ones.
+func TestRegisterAllocation(t *testing.T) {
+	paths, err := filepath.Glob("lua/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	libPaths, err := filepath.Glob("../lib/*/lua/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = append(paths, libPaths...)
+	type funcKey struct {
+		path string
+		name string
+		line int32
+	}
+	regCounts := func(level int) map[funcKey]int {
+		counts := map[funcKey]int{}
+		r := rt.New(nil, rt.WithOptimisationLevel(level))
+		for _, path := range paths {
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			unit, _, err := r.CompileLuaChunk(path, src)
+			if err != nil {
+				// Some test files check compilation errors.
+				continue
+			}
+			for _, k := range unit.Constants {
+				if c, ok := k.(code.Code); ok {
+					counts[funcKey{path, c.Name, c.LineDefined}] = int(c.RegCount)
+				}
+			}
+		}
+		return counts
+	}
+	before := regCounts(ir.NoOptimisation)
+	after := regCounts(ir.BasicOptimisation)
+	var totalBefore, totalAfter int
+	for key, n := range after {
+		m, ok := before[key]
+		if !ok {
+			continue
+		}
+		if n > m {
+			t.Errorf("%s:%d: %s needs %d registers instead of %d", key.path, key.line, key.name, n, m)
+		}
+		totalBefore += m
+		totalAfter += n
+	}
+	t.Logf("%d registers before allocation, %d after", totalBefore, totalAfter)
+}
@@ -0,0 +1,138 @@
+package runtime
+
+import "unsafe"
+
+// An orderedTable holds the entries of a table which iterates over its keys in
+// the order they were inserted.  The entries are stored in a slice in insertion
+// order and a map gives the position of each key in the slice, so getting and
+// setting values is O(1).
+//
+// Removing a key leaves a "hole" in the slice (an entry with a nil value) so
+// that the positions of the other keys don't change and next() can continue a
+// traversal from a removed key.  The holes are reclaimed when new keys are
+// inserted and there are too many of them.
+type orderedTable struct {
+	entries []orderedEntry
+	index   map[Value]int // Position of each key in entries
+	holes   int           // Number of entries with a nil value
+	border  int64         // Last border found by len()
+}
+
+type orderedEntry struct {
+	key, value Value
+}
+
+// Memory required by a new key in an ordered table.
+const orderedEntrySize = uint64(unsafe.Sizeof(orderedEntry{}) + unsafe.Sizeof(Value{}) + unsafe.Sizeof(int(0)))
+
+// orderedKey returns the key under which k is stored, which is an integer if k
+// is a float with an integer value (as in mixedTable).
+func orderedKey(k Value) Value {
+	if n, ok := ToIntNoString(k); ok {
+		return IntValue(n)
+	}
+	return k
+}
+
+func (t *orderedTable) get(k Value) Value {
+	i, ok := t.index[orderedKey(k)]
+	if !ok {
+		return NilValue
+	}
+	return t.entries[i].value
+}
+
+// set sets k => v and returns true if k is a new key.
+func (t *orderedTable) set(k, v Value) bool {
+	k = orderedKey(k)
+	i, ok := t.index[k]
+	if ok && !t.entries[i].value.IsNil() {
+		t.entries[i].value = v
+		if v.IsNil() {
+			t.holes++
+		}
+		return false
+	}
+	if v.IsNil() {
+		return false
+	}
+	if ok {
+		// The key was removed, so it goes to the end as if it had never been
+		// in the table.
+		t.entries[i].key = NilValue
+	}
+	if t.holes > 8 && t.holes > len(t.entries)/2 {
+		t.compact()
+	}
+	if t.index == nil {
+		t.index = map[Value]int{}
+	}
+	t.index[k] = len(t.entries)
+	t.entries = append(t.entries, orderedEntry{key: k, value: v})
+	return true
+}
+
+// reset sets k => v only if k is already in the table, returning true if that
+// is the case.
+func (t *orderedTable) reset(k, v Value) bool {
+	i, ok := t.index[orderedKey(k)]
+	if !ok || t.entries[i].value.IsNil() {
+		return false
+	}
+	t.entries[i].value = v
+	if v.IsNil() {
+		t.holes++
+	}
+	return true
+}
+
+// compact removes the holes in the entries.
+func (t *orderedTable) compact() {
+	entries := make([]orderedEntry, 0, len(t.entries)-t.holes)
+	for _, e := range t.entries {
+		if e.value.IsNil() {
+			if !e.key.IsNil() {
+				delete(t.index, e.key)
+			}
+			continue
+		}
+		t.index[e.key] = len(entries)
+		entries = append(entries, e)
+	}
+	t.entries = entries
+	t.holes = 0
+}
+
+// len returns a border of the table, starting the search from the last border
+// found so that it is fast when the table is used as a sequence.
+func (t *orderedTable) len() int64 {
+	n := t.border
+	for n > 0 && t.get(IntValue(n)).IsNil() {
+		n--
+	}
+	for !t.get(IntValue(n + 1)).IsNil() {
+		n++
+	}
+	t.border = n
+	return n
+}
+
+// next returns the key-value pair inserted after k in the table, or the first
+// one if k is nil.  Once there are no more pairs, it returns nil keys and
+// values and ok is true.  If k is not in the table, ok is false.
+func (t *orderedTable) next(k Value) (next Value, v Value, ok bool) {
+	i := 0
+	if !k.IsNil() {
+		j, found := t.index[orderedKey(k)]
+		if !found {
+			return
+		}
+		i = j + 1
+	}
+	for ; i < len(t.entries); i++ {
+		if e := t.entries[i]; !e.value.IsNil() {
+			return e.key, e.value, true
+		}
+	}
+	return NilValue, NilValue, true
+}
@@ -0,0 +1,133 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/arnodel/golua/runtime/internal/luaprof"
+)
+
+// DefaultLuaProfilePeriod is the interval between two samples of the Lua call
+// stack when profiling Lua code, unless a different one is specified.
+const DefaultLuaProfilePeriod = 10 * time.Millisecond
+
+// A luaProfiler samples the Lua call stack at regular intervals.  A goroutine
+// increments ticks every period and the next time a continuation running in a
+// profiled thread checks for ticks, it records a sample of its call stack.
+//
+// Samples are taken when a Lua instruction is about to be executed or when a
+// Go function returns, so the time spent in a Go function is attributed to it
+// (rather than to the Lua code calling it).
+type luaProfiler struct {
+	ticks   uint32 // Accessed atomically
+	w       io.Writer
+	builder *luaprof.Builder
+	threads []*Thread // Threads with profiling enabled
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// StartLuaProfile starts profiling the Lua code running in the runtime.  The
+// profile is written to w in the pprof format when StopLuaProfile is called,
+// so it can be analysed with `go tool pprof`.  The call stack is sampled every
+// period (or DefaultLuaProfilePeriod if period is 0).
+//
+// Threads running when the profile is started are not profiled, except for
+// the main thread.
+func (r *Runtime) StartLuaProfile(w io.Writer, period time.Duration) error {
+	if r.profiler != nil {
+		return errors.New("Lua profiling already enabled")
+	}
+	if period <= 0 {
+		period = DefaultLuaProfilePeriod
+	}
+	p := &luaProfiler{
+		w:       w,
+		builder: luaprof.NewBuilder(period),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	r.profiler = p
+	p.enable(r.mainThread)
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				atomic.AddUint32(&p.ticks, 1)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopLuaProfile stops the current Lua profile, if any, and writes it.
+func (r *Runtime) StopLuaProfile() error {
+	p := r.profiler
+	if p == nil {
+		return nil
+	}
+	r.profiler = nil
+	close(p.stop)
+	<-p.stopped
+	for _, t := range p.threads {
+		t.DebugHookFlags &= ^hookFlagProfile
+	}
+	return p.builder.Write(p.w)
+}
+
+// enable turns on profiling in the thread.
+func (p *luaProfiler) enable(t *Thread) {
+	t.DebugHookFlags |= hookFlagProfile
+	p.threads = append(p.threads, t)
+}
+
+// profileSample records a sample of the call stack of c if a sample is due.
+// It must only be called if profiling is enabled in the thread.
+func (t *Thread) profileSample(c Cont) {
+	p := t.profiler
+	if p == nil {
+		return
+	}
+	ticks := atomic.SwapUint32(&p.ticks, 0)
+	if ticks == 0 {
+		return
+	}
+	var stack []luaprof.Frame
+	for ; c != nil; c = c.Parent() {
+		info := c.DebugInfo()
+		if info == nil {
+			continue
+		}
+		f := luaprof.Frame{
+			Function: profileFunctionName(info.Name),
+			File:     info.Source,
+		}
+		if info.LineDefined > 0 {
+			f.StartLine = int64(info.LineDefined)
+		}
+		if info.CurrentLine > 0 {
+			f.Line = int64(info.CurrentLine)
+		}
+		stack = append(stack, f)
+	}
+	p.builder.Add(stack, int64(ticks))
+}
+
+// profileFunctionName returns the name to use for a function in the profile.
+// Names such as "<main chunk>" are turned into "main chunk" because pprof
+// tools drop anything between angle brackets (as they are C++ template
+// arguments).
+func profileFunctionName(name string) string {
+	if strings.HasPrefix(name, "<") && strings.HasSuffix(name, ">") {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLuaProfile(t *testing.T) {
+	r := New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("prof", []byte(`
+local function busy(n)
+    local x = 0
+    for i = 1, n do
+        x = x + i
+    end
+    return x
+end
+local res = busy(10000)
+return res
+`), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := r.StartLuaProfile(&buf, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartLuaProfile(&buf, time.Millisecond); err == nil {
+		t.Error("expected profile to be already started")
+	}
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		if _, err := Call1(r.MainThread(), FunctionValue(clos)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.StopLuaProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if r.MainThread().DebugHookFlags&hookFlagProfile != 0 {
+		t.Error("profiling still enabled in the main thread")
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check that the expected strings are in the profile's string table.
+	for _, s := range []string{"samples", "cpu", "nanoseconds", "busy", "main chunk", "prof"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("profile does not contain %q", s)
+		}
+	}
+}
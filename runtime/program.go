@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/scanner"
+)
+
+// A Program is a compiled Lua chunk which does not belong to any runtime.  It
+// can be loaded into any number of runtimes without being compiled again, and
+// its code and constants are shared by all the runtimes it is loaded into.
+//
+// A Program is immutable, so it is safe for concurrent use.
+type Program struct {
+	code              *Code
+	optimisationLevel int // See OptimisationLevel
+}
+
+// CompileProgram parses and compiles a Lua chunk into a Program, with the
+// given level of optimisation (see WithOptimisationLevel).  As the program
+// does not belong to any runtime, the resources used to compile it are not
+// accounted for by any runtime.
+func CompileProgram(name string, source []byte, optimisationLevel int, scannerOptions ...scanner.Option) (*Program, error) {
+	stat, err := parseLuaChunk(name, scanner.New(name, source, scannerOptions...))
+	if err != nil {
+		return nil, err
+	}
+	kidx, constants, err := compileLuaStatToIR(name, stat, optimisationLevel)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := compileIRToUnit(name, kidx, constants)
+	if err != nil {
+		return nil, err
+	}
+	p := NewProgram(unit)
+	p.optimisationLevel = optimisationLevel
+	return p, nil
+}
+
+// NewProgram returns a Program for the code unit of a compiled chunk.
+func NewProgram(unit *code.Unit) *Program {
+	c := loadUnitCode(unit)
+	c.markShared()
+	return &Program{code: c, optimisationLevel: unknownOptimisationLevel}
+}
+
+// UnmarshalProgram reads a Program from r, which must contain a function
+// serialized with MarshalConst (e.g. the output of string.dump).
+func UnmarshalProgram(r io.Reader) (*Program, error) {
+	k, _, err := UnmarshalConst(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	code, ok := k.TryCode()
+	if !ok {
+		return nil, errors.New("Expected function to load")
+	}
+	code.markShared()
+	return &Program{code: code, optimisationLevel: unknownOptimisationLevel}, nil
+}
+
+// Name returns the chunk name of the program.
+func (p *Program) Name() string {
+	return p.code.source
+}
+
+// OptimisationLevel returns the level of optimisation the program was compiled
+// with by CompileProgram.  It returns -1 if the program was not compiled by
+// CompileProgram (i.e. it was made with NewProgram or UnmarshalProgram), as
+// the level is not known then.
+func (p *Program) OptimisationLevel() int {
+	return p.optimisationLevel
+}
+
+const unknownOptimisationLevel = -1
+
+// LoadProgram returns a closure which runs the program in the given global
+// environment.  The program is not copied, so only the closure is accounted
+// for by the runtime (and its inline caches for the program, the first time
+// it is loaded).
+func (r *Runtime) LoadProgram(p *Program, env Value) *Closure {
+	r.loadSharedIndexCaches(p.code)
+	return r.newChunkClosure(p.code, env)
+}
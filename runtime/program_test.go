@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/arnodel/golua/ir"
+)
+
+const programTestSource = `
+local t = {}
+for i = 1, n do
+    t[i] = "item" .. i
+end
+return #t, t[n]
+`
+
+func runProgram(p *Program, n int64, def RuntimeContextDef) (Value, error) {
+	r := New(nil, WithRuntimeContext(def))
+	defer r.Close(nil)
+	env := NewTable()
+	env.Set(StringValue("n"), IntValue(n))
+	return Call1(r.MainThread(), FunctionValue(r.LoadProgram(p, TableValue(env))))
+}
+
+func TestProgramConcurrentUse(t *testing.T) {
+	p, err := CompileProgram("prog", []byte(programTestSource), ir.NoOptimisation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "prog" {
+		t.Errorf("unexpected name: %q", p.Name())
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			v, err := runProgram(p, n, RuntimeContextDef{})
+			if err != nil {
+				t.Error(err)
+			} else if v.AsInt() != n {
+				t.Errorf("expected %d, got %v", n, v)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+func TestProgramMemoryAccounting(t *testing.T) {
+	if !QuotasAvailable {
+		t.Skip("quotas are not available in this build")
+	}
+	p, err := CompileProgram("prog", []byte(programTestSource), ir.NoOptimisation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(nil, WithRuntimeContext(RuntimeContextDef{HardLimits: RuntimeResources{Memory: 1000000}}))
+	defer r.Close(nil)
+	before := r.UsedResources().Memory
+	r.LoadProgram(p, TableValue(r.GlobalEnv()))
+	loadMem := r.UsedResources().Memory - before
+
+	before = r.UsedResources().Memory
+	if _, err := r.CompileAndLoadLuaChunk("prog", []byte(programTestSource), TableValue(r.GlobalEnv())); err != nil {
+		t.Fatal(err)
+	}
+	compileMem := r.UsedResources().Memory - before
+	if loadMem >= compileMem {
+		t.Errorf("loading a program used %d bytes, compiling used %d", loadMem, compileMem)
+	}
+}
+
+func TestCompileProgramOptimisationLevel(t *testing.T) {
+	for level := ir.NoOptimisation; level <= ir.FullOptimisation; level++ {
+		p, err := CompileProgram("prog", []byte(programTestSource), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.OptimisationLevel() != level {
+			t.Errorf("expected level %d, got %d", level, p.OptimisationLevel())
+		}
+		v, err := runProgram(p, 5, RuntimeContextDef{})
+		if err != nil || v.AsInt() != 5 {
+			t.Errorf("level %d: unexpected result: %v, %v", level, v, err)
+		}
+	}
+}
+
+func TestCompileProgramError(t *testing.T) {
+	_, err := CompileProgram("prog", []byte("x = = 1"), ir.NoOptimisation)
+	if _, ok := AsSyntaxError(err); !ok {
+		t.Errorf("expected syntax error, got %v", err)
+	}
+}
+
+func TestUnmarshalProgram(t *testing.T) {
+	r := New(nil)
+	clos, err := r.CompileAndLoadLuaChunk("prog", []byte(programTestSource), TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := MarshalConst(&buf, CodeValue(r.RefactorCodeConsts(clos.Code)), 0); err != nil {
+		t.Fatal(err)
+	}
+	p, err := UnmarshalProgram(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OptimisationLevel() != -1 {
+		t.Errorf("expected unknown level, got %d", p.OptimisationLevel())
+	}
+	v, err := runProgram(p, 3, RuntimeContextDef{})
+	if err != nil || v.AsInt() != 3 {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+	if _, err := UnmarshalProgram(bytes.NewReader([]byte("foo"))); err == nil {
+		t.Error("expected an error")
+	}
+}
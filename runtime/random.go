@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"math/bits"
+	"time"
+	"unsafe"
+)
+
+// A RandSource provides the pseudo-random numbers of a runtime, e.g. for
+// math.random.  Each runtime has its own RandSource (see WithRandSource), so
+// runtimes do not interfere with each other.
+type RandSource interface {
+	// Uint64 returns the next pseudo-random 64 bit integer.
+	Uint64() uint64
+
+	// Seed resets the source with the 128 bit seed made of n1 and n2.
+	Seed(n1, n2 uint64)
+}
+
+// Xoshiro256 is a RandSource implementing the xoshiro256** algorithm, which is
+// what Lua 5.4 uses.  It is seeded the same way, so it produces the same
+// numbers as Lua 5.4 for the same seed.
+type Xoshiro256 struct {
+	s [4]uint64
+}
+
+var _ RandSource = (*Xoshiro256)(nil)
+
+// NewXoshiro256 returns a new Xoshiro256 seeded with n1 and n2.
+func NewXoshiro256(n1, n2 uint64) *Xoshiro256 {
+	x := new(Xoshiro256)
+	x.Seed(n1, n2)
+	return x
+}
+
+// Uint64 returns the next pseudo-random 64 bit integer.
+func (x *Xoshiro256) Uint64() uint64 {
+	s := &x.s
+	res := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return res
+}
+
+// Seed resets x with the 128 bit seed made of n1 and n2.
+func (x *Xoshiro256) Seed(n1, n2 uint64) {
+	x.s = [4]uint64{n1, 0xff, n2, 0} // 0xff avoids a zero state
+	for i := 0; i < 16; i++ {
+		// Discard the initial values to "spread" the seed.
+		x.Uint64()
+	}
+}
+
+// makeRandSeed returns a seed for the RandSource of r when none is given.  Like
+// in Lua 5.4, it is made from the time and an address so it is not hard to
+// guess.
+func makeRandSeed(r *Runtime) (uint64, uint64) {
+	return uint64(time.Now().UnixNano()), uint64(uintptr(unsafe.Pointer(r)))
+}
+
+// RandSource returns the source of pseudo-random numbers of the runtime (see
+// WithRandSource and WithRandSeed).
+func (r *Runtime) RandSource() RandSource {
+	return r.randSource
+}
@@ -0,0 +1,30 @@
+package runtime
+
+import "testing"
+
+func TestXoshiro256(t *testing.T) {
+	// Reference values of xoshiro256** for the state {1, 2, 3, 4}.
+	x := &Xoshiro256{s: [4]uint64{1, 2, 3, 4}}
+	for i, expected := range []uint64{11520, 0, 1509978240, 1215971899390074240} {
+		if got := x.Uint64(); got != expected {
+			t.Errorf("value %d: expected %d, got %d", i, expected, got)
+		}
+	}
+}
+
+func TestXoshiro256Seed(t *testing.T) {
+	// The first numbers Lua 5.4 draws after math.randomseed(n), computed with
+	// the seeding algorithm of its lmathlib.c.
+	for n, expected := range map[int64][]uint64{
+		0:  {4554719557422691265, 4331835599999590920, 1277915526958806955},
+		42: {17170454028988085989, 8333941968102511665, 10088212813307690315},
+		-7: {9257691157299384515, 8114492888104973061, 2595979366261090995},
+	} {
+		x := NewXoshiro256(uint64(n), 0)
+		for i, v := range expected {
+			if got := x.Uint64(); got != v {
+				t.Errorf("seed %d, value %d: expected %d, got %d", n, i, v, got)
+			}
+		}
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sync/atomic"
+	"time"
 
 	"github.com/arnodel/golua/runtime/internal/luagc"
+	"github.com/arnodel/golua/vfs"
 )
 
 // A Runtime is a Lua runtime.  It contains all the global state of the runtime
@@ -27,6 +30,31 @@ type Runtime struct {
 
 	warner Warner // Lua 5.4 introduces a warning system, implemented by this
 
+	profiler *luaProfiler      // Set when Lua code is being profiled
+	cover    *coverageRecorder // Set when code coverage is being recorded
+
+	codeCache CodeCache // If not nil, compiled Lua chunks are cached there
+	fs        vfs.FS    // The filesystem Lua code has access to
+
+	cancelGracePeriod time.Duration // See WithCancelGracePeriod
+	optimisationLevel int           // See WithOptimisationLevel
+	randSource        RandSource    // See WithRandSource
+	deterministic     bool          // See WithDeterministicMode
+
+	// Coroutines always have a goroutine of their own when true.  This is how
+	// coroutines used to work, it is kept for comparison in benchmarks.
+	coroutineGoroutines bool
+
+	// Lua code runs without inline caches when true (see inlinecache.go).
+	// This is kept for comparison in benchmarks.
+	noInlineCaches bool
+
+	sharedIndexCaches map[*Code][]indexCache // Inline caches for shared code
+
+	snapshotNames map[string]Value // See SetSnapshotName
+
+	liveWeakTables int32 // Number of weak tables not yet collected (see weaktable.go)
+
 	// This has an almost empty implementation when the noquotas build tag is
 	// set.  It should allow the compiler to compile away almost all runtime
 	// context manager methods.
@@ -48,11 +76,20 @@ type runtimeOptions struct {
 	regPoolSize       uint
 	regSetMaxAge      uint
 	runtimeContextDef *RuntimeContextDef
+	codeCache         CodeCache
+	fs                vfs.FS
+	cancelGracePeriod time.Duration
+	optimisationLevel int
+	randSource        RandSource
+	randSeed          *[2]uint64
+	deterministic     bool
 }
 
 var defaultRuntimeOptions = runtimeOptions{
-	regPoolSize:  10,
-	regSetMaxAge: 10,
+	regPoolSize:       10,
+	regSetMaxAge:      10,
+	fs:                vfs.OS,
+	cancelGracePeriod: DefaultCancelGracePeriod,
 }
 
 // A RuntimeOption configures the Runtime.
@@ -80,6 +117,66 @@ func WithRuntimeContext(def RuntimeContextDef) RuntimeOption {
 	}
 }
 
+// WithCodeCache sets a cache for compiled Lua chunks.  When a chunk is loaded
+// from source (e.g. by require, loadfile or LoadFromSourceOrCode), its compiled
+// code is looked up in the cache before compiling it, and stored in the cache
+// after compiling it.
+func WithCodeCache(cache CodeCache) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.codeCache = cache
+	}
+}
+
+// WithFS sets the filesystem that Lua code has access to, via the io and os
+// libraries, loadfile, dofile and require.  The default is vfs.OS, the host
+// filesystem.
+func WithFS(fsys vfs.FS) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.fs = fsys
+	}
+}
+
+// WithCancelGracePeriod sets how long Lua code run with
+// Thread.CallWithContext is given to stop after its Go context is done, before
+// it is terminated.  During that time the runtime context is due (see
+// RuntimeContext.Due).  If d is 0, the code is terminated right away.  The
+// default is DefaultCancelGracePeriod.
+func WithCancelGracePeriod(d time.Duration) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.cancelGracePeriod = d
+	}
+}
+
+// WithOptimisationLevel sets the level of optimisation of the code compiled
+// from Lua source by the runtime.  The levels are defined in the ir package:
+// ir.NoOptimisation (the default), ir.BasicOptimisation and
+// ir.FullOptimisation.
+func WithOptimisationLevel(level int) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.optimisationLevel = level
+	}
+}
+
+// WithRandSource sets the source of the pseudo-random numbers returned by
+// math.random.  By default each runtime has its own Xoshiro256 source, seeded
+// from the time.  The source should not be shared with other runtimes.
+func WithRandSource(src RandSource) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.randSource = src
+		rtOpts.randSeed = nil
+	}
+}
+
+// WithRandSeed makes the runtime use a Xoshiro256 source seeded with n1 and n2,
+// so that math.random returns the same numbers as Lua 5.4 after
+// math.randomseed(n1, n2).
+func WithRandSeed(n1, n2 int64) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.randSource = nil
+		rtOpts.randSeed = &[2]uint64{uint64(n1), uint64(n2)}
+	}
+}
+
 // New returns a new pointer to a Runtime with the given stdout.
 func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 	rtOpts := defaultRuntimeOptions
@@ -94,6 +191,21 @@ func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 		regPool:   mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
 		argsPool:  mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
 		cellPool:  mkCellPool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
+		codeCache: rtOpts.codeCache,
+		fs:        rtOpts.fs,
+
+		cancelGracePeriod: rtOpts.cancelGracePeriod,
+		optimisationLevel: rtOpts.optimisationLevel,
+		randSource:        rtOpts.randSource,
+		deterministic:     rtOpts.deterministic,
+	}
+	if r.deterministic && r.randSource == nil && rtOpts.randSeed == nil {
+		rtOpts.randSeed = &[2]uint64{0, 0}
+	}
+	if seed := rtOpts.randSeed; seed != nil {
+		r.randSource = NewXoshiro256(seed[0], seed[1])
+	} else if r.randSource == nil {
+		r.randSource = NewXoshiro256(makeRandSeed(r))
 	}
 
 	mainThread := NewThread(r)
@@ -106,7 +218,14 @@ func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 
 	r.runtimeContextManager.initRoot()
 
-	if rtOpts.runtimeContextDef != nil {
+	if r.deterministic {
+		var def RuntimeContextDef
+		if rtOpts.runtimeContextDef != nil {
+			def = *rtOpts.runtimeContextDef
+		}
+		def.RequiredFlags |= ComplyDetSafe
+		r.PushContext(def)
+	} else if rtOpts.runtimeContextDef != nil {
 		r.PushContext(*rtOpts.runtimeContextDef)
 	}
 
@@ -114,6 +233,23 @@ func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 	return r
 }
 
+// FS returns the filesystem that Lua code has access to (see WithFS).
+func (r *Runtime) FS() vfs.FS {
+	return r.fs
+}
+
+// OptimisationLevel returns the level of optimisation of the code compiled by
+// the runtime (see WithOptimisationLevel).
+func (r *Runtime) OptimisationLevel() int {
+	return r.optimisationLevel
+}
+
+// SetOptimisationLevel sets the level of optimisation of the code compiled by
+// the runtime from now on (see WithOptimisationLevel).
+func (r *Runtime) SetOptimisationLevel(level int) {
+	r.optimisationLevel = level
+}
+
 // GlobalEnv returns the global environment of the runtime.
 func (r *Runtime) GlobalEnv() *Table {
 	return r.globalEnv
@@ -191,6 +327,9 @@ func (r *Runtime) SetRawMetatable(v Value, meta *Table) {
 	case TableType:
 		tbl := v.AsTable()
 		tbl.SetMetatable(meta)
+		if !r.deterministic {
+			tbl.setWeakMode(RawGet(meta, MetaFieldModeValue), r.weakRefPool, &r.liveWeakTables)
+		}
 		if !RawGet(meta, MetaFieldGcValue).IsNil() {
 			r.addFinalizer(tbl, luagc.Finalize)
 		}
@@ -210,6 +349,11 @@ func (r *Runtime) addFinalizer(ref luagc.Value, flags luagc.MarkFlags) {
 }
 
 func (r *Runtime) runPendingFinalizers() {
+	if r.Deterministic() {
+		// Finalizers run when the context is popped or the runtime is closed,
+		// as when values are collected is not deterministic.
+		return
+	}
 
 	// Running finalizers may panic if we run out of resources
 	pendingFinalize := r.weakRefPool.ExtractPendingFinalize()
@@ -237,11 +381,47 @@ func (r *Runtime) runFinalizers(refs []luagc.Value) {
 
 func (t *Thread) CollectGarbage() {
 	if t != t.gcThread {
-		runtime.GC()
+		if atomic.LoadInt32(&t.liveWeakTables) == 0 {
+			runtime.GC()
+		} else {
+			// Values only reachable from values collected in a cycle (e.g. the
+			// values of weak keys), or kept alive because their weak refs were
+			// used recently, can only be collected by the next cycle.  So one
+			// more cycle is run if the first one collected or kept alive such
+			// values.
+			dead, resurrected := luagc.DeadCount(), luagc.ResurrectedCount()
+			waitForGoFinalizers()
+			if luagc.DeadCount() != dead || luagc.ResurrectedCount() != resurrected {
+				waitForGoFinalizers()
+			}
+		}
 		t.runPendingFinalizers()
 	}
 }
 
+// Maximum number of times waitForGoFinalizers yields to the finalizer
+// goroutine.
+const maxFinalizerYields = 1000
+
+// waitForGoFinalizers runs a GC cycle and waits until the Go finalizers of the
+// values it found unreachable have run, so that collecting garbage has
+// predictable effects on weak tables and Lua finalizers.  The wait is bounded
+// by a number of yields to the Go scheduler rather than by a timer, so that it
+// does not depend on the wall clock and gives up if a finalizer is blocked.
+func waitForGoFinalizers() {
+	done := make(chan struct{})
+	runtime.SetFinalizer(&struct{ _ *int }{}, func(interface{}) { close(done) })
+	runtime.GC()
+	for i := 0; i < maxFinalizerYields; i++ {
+		select {
+		case <-done:
+			return
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
 func (r *Runtime) Close(err *error) {
 	runtime.SetFinalizer(r, nil)
 	if r := recover(); r != nil {
@@ -291,7 +471,14 @@ func (r *Runtime) Metatable(v Value) Value {
 // CPU.
 func (r *Runtime) SetTable(t *Table, k, v Value) {
 	r.RequireCPU(1)
-	r.RequireMem(t.Set(k, v))
+	if r.deterministic && t.hashTable == nil && t.ordered == nil {
+		// The iteration order of the hash table part is not deterministic, so
+		// tables iterate in insertion order instead.  This is not done in
+		// detsafe contexts of other runtimes, as tables made ordered would stay
+		// so after the context is popped.
+		t.makeOrdered()
+	}
+	r.requireTrackedMem(&t.memTracker, t.Set(k, v))
 }
 
 var errTableIndexIsNil = errors.New("table index is nil")
@@ -39,6 +39,7 @@ const (
 // should be terminated immediately.
 type ContextTerminationError struct {
 	message string
+	cause   error // The Go context error if terminated by Thread.CallWithContext
 }
 
 var _ error = ContextTerminationError{}
@@ -48,6 +49,12 @@ func (e ContextTerminationError) Error() string {
 	return e.message
 }
 
+// Unwrap returns the error of the Go context which caused the termination, if
+// any (see Thread.CallWithContext).
+func (e ContextTerminationError) Unwrap() error {
+	return e.cause
+}
+
 // RuntimeContextStatus describes the status of a context
 type RuntimeContextStatus uint16
 
@@ -100,6 +107,16 @@ const (
 	// running ops, typically IO)
 	ComplyTimeSafe
 
+	// Only execute code that does not spawn processes (io.popen and
+	// os.execute comply with this by refusing to spawn processes)
+	ComplyExecSafe
+
+	// Only execute code that is deterministic, i.e. which produces the same
+	// results given the same inputs.  Code running in a context with this flag
+	// sees a virtual clock and stable table iteration order (see
+	// WithDeterministicMode).
+	ComplyDetSafe
+
 	complyflagsLimit
 )
 
@@ -108,6 +125,8 @@ const (
 	cpuSafeString  = "cpusafe"
 	timeSafeString = "timesafe"
 	ioSafeString   = "iosafe"
+	execSafeString = "execsafe"
+	detSafeString  = "detsafe"
 )
 
 var complianceFlagNames = map[ComplianceFlags]string{
@@ -115,6 +134,8 @@ var complianceFlagNames = map[ComplianceFlags]string{
 	ComplyCpuSafe:  cpuSafeString,
 	ComplyTimeSafe: timeSafeString,
 	ComplyIoSafe:   ioSafeString,
+	ComplyExecSafe: execSafeString,
+	ComplyDetSafe:  detSafeString,
 }
 
 var complianceFlagsByName = map[string]ComplianceFlags{
@@ -122,6 +143,8 @@ var complianceFlagsByName = map[string]ComplianceFlags{
 	cpuSafeString:  ComplyCpuSafe,
 	timeSafeString: ComplyTimeSafe,
 	ioSafeString:   ComplyIoSafe,
+	execSafeString: ComplyExecSafe,
+	detSafeString:  ComplyDetSafe,
 }
 
 func (f ComplianceFlags) AddFlagWithName(name string) (ComplianceFlags, bool) {
@@ -206,5 +229,40 @@ const (
 	DefaultGCPolicy GCPolicy = iota
 	ShareGCPolicy
 	IsolateGCPolicy
+	LiveMemGCPolicy // Like IsolateGCPolicy, and memory is credited back when objects are collected
 	UnknownGCPolicy
 )
+
+const (
+	shareGCPolicyString   = "share"
+	isolateGCPolicyString = "isolate"
+	liveMemGCPolicyString = "livemem"
+)
+
+func (p GCPolicy) String() string {
+	switch p {
+	case ShareGCPolicy:
+		return shareGCPolicyString
+	case IsolateGCPolicy:
+		return isolateGCPolicyString
+	case LiveMemGCPolicy:
+		return liveMemGCPolicyString
+	default:
+		return ""
+	}
+}
+
+// GCPolicyWithName returns the GC policy whose String() is name, with ok false
+// if there is none.
+func GCPolicyWithName(name string) (p GCPolicy, ok bool) {
+	switch name {
+	case shareGCPolicyString:
+		return ShareGCPolicy, true
+	case isolateGCPolicyString:
+		return IsolateGCPolicy, true
+	case liveMemGCPolicyString:
+		return LiveMemGCPolicy, true
+	default:
+		return UnknownGCPolicy, false
+	}
+}
@@ -42,6 +42,9 @@ type runtimeContextManager struct {
 
 	weakRefPool luagc.Pool
 	gcPolicy    GCPolicy
+	memAccount  *memAccount // Non-nil if memory is credited back (see memaccount.go)
+
+	stopSig *stopSignal // Set when the context can be stopped from a Go context
 }
 
 var _ RuntimeContext = (*runtimeContextManager)(nil)
@@ -60,6 +63,9 @@ func (m *runtimeContextManager) SoftLimits() RuntimeResources {
 }
 
 func (m *runtimeContextManager) UsedResources() RuntimeResources {
+	if m.memAccount != nil {
+		m.takeMemCredits()
+	}
 	return m.usedResources
 }
 
@@ -95,9 +101,37 @@ func (m *runtimeContextManager) SetStopLevel(stopLevel StopLevel) {
 }
 
 func (m *runtimeContextManager) Due() bool {
+	if m.stopSig != nil {
+		m.pollStopSignal()
+	}
 	return m.stopLevel&SoftStop != 0 || !m.softLimits.Dominates(m.usedResources)
 }
 
+func (m *runtimeContextManager) stopSignal() *stopSignal {
+	return m.stopSig
+}
+
+// setStopSignal makes the context poll s for stop requests.  CPU is tracked so
+// that the polling happens regularly.
+func (m *runtimeContextManager) setStopSignal(s *stopSignal) {
+	m.stopSig = s
+	m.trackCpu = true
+}
+
+// pollStopSignal updates the stop level from the stop signal, terminating the
+// context if it has been hard-stopped.
+func (m *runtimeContextManager) pollStopSignal() {
+	lvl, err := m.stopSig.load()
+	if lvl == 0 {
+		return
+	}
+	m.stopLevel |= lvl
+	if lvl&HardStop != 0 && m.status == StatusLive {
+		m.status = StatusKilled
+		panic(ContextTerminationError{message: err.Error(), cause: err})
+	}
+}
+
 func (m *runtimeContextManager) RuntimeContext() RuntimeContext {
 	return m
 }
@@ -123,18 +157,34 @@ func (m *runtimeContextManager) PushContext(ctx RuntimeContextDef) {
 		m.requiredFlags |= ComplyTimeSafe
 	}
 	m.trackTime = m.hardLimits.Millis > 0 || m.softLimits.Millis > 0
-	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime
+	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime || m.stopSig != nil
 	m.trackMem = m.hardLimits.Memory > 0 || m.softLimits.Memory > 0
+	det := m.requiredFlags&ComplyDetSafe != 0
+	if det {
+		// CPU and memory always need tracking as they drive the virtual clock
+		// and the memory count.
+		m.trackCpu = true
+		m.trackMem = true
+	}
 	m.status = StatusLive
 	m.messageHandler = ctx.MessageHandler
 	m.parent = &parent
-	if ctx.GCPolicy == IsolateGCPolicy || ctx.HardLimits.Millis > 0 || ctx.HardLimits.Cpu > 0 || ctx.HardLimits.Memory > 0 {
+	switch {
+	case ctx.GCPolicy == LiveMemGCPolicy && !det:
+		m.weakRefPool = luagc.NewDefaultPool()
+		m.gcPolicy = LiveMemGCPolicy
+	case ctx.GCPolicy == IsolateGCPolicy || ctx.HardLimits.Millis > 0 || ctx.HardLimits.Cpu > 0 || ctx.HardLimits.Memory > 0:
 		m.weakRefPool = luagc.NewDefaultPool()
 		m.gcPolicy = IsolateGCPolicy
-	} else {
+	default:
 		m.weakRefPool = parent.weakRefPool
 		m.gcPolicy = ShareGCPolicy
 	}
+	// Memory is not credited back in deterministic contexts as it depends on
+	// when the Go GC runs.
+	if !det && (m.gcPolicy == LiveMemGCPolicy || parent.memAccount != nil) {
+		m.memAccount = newMemAccount(parent.memAccount)
+	}
 }
 
 func (m *runtimeContextManager) GCPolicy() GCPolicy {
@@ -145,14 +195,19 @@ func (m *runtimeContextManager) PopContext() RuntimeContext {
 	if m == nil || m.parent == nil {
 		return nil
 	}
-	if m.gcPolicy == IsolateGCPolicy {
+	if m.gcPolicy != ShareGCPolicy {
 		m.weakRefPool.ExtractAllMarkedFinalize()
 		releaseResources(m.weakRefPool.ExtractAllMarkedRelease())
 	}
+	if m.memAccount != nil {
+		m.takeMemCredits()
+		m.memAccount.close()
+	}
 	mCopy := *m
 	if mCopy.status == StatusLive {
 		mCopy.status = StatusDone
 	}
+	mCopy.memAccount = nil
 	m.parent.RequireCPU(m.usedResources.Cpu)
 	m.parent.RequireMem(m.usedResources.Memory)
 	*m = *m.parent
@@ -172,6 +227,9 @@ func (m *runtimeContextManager) RequireCPU(cpuAmount uint64) {
 
 //go:noinline
 func (m *runtimeContextManager) requireCPU(cpuAmount uint64) {
+	if m.stopSig != nil {
+		m.pollStopSignal()
+	}
 	if m.stopLevel&HardStop != 0 {
 		m.KillContext()
 	}
@@ -203,13 +261,58 @@ func (m *runtimeContextManager) requireMem(memAmount uint64) {
 	if m.stopLevel&HardStop != 0 {
 		m.KillContext()
 	}
+	if m.memAccount != nil {
+		m.takeMemCredits()
+	}
 	memUsed := m.usedResources.Memory + memAmount
+	if atLimit(memUsed, m.hardLimits.Memory) && m.memAccount != nil {
+		// Some of the memory may be used by garbage which has not been
+		// collected yet.
+		waitForGoFinalizers()
+		m.takeMemCredits()
+		memUsed = m.usedResources.Memory + memAmount
+	}
 	if atLimit(memUsed, m.hardLimits.Memory) {
 		m.TerminateContext("memory limit of %d exceeded", m.hardLimits.Memory)
 	}
 	m.usedResources.Memory = memUsed
 }
 
+// totalUsedResources returns the CPU and memory used by the context and all its
+// ancestors so far.
+func (m *runtimeContextManager) totalUsedResources() (r RuntimeResources) {
+	for ; m != nil; m = m.parent {
+		r.Cpu += m.usedResources.Cpu
+		r.Memory += m.usedResources.Memory
+	}
+	return
+}
+
+// requireTrackedMem is like RequireMem, but if the context credits memory back
+// the memory is credited when the object whose tracker is *tr is collected.
+func (m *runtimeContextManager) requireTrackedMem(tr **memTracker, memAmount uint64) {
+	if m.trackMem {
+		m.requireAndTrackMem(tr, memAmount)
+	}
+}
+
+//go:noinline
+func (m *runtimeContextManager) requireAndTrackMem(tr **memTracker, memAmount uint64) {
+	m.requireMem(memAmount)
+	if m.memAccount != nil && memAmount > 0 {
+		trackMem(tr, m.memAccount, memAmount)
+	}
+}
+
+// takeMemCredits counts down the memory credited back to the context.
+func (m *runtimeContextManager) takeMemCredits() {
+	credits := m.memAccount.take()
+	if credits > m.usedResources.Memory {
+		credits = m.usedResources.Memory
+	}
+	m.usedResources.Memory -= credits
+}
+
 func (m *runtimeContextManager) RequireSize(sz uintptr) (mem uint64) {
 	mem = uint64(sz)
 	m.RequireMem(mem)
@@ -257,7 +360,12 @@ func (m *runtimeContextManager) UnusedMem() uint64 {
 }
 
 func (m *runtimeContextManager) updateTimeUsed() {
-	m.usedResources.Millis = now() - m.startTime
+	if m.requiredFlags&ComplyDetSafe != 0 {
+		// Time is virtual in deterministic contexts (see Runtime.Now).
+		m.usedResources.Millis = uint64(time.Duration(m.usedResources.Cpu) * VirtualTickDuration / time.Millisecond)
+	} else {
+		m.usedResources.Millis = now() - m.startTime
+	}
 	if atLimit(m.usedResources.Millis, m.hardLimits.Millis) {
 		m.TerminateContext("time limit of %d exceeded", m.hardLimits.Millis)
 	}
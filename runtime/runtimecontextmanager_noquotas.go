@@ -35,6 +35,10 @@ func (m *runtimeContextManager) UsedResources() (r RuntimeResources) {
 	return
 }
 
+func (m *runtimeContextManager) totalUsedResources() (r RuntimeResources) {
+	return
+}
+
 func (m *runtimeContextManager) setStatus(RuntimeContextStatus) {
 }
 
@@ -61,6 +65,19 @@ func (m *runtimeContextManager) Due() bool {
 func (m *runtimeContextManager) SetStopLevel(StopLevel) {
 }
 
+func (m *runtimeContextManager) stopSignal() *stopSignal {
+	return nil
+}
+
+func (m *runtimeContextManager) setStopSignal(*stopSignal) {
+}
+
+func (m *runtimeContextManager) pollStopSignal() {
+}
+
+func (m *runtimeContextManager) requireTrackedMem(**memTracker, uint64) {
+}
+
 func (m *runtimeContextManager) GCPolicy() GCPolicy {
 	return ShareGCPolicy
 }
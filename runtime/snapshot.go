@@ -0,0 +1,827 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Snapshots of suspended coroutines.
+//
+// As a coroutine suspended without a Go function mid-stack (see Thread.Resume)
+// is just a chain of continuations, it can be serialised together with all the
+// values it can reach: closures, upvalues, tables, other coroutines...  Values
+// which are shared with the rest of the program are serialised only once, so
+// they are still shared when the snapshot is restored.
+//
+// Go functions and userdata cannot be serialised.  Instead they are written as
+// a name, which is looked up when the snapshot is restored.  A value is named
+// either explicitly with Runtime.SetSnapshotName, or implicitly by its path
+// from the global environment, e.g. "string.format".  In the same way, tables
+// reachable from the global environment are named.  When the new runtime has a
+// table with the same name (e.g. its global environment or the string library)
+// the restored values share it, and the contents of the table in the snapshot
+// are ignored.  So restoring a snapshot never modifies the tables of the new
+// runtime, even when it fails.  Other tables are restored as new tables.
+
+const snapshotVersion = 2
+
+// Tags for values in a snapshot.
+const (
+	snapNil byte = iota
+	snapFalse
+	snapTrue
+	snapInt
+	snapFloat
+	snapString
+	snapRef  // Value already in the snapshot
+	snapName // Value looked up by name
+	snapTable
+	snapClosure
+	snapCode
+	snapCell
+	snapArray
+	snapLuaCont
+	snapGoCont
+	snapTermination
+	snapThread
+	snapMainThread
+	snapOrderedTable
+)
+
+// Ways a suspended thread can be resumed.
+const (
+	snapThreadNotStarted byte = iota
+	snapThreadYielded
+)
+
+// SetSnapshotName registers v under the given name, so that it can be written
+// to and read from snapshots (see MarshalThread) even though it cannot be
+// serialised, e.g. because it is a Go function which is not reachable from the
+// global environment.  The runtime restoring a snapshot must have registered
+// the same names.
+func (r *Runtime) SetSnapshotName(name string, v Value) {
+	if r.snapshotNames == nil {
+		r.snapshotNames = map[string]Value{}
+	}
+	r.snapshotNames[name] = v
+}
+
+// MarshalThread writes a snapshot of the suspended thread t to w.  It can be
+// restored with UnmarshalThread, in this runtime or another one.
+//
+// A thread cannot be serialised if it is suspended with a Go function
+// mid-stack, or if it can reach a Go function or a userdata which has no name
+// (see SetSnapshotName).
+func MarshalThread(w io.Writer, t *Thread) error {
+	if t.IsMain() {
+		return errors.New("cannot snapshot the main thread")
+	}
+	if t.status != ThreadSuspended {
+		return errors.New("cannot snapshot a thread which is not suspended")
+	}
+	if _, err := w.Write(marshalPrefix); err != nil {
+		return err
+	}
+	sw := snapshotWriter{
+		bwriter: bwriter{w: w},
+		r:       t.Runtime,
+		ids:     map[interface{}]uint32{},
+		names:   t.snapshotNameMap(),
+	}
+	sw.write(uint8(snapshotVersion))
+	sw.writeValue(ThreadValue(t))
+	return sw.err
+}
+
+// UnmarshalThread reads a snapshot written by MarshalThread from rd and returns
+// the suspended thread it contains, ready to be resumed in r.
+func UnmarshalThread(r *Runtime, rd io.Reader) (*Thread, error) {
+	pfx := make([]byte, len(marshalPrefix))
+	if _, err := io.ReadFull(rd, pfx); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pfx, marshalPrefix) {
+		return nil, ErrInvalidMarshalPrefix
+	}
+	sr := snapshotReader{
+		breader: breader{r: rd},
+		r:       r,
+	}
+	var version uint8
+	sr.read(1, &version)
+	if sr.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	v := sr.readValue()
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	t, ok := v.TryThread()
+	if !ok {
+		return nil, errors.New("snapshot does not contain a thread")
+	}
+	return t, nil
+}
+
+// snapshotNameMap returns the names of values which are not serialised but
+// looked up by name when a snapshot is restored.
+func (r *Runtime) snapshotNameMap() map[interface{}]string {
+	names := map[interface{}]string{}
+	for name, v := range r.snapshotNames {
+		names[v.Interface()] = name
+	}
+	if _, ok := names[r.globalEnv]; !ok {
+		names[r.globalEnv] = "_G"
+	}
+	type item struct {
+		t    *Table
+		path string
+	}
+	// A breadth first search so that values get their shortest path as name.
+	queue := []item{{t: r.globalEnv}}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		var keys []Value
+		for k, v, ok := it.t.Next(NilValue); ok && !k.IsNil(); k, v, ok = it.t.Next(k) {
+			if snapshotPathSegment(k) == "" {
+				continue
+			}
+			switch v.Interface().(type) {
+			case *Table, *GoFunction, *UserData:
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return snapshotPathSegment(keys[i]) < snapshotPathSegment(keys[j])
+		})
+		for _, k := range keys {
+			v := it.t.Get(k).Interface()
+			if _, ok := names[v]; ok {
+				continue
+			}
+			path := it.path + snapshotPathSegment(k)
+			if path[0] == '.' && it.path == "" {
+				path = path[1:]
+			}
+			names[v] = path
+			if tbl, ok := v.(*Table); ok {
+				queue = append(queue, item{t: tbl, path: path})
+			}
+		}
+	}
+	return names
+}
+
+// snapshotPathSegment returns how the key k is written in a path, e.g. ".foo"
+// or "[1]", or "" if it can't be part of a path.
+func snapshotPathSegment(k Value) string {
+	switch x := k.Interface().(type) {
+	case string:
+		if x == "" || strings.ContainsAny(x, ".[") {
+			return ""
+		}
+		return "." + x
+	case int64:
+		return "[" + strconv.FormatInt(x, 10) + "]"
+	}
+	return ""
+}
+
+// lookupSnapshotName returns the value with the given name in r (see
+// snapshotNameMap).
+func (r *Runtime) lookupSnapshotName(name string) (Value, bool) {
+	if v, ok := r.snapshotNames[name]; ok {
+		return v, true
+	}
+	v := TableValue(r.globalEnv)
+	if name == "_G" {
+		return v, true
+	}
+	if name == "" {
+		return NilValue, false
+	}
+	path := name
+	if path[0] != '[' {
+		path = "." + path
+	}
+	for path != "" {
+		t, ok := v.TryTable()
+		if !ok {
+			return NilValue, false
+		}
+		var k Value
+		if path[0] == '[' {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return NilValue, false
+			}
+			n, err := strconv.ParseInt(path[1:end], 10, 64)
+			if err != nil {
+				return NilValue, false
+			}
+			k, path = IntValue(n), path[end+1:]
+		} else {
+			end := strings.IndexAny(path[1:], ".[") + 1
+			if end == 0 {
+				end = len(path)
+			}
+			k, path = StringValue(path[1:end]), path[end:]
+		}
+		v = t.Get(k)
+	}
+	return v, !v.IsNil()
+}
+
+// snapshotWriter: serialises a graph of values.
+type snapshotWriter struct {
+	bwriter
+	r     *Runtime
+	ids   map[interface{}]uint32 // Objects already written
+	names map[interface{}]string // See Runtime.snapshotNameMap
+}
+
+// writeRef writes a reference to x if it has already been written and returns
+// true.  Otherwise it gives x the next id and returns false.
+func (w *snapshotWriter) writeRef(x interface{}) bool {
+	if id, ok := w.ids[x]; ok {
+		w.write(snapRef, id)
+		return true
+	}
+	w.ids[x] = uint32(len(w.ids))
+	return false
+}
+
+func (w *snapshotWriter) writeName(x interface{}, what string) {
+	name, ok := w.names[x]
+	if !ok {
+		w.err = fmt.Errorf("cannot snapshot %s: it is not reachable from the global environment and has no snapshot name", what)
+		return
+	}
+	w.write(snapName, name)
+}
+
+func (w *snapshotWriter) writeValue(v Value) {
+	if w.err != nil {
+		return
+	}
+	switch x := v.Interface().(type) {
+	case nil:
+		w.write(snapNil)
+	case bool:
+		if x {
+			w.write(snapTrue)
+		} else {
+			w.write(snapFalse)
+		}
+	case int64:
+		w.write(snapInt, x)
+	case float64:
+		w.write(snapFloat, x)
+	case string:
+		w.write(snapString, x)
+	case *Table:
+		w.writeTable(x)
+	case *Closure:
+		w.writeClosure(x)
+	case *GoFunction:
+		w.writeName(x, fmt.Sprintf("Go function %q", x.name))
+	case *UserData:
+		w.writeName(x, fmt.Sprintf("userdata of type %s", v.CustomTypeName()))
+	case *Thread:
+		w.writeThread(x)
+	case *Code:
+		w.writeCodeValue(x)
+	case []Value:
+		w.writeValues(snapArray, x)
+	case Cont:
+		w.writeCont(x)
+	default:
+		w.err = fmt.Errorf("cannot snapshot value of type %T", x)
+	}
+}
+
+func (w *snapshotWriter) writeValues(tag byte, vs []Value) {
+	w.write(tag, int64(len(vs)))
+	for _, v := range vs {
+		w.writeValue(v)
+	}
+}
+
+func (w *snapshotWriter) writeTable(t *Table) {
+	if w.writeRef(t) {
+		return
+	}
+	tag := snapTable
+	if t.ordered != nil {
+		tag = snapOrderedTable
+	}
+	w.write(tag, w.names[t])
+	if t.meta == nil {
+		w.writeValue(NilValue)
+	} else {
+		w.writeValue(TableValue(t.meta))
+	}
+	for k, v, ok := t.Next(NilValue); ok && !k.IsNil() && w.err == nil; k, v, ok = t.Next(k) {
+		w.writeValue(k)
+		w.writeValue(v)
+	}
+	w.writeValue(NilValue)
+}
+
+func (w *snapshotWriter) writeCodeValue(c *Code) {
+	if w.writeRef(c) {
+		return
+	}
+	w.write(snapCode)
+	if w.err == nil {
+		// Functions in the same unit share their constants, which may include
+		// themselves, so only write the constants they use.
+		w.writeCode(w.r.RefactorCodeConsts(c))
+	}
+}
+
+func (w *snapshotWriter) writeCell(c Cell) {
+	if w.err != nil || w.writeRef(c.ref) {
+		return
+	}
+	w.write(snapCell)
+	w.writeValue(*c.ref)
+}
+
+func (w *snapshotWriter) writeCells(cells []Cell) {
+	w.write(int64(len(cells)))
+	for _, c := range cells {
+		w.writeCell(c)
+	}
+}
+
+func (w *snapshotWriter) writeClosure(c *Closure) {
+	if w.writeRef(c) {
+		return
+	}
+	w.write(snapClosure)
+	w.writeCodeValue(c.Code)
+	w.write(int64(c.upvalueIndex))
+	w.writeCells(c.Upvalues)
+}
+
+func (w *snapshotWriter) writeCont(c Cont) {
+	if w.err != nil || w.writeRef(c) {
+		return
+	}
+	switch cc := c.(type) {
+	case *LuaCont:
+		w.write(snapLuaCont)
+		w.writeClosure(cc.Closure)
+		w.write(cc.pc, cc.running, cc.borrowedCells, cc.tailCall, int64(cc.closeStackBase))
+		w.writeValues(snapArray, cc.registers)
+		if !cc.borrowedCells {
+			w.writeCells(cc.cells)
+		}
+		w.writeValues(snapArray, cc.acc)
+	case *GoCont:
+		w.write(snapGoCont)
+		w.writeValue(FunctionValue(cc.GoFunction))
+		w.writeValue(contOrNil(cc.next))
+		w.write(cc.tailCall)
+		w.writeValues(snapArray, cc.args[:cc.nArgs])
+		w.writeValues(snapArray, derefEtc(cc.etc))
+	case *Termination:
+		w.write(snapTermination)
+		w.writeValue(contOrNil(cc.parent))
+		w.write(int64(cc.pushIndex), cc.etc != nil)
+		w.writeValues(snapArray, cc.args)
+		w.writeValues(snapArray, derefEtc(cc.etc))
+	default:
+		w.err = fmt.Errorf("cannot snapshot continuation of type %T", c)
+	}
+}
+
+func (w *snapshotWriter) writeThread(t *Thread) {
+	if t == w.r.mainThread {
+		w.write(snapMainThread)
+		return
+	}
+	if w.writeRef(t) {
+		return
+	}
+	switch {
+	case t.status == ThreadOK:
+		w.err = errors.New("cannot snapshot a running thread")
+	case t.hasGoroutine:
+		w.err = errors.New("cannot snapshot a thread suspended with a Go function mid-stack")
+	}
+	w.write(snapThread, uint8(t.status))
+	if t.status == ThreadDead {
+		return
+	}
+	if t.start != nil {
+		w.write(snapThreadNotStarted)
+		w.writeValue(FunctionValue(t.start))
+		return
+	}
+	w.write(snapThreadYielded)
+	w.writeValue(ContValue(t.resumeCont))
+	w.writeValue(ContValue(t.term))
+	w.writeValues(snapArray, t.closeStack.stack)
+}
+
+func contOrNil(c Cont) Value {
+	if c == nil {
+		return NilValue
+	}
+	return ContValue(c)
+}
+
+func derefEtc(etc *[]Value) []Value {
+	if etc == nil {
+		return nil
+	}
+	return *etc
+}
+
+// snapshotReader: deserialises a graph of values.
+type snapshotReader struct {
+	breader
+	r    *Runtime
+	objs []interface{} // Objects read so far, indexed by id
+}
+
+var errInvalidSnapshot = errors.New("invalid snapshot")
+
+// newObj reserves an id for an object about to be read.
+func (r *snapshotReader) newObj() int {
+	r.objs = append(r.objs, nil)
+	return len(r.objs) - 1
+}
+
+func (r *snapshotReader) readTag() (tag byte) {
+	r.read(1, &tag)
+	return
+}
+
+func (r *snapshotReader) readInt() int {
+	var n int64
+	r.read(8, &n)
+	if n < 0 {
+		r.err = errInvalidSnapshot
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapshotReader) readRef() interface{} {
+	var id uint32
+	r.read(4, &id)
+	if r.err != nil {
+		return nil
+	}
+	if int(id) >= len(r.objs) || r.objs[id] == nil {
+		r.err = errInvalidSnapshot
+		return nil
+	}
+	return r.objs[id]
+}
+
+func (r *snapshotReader) readValue() Value {
+	if r.err != nil {
+		return NilValue
+	}
+	switch tag := r.readTag(); tag {
+	case snapNil:
+		return NilValue
+	case snapFalse:
+		return BoolValue(false)
+	case snapTrue:
+		return BoolValue(true)
+	case snapInt:
+		var n int64
+		r.read(8, &n)
+		return IntValue(n)
+	case snapFloat:
+		var f float64
+		r.read(8, &f)
+		return FloatValue(f)
+	case snapString:
+		return StringValue(r.readString())
+	case snapRef:
+		x := r.readRef()
+		if r.err != nil {
+			return NilValue
+		}
+		if c, ok := x.(Cont); ok {
+			return ContValue(c)
+		}
+		return AsValue(x)
+	case snapName:
+		name := r.readString()
+		if r.err != nil {
+			return NilValue
+		}
+		v, ok := r.r.lookupSnapshotName(name)
+		if !ok {
+			r.err = fmt.Errorf("cannot restore snapshot: %q not found", name)
+		}
+		return v
+	case snapTable:
+		return TableValue(r.readTable(false))
+	case snapOrderedTable:
+		return TableValue(r.readTable(true))
+	case snapClosure:
+		return FunctionValue(r.readClosure())
+	case snapCode:
+		return CodeValue(r.readCodeValue())
+	case snapArray:
+		return ArrayValue(r.readValues())
+	case snapLuaCont, snapGoCont, snapTermination:
+		return ContValue(r.readCont(tag))
+	case snapThread:
+		return ThreadValue(r.readThread())
+	case snapMainThread:
+		return ThreadValue(r.r.mainThread)
+	case snapCell:
+		// Cells only appear as upvalues or registers
+		r.err = errInvalidSnapshot
+	default:
+		if r.err == nil {
+			r.err = errInvalidSnapshot
+		}
+	}
+	return NilValue
+}
+
+func (r *snapshotReader) readValues() []Value {
+	n := r.readInt()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	vs := make([]Value, n)
+	for i := range vs {
+		vs[i] = r.readValue()
+	}
+	return vs
+}
+
+// readArray reads a value which must be an array (as written by
+// snapshotWriter.writeValues).
+func (r *snapshotReader) readArray() []Value {
+	if tag := r.readTag(); tag != snapArray && r.err == nil {
+		r.err = errInvalidSnapshot
+	}
+	return r.readValues()
+}
+
+// readTable reads a table.  If it has a name which is the name of a table in the
+// runtime, that table is returned instead and the contents read are discarded
+// (see the top of this file).
+func (r *snapshotReader) readTable(ordered bool) *Table {
+	id := r.newObj()
+	name := r.readString()
+	var shared *Table
+	if name != "" {
+		if v, ok := r.r.lookupSnapshotName(name); ok {
+			shared, _ = v.TryTable()
+		}
+	}
+	var t *Table
+	if ordered {
+		t = NewOrderedTable()
+	} else {
+		t = NewTable()
+	}
+	if shared != nil {
+		r.objs[id] = shared
+	} else {
+		r.objs[id] = t
+	}
+	meta := r.readValue()
+	for r.err == nil {
+		k := r.readValue()
+		if k.IsNil() {
+			break
+		}
+		v := r.readValue()
+		r.r.SetTable(t, k, v)
+	}
+	if shared != nil {
+		return shared
+	}
+	if !meta.IsNil() {
+		m, ok := meta.TryTable()
+		if !ok {
+			r.err = errInvalidSnapshot
+			return t
+		}
+		r.r.SetRawMetatable(TableValue(t), m)
+	}
+	return t
+}
+
+func (r *snapshotReader) readCodeValue() *Code {
+	id := r.newObj()
+	c, ok := r.readConst().TryCode()
+	if !ok {
+		if r.err == nil {
+			r.err = errInvalidSnapshot
+		}
+		return nil
+	}
+	r.objs[id] = c
+	return c
+}
+
+// readTyped reads an object with the given tag, or a reference to one.
+func (r *snapshotReader) readTyped(tag byte, read func() interface{}) interface{} {
+	switch t := r.readTag(); {
+	case r.err != nil:
+		return nil
+	case t == snapRef:
+		return r.readRef()
+	case t == tag:
+		return read()
+	default:
+		r.err = errInvalidSnapshot
+		return nil
+	}
+}
+
+func (r *snapshotReader) readCell() Cell {
+	ref, ok := r.readTyped(snapCell, func() interface{} {
+		id := r.newObj()
+		ref := new(Value)
+		r.objs[id] = ref
+		*ref = r.readValue()
+		return ref
+	}).(*Value)
+	if !ok && r.err == nil {
+		r.err = errInvalidSnapshot
+	}
+	return Cell{ref: ref}
+}
+
+func (r *snapshotReader) readCells() []Cell {
+	n := r.readInt()
+	if r.err != nil {
+		return nil
+	}
+	cells := make([]Cell, n)
+	for i := range cells {
+		cells[i] = r.readCell()
+	}
+	return cells
+}
+
+func (r *snapshotReader) readClosure() *Closure {
+	id := r.newObj()
+	c := new(Closure)
+	r.objs[id] = c
+	c.Code, _ = r.readTyped(snapCode, func() interface{} {
+		return r.readCodeValue()
+	}).(*Code)
+	c.upvalueIndex = r.readInt()
+	c.Upvalues = r.readCells()
+	if r.err == nil && (c.Code == nil || len(c.Upvalues) != int(c.UpvalueCount) || c.upvalueIndex > len(c.Upvalues)) {
+		r.err = errInvalidSnapshot
+	}
+	return c
+}
+
+// readContValue reads a value which must be a continuation or nil.
+func (r *snapshotReader) readContValue() Cont {
+	v := r.readValue()
+	if v.IsNil() || r.err != nil {
+		return nil
+	}
+	c, ok := v.Interface().(Cont)
+	if !ok {
+		r.err = errInvalidSnapshot
+	}
+	return c
+}
+
+func (r *snapshotReader) readCont(tag byte) Cont {
+	id := r.newObj()
+	switch tag {
+	case snapLuaCont:
+		c := new(LuaCont)
+		r.objs[id] = c
+		c.Closure, _ = r.readTyped(snapClosure, func() interface{} {
+			return r.readClosure()
+		}).(*Closure)
+		var closeStackBase int64
+		r.read(2+1+1+1+8, &c.pc, &c.running, &c.borrowedCells, &c.tailCall, &closeStackBase)
+		c.closeStackBase = int(closeStackBase)
+		c.registers = r.readArray()
+		if c.borrowedCells {
+			if c.Closure != nil {
+				c.cells = c.Upvalues
+			}
+		} else {
+			c.cells = r.readCells()
+		}
+		c.acc = r.readArray()
+		if r.err == nil && (c.Closure == nil || len(c.registers) != int(c.RegCount) || len(c.cells) != int(c.CellCount) || int(c.pc) >= len(c.code)) {
+			r.err = errInvalidSnapshot
+			return nil
+		}
+		r.r.RequireSize(unsafe.Sizeof(LuaCont{}))
+		r.r.RequireArrSize(unsafe.Sizeof(Value{}), int(c.RegCount))
+		if !c.borrowedCells {
+			r.r.RequireArrSize(unsafe.Sizeof(Cell{}), int(c.CellCount))
+		}
+		return c
+	case snapGoCont:
+		c := new(GoCont)
+		r.objs[id] = c
+		f := r.readValue()
+		c.next = r.readContValue()
+		r.read(1, &c.tailCall)
+		args := r.readArray()
+		etc := r.readArray()
+		if r.err != nil {
+			return nil
+		}
+		gof, ok := f.Interface().(*GoFunction)
+		if !ok || len(args) > gof.nArgs || len(etc) > 0 && !gof.hasEtc {
+			r.err = errInvalidSnapshot
+			return nil
+		}
+		c.GoFunction = gof
+		if gof.nArgs > 0 {
+			r.r.RequireArrSize(unsafe.Sizeof(Value{}), gof.nArgs)
+			c.args = make([]Value, gof.nArgs)
+			c.nArgs = copy(c.args, args)
+		}
+		if gof.hasEtc {
+			c.etc = &etc
+		}
+		r.r.RequireSize(unsafe.Sizeof(GoCont{}))
+		return c
+	default:
+		c := new(Termination)
+		r.objs[id] = c
+		c.parent = r.readContValue()
+		var hasEtc bool
+		var pushIndex int64
+		r.read(8+1, &pushIndex, &hasEtc)
+		c.pushIndex = int(pushIndex)
+		c.args = r.readArray()
+		etc := r.readArray()
+		if hasEtc {
+			c.etc = &etc
+		}
+		if r.err == nil && (c.pushIndex < 0 || c.pushIndex > len(c.args)) {
+			r.err = errInvalidSnapshot
+		}
+		return c
+	}
+}
+
+func (r *snapshotReader) readThread() *Thread {
+	id := r.newObj()
+	t := NewThread(r.r)
+	r.objs[id] = t
+	var status, how uint8
+	r.read(1, &status)
+	switch ThreadStatus(status) {
+	case ThreadDead:
+		t.status = ThreadDead
+		return t
+	case ThreadSuspended:
+	default:
+		r.err = errInvalidSnapshot
+		return t
+	}
+	r.read(1, &how)
+	switch how {
+	case snapThreadNotStarted:
+		f := r.readValue()
+		if r.err != nil {
+			return t
+		}
+		c, ok := f.TryCallable()
+		if !ok {
+			r.err = errInvalidSnapshot
+			return t
+		}
+		t.Start(c)
+	case snapThreadYielded:
+		t.resumeCont = r.readContValue()
+		t.term, _ = r.readContValue().(*Termination)
+		t.closeStack.stack = r.readArray()
+		t.currentCont = t.resumeCont
+		if r.err == nil && (t.resumeCont == nil || t.term == nil) {
+			r.err = errInvalidSnapshot
+		}
+	default:
+		r.err = errInvalidSnapshot
+	}
+	return t
+}
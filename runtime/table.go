@@ -11,7 +11,10 @@ type Table struct {
 	// This is where the implementation details are.
 	*mixedTable
 
-	meta *Table
+	meta       *Table
+	weak       *weakTable    // Non-nil if the table has weak keys or values (see weaktable.go)
+	ordered    *orderedTable // Non-nil if the table iterates in insertion order (see orderedtable.go)
+	memTracker *memTracker   // Memory charged for the entries (see memaccount.go)
 }
 
 // NewTable returns a new Table.
@@ -19,6 +22,20 @@ func NewTable() *Table {
 	return &Table{mixedTable: &mixedTable{}}
 }
 
+// NewOrderedTable returns a new Table which iterates over its keys in the order
+// they were inserted.  A key which is removed and set again goes to the end.
+// Getting and setting values is O(1), but uses more memory than in a Table
+// returned by NewTable.  Ordered tables cannot be weak.
+func NewOrderedTable() *Table {
+	return &Table{mixedTable: &mixedTable{}, ordered: &orderedTable{}}
+}
+
+// IsOrdered returns true if t iterates over its keys in insertion order (see
+// NewOrderedTable).
+func (t *Table) IsOrdered() bool {
+	return t.ordered != nil
+}
+
 // Metatable returns the table's metatable.
 func (t *Table) Metatable() *Table {
 	return t.meta
@@ -43,11 +60,33 @@ func (t *Table) Clone() luagc.Value {
 
 // Get returns t[k].
 func (t *Table) Get(k Value) Value {
+	if t.weak != nil {
+		return t.weakGet(k)
+	}
+	if t.ordered != nil {
+		return t.ordered.get(k)
+	}
 	return t.get(k)
 }
 
 // Set implements t[k] = v (doesn't check if k is nil).
 func (t *Table) Set(k, v Value) uint64 {
+	if t.weak != nil {
+		t.weakSet(k, v)
+		if v.IsNil() {
+			return 0
+		}
+		return 16
+	}
+	if t.ordered != nil {
+		// The version is incremented so that inline caches which go through
+		// the table are invalidated.
+		t.version++
+		if t.ordered.set(k, v) {
+			return orderedEntrySize
+		}
+		return 0
+	}
 	if v.IsNil() {
 		t.mixedTable.remove(k)
 		return 0
@@ -58,6 +97,13 @@ func (t *Table) Set(k, v Value) uint64 {
 
 // Reset implements t[k] = v only if t[k] was already non-nil.
 func (t *Table) Reset(k, v Value) (wasSet bool) {
+	if t.weak != nil {
+		return t.weakReset(k, v)
+	}
+	if t.ordered != nil {
+		t.version++
+		return t.ordered.reset(k, v)
+	}
 	if v.IsNil() {
 		return t.mixedTable.remove(k)
 	}
@@ -66,10 +112,36 @@ func (t *Table) Reset(k, v Value) (wasSet bool) {
 
 // Len returns a length for t (see lua docs for details).
 func (t *Table) Len() int64 {
+	if t.weak != nil {
+		return t.weakLen()
+	}
+	if t.ordered != nil {
+		return t.ordered.len()
+	}
 	return int64(t.mixedTable.len())
 }
 
 // Next returns the key-value pair that comes after k in the table t.
 func (t *Table) Next(k Value) (next Value, val Value, ok bool) {
+	if t.weak != nil {
+		return t.weakNext(k)
+	}
+	if t.ordered != nil {
+		return t.ordered.next(k)
+	}
 	return t.mixedTable.next(k)
 }
+
+// makeOrdered makes t iterate in insertion order from now on.  The existing
+// entries are inserted first, in their current iteration order.
+func (t *Table) makeOrdered() {
+	if t.ordered != nil || t.weak != nil {
+		return
+	}
+	ordered := &orderedTable{}
+	for k, v, ok := t.mixedTable.next(NilValue); ok && !k.IsNil(); k, v, ok = t.mixedTable.next(k) {
+		ordered.set(k, v)
+	}
+	*t.mixedTable = mixedTable{version: t.version + 1, ephemerons: t.ephemerons}
+	t.ordered = ordered
+}
@@ -53,3 +53,62 @@ func TestTable_Next(t *testing.T) {
 		t.Errorf("Expected (1, x) and (2, y) to be the items, got (%v, %v) and (%v, %v)", k1, v1, k2, v2)
 	}
 }
+
+func TestOrderedTable(t *testing.T) {
+	tbl := NewOrderedTable()
+	var keys []Value
+	for i := 0; i < 100; i++ {
+		k := v(string(rune('a'+i%26)) + string(rune('a'+i/26)))
+		if i%3 == 0 {
+			k = v(i)
+		}
+		keys = append(keys, k)
+		if mem := tbl.Set(k, v(i)); mem != orderedEntrySize {
+			t.Fatalf("Expected new key to use %d bytes, got %d", orderedEntrySize, mem)
+		}
+	}
+	if mem := tbl.Set(keys[10], v("x")); mem != 0 {
+		t.Errorf("Expected existing key to use no memory, got %d", mem)
+	}
+	// Remove most keys so that the entries are compacted.
+	for i := 0; i < 80; i++ {
+		tbl.Set(keys[i], NilValue)
+	}
+	tbl.Set(keys[0], v("back"))
+	keys = append(keys[80:], keys[0])
+	var i int
+	for k, _, ok := tbl.Next(NilValue); !k.IsNil(); k, _, ok = tbl.Next(k) {
+		if !ok {
+			t.Fatalf("Next failed at %v", k)
+		}
+		if i >= len(keys) || k != keys[i] {
+			t.Fatalf("Expected key %d to be %v, got %v", i, keys[i], k)
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Errorf("Expected %d keys, got %d", len(keys), i)
+	}
+	if val := tbl.Get(v(0.0)); val != v("back") {
+		t.Errorf(`Expected "back", got %v`, val)
+	}
+}
+
+func TestLiveWeakTables(t *testing.T) {
+	if !WeakTablesAvailable {
+		t.Skip("requires weak tables")
+	}
+	r := New(nil)
+	defer r.Close(nil)
+	tbl := NewTable()
+	weakMeta, strongMeta := NewTable(), NewTable()
+	weakMeta.Set(v("__mode"), v("k"))
+	r.SetRawMetatable(TableValue(tbl), weakMeta)
+	if r.liveWeakTables != 1 {
+		t.Errorf("Expected 1 live weak table, got %d", r.liveWeakTables)
+	}
+	r.SetRawMetatable(TableValue(tbl), strongMeta)
+	if r.liveWeakTables != 0 {
+		t.Errorf("Expected no live weak tables, got %d", r.liveWeakTables)
+	}
+}
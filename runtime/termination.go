@@ -77,6 +77,9 @@ func (c *Termination) Next() Cont {
 	return nil
 }
 
+// Parent implements Cont.Parent.  In a call stack, a termination stands for the
+// continuation it was created from, so this returns the parent of that
+// continuation.
 func (c *Termination) Parent() Cont {
 	if c.parent == nil {
 		return nil
@@ -84,6 +87,13 @@ func (c *Termination) Parent() Cont {
 	return c.parent.Parent()
 }
 
+// Origin returns the continuation the termination was created from (which may
+// be nil).  E.g. when a debug hook is called, the termination the hook returns
+// to has the hooked continuation as origin.
+func (c *Termination) Origin() Cont {
+	return c.parent
+}
+
 // DebugInfo implements Cont.DebugInfo.
 func (c *Termination) DebugInfo() *DebugInfo {
 	if c.parent == nil {
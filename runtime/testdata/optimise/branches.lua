@@ -0,0 +1,18 @@
+-- Tests whose outcome is known are removed, as well as the code that cannot
+-- be reached.
+local n = 0
+while true do
+    n = n + 1
+    if not (n < 10) then
+        break
+    end
+end
+if 1 > 2 then
+    print("never")
+else
+    print("always")
+end
+local function f(a, b)
+    return a and b or "default"
+end
+return f(n, nil)
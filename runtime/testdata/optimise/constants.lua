@@ -0,0 +1,9 @@
+-- Operations on constants are folded, unless they depend on the runtime or
+-- may fail.
+local x = 1 + 2 * 3
+local y = 2^10 / 4
+local s = "a" .. "b" .. 10
+local t = {-1, ~0, 7 // 2, 7.0 // 2, -7 % 3, 1 << 62, #"hello"}
+print(x, y, s, 1 < 2, "a" <= "b", 1 == 1.0, "1" == 1, not nil)
+print(1 // 0, 0/0, "10" + 1, 1.5 .. "")
+return t
@@ -0,0 +1,13 @@
+-- Values computed into a temporary register and moved to a local variable
+-- are computed directly into the variable.
+local x, y = 1, 2
+for i = 1, 10 do
+    x = x + i
+    y = x * y
+end
+local function g(t)
+    local v = t.v
+    v = v .. t.w
+    return v
+end
+return x, y, g({v = "a", w = "b"})
@@ -0,0 +1,27 @@
+-- Registers are shared between values whose live ranges do not overlap.  Local
+-- variables are live for their whole scope.
+local function fib(n)
+    if n < 2 then
+        return n
+    end
+    local a = fib(n - 1)
+    local b = fib(n - 2)
+    return a + b
+end
+
+-- Cells keep their registers.
+local log = {}
+local function closer(name)
+    return setmetatable({}, {__close = function() log[#log + 1] = name end})
+end
+do
+    local c <close> = closer("c")
+    local n = 0
+    local function inc() n = n + 1 end
+    for i = 1, 3 do
+        local t = {i, i * 2}
+        inc()
+    end
+    log[#log + 1] = n
+end
+print(fib(10), table.concat(log, " "))
@@ -55,17 +55,36 @@ type Thread struct {
 	DebugHooks
 
 	closeStack // Stack of pending to-be-closed values
+
+	// Coroutine state.  A coroutine only has a goroutine of its own when a Go
+	// function is mid-stack (see Resume).
+	start        Callable     // What to run when first resumed
+	resumeCont   Cont         // Where to push resume values when suspended without a goroutine
+	term         *Termination // Receives the values returned by the coroutine
+	yieldArgs    []Value      // Values passed by the last yield without a goroutine
+	yielding     bool         // True while yielding without blocking the goroutine
+	inline       bool         // True while running in its resumer's goroutine
+	hasGoroutine bool         // True while running in a goroutine of its own
+	loopDepth    int          // Number of nested calls to runLoop
+	memTracker   *memTracker  // Memory charged for the thread (see memaccount.go)
 }
 
 // NewThread creates a new thread out of a Runtime.  Its initial
 // status is suspended.  Call Resume to run it.
 func NewThread(r *Runtime) *Thread {
-	r.RequireSize(unsafe.Sizeof(Thread{}) + 100) // 100 is my guess at the size of a channel
-	return &Thread{
+	t := &Thread{
 		resumeCh: make(chan valuesError),
 		status:   ThreadSuspended,
 		Runtime:  r,
 	}
+	r.requireTrackedMem(&t.memTracker, uint64(unsafe.Sizeof(Thread{})+100)) // 100 is my guess at the size of a channel
+	if r.profiler != nil {
+		r.profiler.enable(t)
+	}
+	if r.cover != nil {
+		r.cover.enable(t)
+	}
+	return t
 }
 
 // CurrentCont returns the continuation currently running (or suspended) in the
@@ -87,9 +106,20 @@ var errErrorInMessageHandler = StringValue("error in error handling")
 // the next continuation is nil or an error occurs, in which case it returns the
 // error.
 func (t *Thread) RunContinuation(c Cont) (err error) {
+	if t.inline {
+		// This call would nest Go frames on the resumer's goroutine, so the
+		// coroutine needs its own goroutine (see runInline).
+		panic(needGoroutine{})
+	}
+	_ = t.triggerCall(t, c)
+	return t.runLoop(c)
+}
+
+func (t *Thread) runLoop(c Cont) (err error) {
 	var next Cont
 	var errContCount = 0
-	_ = t.triggerCall(t, c)
+	t.loopDepth++
+	defer func() { t.loopDepth-- }()
 	for c != nil {
 		if t != t.gcThread {
 			t.runPendingFinalizers()
@@ -97,69 +127,71 @@ func (t *Thread) RunContinuation(c Cont) (err error) {
 		t.currentCont = c
 		next, err = c.RunInThread(t)
 		if err != nil {
-			rtErr := ToError(err)
-			if rtErr.Handled() {
-				return rtErr
+			next, err = t.errorCont(c, err, &errContCount)
+			if next == nil {
+				return err
 			}
-			err = rtErr.AddContext(c, -1)
-			errContCount++
-			if t.messageHandler != nil {
-				if errContCount > maxErrorsInMessageHandler {
-					return newHandledError(errErrorInMessageHandler)
-				}
-				next = t.messageHandler.Continuation(t, newMessageHandlerCont(c))
-			} else {
-				next = newMessageHandlerCont(c)
-			}
-			next.Push(t.Runtime, ErrorValue(err))
 		}
 		c = next
 	}
 	return
 }
 
+// errorCont returns the continuation that handles the error err raised when
+// running c.  If there is none, the returned error should be returned to the
+// caller.
+func (t *Thread) errorCont(c Cont, err error, errContCount *int) (Cont, error) {
+	rtErr := ToError(err)
+	if rtErr.Handled() {
+		return nil, rtErr
+	}
+	err = rtErr.AddContext(c, -1)
+	*errContCount++
+	var next Cont
+	if t.messageHandler != nil {
+		if *errContCount > maxErrorsInMessageHandler {
+			return nil, newHandledError(errErrorInMessageHandler)
+		}
+		next = t.messageHandler.Continuation(t, newMessageHandlerCont(c))
+	} else {
+		next = newMessageHandlerCont(c)
+	}
+	next.Push(t.Runtime, ErrorValue(err))
+	return next, err
+}
+
 // This is to be able to close a suspended coroutine without completing it, but
 // still allow cleaning up the to-be-closed variables.  If this is put on the
 // resume channel of a running thread, yield will cause a panic in the goroutine
 // and that will be caught in the defer() clause below.
 type threadClose struct{}
 
+// This is panicked when a coroutine running in its resumer's goroutine needs a
+// goroutine of its own, and recovered in Thread.runInline.
+type needGoroutine struct{}
+
 //
 // Coroutine management
 //
+// A coroutine runs in the goroutine of the thread resuming it, as long as it
+// only runs Lua code and restartable Go functions (see
+// GoFunction.SolemnlyDeclareRestartable).  Yielding is then just a matter of
+// stopping the run loop and remembering the continuation to resume from, which
+// is a lot cheaper than switching goroutines.
+//
+// Frames of Go functions cannot be moved between goroutines though, so before a
+// Go function which is not restartable is called, the coroutine moves to a
+// goroutine of its own, and the threads hand over to each other via their
+// resume channels.  When a Lua instruction or a restartable Go function calls
+// back into Lua code, it is abandoned and run again from the start in the new
+// goroutine.  The goroutine stops when the coroutine yields from its outermost
+// run loop, so the coroutine can run without it again when resumed.
+//
 
-// Start starts the thread in a goroutine, giving it the callable c to run.  the
-// t.Resume() method needs to be called to provide arguments to the callable.
+// Start gives the thread the callable c to run.  The t.Resume() method needs to
+// be called to provide arguments to the callable.
 func (t *Thread) Start(c Callable) {
-	t.RequireBytes(2 << 10) // A goroutine starts off with 2k stack
-	go func() {
-		var (
-			args []Value
-			err  error
-		)
-		// If there was a panic due to an exceeded quota, we need to end the
-		// thread and propagate that panic to the calling thread
-		defer func() {
-			r := recover()
-			if r != nil {
-				switch r.(type) {
-				case ContextTerminationError:
-				case threadClose:
-					// This means we want to close the coroutine, so no panic!
-					r = nil
-				default:
-					panic(r)
-				}
-			}
-			t.end(args, err, r)
-		}()
-		args, err = t.getResumeValues()
-		if err == nil {
-			next := NewTerminationWith(t.CurrentCont(), 0, true)
-			err = t.call(c, args, next)
-			args = next.Etc()
-		}
-	}()
+	t.start = c
 }
 
 // Status returns the status of a thread (suspended, running or dead).
@@ -188,7 +220,33 @@ func (t *Thread) Resume(caller *Thread, args []Value) ([]Value, error) {
 	t.status = ThreadOK
 	t.mux.Unlock()
 	caller.mux.Unlock()
-	t.sendResumeValues(args, nil, nil)
+	if t.hasGoroutine {
+		t.sendResumeValues(args, nil, nil)
+		return caller.getResumeValues()
+	}
+	c := t.resumeCont
+	start := c == nil
+	if start {
+		t.term = NewTerminationWith(t.CurrentCont(), 0, true)
+		c = t.start.Continuation(t, t.term)
+		t.start = nil
+	}
+	t.resumeCont = nil
+	t.Push(c, args...)
+	if !t.coroutineGoroutines && !t.areFlagsEnabled(HookFlagCall|HookFlagReturn|HookFlagLine|HookFlagCount) {
+		var err error
+		c, err = t.runInline(c)
+		switch {
+		case c != nil:
+			start = false
+		case t.yielding:
+			return t.suspend(), nil
+		default:
+			return t.finish(err)
+		}
+	}
+	// Debug hooks may call Lua code at any point so need a goroutine.
+	t.runInGoroutine(c, start)
 	return caller.getResumeValues()
 }
 
@@ -218,14 +276,27 @@ func (t *Thread) Close(caller *Thread) (bool, error) {
 	t.status = ThreadOK
 	t.mux.Unlock()
 	caller.mux.Unlock()
-	t.sendResumeValues(nil, nil, threadClose{})
-	_, err := caller.getResumeValues()
+	if t.hasGoroutine {
+		t.sendResumeValues(nil, nil, threadClose{})
+		_, err := caller.getResumeValues()
+		return true, err
+	}
+	// There are no Go frames to unwind, so the close stack can be emptied
+	// right away.
+	t.start, t.resumeCont, t.yieldArgs = nil, nil, nil
+	_, err := t.finish(nil)
 	return true, err
 }
 
 // Yield to the caller thread.  The yielding thread's status switches to
 // suspended.  The caller's status must be OK.
+//
+// This blocks until the thread is resumed, so the thread needs a goroutine of
+// its own.  Go functions implementing yielding should use YieldCont instead.
 func (t *Thread) Yield(args []Value) ([]Value, error) {
+	if t.inline {
+		panic(needGoroutine{})
+	}
 	t.mux.Lock()
 	if t.status != ThreadOK {
 		panic("Thread to yield is not running")
@@ -239,6 +310,13 @@ func (t *Thread) Yield(args []Value) ([]Value, error) {
 	if caller.status != ThreadOK {
 		panic("Caller of thread to yield is not OK")
 	}
+	if !t.hasGoroutine {
+		// This happens when __close metamethods yield while the thread is
+		// being closed or is ending (see finish and end).
+		t.mux.Unlock()
+		caller.mux.Unlock()
+		return nil, errors.New("attempt to yield across a Go function call")
+	}
 	t.status = ThreadSuspended
 	t.caller = nil
 	t.mux.Unlock()
@@ -247,9 +325,165 @@ func (t *Thread) Yield(args []Value) ([]Value, error) {
 	return t.getResumeValues()
 }
 
+// YieldCont yields args to the caller thread, like Yield.  When the thread is
+// resumed, the values it is resumed with are pushed to next, which is returned
+// so that execution carries on from there.
+//
+// When no Go function is mid-stack, this does not block but returns a nil
+// continuation so that the thread's run loop stops, and the thread is resumed
+// without needing a goroutine of its own.  So a Go function should return
+// YieldCont's return values directly.
+func (t *Thread) YieldCont(next Cont, args []Value) (Cont, error) {
+	if t.inline || t.hasGoroutine && t.loopDepth == 1 && !t.coroutineGoroutines {
+		t.yieldArgs = args
+		t.resumeCont = next
+		t.yielding = true
+		return nil, nil
+	}
+	res, err := t.Yield(args)
+	if err != nil {
+		return nil, err
+	}
+	t.Push(next, res...)
+	return next, nil
+}
+
+// runInline runs the continuation c in the goroutine of the thread which
+// resumed t, until t yields or stops.  If t needs a goroutine of its own, it
+// returns the continuation to run in it.
+func (t *Thread) runInline(c Cont) (migrate Cont, err error) {
+	defer func() {
+		t.inline = false
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case needGoroutine:
+				// c has not changed anything yet, so can be run again.
+				migrate, err = c, nil
+				return
+			case ContextTerminationError:
+				// Like in the goroutine case, the coroutine is dead and the
+				// panic propagates to the resumer.
+				_, _ = t.finish(nil)
+			}
+			panic(r)
+		}
+	}()
+	t.inline = true
+	var next Cont
+	var errContCount = 0
+	for c != nil {
+		if !runsInline(c) {
+			return c, nil
+		}
+		t.runPendingFinalizers()
+		t.currentCont = c
+		next, err = c.RunInThread(t)
+		if err != nil {
+			next, err = t.errorCont(c, err, &errContCount)
+			if next == nil {
+				return nil, err
+			}
+		}
+		c = next
+	}
+	return nil, err
+}
+
+// runsInline returns true if c can be run in the goroutine of the thread
+// resuming a coroutine.
+func runsInline(c Cont) bool {
+	switch cc := c.(type) {
+	case *LuaCont, *Termination, *messageHandlerCont:
+		return true
+	case *GoCont:
+		return cc.restartable
+	default:
+		return false
+	}
+}
+
+// runInGoroutine starts a goroutine running the continuation c in the thread.
+// If start is true, c is the start of the coroutine.
+func (t *Thread) runInGoroutine(c Cont, start bool) {
+	t.RequireBytes(2 << 10) // A goroutine starts off with 2k stack
+	t.hasGoroutine = true
+	go func() {
+		var (
+			args []Value
+			err  error
+		)
+		// If there was a panic due to an exceeded quota, we need to end the
+		// thread and propagate that panic to the calling thread
+		defer func() {
+			r := recover()
+			if r != nil {
+				switch r.(type) {
+				case ContextTerminationError:
+				case threadClose:
+					// This means we want to close the coroutine, so no panic!
+					r = nil
+				default:
+					panic(r)
+				}
+			} else if t.yielding {
+				// The coroutine can do without a goroutine again.
+				t.hasGoroutine = false
+				t.ReleaseBytes(2 << 10)
+				caller := t.caller
+				caller.sendResumeValues(t.suspend(), nil, nil)
+				return
+			}
+			t.end(args, err, r)
+		}()
+		if start {
+			_ = t.triggerCall(t, c)
+		}
+		err = t.runLoop(c)
+		args = t.term.Etc()
+	}()
+}
+
+// suspend switches t to suspended after it has yielded without blocking, and
+// returns the values it yielded.
+func (t *Thread) suspend() []Value {
+	args := t.yieldArgs
+	t.yieldArgs = nil
+	t.yielding = false
+	t.mux.Lock()
+	t.status = ThreadSuspended
+	t.caller = nil
+	t.mux.Unlock()
+	return args
+}
+
+// finish turns off a thread without a goroutine, cleaning up its close stack.
+// It returns the values returned by the coroutine.
+func (t *Thread) finish(err error) ([]Value, error) {
+	var args []Value
+	if t.term != nil {
+		args = t.term.Etc()
+		t.term = nil
+	}
+	// The thread is still running while its close stack is emptied, so that
+	// a __close metamethod which yields gets an error (see Yield).
+	err = t.cleanupCloseStack(nil, 0, err) // TODO: not nil
+	t.mux.Lock()
+	t.status = ThreadDead
+	t.caller = nil
+	t.mux.Unlock()
+	t.closeErr = err
+	return args, err
+}
+
 // This turns off the thread, cleaning up its close stack.  The thread must be
 // running.
 func (t *Thread) end(args []Value, err error, exception interface{}) {
+	// As in finish, the close stack is emptied before the thread becomes dead
+	// and without holding the locks.  As the goroutine is about to terminate,
+	// a __close metamethod cannot yield.
+	t.hasGoroutine = false
+	t.term = nil
+	err = t.cleanupCloseStack(nil, 0, err) // TODO: not nil
 	caller := t.caller
 	t.mux.Lock()
 	caller.mux.Lock()
@@ -261,13 +495,11 @@ func (t *Thread) end(args []Value, err error, exception interface{}) {
 	case caller.status != ThreadOK:
 		panic("Caller thread of ending thread is not OK")
 	}
-	close(t.resumeCh)
 	t.status = ThreadDead
 	t.caller = nil
-	err = t.cleanupCloseStack(nil, 0, err) // TODO: not nil
 	t.closeErr = err
-	caller.sendResumeValues(args, err, exception)
 	t.ReleaseBytes(2 << 10) // The goroutine will terminate after this
+	caller.sendResumeValues(args, err, exception)
 }
 
 func (t *Thread) call(c Callable, args []Value, next Cont) error {
@@ -316,7 +548,7 @@ func (t *Thread) CallContext(def RuntimeContextDef, f func() error) (ctx Runtime
 		}
 	}()
 	err = t.cleanupCloseStack(c, h, f())
-	if t.GCPolicy() == IsolateGCPolicy {
+	if t.GCPolicy() != ShareGCPolicy {
 		t.runFinalizers(t.weakRefPool.ExtractAllMarkedFinalize())
 	}
 	if err != nil {
@@ -341,15 +573,10 @@ func (s *closeStack) push(v Value) {
 	s.stack = append(s.stack, v)
 }
 
-func (s *closeStack) pop() (Value, bool) {
-	sz := len(s.stack)
-	if sz == 0 {
-		return NilValue, false
-	}
-	sz--
-	v := s.stack[sz]
-	s.stack = s.stack[:sz]
-	return v, true
+// remove removes the value at index i, which may not be at the top of the stack
+// if a call has left values behind (e.g. because of an error).
+func (s *closeStack) remove(i int) {
+	s.stack = append(s.stack[:i], s.stack[i+1:]...)
 }
 
 func (s *closeStack) truncate(h int) {
@@ -364,16 +591,21 @@ func (s *closeStack) truncate(h int) {
 func (t *Thread) cleanupCloseStack(c Cont, h int, err error) error {
 	closeStack := &t.closeStack
 	for closeStack.size() > h {
-		v, _ := closeStack.pop()
+		// The value is only removed after __close is called, so that the call
+		// can be abandoned and made again (see Thread.runInline).
+		i := closeStack.size() - 1
+		v := closeStack.stack[i]
 		if Truth(v) {
 			closeErr, ok := Metacall(t, v, "__close", []Value{v, ErrorValue(err)}, NewTerminationWith(c, 0, false))
 			if !ok {
+				closeStack.remove(i)
 				return errors.New("to be closed value missing a __close metamethod")
 			}
 			if closeErr != nil {
 				err = closeErr
 			}
 		}
+		closeStack.remove(i)
 	}
 	return err
 }
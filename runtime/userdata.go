@@ -21,8 +21,9 @@ type UserDataResourceReleaser interface {
 // A UserData is a Go value of any type wrapped to be used as a Lua value.  It
 // has a metatable which may allow Lua code to interact with it.
 type UserData struct {
-	value interface{}
-	meta  *Table
+	value      interface{}
+	meta       *Table
+	ephemerons ephemerons // Values associated with the userdata in weak tables
 }
 
 var _ ResourceReleaser = (*UserData)(nil)
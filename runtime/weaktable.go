@@ -0,0 +1,299 @@
+package runtime
+
+import (
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/arnodel/golua/runtime/internal/luagc"
+)
+
+// A table whose metatable has a '__mode' field containing 'k' and / or 'v' when
+// the metatable is set has weak keys and / or values.  Only tables and userdata
+// are held weakly, as they are the values for which the runtime's luagc.Pool
+// provides weak references.  Other keys and values are held as in any table.
+//
+// - A weak key is stored as its address (a weakKey), and the value of its entry
+//   is a *weakEntry holding a weak reference to the key.
+//
+// - A weak value is replaced with a *weakEntry holding a weak reference to it.
+//
+// - In a table with weak keys and strong values, the value of an entry with a
+//   weak key is not stored in the table but in the key itself (see
+//   ephemerons), so that the value does not keep other weak keys alive after
+//   its key is collected.
+//
+// Entries whose key or value have been collected are ignored, and removed from
+// the table when its length is computed or when it needs to grow.
+//
+// The weak references rely on Go finalizers, so like values with a '__gc'
+// metamethod, a value which can be reached from itself (e.g. a table containing
+// itself) is never collected.  As the values are stored in their keys, this
+// includes a key whose value refers back to it, so unlike Lua's ephemeron
+// tables, such entries are never removed.
+
+// WeakTablesAvailable is true if tables can have weak keys and values.  When it
+// is false (i.e. golua is built with the safepool tag), the '__mode' metafield
+// is ignored.
+const WeakTablesAvailable = luagc.DefaultPoolHasWeakRefs
+
+// A weakTable contains the information about the weakness of a table.
+type weakTable struct {
+	keys, values bool       // True if the keys / values are weak
+	pool         luagc.Pool // Provides the weak references
+	deadCount    uint64     // Value of luagc.DeadCount() when last swept
+	liveCount    *int32     // Number of live weak tables in the runtime
+}
+
+// newWeakTable returns a new weakTable, counted in *liveCount until it is
+// collected or released.
+func newWeakTable(keys, values bool, pool luagc.Pool, liveCount *int32) *weakTable {
+	w := &weakTable{
+		keys:      keys,
+		values:    values,
+		pool:      pool,
+		deadCount: luagc.DeadCount(),
+		liveCount: liveCount,
+	}
+	atomic.AddInt32(liveCount, 1)
+	runtime.SetFinalizer(w, (*weakTable).release)
+	return w
+}
+
+// release stops counting w as a live weak table.
+func (w *weakTable) release() {
+	runtime.SetFinalizer(w, nil)
+	atomic.AddInt32(w.liveCount, -1)
+}
+
+// A weakKey is stored in place of a weak key.
+type weakKey uintptr
+
+// A weakEntry is stored in place of the value of an entry whose key or value is
+// weak.
+type weakEntry struct {
+	key   luagc.WeakRef // Reference to the key if it is weak
+	value luagc.WeakRef // Reference to the value if it is weak
+}
+
+// The ephemerons of a table or userdata are the values associated with it in
+// the tables with weak keys and strong values where it is a key.
+type ephemerons map[*weakTable]Value
+
+// weakRefTarget returns the object that v refers to and its address, with ok
+// true if v can be held weakly.
+func weakRefTarget(v Value) (obj luagc.Value, addr uintptr, ok bool) {
+	switch x := v.iface.(type) {
+	case *Table:
+		return x, uintptr(unsafe.Pointer(x)), true
+	case *UserData:
+		return x, uintptr(unsafe.Pointer(x)), true
+	default:
+		return nil, 0, false
+	}
+}
+
+// ephemeronsOf returns a pointer to the ephemerons of obj, which must be
+// returned by weakRefTarget.
+func ephemeronsOf(obj luagc.Value) *ephemerons {
+	switch x := obj.(type) {
+	case *Table:
+		return &x.ephemerons
+	case *UserData:
+		return &x.ephemerons
+	default:
+		panic("unreachable")
+	}
+}
+
+// setWeakMode makes the keys and / or values of t weak according to the value
+// of the '__mode' metafield, using pool to get weak references.  The existing
+// entries are converted.  Ordered tables cannot be weak.  The number of weak
+// tables is kept in *liveCount.
+func (t *Table) setWeakMode(mode Value, pool luagc.Pool, liveCount *int32) {
+	if t.ordered != nil {
+		return
+	}
+	var keys, values bool
+	if s, ok := mode.TryString(); ok && WeakTablesAvailable {
+		keys = strings.IndexByte(s, 'k') >= 0
+		values = strings.IndexByte(s, 'v') >= 0
+	}
+	if t.weak == nil && !keys && !values || t.weak != nil && t.weak.keys == keys && t.weak.values == values {
+		return
+	}
+	var kvs []Value
+	for k, v, ok := t.Next(NilValue); ok && !k.IsNil(); k, v, ok = t.Next(k) {
+		kvs = append(kvs, k, v)
+	}
+	for i := 0; i < len(kvs); i += 2 {
+		t.Set(kvs[i], NilValue)
+	}
+	if t.weak != nil {
+		t.weakSweep()
+		t.weak.release()
+		t.weak = nil
+	}
+	if keys || values {
+		t.weak = newWeakTable(keys, values, pool, liveCount)
+	}
+	for i := 0; i < len(kvs); i += 2 {
+		t.Set(kvs[i], kvs[i+1])
+	}
+}
+
+// weakStoredKey returns the key under which k is stored in t, and the object
+// it refers to if it is held weakly.
+func (t *Table) weakStoredKey(k Value) (Value, luagc.Value) {
+	if t.weak.keys {
+		if obj, addr, ok := weakRefTarget(k); ok {
+			return Value{iface: weakKey(addr)}, obj
+		}
+	}
+	return k, nil
+}
+
+// weakLoad returns the key and value of the entry sk => sv of t, with ok false
+// if the key or value has been collected.
+func (t *Table) weakLoad(sk, sv Value) (k Value, v Value, ok bool) {
+	e, isEntry := sv.iface.(*weakEntry)
+	if !isEntry {
+		return sk, sv, true
+	}
+	k = sk
+	var keyObj luagc.Value
+	if e.key != nil {
+		keyObj = e.key.Value()
+		if keyObj == nil {
+			return
+		}
+		k = AsValue(keyObj)
+	}
+	if e.value != nil {
+		valObj := e.value.Value()
+		if valObj == nil {
+			return
+		}
+		v = AsValue(valObj)
+	} else {
+		v = (*ephemeronsOf(keyObj))[t.weak]
+	}
+	return k, v, !v.IsNil()
+}
+
+func (t *Table) weakGet(k Value) Value {
+	sk, obj := t.weakStoredKey(k)
+	sv := t.mixedTable.get(sk)
+	if sv.IsNil() {
+		return NilValue
+	}
+	k1, v, ok := t.weakLoad(sk, sv)
+	if !ok || obj != nil && k1.iface != interface{}(obj) {
+		// The second case is an entry for a collected key which had the
+		// same address.
+		return NilValue
+	}
+	return v
+}
+
+func (t *Table) weakSet(k, v Value) {
+	w := t.weak
+	sk, keyObj := t.weakStoredKey(k)
+	if keyObj != nil {
+		delete(*ephemeronsOf(keyObj), w)
+	}
+	if v.IsNil() {
+		t.mixedTable.remove(sk)
+		return
+	}
+	var e *weakEntry
+	if keyObj != nil {
+		e = &weakEntry{key: w.pool.Get(keyObj)}
+		if e.key == nil {
+			// The pool no longer provides weak references, it is as if the
+			// key had been collected already.
+			t.mixedTable.remove(sk)
+			return
+		}
+	}
+	if w.values {
+		if valObj, _, ok := weakRefTarget(v); ok {
+			if e == nil {
+				e = &weakEntry{}
+			}
+			e.value = w.pool.Get(valObj)
+			if e.value == nil {
+				t.mixedTable.remove(sk)
+				return
+			}
+		}
+	}
+	sv := v
+	if e != nil {
+		if e.value == nil {
+			eph := ephemeronsOf(keyObj)
+			if *eph == nil {
+				*eph = ephemerons{}
+			}
+			(*eph)[w] = v
+		}
+		sv = Value{iface: e}
+	}
+	if t.hashTable.full() && w.deadCount != luagc.DeadCount() && t.weakSweep() > 0 {
+		// Reclaim the slots of the removed entries rather than growing.
+		t.hashTable.cleanup()
+		t.version++
+	}
+	t.mixedTable.insert(sk, sv)
+}
+
+func (t *Table) weakReset(k, v Value) bool {
+	if t.weakGet(k).IsNil() {
+		return false
+	}
+	t.weakSet(k, v)
+	return true
+}
+
+func (t *Table) weakLen() int64 {
+	if t.weak.deadCount != luagc.DeadCount() {
+		t.weakSweep()
+	}
+	return int64(t.mixedTable.len())
+}
+
+func (t *Table) weakNext(k Value) (Value, Value, bool) {
+	sk := k
+	if !k.IsNil() {
+		sk, _ = t.weakStoredKey(k)
+	}
+	for {
+		nk, nv, ok := t.mixedTable.next(sk)
+		if !ok || nk.IsNil() {
+			return nk, nv, ok
+		}
+		if k, v, ok := t.weakLoad(nk, nv); ok {
+			return k, v, true
+		}
+		sk = nk
+	}
+}
+
+// weakSweep removes the entries of t whose key or value have been collected and
+// returns how many there were.
+func (t *Table) weakSweep() (removed int) {
+	t.weak.deadCount = luagc.DeadCount()
+	var sk Value
+	for {
+		nk, nv, ok := t.mixedTable.next(sk)
+		if !ok || nk.IsNil() {
+			return
+		}
+		if _, _, ok := t.weakLoad(nk, nv); !ok {
+			t.mixedTable.remove(nk)
+			removed++
+		}
+		sk = nk
+	}
+}
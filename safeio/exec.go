@@ -0,0 +1,57 @@
+package safeio
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// ShellCommand returns a command that runs the given command line with the
+// system shell.  This is not allowed when ComplyExecSafe is required.
+func ShellCommand(r *rt.Runtime, command string) (*exec.Cmd, error) {
+	if r.RequiredFlags()&rt.ComplyExecSafe != 0 {
+		return nil, ErrNotAllowed
+	}
+	shell, flag := shellAndFlag()
+	return exec.Command(shell, flag, command), nil
+}
+
+// ShellAvailable returns true if commands can be run with the system shell,
+// which is never the case when ComplyExecSafe is required.
+func ShellAvailable(r *rt.Runtime) bool {
+	if r.RequiredFlags()&rt.ComplyExecSafe != 0 {
+		return false
+	}
+	shell, _ := shellAndFlag()
+	_, err := exec.LookPath(shell)
+	return err == nil
+}
+
+// ExitStatus interprets the error returned when waiting for a command to
+// complete the way Lua does: what is "exit" if the command terminated normally
+// (then code is its exit status) or "signal" if it was terminated by a signal
+// (then code is the signal number).  If the command could not be run or waited
+// for, a non-nil error is returned.
+func ExitStatus(err error) (what string, code int, runErr error) {
+	if err == nil {
+		return "exit", 0, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "", 0, err
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return "signal", int(ws.Signal()), nil
+	}
+	return "exit", exitErr.ExitCode(), nil
+}
+
+func shellAndFlag() (string, string) {
+	if runtime.GOOS == "windows" {
+		return "cmd", "/C"
+	}
+	return "/bin/sh", "-c"
+}
@@ -18,6 +18,7 @@ type Scanner struct {
 	items            chan *token.Token // channel of scanned items.
 	state            stateFn
 	errorMsg         string
+	keepComments     bool // emit COMMENT tokens rather than skipping comments
 }
 
 type Option func(*Scanner)
@@ -29,6 +30,15 @@ func ForNumber() Option {
 	}
 }
 
+// KeepComments makes the scanner emit comments as COMMENT tokens, rather than
+// skipping them.  The literal of a COMMENT token is the whole comment,
+// including the leading "--" and excluding the end of line.
+func KeepComments() Option {
+	return func(s *Scanner) {
+		s.keepComments = true
+	}
+}
+
 func WithStartLine(l int) Option {
 	return func(s *Scanner) {
 		pos := token.Pos{Line: l, Column: 1}
@@ -52,6 +52,14 @@ func TestScanner(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"--[\nx",
+			[]tok{
+				{token.IDENT, "x", 4, 2, 1},
+				{token.EOF, "", 5, 2, 2},
+			},
+			"",
+		},
 		{
 			`123.45 "abc" -0xff .5`,
 			[]tok{
@@ -230,3 +238,52 @@ func TestScanner(t *testing.T) {
 		})
 	}
 }
+
+func TestScannerKeepComments(t *testing.T) {
+	tests := []struct {
+		text string
+		toks []tok
+	}{
+		{
+			"x -- short\ny",
+			[]tok{
+				{token.IDENT, "x", 0, 1, 1},
+				{token.COMMENT, "-- short", 2, 1, 3},
+				{token.IDENT, "y", 11, 2, 1},
+				{token.EOF, "", 12, 2, 2},
+			},
+		},
+		{
+			"--[==[ long\n]] comment ]==]x",
+			[]tok{
+				{token.COMMENT, "--[==[ long\n]] comment ]==]", 0, 1, 1},
+				{token.IDENT, "x", 27, 2, 16},
+				{token.EOF, "", 28, 2, 17},
+			},
+		},
+		{
+			"--[\nx --",
+			[]tok{
+				{token.COMMENT, "--[", 0, 1, 1},
+				{token.IDENT, "x", 4, 2, 1},
+				{token.COMMENT, "--", 6, 2, 3},
+				{token.EOF, "", 8, 2, 5},
+			},
+		},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("Test %d", i+1)
+		t.Run(name, func(t *testing.T) {
+			scanner := New("test", []byte(test.text), KeepComments())
+			for j, ts := range test.toks {
+				next := scanner.Scan()
+				if next == nil {
+					t.Fatalf("Token %d: scan returns nil", j+1)
+				}
+				if !reflect.DeepEqual(next, ts.Token()) {
+					t.Fatalf("Token %d: expected <%s>, got <%s>", j+1, tokenString(ts.Token()), tokenString(next))
+				}
+			}
+		})
+	}
+}
@@ -77,17 +77,32 @@ func scanShortComment(l *Scanner) stateFn {
 	for {
 		switch c := l.next(); c {
 		case '\n':
+			if l.keepComments {
+				l.backup()
+				l.emit(token.COMMENT)
+				return scanToken
+			}
 			l.acceptRune('\r')
 			l.ignore()
 			return scanToken
 		case -1:
-			l.ignore()
+			l.skipComment()
 			l.emit(token.EOF)
 			return nil
 		}
 	}
 }
 
+// skipComment emits the pending input as a COMMENT token if comments are kept,
+// otherwise it ignores it.
+func (l *Scanner) skipComment() {
+	if l.keepComments {
+		l.emit(token.COMMENT)
+	} else {
+		l.ignore()
+	}
+}
+
 func scanLongComment(l *Scanner) stateFn {
 	return scanLong(true)
 }
@@ -104,7 +119,9 @@ func scanLong(comment bool) stateFn {
 				break OpeningLoop
 			default:
 				if comment {
-					l.ignore()
+					// Not a long bracket after all, and c may be the end of
+					// the line so it must be scanned again.
+					l.backup()
 					return scanShortComment
 				}
 				return l.errorf(token.INVALID, "expected opening long bracket")
@@ -119,7 +136,7 @@ func scanLong(comment bool) stateFn {
 			case ']':
 				if closeLevel == level {
 					if comment {
-						l.ignore()
+						l.skipComment()
 					} else {
 						l.emit(token.LONGSTRING)
 					}
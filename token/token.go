@@ -28,6 +28,7 @@ const (
 	STRING
 	NUMHEX
 	IDENT
+	COMMENT // Only emitted by scanners which keep comments
 
 	KwBreak
 	KwGoto
@@ -0,0 +1,122 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// FromFS returns a read-only FS giving access to the files in fsys.  Names
+// are cleaned and made relative to the root of fsys, so "/a/b.lua" and
+// "./a/b.lua" both name the file "a/b.lua".  Opening a file for writing,
+// removing or renaming a file and creating a temporary file all fail with
+// fs.ErrPermission.
+//
+// Files which are not seekable (i.e. do not implement io.Seeker) are read into
+// memory when they are opened.
+func FromFS(fsys fs.FS) FS {
+	return roFS{fsys: fsys}
+}
+
+// DirFS returns a read-only FS giving access to the files in the directory
+// dir of the host filesystem.  It is FromFS(os.DirFS(dir)).
+func DirFS(dir string) FS {
+	return FromFS(os.DirFS(dir))
+}
+
+type roFS struct {
+	fsys fs.FS
+}
+
+func (r roFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f, err := r.fsys.Open(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if s, ok := f.(io.ReadSeeker); ok {
+		return &roFile{name: name, f: f, rs: s}, nil
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &roFile{name: name, rs: bytes.NewReader(data)}, nil
+}
+
+func (r roFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (r roFS) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrPermission}
+}
+
+func (r roFS) TempFile(dir, pattern string) (File, error) {
+	return nil, &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: fs.ErrPermission}
+}
+
+// fsPath turns a file name into a valid fs.FS path.
+func fsPath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// A roFile is a file open in a roFS.
+type roFile struct {
+	name   string
+	f      fs.File // nil if the content was read into memory
+	rs     io.ReadSeeker
+	closed bool
+}
+
+func (f *roFile) Name() string {
+	return f.name
+}
+
+func (f *roFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.rs.Read(p)
+}
+
+func (f *roFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.rs.Seek(offset, whence)
+}
+
+func (f *roFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f *roFile) Sync() error {
+	return nil
+}
+
+func (f *roFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
@@ -0,0 +1,245 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A MemFS is a filesystem held in memory.  It is safe for concurrent use.
+//
+// A MemFS has no directories: a file name is a path which is cleaned and made
+// relative to the root, so "a/b.lua", "./a/b.lua" and "/a/b.lua" all name the
+// same file.  Permissions are ignored.
+type MemFS struct {
+	mu      sync.Mutex
+	files   map[string]*memData
+	tempSeq uint64
+}
+
+var _ FS = (*MemFS)(nil)
+
+// The content of a file in a MemFS.  Open files keep a reference to it, so
+// they can still be used after the file is removed or renamed (like on Unix).
+type memData struct {
+	data []byte
+}
+
+// NewMemFS returns a new empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memData{}}
+}
+
+// WriteFile creates or replaces the named file with the given content.  It is
+// convenient to populate the filesystem.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[memPath(name)] = &memData{data: append([]byte(nil), data...)}
+}
+
+// ReadFile returns the content of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.files[memPath(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), d.data...), nil
+}
+
+// OpenFile opens the named file.  The supported flags are os.O_RDONLY,
+// os.O_WRONLY, os.O_RDWR, os.O_CREATE, os.O_EXCL, os.O_TRUNC and os.O_APPEND.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	d, ok := m.files[key]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		d = &memData{}
+		m.files[key] = d
+	}
+	f := &memFile{fs: m, name: name, d: d, flag: flag}
+	if f.canWrite() && flag&os.O_TRUNC != 0 {
+		d.data = nil
+	}
+	return f, nil
+}
+
+// Remove removes the named file.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	if _, ok := m.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// Rename renames the file oldName to newName, replacing newName if it exists.
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldKey, newKey := memPath(oldName), memPath(newName)
+	d, ok := m.files[oldKey]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldKey)
+	m.files[newKey] = d
+	return nil
+}
+
+// TempFile creates a new empty file in dir ("tmp" if dir is "").  The name of
+// the file is made by replacing the last "*" in pattern with a number, or
+// appending a number if there is no "*".
+func (m *MemFS) TempFile(dir, pattern string) (File, error) {
+	if dir == "" {
+		dir = "tmp"
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndexByte(pattern, '*'); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for {
+		m.mu.Lock()
+		m.tempSeq++
+		seq := m.tempSeq
+		m.mu.Unlock()
+		name := path.Join(dir, prefix+strconv.FormatUint(seq, 10)+suffix)
+		f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+// memPath returns the key of the named file in a MemFS.
+func memPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// A memFile is an open file in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	d      *memData
+	flag   int
+	pos    int64
+	closed bool
+}
+
+var _ File = (*memFile)(nil)
+
+func (f *memFile) canRead() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func (f *memFile) canWrite() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", f.canRead()); err != nil {
+		return 0, err
+	}
+	if f.pos >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", f.canWrite()); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.d.data))
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.d.data)) {
+		if end > int64(cap(f.d.data)) {
+			data := make([]byte, end, 2*end)
+			copy(data, f.d.data)
+			f.d.data = data
+		} else {
+			f.d.data = f.d.data[:end]
+		}
+	}
+	copy(f.d.data[f.pos:], p)
+	f.pos = end
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("seek", true); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.d.data))
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.check("sync", true)
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("close", true); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+// check returns an error if the file is closed or if the operation is not
+// allowed.
+func (f *memFile) check(op string, allowed bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case !allowed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
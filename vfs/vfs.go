@@ -0,0 +1,81 @@
+// Package vfs defines the filesystem interface used by a runtime to access
+// files, so that the io and os libraries, loadfile, dofile and require can be
+// pointed at something else than the host filesystem.
+//
+// Three implementations are provided: OS (the host filesystem), MemFS (an
+// in-memory read-write filesystem) and FromFS (a read-only adapter for any
+// fs.FS, e.g. an embed.FS or the value returned by os.DirFS).
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// An FS is a filesystem where files can be opened, created, removed and
+// renamed.  File names are slash-separated paths, although OS accepts any
+// name accepted by the os package.
+type FS interface {
+	// OpenFile opens the named file with the given flag (os.O_RDONLY,
+	// os.O_CREATE, etc.) and permissions, like os.OpenFile.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Remove removes the named file.
+	Remove(name string) error
+
+	// Rename renames the file oldName to newName.
+	Rename(oldName, newName string) error
+
+	// TempFile creates a new temporary file in dir (or a default location if
+	// dir is ""), opened for reading and writing.  Its name is made from
+	// pattern like ioutil.TempFile does.
+	TempFile(dir, pattern string) (File, error)
+}
+
+// A File is an open file in an FS.  *os.File implements this interface.
+type File interface {
+	io.ReadWriteSeeker
+	io.Closer
+
+	// Name returns the name of the file as passed to OpenFile (or generated by
+	// TempFile).
+	Name() string
+
+	// Sync commits the content of the file to stable storage, if it makes
+	// sense for the filesystem.
+	Sync() error
+}
+
+var _ File = (*os.File)(nil)
+
+// OS is the host filesystem, accessed via the os package.  This is the
+// filesystem a runtime uses unless told otherwise.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) TempFile(dir, pattern string) (File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,164 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func readAll(t *testing.T, fsys FS, name string) string {
+	t.Helper()
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func writeString(t *testing.T, fsys FS, name string, flag int, s string) {
+	t.Helper()
+	f, err := fsys.OpenFile(name, flag, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	if _, err := m.OpenFile("a.txt", os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+	writeString(t, m, "a.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, "hello")
+	writeString(t, m, "/a.txt", os.O_WRONLY|os.O_APPEND, " world")
+	if s := readAll(t, m, "./a.txt"); s != "hello world" {
+		t.Errorf("unexpected content %q", s)
+	}
+	if _, err := m.OpenFile("a.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected ErrExist, got %v", err)
+	}
+
+	// Seek and overwrite past the end
+	f, err := m.OpenFile("a.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Seek(-5, io.SeekEnd); err != nil || n != 6 {
+		t.Fatalf("seek: %d, %v", n, err)
+	}
+	io.WriteString(f, "there!")
+	f.Close()
+	if s := readAll(t, m, "a.txt"); s != "hello there!" {
+		t.Errorf("unexpected content %q", s)
+	}
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// Write to a read-only file
+	f, _ = m.OpenFile("a.txt", os.O_RDONLY, 0)
+	if _, err := io.WriteString(f, "x"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	f.Close()
+
+	// Rename and remove
+	if err := m.Rename("a.txt", "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if s := readAll(t, m, "dir/b.txt"); s != "hello there!" {
+		t.Errorf("unexpected content %q", s)
+	}
+	if err := m.Remove("a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if err := m.Remove("dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadFile("dir/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemFSTempFile(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("tmp/x1.txt", nil)
+	f, err := m.TempFile("", "x*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Name() != "tmp/x2.txt" {
+		t.Errorf("unexpected name %q", f.Name())
+	}
+	io.WriteString(f, "temp")
+	if s := readAll(t, m, f.Name()); s != "temp" {
+		t.Errorf("unexpected content %q", s)
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	r := FromFS(fstest.MapFS{
+		"dir/a.txt": {Data: []byte("some text")},
+	})
+	f, err := r.OpenFile("/dir/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != "/dir/a.txt" {
+		t.Errorf("unexpected name %q", f.Name())
+	}
+	f.Seek(5, io.SeekStart)
+	b, _ := ioutil.ReadAll(f)
+	if string(b) != "text" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if _, err := f.Write(b); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	f.Close()
+
+	if _, err := r.OpenFile("dir", os.O_RDONLY, 0); err == nil {
+		t.Error("expected error opening a directory")
+	}
+	if _, err := r.OpenFile("dir/b.txt", os.O_WRONLY|os.O_CREATE, 0666); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	if err := r.Remove("dir/a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	if err := r.Rename("dir/a.txt", "b.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	if _, err := r.TempFile("", ""); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.lua"), []byte("return 1"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if s := readAll(t, DirFS(dir), "a.lua"); s != "return 1" {
+		t.Errorf("unexpected content %q", s)
+	}
+	if s := readAll(t, OS, filepath.Join(dir, "a.lua")); s != "return 1" {
+		t.Errorf("unexpected content %q", s)
+	}
+}